@@ -1,57 +1,201 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/elmq0022/kv-store/internal/aof"
+	"github.com/elmq0022/kv-store/internal/certs"
+	"github.com/elmq0022/kv-store/internal/config"
 	"github.com/elmq0022/kv-store/internal/executor"
-	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/metrics"
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/replication"
+	"github.com/elmq0022/kv-store/internal/server"
 	"github.com/elmq0022/kv-store/internal/storage"
 )
 
 func main() {
-	var s storage.Storage = storage.NewInMemoryShardedStorage()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until the process is interrupted or terminated, or a listener
+// fails. Either way, the listeners, the server and the append-only file are
+// closed in that order before it returns, so that the last writes are synced.
+func run() error {
+	cfg := config.New(server.Params())
+	if err := cfg.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		return err
+	}
+	dir := cfg.String("dir")
+
+	var s storage.Storage = storage.NewInMemoryShardedStorageShards(int(cfg.Int("storage-shards")))
 	var exe = executor.NewExecutor(s)
 
-	ln, err := net.Listen("tcp", ":6379")
+	rules, err := rdb.ParseSaveRules(cfg.String("save"))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	opts := server.Options{
+		RDBPath:   filepath.Join(dir, cfg.String("dbfilename")),
+		SaveRules: rules,
+		ReplicaOf: server.ReplicaOfAddr(cfg.String("replicaof")),
+		Replication: replication.Options{
+			BacklogSize:     int(cfg.Int("repl-backlog-size")),
+			ReplicaWritable: !cfg.Bool("replica-read-only"),
+		},
+		RequirePass:        cfg.String("requirepass"),
+		ACLFile:            cfg.String("aclfile"),
+		TLSAuthClientsUser: cfg.Bool("tls-auth-clients-user"),
+		Config:             cfg,
 	}
-	defer ln.Close()
 
-	fmt.Println("listening on :6379")
+	// The append-only file, when enabled, is the more complete record and
+	// takes precedence over the snapshot.
+	if cfg.Bool("appendonly") {
+		policy, err := aof.ParseFsyncPolicy(cfg.String("appendfsync"))
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, cfg.String("appendfilename"))
+		n, err := aof.Load(path, exe)
+		if err != nil {
+			return err
+		}
+		log.Printf("loaded %d commands from %s", n, path)
 
-	for {
-		conn, err := ln.Accept()
+		opts.AOF, err = aof.Open(path, policy)
 		if err != nil {
-			log.Println("accept error:", err)
-			continue
+			return err
 		}
-		go handleConn(conn, exe)
+		defer func() {
+			// Writes still applied by the replication link must not reach
+			// the log once it is closed.
+			exe.Barrier(func() {
+				if err := opts.AOF.Close(); err != nil {
+					log.Println("closing append only file:", err)
+				}
+			})
+		}()
+		exe.AddPropagator(opts.AOF)
+	} else {
+		n, err := rdb.Load(opts.RDBPath, s)
+		if err != nil {
+			return err
+		}
+		log.Printf("loaded %d keys from %s", n, opts.RDBPath)
+	}
+
+	port, tlsPort := cfg.Int("port"), cfg.Int("tls-port")
+	if port == 0 && tlsPort == 0 {
+		return errors.New("no port to listen on")
 	}
-}
 
-func handleConn(conn net.Conn, exe *executor.Executor) {
-	defer conn.Close()
+	srv := server.New(s, exe, opts)
+	defer srv.Close()
+	if err := srv.LoadACL(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 
-	decoder := resp.NewDecoder(conn)
-	encoder := resp.NewEncoder(conn)
+	errc := make(chan error, 3)
+	if metricsPort := cfg.Int("metrics-port"); metricsPort != 0 {
+		reg := metrics.NewRegistry()
+		srv.RegisterMetrics(reg)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", reg.Handler())
 
-	for {
-		input, err := decoder.Decode()
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", metricsPort))
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		fmt.Printf("serving metrics on :%d\n", metricsPort)
+		go func() { errc <- http.Serve(ln, mux) }()
+	}
+	if port != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		fmt.Printf("listening on :%d\n", port)
+		go func() { errc <- srv.Serve(ln) }()
+	}
+	if tlsPort != 0 {
+		certOpts, err := tlsOptions(cfg)
+		if err != nil {
+			return err
+		}
+		store, err := certs.New(certOpts)
 		if err != nil {
-			return
+			return err
 		}
+		go reloadOnHangup(store)
+		// Certificates given with CONFIG SET take effect immediately.
+		cfg.Watch(func() error {
+			certOpts, err := tlsOptions(cfg)
+			if err != nil {
+				return err
+			}
+			return store.Update(certOpts)
+		}, "tls-cert-file", "tls-key-file", "tls-ca-cert-file", "tls-auth-clients")
 
-		output, err := exe.Execute(input)
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", tlsPort))
 		if err != nil {
-			encoder.Encode(resp.Value{Type: resp.TypeError, Bytes: []byte(err.Error())})
-			return
+			return err
 		}
+		defer ln.Close()
+		fmt.Printf("listening for TLS on :%d\n", tlsPort)
+		go func() { errc <- srv.ServeTLS(ln, store.Config()) }()
+	}
 
-		if err := encoder.Encode(output); err != nil {
-			return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down")
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// tlsOptions returns the options of the TLS certificates from cfg.
+func tlsOptions(cfg *config.Config) (certs.Options, error) {
+	clientAuth, err := certs.ParseClientAuth(cfg.String("tls-auth-clients"))
+	if err != nil {
+		return certs.Options{}, err
+	}
+	return certs.Options{
+		CertFile:   cfg.String("tls-cert-file"),
+		KeyFile:    cfg.String("tls-key-file"),
+		CAFile:     cfg.String("tls-ca-cert-file"),
+		ClientAuth: clientAuth,
+	}, nil
+}
+
+// reloadOnHangup reloads the TLS certificates whenever the process receives
+// SIGHUP, so that they can be rotated without a restart.
+func reloadOnHangup(store *certs.Store) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := store.Reload(); err != nil {
+			log.Println("reloading TLS certificates:", err)
+			continue
 		}
+		log.Println("reloaded TLS certificates")
 	}
 }
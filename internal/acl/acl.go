@@ -0,0 +1,521 @@
+// Package acl keeps the users allowed to connect and what each of them may
+// do: which commands they may run, which keys they may read or write and
+// which channels they may publish or subscribe to. Users are configured with
+// the rules of ACL SETUSER, and authenticate with passwords stored as
+// SHA-256 hashes.
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// DefaultUser is the user connections start as.
+const DefaultUser = "default"
+
+var (
+	// ErrNoSuchUser is returned when checking the permissions of a user
+	// that was deleted.
+	ErrNoSuchUser = errors.New("no such user")
+	// ErrDefaultUser is returned when deleting the default user.
+	ErrDefaultUser = errors.New("The 'default' user cannot be removed")
+)
+
+// Denial is returned when a user is not allowed to run a command.
+type Denial struct {
+	// Reason is what was denied: "command", "key" or "channel".
+	Reason string
+	// Object is the name of the command, key or channel denied.
+	Object string
+	User   string
+}
+
+func (d *Denial) Error() string {
+	switch d.Reason {
+	case "key":
+		return "NOPERM No permissions to access a key"
+	case "channel":
+		return "NOPERM No permissions to access a channel"
+	default:
+		return "NOPERM User " + d.User + " has no permissions to run the '" + d.Object + "' command"
+	}
+}
+
+// keyPattern is a pattern of keys a user may read, write or both.
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (k keyPattern) String() string {
+	switch {
+	case k.read && k.write:
+		return "~" + k.pattern
+	case k.read:
+		return "%R~" + k.pattern
+	default:
+		return "%W~" + k.pattern
+	}
+}
+
+// User is a user and its permissions.
+type User struct {
+	Name    string
+	Enabled bool
+	// NoPass lets the user authenticate with any password.
+	NoPass bool
+	// passwords are the hex encoded SHA-256 hashes of the user's passwords.
+	passwords []string
+	// commands holds the commands the user may run. A container command
+	// stands for the subcommands it has no entry for.
+	commands map[string]bool
+	// commandRules are the command rules applied since the last +@all or
+	// -@all, describing commands.
+	commandRules []string
+	keys         []keyPattern
+	channels     []string
+}
+
+func newUser(name string) *User {
+	return &User{Name: name, commands: make(map[string]bool), commandRules: []string{"-@all"}}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = make(map[string]bool, len(u.commands))
+	for k, v := range u.commands {
+		c.commands[k] = v
+	}
+	c.commandRules = slices.Clone(u.commandRules)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+// Flags returns the flags of the user, as reported by ACL GETUSER.
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.Enabled {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// Passwords returns the hashes of the user's passwords.
+func (u *User) Passwords() []string {
+	return slices.Clone(u.passwords)
+}
+
+// Commands describes the commands the user may run, as rules.
+func (u *User) Commands() string {
+	return strings.Join(u.commandRules, " ")
+}
+
+// Keys describes the keys the user may access, as rules.
+func (u *User) Keys() string {
+	out := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		out[i] = k.String()
+	}
+	return strings.Join(out, " ")
+}
+
+// Channels describes the channels the user may access, as rules.
+func (u *User) Channels() string {
+	out := make([]string, len(u.channels))
+	for i, ch := range u.channels {
+		out[i] = "&" + ch
+	}
+	return strings.Join(out, " ")
+}
+
+// Rules describes the user as the rules recreating it, in the format of
+// ACL LIST and ACL files.
+func (u *User) Rules() string {
+	rules := []string{u.Flags()[0]}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.passwords {
+		rules = append(rules, "#"+p)
+	}
+	if keys := u.Keys(); keys != "" {
+		rules = append(rules, keys)
+	}
+	if channels := u.Channels(); channels != "" {
+		rules = append(rules, channels)
+	} else {
+		rules = append(rules, "resetchannels")
+	}
+	rules = append(rules, u.Commands())
+	return strings.Join(rules, " ")
+}
+
+// ACL holds the users.
+type ACL struct {
+	commands *command.Table
+
+	mu    sync.RWMutex
+	users map[string]*User
+
+	logMu sync.Mutex
+	log   []*LogEntry
+	// nextEntryID numbers log entries from 0.
+	nextEntryID int64
+}
+
+// New returns an ACL with the default user, which may run every command
+// without a password.
+func New(commands *command.Table) *ACL {
+	a := &ACL{commands: commands}
+	a.users = map[string]*User{DefaultUser: a.defaultUser()}
+	return a
+}
+
+func (a *ACL) defaultUser() *User {
+	u := newUser(DefaultUser)
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		if err := a.apply(u, rule); err != nil {
+			panic(err)
+		}
+	}
+	return u
+}
+
+// SetUser creates the named user if needed and applies rules to it in
+// order. If any rule is invalid the user is left unchanged.
+func (a *ACL) SetUser(name string, rules ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := a.apply(u, rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", rule, err)
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+// DelUser deletes the named users and returns how many existed.
+func (a *ACL) DelUser(names ...string) (int, error) {
+	if slices.Contains(names, DefaultUser) {
+		return 0, ErrDefaultUser
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// User returns a copy of the named user.
+func (a *ACL) User(name string) (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	if !ok {
+		return nil, false
+	}
+	return u.clone(), true
+}
+
+// Users returns copies of all the users, ordered by name.
+func (a *ACL) Users() []*User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		out = append(out, u.clone())
+	}
+	slices.SortFunc(out, func(x, y *User) int { return strings.Compare(x.Name, y.Name) })
+	return out
+}
+
+// DefaultLogin reports whether new connections are authenticated as the
+// default user without a password.
+func (a *ACL) DefaultLogin() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u := a.users[DefaultUser]
+	return u.Enabled && u.NoPass
+}
+
+// Authenticate reports whether password is one of the named user's, and the
+// user is enabled.
+func (a *ACL) Authenticate(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	if !ok || !u.Enabled {
+		return false
+	}
+	return u.NoPass || slices.Contains(u.passwords, hash(password))
+}
+
+// Check returns a *Denial if the named user may not run the command args
+// invoke, resolved to spec, or access the keys it names. Commands need
+// write access to the keys they write and read access to those they only
+// read.
+func (a *ACL) Check(name string, spec *command.Spec, args []resp.Value) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	if !ok {
+		return ErrNoSuchUser
+	}
+	if !u.canRun(spec) {
+		return &Denial{Reason: "command", Object: spec.Name, User: name}
+	}
+	for _, key := range spec.KeyAccess(args) {
+		k := string(args[key.Index].Bytes)
+		if !u.canAccessKey(k, key.Write) {
+			return &Denial{Reason: "key", Object: k, User: name}
+		}
+	}
+	return nil
+}
+
+// CheckChannels returns a *Denial if the named user may not access any of
+// channels. Patterns subscribed to are only allowed if the user has the very
+// same pattern, as one pattern matching another is not decidable in general.
+func (a *ACL) CheckChannels(name string, channels []string, patterns bool) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	if !ok {
+		return ErrNoSuchUser
+	}
+	for _, ch := range channels {
+		allowed := slices.ContainsFunc(u.channels, func(p string) bool {
+			if patterns {
+				return p == "*" || p == ch
+			}
+			return glob.Match(p, ch)
+		})
+		if !allowed {
+			return &Denial{Reason: "channel", Object: ch, User: name}
+		}
+	}
+	return nil
+}
+
+func (u *User) canRun(spec *command.Spec) bool {
+	if allowed, ok := u.commands[spec.Name]; ok {
+		return allowed
+	}
+	if container, _, ok := strings.Cut(spec.Name, "|"); ok {
+		return u.commands[container]
+	}
+	return false
+}
+
+func (u *User) canAccessKey(k string, write bool) bool {
+	for _, p := range u.keys {
+		if (write && p.write || !write && p.read) && glob.Match(p.pattern, k) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errSyntax         = errors.New("Syntax error")
+	errUnknownCommand = errors.New("Unknown command or category name in ACL")
+	errBadHash        = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword = errors.New("The password you are trying to remove from the user does not exist")
+)
+
+// apply applies a single rule to u.
+func (a *ACL) apply(u *User, rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+		return nil
+	case "off":
+		u.Enabled = false
+		return nil
+	case "nopass":
+		u.NoPass, u.passwords = true, nil
+		return nil
+	case "resetpass":
+		u.NoPass, u.passwords = false, nil
+		return nil
+	case "allkeys":
+		return a.apply(u, "~*")
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		return a.apply(u, "&*")
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return a.apply(u, "+@all")
+	case "nocommands":
+		return a.apply(u, "-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			if err := a.apply(u, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if rule == "" {
+		return errSyntax
+	}
+
+	switch rule[0] {
+	case '>':
+		addPassword(u, hash(rule[1:]))
+	case '#':
+		h := rule[1:]
+		if !validHash(h) {
+			return errBadHash
+		}
+		addPassword(u, h)
+	case '<', '!':
+		h := rule[1:]
+		if rule[0] == '<' {
+			h = hash(h)
+		} else if !validHash(h) {
+			return errBadHash
+		}
+		i := slices.Index(u.passwords, h)
+		if i < 0 {
+			return errNoSuchPassword
+		}
+		u.passwords = slices.Delete(u.passwords, i, i+1)
+	case '~', '%':
+		return addKeyPattern(u, rule)
+	case '&':
+		if !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case '+', '-':
+		return a.applyCommandRule(u, rule)
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func addPassword(u *User, h string) {
+	u.NoPass = false
+	if !slices.Contains(u.passwords, h) {
+		u.passwords = append(u.passwords, h)
+	}
+}
+
+// addKeyPattern applies ~pattern, or %R~pattern, %W~pattern or
+// %RW~pattern.
+func addKeyPattern(u *User, rule string) error {
+	k := keyPattern{read: true, write: true}
+	if rule[0] == '%' {
+		perms, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || perms == "" {
+			return errSyntax
+		}
+		k = keyPattern{}
+		for _, p := range strings.ToUpper(perms) {
+			switch p {
+			case 'R':
+				k.read = true
+			case 'W':
+				k.write = true
+			default:
+				return errSyntax
+			}
+		}
+		rule = "~" + pattern
+	}
+	k.pattern = rule[1:]
+	for i, existing := range u.keys {
+		if existing.pattern == k.pattern {
+			u.keys[i].read = existing.read || k.read
+			u.keys[i].write = existing.write || k.write
+			return nil
+		}
+	}
+	u.keys = append(u.keys, k)
+	return nil
+}
+
+// applyCommandRule applies +command, -command, +@category or -@category.
+// Commands may be subcommands, as in +xgroup|create.
+func (a *ACL) applyCommandRule(u *User, rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+	if cat, ok := strings.CutPrefix(name, "@"); ok {
+		if cat != "all" && !slices.Contains(command.Categories, cat) {
+			return errUnknownCommand
+		}
+		if cat == "all" {
+			clear(u.commands)
+			u.commandRules = nil
+		}
+		for _, spec := range a.commands.All() {
+			if cat == "all" || slices.Contains(spec.ACLCategories(), cat) {
+				u.commands[spec.Name] = allow
+			}
+			for _, sub := range spec.Subcommands {
+				if cat != "all" && slices.Contains(sub.ACLCategories(), cat) {
+					u.commands[sub.Name] = allow
+				}
+			}
+		}
+	} else {
+		spec, ok := a.commands.Lookup(name)
+		if !ok {
+			return errUnknownCommand
+		}
+		u.commands[spec.Name] = allow
+		for _, sub := range spec.Subcommands {
+			delete(u.commands, sub.Name)
+		}
+	}
+	u.commandRules = append(u.commandRules, rule[:1]+name)
+	return nil
+}
+
+func hash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func validHash(h string) bool {
+	if len(h) != 64 {
+		return false
+	}
+	for i := 0; i < len(h); i++ {
+		if !('0' <= h[i] && h[i] <= '9' || 'a' <= h[i] && h[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package acl_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var table = command.NewTable([]*command.Spec{
+	{Name: "get", Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1, Group: "string"},
+	{Name: "set", Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Group: "string"},
+	{Name: "del", Arity: -2, Flags: command.Write, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic"},
+	{Name: "sunionstore", Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: -1, Step: 1, WrittenKeys: 1, Group: "set"},
+	{Name: "ping", Arity: -1, Flags: command.Fast, Group: "connection"},
+	{Name: "config", Arity: -2, Subcommands: []*command.Spec{
+		{Name: "config|get", Arity: -3, Flags: command.Admin},
+		{Name: "config|set", Arity: -4, Flags: command.Admin},
+	}},
+})
+
+func check(t *testing.T, a *acl.ACL, user string, words ...string) error {
+	t.Helper()
+	args := make([]resp.Value, len(words))
+	for i, w := range words {
+		args[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(w)}
+	}
+	spec, ok := table.Find(args)
+	require.True(t, ok)
+	return a.Check(user, spec, args)
+}
+
+func reason(err error) string {
+	var d *acl.Denial
+	if errors.As(err, &d) {
+		return d.Reason
+	}
+	return ""
+}
+
+func TestDefaultUser(t *testing.T) {
+	a := acl.New(table)
+	assert.True(t, a.DefaultLogin())
+	assert.True(t, a.Authenticate(acl.DefaultUser, "anything"))
+	assert.NoError(t, check(t, a, acl.DefaultUser, "config", "set", "k", "v"))
+	assert.Equal(t, "user default on nopass ~* &* +@all", "user default "+mustUser(t, a, acl.DefaultUser).Rules())
+
+	require.NoError(t, a.SetUser(acl.DefaultUser, "resetpass", ">secret"))
+	assert.False(t, a.DefaultLogin())
+	assert.False(t, a.Authenticate(acl.DefaultUser, "wrong"))
+	assert.True(t, a.Authenticate(acl.DefaultUser, "secret"))
+
+	_, err := a.DelUser(acl.DefaultUser)
+	assert.ErrorIs(t, err, acl.ErrDefaultUser)
+}
+
+func mustUser(t *testing.T, a *acl.ACL, name string) *acl.User {
+	t.Helper()
+	u, ok := a.User(name)
+	require.True(t, ok)
+	return u
+}
+
+func TestSetUser(t *testing.T) {
+	a := acl.New(table)
+	require.NoError(t, a.SetUser("alice", "on", ">pw1", ">pw2", "<pw1"))
+	assert.False(t, a.Authenticate("alice", "pw1"))
+	assert.True(t, a.Authenticate("alice", "pw2"))
+	assert.Len(t, mustUser(t, a, "alice").Passwords(), 1)
+
+	require.NoError(t, a.SetUser("alice", "off"))
+	assert.False(t, a.Authenticate("alice", "pw2"), "disabled users cannot authenticate")
+
+	t.Run("invalid rules leave the user unchanged", func(t *testing.T) {
+		err := a.SetUser("alice", "on", "+nosuch")
+		assert.EqualError(t, err, "Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL")
+		assert.Equal(t, []string{"off"}, mustUser(t, a, "alice").Flags())
+		assert.Error(t, a.SetUser("alice", "#nothex"))
+		assert.Error(t, a.SetUser("alice", "<notapassword"))
+		assert.Error(t, a.SetUser("alice", "bogus"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		n, err := a.DelUser("alice", "nobody")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, ok := a.User("alice")
+		assert.False(t, ok)
+		assert.ErrorIs(t, check(t, a, "alice", "ping"), acl.ErrNoSuchUser)
+	})
+}
+
+func TestCommandPermissions(t *testing.T) {
+	a := acl.New(table)
+	require.NoError(t, a.SetUser("bob", "on", "nopass", "allkeys", "+@read", "+ping", "+config|get"))
+
+	assert.NoError(t, check(t, a, "bob", "get", "k"))
+	assert.NoError(t, check(t, a, "bob", "ping"))
+	assert.NoError(t, check(t, a, "bob", "config", "get", "maxmemory"))
+	err := check(t, a, "bob", "set", "k", "v")
+	assert.Equal(t, "command", reason(err))
+	assert.EqualError(t, err, "NOPERM User bob has no permissions to run the 'set' command")
+	assert.EqualError(t, check(t, a, "bob", "config", "set", "k", "v"), "NOPERM User bob has no permissions to run the 'config|set' command")
+	assert.Equal(t, "-@all +@read +ping +config|get", mustUser(t, a, "bob").Commands())
+
+	require.NoError(t, a.SetUser("bob", "+@all", "-@admin"))
+	assert.NoError(t, check(t, a, "bob", "set", "k", "v"))
+	assert.Equal(t, "command", reason(check(t, a, "bob", "config", "get", "maxmemory")))
+
+	require.NoError(t, a.SetUser("bob", "+config"))
+	assert.NoError(t, check(t, a, "bob", "config", "set", "k", "v"), "allowing a container allows its subcommands")
+}
+
+func TestKeyPermissions(t *testing.T) {
+	a := acl.New(table)
+	require.NoError(t, a.SetUser("carol", "on", "nopass", "+@all", "~app:*", "%R~shared:*", "%W~log:*"))
+
+	assert.NoError(t, check(t, a, "carol", "get", "app:1"))
+	assert.NoError(t, check(t, a, "carol", "set", "app:1", "v"))
+	assert.NoError(t, check(t, a, "carol", "get", "shared:1"))
+	assert.Equal(t, "key", reason(check(t, a, "carol", "set", "shared:1", "v")))
+	assert.NoError(t, check(t, a, "carol", "set", "log:1", "v"))
+	assert.Equal(t, "key", reason(check(t, a, "carol", "get", "log:1")))
+	assert.Equal(t, "key", reason(check(t, a, "carol", "del", "app:1", "other")))
+	assert.EqualError(t, check(t, a, "carol", "get", "other"), "NOPERM No permissions to access a key")
+	assert.Equal(t, "~app:* %R~shared:* %W~log:*", mustUser(t, a, "carol").Keys())
+
+	// The destination is written and the sources only read.
+	assert.NoError(t, check(t, a, "carol", "sunionstore", "log:1", "shared:1", "shared:2"))
+	assert.Equal(t, "key", reason(check(t, a, "carol", "sunionstore", "shared:1", "log:1")))
+	assert.Equal(t, "key", reason(check(t, a, "carol", "sunionstore", "log:1", "log:2")))
+
+	require.NoError(t, a.SetUser("carol", "%W~shared:*"))
+	assert.NoError(t, check(t, a, "carol", "set", "shared:1", "v"))
+	assert.Equal(t, "~app:* ~shared:* %W~log:*", mustUser(t, a, "carol").Keys())
+}
+
+func TestChannelPermissions(t *testing.T) {
+	a := acl.New(table)
+	require.NoError(t, a.SetUser("dave", "on", "nopass", "&news.*"))
+
+	assert.NoError(t, a.CheckChannels("dave", []string{"news.sport", "news.tech"}, false))
+	err := a.CheckChannels("dave", []string{"news.sport", "gossip"}, false)
+	assert.Equal(t, "channel", reason(err))
+	assert.EqualError(t, err, "NOPERM No permissions to access a channel")
+	assert.NoError(t, a.CheckChannels("dave", []string{"news.*"}, true))
+	assert.Error(t, a.CheckChannels("dave", []string{"news.s*"}, true), "patterns must match literally")
+}
+
+func TestLog(t *testing.T) {
+	a := acl.New(table)
+	a.LogDenial("command", "toplevel", "set", "bob", "id=1")
+	a.LogDenial("key", "toplevel", "k", "bob", "id=1")
+	a.LogDenial("command", "toplevel", "set", "bob", "id=2")
+
+	entries := a.Log(-1)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "command", entries[0].Reason, "a grouped entry moves to the front")
+	assert.Equal(t, 2, entries[0].Count)
+	assert.Equal(t, "id=2", entries[0].ClientInfo)
+	assert.Equal(t, "key", entries[1].Reason)
+	assert.Len(t, a.Log(1), 1)
+
+	a.ResetLog()
+	assert.Empty(t, a.Log(-1))
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	a := acl.New(table)
+	require.NoError(t, a.SetUser("alice", "on", ">pw", "~app:*", "&news", "+@read", "-get"))
+	require.NoError(t, a.Save(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "user alice on #"))
+	assert.NotContains(t, string(data), ">pw", "passwords are stored hashed")
+
+	b := acl.New(table)
+	require.NoError(t, b.SetUser("stale", "on"))
+	require.NoError(t, b.Load(path))
+	_, ok := b.User("stale")
+	assert.False(t, ok, "loading replaces all users")
+	assert.True(t, b.Authenticate("alice", "pw"))
+	assert.Equal(t, mustUser(t, a, "alice").Rules(), mustUser(t, b, "alice").Rules())
+
+	t.Run("errors", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("# users\nuser alice on\nuser bob +nosuch\n"), 0o644))
+		err := b.Load(path)
+		assert.ErrorContains(t, err, ":3:")
+		assert.True(t, b.Authenticate("alice", "pw"), "a failed load changes nothing")
+
+		require.NoError(t, os.WriteFile(path, []byte("user bob on nopass\n"), 0o644))
+		require.NoError(t, b.Load(path))
+		assert.True(t, b.DefaultLogin(), "the default user is recreated if missing")
+	})
+}
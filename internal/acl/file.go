@@ -0,0 +1,81 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Load replaces the users with those defined in the ACL file at path, one
+// per line as "user <name> <rules...>". Blank lines and lines starting with
+// '#' are ignored. The default user is reset to its initial permissions if
+// the file does not define it. If the file has an error nothing changes.
+func (a *ACL) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := make(map[string]*User)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: should start with user keyword", path, n)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, n, name)
+		}
+		u := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := a.apply(u, rule); err != nil {
+				return fmt.Errorf("%s:%d: %s: %w", path, n, rule, err)
+			}
+		}
+		users[name] = u
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = a.defaultUser()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	return nil
+}
+
+// Save writes the users to the ACL file at path, replacing it atomically.
+func (a *ACL) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.acl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	for _, u := range a.Users() {
+		fmt.Fprintf(w, "user %s %s\n", u.Name, u.Rules())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,87 @@
+package acl
+
+import "time"
+
+const (
+	// maxLogLen bounds the entries kept in the log.
+	maxLogLen = 128
+	// logGrouping is how long a denial is counted in the entry of an
+	// identical earlier one rather than getting its own.
+	logGrouping = 60 * time.Second
+)
+
+// LogEntry records denied commands and failed authentications, as shown by
+// ACL LOG. Identical denials in quick succession share an entry, which moves
+// to the front of the log each time.
+type LogEntry struct {
+	ID    int64
+	Count int
+	// Reason is "command", "key", "channel" or "auth".
+	Reason string
+	// Context is "toplevel" or "multi", for commands queued in a
+	// transaction.
+	Context  string
+	Object   string
+	Username string
+	// ClientInfo describes the connection.
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// LogDenial records a denial in the log.
+func (a *ACL) LogDenial(reason, context, object, username, clientInfo string) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	now := time.Now()
+	for i, e := range a.log {
+		if e.Reason == reason && e.Context == context && e.Object == object && e.Username == username &&
+			now.Sub(e.Updated) < logGrouping {
+			e.Count++
+			e.Updated = now
+			e.ClientInfo = clientInfo
+			// Move it back to the front.
+			copy(a.log[1:i+1], a.log[:i])
+			a.log[0] = e
+			return
+		}
+	}
+	e := &LogEntry{
+		ID:         a.nextEntryID,
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		Created:    now,
+		Updated:    now,
+	}
+	a.nextEntryID++
+	a.log = append([]*LogEntry{e}, a.log...)
+	if len(a.log) > maxLogLen {
+		a.log = a.log[:maxLogLen]
+	}
+}
+
+// Log returns up to n of the latest entries, most recent first, or all of
+// them if n is negative.
+func (a *ACL) Log(n int) []LogEntry {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	if n < 0 || n > len(a.log) {
+		n = len(a.log)
+	}
+	out := make([]LogEntry, n)
+	for i := range out {
+		out[i] = *a.log[i]
+	}
+	return out
+}
+
+// ResetLog clears the log.
+func (a *ACL) ResetLog() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.log = nil
+}
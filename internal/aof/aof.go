@@ -0,0 +1,458 @@
+// Package aof implements append-only file persistence. Every write command is
+// appended to the log in RESP, and the log is replayed through the executor
+// on startup to rebuild the keyspace.
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+var ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+
+// FsyncPolicy controls how often the log is flushed to stable storage.
+type FsyncPolicy int
+
+const (
+	FsyncEverySec FsyncPolicy = iota // fsync once a second in the background
+	FsyncAlways                      // fsync after every command
+	FsyncNo                          // leave flushing to the operating system
+)
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNo:
+		return "no"
+	default:
+		return "everysec"
+	}
+}
+
+// ParseFsyncPolicy parses an appendfsync setting.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	default:
+		return 0, fmt.Errorf("invalid appendfsync policy %q", s)
+	}
+}
+
+// Executor replays logged commands.
+type Executor interface {
+	Execute(val resp.Value) (resp.Value, error)
+	// ExecuteAll replays the commands of a transaction atomically.
+	ExecuteAll(cmds []resp.Value) ([]resp.Value, error)
+}
+
+type AOF struct {
+	mu     sync.Mutex
+	path   string
+	policy FsyncPolicy
+	f      *os.File
+	enc    *resp.Encoder
+	dirty  bool // written since the last fsync
+	err    error
+
+	// rewriteBuf accumulates commands appended while a rewrite is running.
+	rewriteBuf *bytes.Buffer
+	rewriteEnc *resp.Encoder
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Open opens the log at path for appending, creating it if necessary.
+func Open(path string, policy FsyncPolicy) (*AOF, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a := &AOF{
+		path:   path,
+		policy: policy,
+		f:      f,
+		enc:    resp.NewEncoder(f),
+		done:   make(chan struct{}),
+	}
+	go a.fsyncEverySec()
+	return a, nil
+}
+
+// SetPolicy changes when the log is flushed to disk.
+func (a *AOF) SetPolicy(policy FsyncPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+}
+
+// Propagate appends a write command to the log. Write errors are logged and
+// reported by Err, and the server refuses writes until they clear; the
+// command has already been applied in memory. A rewrite in progress still
+// records the command, so that the rewritten log is complete again.
+func (a *AOF) Propagate(args []resp.Value) {
+	cmd := resp.Value{Type: resp.TypeArray, Array: args}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriteEnc != nil {
+		a.rewriteEnc.Encode(cmd)
+	}
+	if err := a.enc.Encode(cmd); err != nil {
+		a.setErr(err)
+		return
+	}
+	a.dirty = true
+	if a.policy == FsyncAlways {
+		a.sync()
+	}
+}
+
+// Err returns the last write or fsync error, if any.
+func (a *AOF) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *AOF) setErr(err error) {
+	if a.err == nil {
+		log.Println("aof:", err)
+	}
+	a.err = err
+}
+
+// sync flushes the log to disk. Callers must hold mu.
+func (a *AOF) sync() {
+	if err := a.f.Sync(); err != nil {
+		a.setErr(err)
+		return
+	}
+	a.dirty = false
+	a.err = nil
+}
+
+func (a *AOF) fsyncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			// fsync outside the lock so appends are not held up by the disk.
+			a.mu.Lock()
+			f, dirty := a.f, a.dirty && a.policy == FsyncEverySec
+			if dirty {
+				a.dirty = false
+			}
+			a.mu.Unlock()
+			if !dirty {
+				continue
+			}
+			if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				a.mu.Lock()
+				a.setErr(err)
+				a.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Close flushes and closes the log.
+func (a *AOF) Close() error {
+	a.closeOnce.Do(func() { close(a.done) })
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// StartRewrite begins buffering appended commands for a rewrite. It must be
+// called at the same instant the snapshot passed to FinishRewrite is taken,
+// so that the buffered commands are exactly those missing from the snapshot.
+func (a *AOF) StartRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriteBuf != nil {
+		return ErrRewriteInProgress
+	}
+	a.rewriteBuf = new(bytes.Buffer)
+	a.rewriteEnc = resp.NewEncoder(a.rewriteBuf)
+	return nil
+}
+
+// FinishRewrite writes the minimal set of commands that recreate snap to a
+// temporary file, appends the commands buffered since StartRewrite, and
+// atomically replaces the log with it. Clients are only blocked while the
+// buffered tail is written and the files are swapped.
+func (a *AOF) FinishRewrite(snap *storage.Snapshot) error {
+	err := a.rewrite(snap)
+	if err != nil {
+		a.mu.Lock()
+		a.rewriteBuf, a.rewriteEnc = nil, nil
+		a.mu.Unlock()
+	}
+	return err
+}
+
+func (a *AOF) rewrite(snap *storage.Snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), "temp-rewriteaof-*.aof")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if err := writeSnapshot(w, snap); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.rewriteBuf.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	a.f.Close()
+	a.f = f
+	a.enc = resp.NewEncoder(f)
+	a.rewriteBuf, a.rewriteEnc = nil, nil
+	a.dirty = false
+	// The new log holds every command, whatever failed to reach the old one.
+	a.err = nil
+	return nil
+}
+
+// rewriteBatch is the maximum number of elements per command when a
+// collection is rewritten, keeping each command reasonably small. It is even
+// so that hash fields and sorted set scores stay next to their pair.
+const rewriteBatch = 64
+
+// writeSnapshot writes the commands that recreate snap to w.
+func writeSnapshot(w *bufio.Writer, snap *storage.Snapshot) error {
+	enc := resp.NewEncoder(w)
+	return snap.Walk(func(e storage.Entry) error {
+		var cmds [][]resp.Value
+		switch e.Kind {
+		case storage.KindString:
+			args := []resp.Value{bulk("SET"), bulk(e.Key), {Type: resp.TypeBulkString, Bytes: e.Value}}
+			if !e.ExpireAt.IsZero() {
+				args = append(args, bulk("PXAT"), bulk(strconv.FormatInt(e.ExpireAt.UnixMilli(), 10)))
+			}
+			cmds = append(cmds, args)
+		case storage.KindList:
+			cmds = batches("RPUSH", e.Key, e.Elems)
+		case storage.KindHash:
+			cmds = batches("HSET", e.Key, e.Elems)
+		case storage.KindSet:
+			cmds = batches("SADD", e.Key, e.Elems)
+		case storage.KindZSet:
+			cmds = batches("ZADD", e.Key, e.Elems)
+		case storage.KindStream:
+			cmds = streamCommands(e.Key, e.Stream)
+		default:
+			return fmt.Errorf("aof: cannot rewrite %s: unsupported kind %s", e.Key, e.Kind)
+		}
+		if e.Kind != storage.KindString && !e.ExpireAt.IsZero() {
+			cmds = append(cmds, []resp.Value{bulk("PEXPIREAT"), bulk(e.Key), bulk(strconv.FormatInt(e.ExpireAt.UnixMilli(), 10))})
+		}
+
+		for _, args := range cmds {
+			if err := enc.Encode(resp.Value{Type: resp.TypeArray, Array: args}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// batches splits the elements of a collection into commands of the form
+// "name key elem...".
+func batches(name, key string, elems [][]byte) [][]resp.Value {
+	var cmds [][]resp.Value
+	for len(elems) > 0 {
+		n := min(len(elems), rewriteBatch)
+		args := make([]resp.Value, 0, n+2)
+		args = append(args, bulk(name), bulk(key))
+		for _, v := range elems[:n] {
+			args = append(args, resp.Value{Type: resp.TypeBulkString, Bytes: v})
+		}
+		cmds = append(cmds, args)
+		elems = elems[n:]
+	}
+	return cmds
+}
+
+// streamCommands returns the commands that recreate a stream, its consumer
+// groups and their pending entries. Pending entries deleted from the stream
+// cannot be claimed back and are dropped, as Redis does.
+func streamCommands(key string, st *storage.StreamValue) [][]resp.Value {
+	var cmds [][]resp.Value
+	for _, ent := range st.Entries {
+		args := []resp.Value{bulk("XADD"), bulk(key), bulk(ent.ID.String())}
+		for _, f := range ent.Fields {
+			args = append(args, resp.Value{Type: resp.TypeBulkString, Bytes: f})
+		}
+		cmds = append(cmds, args)
+	}
+	if n := len(st.Entries); n == 0 || st.Entries[n-1].ID != st.LastID {
+		if n == 0 {
+			// Create the stream with an entry trimmed straight away. Its ID
+			// must be valid even if the stream never had any.
+			id := st.LastID
+			if id == (storage.StreamID{}) {
+				id.Seq = 1
+			}
+			cmds = append(cmds, []resp.Value{bulk("XADD"), bulk(key), bulk("MAXLEN"), bulk("0"), bulk(id.String()), bulk("x"), bulk("y")})
+		}
+		cmds = append(cmds, []resp.Value{bulk("XSETID"), bulk(key), bulk(st.LastID.String())})
+	}
+	for _, g := range st.Groups {
+		cmds = append(cmds, []resp.Value{bulk("XGROUP"), bulk("CREATE"), bulk(key), bulk(g.Name), bulk(g.LastID.String())})
+		for _, c := range g.Consumers {
+			cmds = append(cmds, []resp.Value{bulk("XGROUP"), bulk("CREATECONSUMER"), bulk(key), bulk(g.Name), bulk(c.Name)})
+		}
+		for _, p := range g.Pending {
+			cmds = append(cmds, []resp.Value{
+				bulk("XCLAIM"), bulk(key), bulk(g.Name), bulk(p.Consumer), bulk("0"), bulk(p.ID.String()),
+				bulk("TIME"), bulk(strconv.FormatInt(p.DeliveredAt.UnixMilli(), 10)),
+				bulk("RETRYCOUNT"), bulk(strconv.Itoa(p.Deliveries)),
+				bulk("FORCE"), bulk("JUSTID"),
+			})
+		}
+	}
+	return cmds
+}
+
+// Load replays the log at path through exe and returns the number of commands
+// applied. A missing file is not an error. A command cut short at the end of
+// the file, as left by a crash mid-write, is truncated away with a warning.
+func Load(path string, exe Executor) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	cr := &countingReader{r: f}
+	br := bufio.NewReader(cr)
+	dec := resp.NewDecoder(br)
+	// Commands were bounded when they were accepted, possibly with limits
+	// since raised, so the log must not be held to the defaults.
+	dec.SetLimits(resp.NoLimits)
+	n := 0
+	var offset int64
+	// A transaction is buffered from MULTI to EXEC and applied as a whole.
+	// txStart is the offset of its MULTI, where an incomplete one is cut off.
+	var tx []resp.Value
+	var txStart int64 = -1
+	for {
+		cmd, err := dec.Decode()
+		if err != nil {
+			if offset == info.Size() && txStart < 0 {
+				return n, nil
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				if txStart >= 0 {
+					offset = txStart
+				}
+				log.Printf("aof: truncating incomplete command at offset %d of %s", offset, path)
+				return n, f.Truncate(offset)
+			}
+			return n, fmt.Errorf("aof: bad format at offset %d: %w", offset, err)
+		}
+
+		var outs []resp.Value
+		switch name := commandName(cmd); {
+		case name == "multi" && txStart < 0:
+			txStart = offset
+		case name == "exec" && txStart >= 0:
+			outs, err = exe.ExecuteAll(tx)
+			n += len(tx)
+			tx, txStart = nil, -1
+		case txStart >= 0:
+			tx = append(tx, cmd)
+		default:
+			var out resp.Value
+			out, err = exe.Execute(cmd)
+			outs = []resp.Value{out}
+			n++
+		}
+		if err != nil {
+			return n, fmt.Errorf("aof: replaying command at offset %d: %w", offset, err)
+		}
+		for _, out := range outs {
+			if out.Type == resp.TypeError {
+				return n, fmt.Errorf("aof: replaying command at offset %d: %s", offset, out.Bytes)
+			}
+		}
+		offset = cr.n - int64(br.Buffered())
+	}
+}
+
+func commandName(cmd resp.Value) string {
+	if len(cmd.Array) == 0 {
+		return ""
+	}
+	return strings.ToLower(string(cmd.Array[0].Bytes))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
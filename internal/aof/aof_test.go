@@ -0,0 +1,332 @@
+package aof_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/aof"
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cmd(args ...string) resp.Value {
+	vals := make([]resp.Value, len(args))
+	for i, a := range args {
+		vals[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(a)}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: vals}
+}
+
+// open returns an executor whose writes are logged to a fresh AOF in a
+// temporary directory.
+func open(t *testing.T, path string) (*executor.Executor, *storage.InMemoryStorage, *aof.AOF) {
+	t.Helper()
+	s := storage.NewInMemoryStorage()
+	exe := executor.NewExecutor(s)
+	_, err := aof.Load(path, exe)
+	require.NoError(t, err)
+
+	log, err := aof.Open(path, aof.FsyncAlways)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+	exe.AddPropagator(log)
+	return exe, s, log
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, name := range []string{"always", "everysec", "no"} {
+		p, err := aof.ParseFsyncPolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, p.String())
+	}
+	_, err := aof.ParseFsyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestLoadReplaysLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	exe, _, log := open(t, path)
+	for _, c := range []resp.Value{
+		cmd("SET", "a", "1"),
+		cmd("INCR", "a"),
+		cmd("SET", "b", "x", "EX", "1000"),
+		cmd("SET", "c", "gone"),
+		cmd("DEL", "c"),
+		cmd("GET", "a"),
+		cmd("SET", "a", "2", "BADOPT"),
+	} {
+		_, err := exe.Execute(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "GET", "reads are not logged")
+	assert.NotContains(t, string(data), "BADOPT", "failed commands are not logged")
+	assert.Contains(t, string(data), "PXAT", "relative expiry is logged as absolute")
+
+	s := storage.NewInMemoryStorage()
+	n, err := aof.Load(path, executor.NewExecutor(s))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	v, err := s.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(v))
+	at, err := s.ExpireTime("b")
+	require.NoError(t, err)
+	assert.False(t, at.IsZero())
+	_, err = s.Get("c")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	n, err := aof.Load(filepath.Join(t.TempDir(), "nope.aof"), executor.NewExecutor(storage.NewInMemoryStorage()))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestLoadTruncatesIncompleteTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	require.NoError(t, os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$1\r\nj"), 0o644))
+
+	s := storage.NewInMemoryStorage()
+	n, err := aof.Load(path, executor.NewExecutor(s))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, complete, string(data))
+}
+
+func TestLoadBeyondDecoderLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	exe, _, log := open(t, path)
+	// More elements than a client may send by default, as accepted with a
+	// raised proto-max-multibulk-len.
+	args := []string{"RPUSH", "l"}
+	for i := range resp.DefaultLimits.MaxArrayLen {
+		args = append(args, strconv.Itoa(i))
+	}
+	_, err := exe.Execute(cmd(args...))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	s := storage.NewInMemoryStorage()
+	n, err := aof.Load(path, executor.NewExecutor(s))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	l, err := s.ListLen("l")
+	require.NoError(t, err)
+	assert.Equal(t, resp.DefaultLimits.MaxArrayLen, l)
+}
+
+func TestLoadTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	exe, _, log := open(t, path)
+	_, err := exe.ExecuteAll([]resp.Value{cmd("SET", "a", "1"), cmd("SET", "b", "2")})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	complete, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(complete), "MULTI")
+
+	s := storage.NewInMemoryStorage()
+	n, err := aof.Load(path, executor.NewExecutor(s))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	v, err := s.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(v))
+
+	// A transaction cut short by a crash is dropped as a whole.
+	partial := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$1\r\n3\r\n"
+	require.NoError(t, os.WriteFile(path, append(complete, partial...), 0o644))
+	s = storage.NewInMemoryStorage()
+	n, err = aof.Load(path, executor.NewExecutor(s))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = s.Get("c")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(complete), string(data))
+}
+
+func TestLoadRejectsCorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	require.NoError(t, os.WriteFile(path, []byte("*1\r\n$7\r\nBOGUSXX\r\n"), 0o644))
+
+	_, err := aof.Load(path, executor.NewExecutor(storage.NewInMemoryStorage()))
+	assert.Error(t, err)
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	exe, s, log := open(t, path)
+
+	for range 100 {
+		_, err := exe.Execute(cmd("INCR", "counter"))
+		require.NoError(t, err)
+	}
+	_, err := exe.Execute(cmd("SET", "session", "abc", "PX", "600000"))
+	require.NoError(t, err)
+
+	var snap *storage.Snapshot
+	exe.Barrier(func() {
+		require.NoError(t, log.StartRewrite())
+		snap = s.Snapshot()
+	})
+	assert.ErrorIs(t, log.StartRewrite(), aof.ErrRewriteInProgress)
+
+	// Writes made while the rewrite runs must survive it exactly once.
+	_, err = exe.Execute(cmd("INCR", "counter"))
+	require.NoError(t, err)
+	_, err = exe.Execute(cmd("SET", "late", "1"))
+	require.NoError(t, err)
+
+	require.NoError(t, log.FinishRewrite(snap))
+	_, err = exe.Execute(cmd("INCR", "counter"))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Less(t, len(before), 400, "log was compacted")
+
+	replayed := storage.NewInMemoryStorage()
+	_, err = aof.Load(path, executor.NewExecutor(replayed))
+	require.NoError(t, err)
+
+	v, err := replayed.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "102", string(v))
+	v, err = replayed.Get("late")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(v))
+	at, err := replayed.ExpireTime("session")
+	require.NoError(t, err)
+	assert.False(t, at.IsZero())
+}
+
+func TestRewriteCollections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	exe, s, log := open(t, path)
+
+	var want []string
+	for i := range 200 {
+		v := strconv.Itoa(i)
+		want = append(want, v)
+		_, err := exe.Execute(cmd("RPUSH", "list", v))
+		require.NoError(t, err)
+	}
+	_, err := exe.Execute(cmd("PEXPIRE", "list", "600000"))
+	require.NoError(t, err)
+	for i := range 100 {
+		_, err := exe.Execute(cmd("HSET", "hash", "f"+strconv.Itoa(i), strconv.Itoa(i)))
+		require.NoError(t, err)
+		_, err = exe.Execute(cmd("SADD", "set", strconv.Itoa(i)))
+		require.NoError(t, err)
+		_, err = exe.Execute(cmd("ZADD", "zset", strconv.Itoa(i)+".5", "m"+strconv.Itoa(i)))
+		require.NoError(t, err)
+	}
+	_, err = exe.Execute(cmd("ZADD", "zset", "-inf", "bottom"))
+	require.NoError(t, err)
+
+	var snap *storage.Snapshot
+	exe.Barrier(func() {
+		require.NoError(t, log.StartRewrite())
+		snap = s.Snapshot()
+	})
+	require.NoError(t, log.FinishRewrite(snap))
+	require.NoError(t, log.Close())
+
+	replayed := storage.NewInMemoryStorage()
+	_, err = aof.Load(path, executor.NewExecutor(replayed))
+	require.NoError(t, err)
+
+	got, err := replayed.ListRange("list", 0, -1)
+	require.NoError(t, err)
+	require.Len(t, got, len(want))
+	for i, v := range got {
+		assert.Equal(t, want[i], string(v))
+	}
+	at, err := replayed.ExpireTime("list")
+	require.NoError(t, err)
+	assert.False(t, at.IsZero())
+
+	n, err := replayed.HashLen("hash")
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	vals, err := replayed.HashGet("hash", []byte("f42"))
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(vals[0]))
+	n, err = replayed.SetCard("set")
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	zs, err := replayed.ZRange("zset", storage.ZRange{Start: 0, Stop: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ScoredMember{{Member: []byte("bottom"), Score: math.Inf(-1)}, {Member: []byte("m0"), Score: 0.5}}, zs)
+}
+
+func TestRewriteStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	exe, s, log := open(t, path)
+
+	for _, c := range [][]string{
+		{"XADD", "s", "1-1", "a", "1"},
+		{"XADD", "s", "2-0", "b", "2", "c", "3"},
+		{"XADD", "s", "3-0", "d", "4"},
+		{"XGROUP", "CREATE", "s", "g", "0"},
+		{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"},
+		{"XGROUP", "CREATECONSUMER", "s", "g", "bob"},
+		{"XDEL", "s", "3-0"},
+		{"XGROUP", "CREATE", "empty", "g", "$", "MKSTREAM"},
+		{"XADD", "trimmed", "MAXLEN", "0", "5-0", "x", "y"},
+	} {
+		out, err := exe.Execute(cmd(c...))
+		require.NoError(t, err)
+		require.NotEqual(t, resp.TypeError, out.Type, "%v: %s", c, out.Bytes)
+	}
+
+	var snap *storage.Snapshot
+	exe.Barrier(func() {
+		require.NoError(t, log.StartRewrite())
+		snap = s.Snapshot()
+	})
+	require.NoError(t, log.FinishRewrite(snap))
+	require.NoError(t, log.Close())
+
+	replayed := storage.NewInMemoryStorage()
+	_, err := aof.Load(path, executor.NewExecutor(replayed))
+	require.NoError(t, err)
+
+	streams := func(s storage.Storage) map[string]*storage.StreamValue {
+		out := make(map[string]*storage.StreamValue)
+		require.NoError(t, s.Snapshot().Walk(func(e storage.Entry) error {
+			// Replaying claims marks consumers as seen.
+			for i := range e.Stream.Groups {
+				for j := range e.Stream.Groups[i].Consumers {
+					e.Stream.Groups[i].Consumers[j].SeenAt = time.Time{}
+				}
+			}
+			out[e.Key] = e.Stream
+			return nil
+		}))
+		return out
+	}
+	assert.Equal(t, streams(s), streams(replayed))
+}
@@ -0,0 +1,119 @@
+// Package certs keeps the certificates of TLS listeners. Certificates are
+// read from files and can be reloaded while the server runs, so that they can
+// be rotated without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Options locate the certificates and set whether clients must present
+// one.
+type Options struct {
+	// CertFile and KeyFile hold the server's certificate and private key in
+	// PEM format.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the PEM certificates of the authorities client
+	// certificates are verified against.
+	CAFile string
+	// ClientAuth sets whether clients must present a certificate.
+	ClientAuth tls.ClientAuthType
+}
+
+// ParseClientAuth parses the client certificate policy: "no", "optional" or
+// "yes".
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "no":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("invalid client certificate policy %q", s)
+	}
+}
+
+// Store holds the configuration TLS connections are accepted with.
+type Store struct {
+	// mu serializes reloads.
+	mu     sync.Mutex
+	opts   Options
+	config atomic.Pointer[tls.Config]
+}
+
+// New loads the certificates described by opts.
+func New(opts Options) (*Store, error) {
+	s := &Store{opts: opts}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the certificates again. Connections accepted afterwards use
+// the new ones, and those already established are unaffected. If the files
+// cannot be read the certificates in use are kept.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(s.opts)
+}
+
+// Update replaces the options and loads the certificates they describe. If
+// that fails the options and certificates in use are kept.
+func (s *Store) Update(opts Options) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(opts); err != nil {
+		return err
+	}
+	s.opts = opts
+	return nil
+}
+
+func (s *Store) load(opts Options) error {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   opts.ClientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in " + opts.CAFile)
+		}
+		config.ClientCAs = pool
+	} else if opts.ClientAuth != tls.NoClientCert {
+		return errors.New("verifying client certificates needs a CA certificate file")
+	}
+	s.config.Store(config)
+	return nil
+}
+
+// Config returns the configuration for a TLS listener. It picks up the
+// certificates in use for each new connection.
+func (s *Store) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.config.Load(), nil
+		},
+	}
+}
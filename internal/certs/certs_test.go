@@ -0,0 +1,117 @@
+package certs_test
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/certs"
+	"github.com/elmq0022/kv-store/internal/certs/certstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientAuth(t *testing.T) {
+	for in, want := range map[string]tls.ClientAuthType{
+		"no":       tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"YES":      tls.RequireAndVerifyClientCert,
+	} {
+		got, err := certs.ParseClientAuth(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := certs.ParseClientAuth("maybe")
+	assert.Error(t, err)
+}
+
+// serverCN connects with the client configuration to a TLS listener using
+// config, and returns the common name of the certificate the server presents.
+func serverCN(t *testing.T, config *tls.Config, client *tls.Config) (string, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := tls.Server(conn, config)
+		if tc.Handshake() == nil {
+			tc.Write([]byte{'+'})
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// With TLS 1.3 the server verifies the client's certificate after the
+	// client completes the handshake, so wait for it to accept the client.
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t)
+	certFile, keyFile := ca.WriteIssued(t, dir, "server")
+	caFile := ca.WriteCert(t, dir)
+
+	store, err := certs.New(certs.Options{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ClientAuth: tls.RequireAndVerifyClientCert})
+	require.NoError(t, err)
+	client := &tls.Config{RootCAs: ca.Pool(), Certificates: []tls.Certificate{ca.Issue(t, "alice")}}
+
+	cn, err := serverCN(t, store.Config(), client)
+	require.NoError(t, err)
+	assert.Equal(t, "server", cn)
+
+	t.Run("client certificates are required", func(t *testing.T) {
+		_, err := serverCN(t, store.Config(), &tls.Config{RootCAs: ca.Pool()})
+		assert.Error(t, err)
+		other := certstest.NewCA(t)
+		_, err = serverCN(t, store.Config(), &tls.Config{RootCAs: ca.Pool(), Certificates: []tls.Certificate{other.Issue(t, "mallory")}})
+		assert.Error(t, err)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		config := store.Config()
+		renamed, renamedKey := ca.WriteIssued(t, dir, "renamed")
+		require.NoError(t, copyFile(renamed, certFile))
+		require.NoError(t, copyFile(renamedKey, keyFile))
+		require.NoError(t, store.Reload())
+
+		cn, err := serverCN(t, config, client)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", cn, "listeners pick up reloaded certificates")
+
+		require.NoError(t, copyFile(caFile, keyFile))
+		assert.Error(t, store.Reload())
+		cn, err = serverCN(t, config, client)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", cn, "a failed reload keeps the certificates in use")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := certs.New(certs.Options{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")})
+		assert.Error(t, err)
+		certFile, keyFile := ca.WriteIssued(t, dir, "other")
+		_, err = certs.New(certs.Options{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert})
+		assert.Error(t, err, "verifying clients needs a CA")
+	})
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o600)
+}
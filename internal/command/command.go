@@ -0,0 +1,369 @@
+// Package command describes the commands the server understands: how many
+// arguments they take, where their keys are and how they behave. The
+// descriptions drive dispatch and argument validation, and are what COMMAND
+// reports to clients.
+package command
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// Flags describe how a command behaves.
+type Flags uint32
+
+const (
+	// Write marks commands that may modify the keyspace.
+	Write Flags = 1 << iota
+	// ReadOnly marks commands that read keys without modifying them.
+	ReadOnly
+	// DenyOOM marks commands that may grow memory usage, and so are refused
+	// once the memory limit is reached.
+	DenyOOM
+	// Admin marks administrative commands.
+	Admin
+	// PubSub marks commands of the publish/subscribe family.
+	PubSub
+	// NoScript marks commands that cannot be called from scripts.
+	NoScript
+	// Blocking marks commands that may wait for keys to change.
+	Blocking
+	// SkipMonitor marks commands not shown to monitoring clients.
+	SkipMonitor
+	// SkipSlowlog marks commands never recorded as slow.
+	SkipSlowlog
+	// Fast marks commands that run in constant or logarithmic time.
+	Fast
+	// NoAuth marks commands that may run before the client authenticates.
+	NoAuth
+	// NoMulti marks commands that cannot be queued in a transaction.
+	NoMulti
+)
+
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{Write, "write"},
+	{ReadOnly, "readonly"},
+	{DenyOOM, "denyoom"},
+	{Admin, "admin"},
+	{PubSub, "pubsub"},
+	{NoScript, "noscript"},
+	{Blocking, "blocking"},
+	{SkipMonitor, "skip_monitor"},
+	{SkipSlowlog, "skip_slowlog"},
+	{Fast, "fast"},
+	{NoAuth, "no_auth"},
+	{NoMulti, "no_multi"},
+}
+
+// Has reports whether all of flags are set in f.
+func (f Flags) Has(flags Flags) bool {
+	return f&flags == flags
+}
+
+// Categories are the ACL categories commands belong to, without the
+// leading @.
+var Categories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string",
+	"bitmap", "hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow",
+	"blocking", "dangerous", "connection", "transaction", "scripting",
+}
+
+// groupCategories maps the documentation group of a command to the ACL
+// category it belongs to because of it.
+var groupCategories = map[string]string{
+	"generic":      "keyspace",
+	"string":       "string",
+	"list":         "list",
+	"hash":         "hash",
+	"set":          "set",
+	"sorted-set":   "sortedset",
+	"stream":       "stream",
+	"pubsub":       "pubsub",
+	"transactions": "transaction",
+	"connection":   "connection",
+}
+
+// Spec describes a command.
+type Spec struct {
+	// Name is the lowercase command name. Subcommands are named after their
+	// container, as in "xgroup|create".
+	Name string
+	// Arity is the number of arguments, the command name included. A
+	// negative arity is the minimum number of arguments.
+	Arity int
+	Flags Flags
+	// FirstKey, LastKey and Step give the positions of the keys among the
+	// arguments. A negative LastKey counts from the end, and a zero FirstKey
+	// means the command takes no keys.
+	FirstKey, LastKey, Step int
+	// Keys, if set, finds the positions of the keys of commands whose keys
+	// are not at fixed positions, such as those taking a number of keys.
+	// FirstKey, LastKey and Step then only describe the keys that are, as
+	// the destination of ZUNIONSTORE, for COMMAND INFO.
+	Keys func(args []resp.Value) []int
+	// WrittenKeys is the number of keys, counted from the first, that a
+	// write command writes, if it only reads the others, as SUNIONSTORE
+	// does its sources. Zero means that it writes all of them.
+	WrittenKeys int
+	// Categories are the ACL categories of the command besides the ones
+	// following from its group and flags.
+	Categories []string
+
+	// Group, Since and Summary document the command.
+	Group   string
+	Since   string
+	Summary string
+
+	// Subcommands are the commands of a container command, named by its
+	// first argument.
+	Subcommands []*Spec
+}
+
+// CheckArity reports whether n arguments, the command name included, are
+// acceptable.
+func (s *Spec) CheckArity(n int) bool {
+	if s.Arity < 0 {
+		return n >= -s.Arity
+	}
+	return n == s.Arity
+}
+
+// Subcommand returns the subcommand of a container command with the given
+// name.
+func (s *Spec) Subcommand(name string) (*Spec, bool) {
+	full := s.Name + "|" + strings.ToLower(name)
+	for _, sub := range s.Subcommands {
+		if sub.Name == full {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// Resolve returns the spec of the subcommand args names, or s itself if s is
+// not a container or args names no subcommand of it.
+func (s *Spec) Resolve(args []resp.Value) *Spec {
+	if len(s.Subcommands) == 0 || len(args) < 2 {
+		return s
+	}
+	if sub, ok := s.Subcommand(string(args[1].Bytes)); ok {
+		return sub
+	}
+	return s
+}
+
+// KeyIndexes returns the positions of the keys in args, the command name
+// included.
+func (s *Spec) KeyIndexes(args []resp.Value) []int {
+	if s.Keys != nil {
+		return s.Keys(args)
+	}
+	if s.FirstKey == 0 {
+		return nil
+	}
+	last := s.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	var out []int
+	for i := s.FirstKey; i <= last && i < len(args); i += s.Step {
+		out = append(out, i)
+	}
+	return out
+}
+
+// Key is the position of a key among the arguments of a command, and
+// whether the command writes it or only reads it.
+type Key struct {
+	Index int
+	Write bool
+}
+
+// KeyAccess returns the keys in args, as KeyIndexes does, with the access
+// the command needs to each.
+func (s *Spec) KeyAccess(args []resp.Value) []Key {
+	idx := s.KeyIndexes(args)
+	out := make([]Key, len(idx))
+	for i, j := range idx {
+		write := s.Flags.Has(Write) && (s.WrittenKeys == 0 || i < s.WrittenKeys)
+		out[i] = Key{Index: j, Write: write}
+	}
+	return out
+}
+
+// FlagNames returns the names of the command's flags, as reported by
+// COMMAND INFO.
+func (s *Spec) FlagNames() []string {
+	var out []string
+	for _, f := range flagNames {
+		if s.Flags.Has(f.flag) {
+			out = append(out, f.name)
+		}
+	}
+	if s.Keys != nil {
+		out = append(out, "movablekeys")
+	}
+	return out
+}
+
+// ACLCategories returns the ACL categories of the command, without the
+// leading @.
+func (s *Spec) ACLCategories() []string {
+	var out []string
+	add := func(c string) {
+		if !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	if s.Flags.Has(Write) {
+		add("write")
+	}
+	if s.Flags.Has(ReadOnly) {
+		add("read")
+	}
+	if s.Flags.Has(Admin) {
+		add("admin")
+		add("dangerous")
+	}
+	if s.Flags.Has(PubSub) {
+		add("pubsub")
+	}
+	if s.Flags.Has(Blocking) {
+		add("blocking")
+	}
+	if s.Flags.Has(Fast) {
+		add("fast")
+	} else {
+		add("slow")
+	}
+	if c, ok := groupCategories[s.Group]; ok {
+		add(c)
+	}
+	for _, c := range s.Categories {
+		add(c)
+	}
+	return out
+}
+
+// Info returns the command's COMMAND INFO reply: its name, arity, flags, key
+// positions, ACL categories, tips, key specifications and subcommands.
+func (s *Spec) Info() resp.Value {
+	flags := s.FlagNames()
+	categories := s.ACLCategories()
+	for i, c := range categories {
+		categories[i] = "@" + c
+	}
+	subs := make([]resp.Value, len(s.Subcommands))
+	for i, sub := range s.Subcommands {
+		subs[i] = sub.Info()
+	}
+	return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+		bulk(s.Name),
+		integer(s.Arity),
+		set(flags),
+		integer(s.FirstKey),
+		integer(s.LastKey),
+		integer(s.Step),
+		set(categories),
+		set(nil),
+		{Type: resp.TypeArray, Array: []resp.Value{}},
+		{Type: resp.TypeArray, Array: subs},
+	}}
+}
+
+// Docs returns the command's COMMAND DOCS reply, a map of its summary, the
+// version it appeared in, its group and the docs of its subcommands.
+func (s *Spec) Docs() resp.Value {
+	docs := []resp.Value{
+		bulk("summary"), bulk(s.Summary),
+		bulk("since"), bulk(s.Since),
+		bulk("group"), bulk(s.Group),
+	}
+	if len(s.Subcommands) > 0 {
+		subs := make([]resp.Value, 0, 2*len(s.Subcommands))
+		for _, sub := range s.Subcommands {
+			subs = append(subs, bulk(sub.Name), sub.Docs())
+		}
+		docs = append(docs, bulk("subcommands"), resp.Value{Type: resp.TypeMap, Array: subs})
+	}
+	return resp.Value{Type: resp.TypeMap, Array: docs}
+}
+
+// Table is a set of commands, looked up by name.
+type Table struct {
+	specs map[string]*Spec
+}
+
+// NewTable returns a table of the given commands. A command listed more than
+// once is described by its last listing.
+func NewTable(lists ...[]*Spec) *Table {
+	t := &Table{specs: make(map[string]*Spec)}
+	for _, list := range lists {
+		for _, s := range list {
+			t.specs[s.Name] = s
+		}
+	}
+	return t
+}
+
+// Lookup returns the command with the given name, in any case. Subcommands
+// are looked up by their full name, as in "xgroup|create".
+func (t *Table) Lookup(name string) (*Spec, bool) {
+	name = strings.ToLower(name)
+	container, sub, ok := strings.Cut(name, "|")
+	s, found := t.specs[container]
+	if !found || !ok {
+		return s, found
+	}
+	return s.Subcommand(sub)
+}
+
+// Find returns the command args invoke, resolved to a subcommand if args
+// name one, or false if the command is unknown.
+func (t *Table) Find(args []resp.Value) (*Spec, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	s, ok := t.specs[strings.ToLower(string(args[0].Bytes))]
+	if !ok {
+		return nil, false
+	}
+	return s.Resolve(args), true
+}
+
+// All returns the commands in the table, ordered by name.
+func (t *Table) All() []*Spec {
+	out := make([]*Spec, 0, len(t.specs))
+	for _, s := range t.specs {
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b *Spec) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// Len returns the number of commands in the table, not counting subcommands.
+func (t *Table) Len() int {
+	return len(t.specs)
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
+
+func integer(n int) resp.Value {
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))}
+}
+
+func set(members []string) resp.Value {
+	out := make([]resp.Value, len(members))
+	for i, m := range members {
+		out[i] = resp.Value{Type: resp.TypeSimpleString, Bytes: []byte(m)}
+	}
+	return resp.Value{Type: resp.TypeSet, Array: out}
+}
@@ -0,0 +1,113 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func args(words ...string) []resp.Value {
+	out := make([]resp.Value, len(words))
+	for i, w := range words {
+		out[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(w)}
+	}
+	return out
+}
+
+func strs(vals []resp.Value) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = string(v.Bytes)
+	}
+	return out
+}
+
+func TestSpec(t *testing.T) {
+	del := &command.Spec{Name: "del", Arity: -2, Flags: command.Write, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic"}
+	get := &command.Spec{Name: "get", Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1, Group: "string"}
+	mset := &command.Spec{Name: "mset", Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: -1, Step: 2, Group: "string"}
+
+	t.Run("arity", func(t *testing.T) {
+		assert.True(t, get.CheckArity(2))
+		assert.False(t, get.CheckArity(3))
+		assert.False(t, del.CheckArity(1))
+		assert.True(t, del.CheckArity(5))
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		assert.Equal(t, []int{1, 2, 3}, del.KeyIndexes(args("del", "a", "b", "c")))
+		assert.Equal(t, []int{1, 3}, mset.KeyIndexes(args("mset", "a", "1", "b", "2")))
+		assert.Nil(t, (&command.Spec{Name: "ping", Arity: -1}).KeyIndexes(args("ping")))
+
+		movable := &command.Spec{Name: "m", Arity: -2, Keys: func([]resp.Value) []int { return []int{2} }}
+		assert.Equal(t, []int{2}, movable.KeyIndexes(args("m", "x", "k")))
+		assert.Contains(t, movable.FlagNames(), "movablekeys")
+	})
+
+	t.Run("key access", func(t *testing.T) {
+		store := &command.Spec{Name: "sunionstore", Arity: -3, Flags: command.Write, FirstKey: 1, LastKey: -1, Step: 1, WrittenKeys: 1}
+		assert.Equal(t, []command.Key{{Index: 1, Write: true}, {Index: 2}, {Index: 3}}, store.KeyAccess(args("sunionstore", "d", "a", "b")))
+		assert.Equal(t, []command.Key{{Index: 1, Write: true}, {Index: 2, Write: true}}, del.KeyAccess(args("del", "a", "b")))
+		assert.Equal(t, []command.Key{{Index: 1}}, get.KeyAccess(args("get", "a")))
+	})
+
+	t.Run("categories", func(t *testing.T) {
+		assert.Equal(t, []string{"read", "fast", "string"}, get.ACLCategories())
+		assert.Equal(t, []string{"write", "slow", "keyspace"}, del.ACLCategories())
+		admin := &command.Spec{Name: "save", Arity: 1, Flags: command.Admin, Group: "server"}
+		assert.Equal(t, []string{"admin", "dangerous", "slow"}, admin.ACLCategories())
+	})
+
+	t.Run("info", func(t *testing.T) {
+		info := mset.Info()
+		require.Len(t, info.Array, 10)
+		assert.Equal(t, "mset", string(info.Array[0].Bytes))
+		assert.Equal(t, "-3", string(info.Array[1].Bytes))
+		assert.Equal(t, resp.TypeSet, info.Array[2].Type)
+		assert.Equal(t, []string{"write", "denyoom"}, strs(info.Array[2].Array))
+		assert.Equal(t, []string{"1", "-1", "2"}, strs(info.Array[3:6]))
+		assert.Equal(t, []string{"@write", "@slow", "@string"}, strs(info.Array[6].Array))
+	})
+}
+
+func TestTable(t *testing.T) {
+	group := &command.Spec{
+		Name: "xgroup", Arity: -2,
+		Subcommands: []*command.Spec{
+			{Name: "xgroup|create", Arity: -5, Flags: command.Write, FirstKey: 2, LastKey: 2, Step: 1},
+		},
+	}
+	table := command.NewTable(
+		[]*command.Spec{{Name: "get", Arity: 2}, group},
+		[]*command.Spec{{Name: "ping", Arity: -1}},
+	)
+
+	assert.Equal(t, 3, table.Len())
+	names := []string{}
+	for _, s := range table.All() {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"get", "ping", "xgroup"}, names)
+
+	s, ok := table.Lookup("GET")
+	require.True(t, ok)
+	assert.Equal(t, "get", s.Name)
+	s, ok = table.Lookup("XGROUP|Create")
+	require.True(t, ok)
+	assert.Equal(t, "xgroup|create", s.Name)
+	_, ok = table.Lookup("xgroup|nope")
+	assert.False(t, ok)
+
+	s, ok = table.Find(args("xgroup", "CREATE", "s", "g", "$"))
+	require.True(t, ok)
+	assert.Equal(t, "xgroup|create", s.Name)
+	assert.Equal(t, []int{2}, s.KeyIndexes(args("xgroup", "CREATE", "s", "g", "$")))
+	s, ok = table.Find(args("xgroup", "bogus"))
+	require.True(t, ok)
+	assert.Equal(t, "xgroup", s.Name, "unknown subcommands are left to the container")
+	_, ok = table.Find(args("nope"))
+	assert.False(t, ok)
+}
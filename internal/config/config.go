@@ -0,0 +1,304 @@
+// Package config holds the server's configuration parameters. Parameters
+// are set from a redis.conf style file and from command-line flags at
+// startup, read and changed with CONFIG GET and CONFIG SET while the server
+// runs, and written back to the file with CONFIG REWRITE.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elmq0022/kv-store/internal/glob"
+)
+
+// Kind is the type of a parameter's values.
+type Kind int
+
+const (
+	// String parameters take any value.
+	String Kind = iota
+	// Int parameters take integers.
+	Int
+	// Bool parameters take "yes" or "no".
+	Bool
+	// Enum parameters take one of a fixed set of values.
+	Enum
+	// Memory parameters take a number of bytes, optionally with a unit such
+	// as "mb", and are reported in bytes.
+	Memory
+)
+
+// Param describes a parameter.
+type Param struct {
+	Name    string
+	Kind    Kind
+	Default string
+	// Usage describes the parameter, for command-line help.
+	Usage string
+	// Min and Max bound the values of Int and Memory parameters. A zero Max
+	// leaves them unbounded above.
+	Min, Max int64
+	// Choices are the values an Enum parameter takes.
+	Choices []string
+	// Immutable parameters can only be set at startup.
+	Immutable bool
+	// Repeat joins the values of a parameter given on several lines of a
+	// file instead of keeping the last one, as for "save".
+	Repeat bool
+	// Check, if set, further validates values.
+	Check func(value string) error
+}
+
+// normalize validates value and returns it in canonical form: integers for
+// Int and Memory parameters and "yes" or "no" for Bool parameters.
+func (p *Param) normalize(value string) (string, error) {
+	switch p.Kind {
+	case Int, Memory:
+		var n int64
+		var err error
+		if p.Kind == Int {
+			n, err = strconv.ParseInt(value, 10, 64)
+		} else {
+			n, err = ParseMemory(value)
+		}
+		if err != nil {
+			return "", fmt.Errorf("argument couldn't be parsed into an integer")
+		}
+		if p.Max == 0 && n < p.Min {
+			return "", fmt.Errorf("argument must be at least %d", p.Min)
+		}
+		if p.Max != 0 && (n < p.Min || n > p.Max) {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", p.Min, p.Max)
+		}
+		value = strconv.FormatInt(n, 10)
+	case Bool:
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			value = "yes"
+		case "no", "false":
+			value = "no"
+		default:
+			return "", errors.New("argument must be 'yes' or 'no'")
+		}
+	case Enum:
+		value = strings.ToLower(value)
+		if !slices.Contains(p.Choices, value) {
+			return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(p.Choices, ", "))
+		}
+	}
+	if p.Check != nil {
+		if err := p.Check(value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// ParseMemory parses a number of bytes with an optional unit: k, m or g for
+// powers of 1000, and kb, mb or gb for powers of 1024, in any case.
+func ParseMemory(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	} {
+		if rest, ok := strings.CutSuffix(lower, u.suffix); ok {
+			lower, mul = rest, u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * mul, nil
+}
+
+// watcher applies changes to a set of parameters.
+type watcher struct {
+	apply func() error
+	names []string
+}
+
+// Config holds the value of every parameter.
+type Config struct {
+	params []*Param
+	byName map[string]*Param
+
+	mu     sync.RWMutex
+	values map[string]string
+	// path is the file the configuration was loaded from, which Rewrite
+	// writes to.
+	path string
+
+	// setMu serializes Apply, so that watchers see the changes of one call
+	// at a time.
+	setMu    sync.Mutex
+	watchers []*watcher
+}
+
+// New returns a configuration of the given parameters, set to their
+// defaults. It panics if a default is invalid.
+func New(params []*Param) *Config {
+	c := &Config{
+		params: params,
+		byName: make(map[string]*Param, len(params)),
+		values: make(map[string]string, len(params)),
+	}
+	for _, p := range params {
+		v, err := p.normalize(p.Default)
+		if err != nil {
+			panic(fmt.Sprintf("config: invalid default for %s: %v", p.Name, err))
+		}
+		c.byName[p.Name] = p
+		c.values[p.Name] = v
+	}
+	return c
+}
+
+// Params returns the parameters, in the order they were given to New.
+func (c *Config) Params() []*Param {
+	return c.params
+}
+
+// Set sets a parameter, immutable or not, without notifying watchers. It is
+// meant for setting up the configuration at startup.
+func (c *Config) Set(name, value string) error {
+	p, ok := c.byName[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown parameter '%s'", name)
+	}
+	v, err := p.normalize(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for '%s': %w", p.Name, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[p.Name] = v
+	return nil
+}
+
+// Watch registers apply to be called after Apply changes any of the named
+// parameters, once per call however many of them change. If apply returns
+// an error the change is undone.
+func (c *Config) Watch(apply func() error, names ...string) {
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+	c.watchers = append(c.watchers, &watcher{apply: apply, names: names})
+}
+
+// Apply sets parameters while the server runs, as CONFIG SET does, from
+// alternating names and values. Either all of them are set or, if any is
+// unknown, immutable or given an invalid value, or a watcher fails, none.
+func (c *Config) Apply(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	c.setMu.Lock()
+	defer c.setMu.Unlock()
+
+	changes := make(map[string]string)
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		p, ok := c.byName[name]
+		if !ok {
+			return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])
+		}
+		if p.Immutable {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", p.Name)
+		}
+		if _, dup := changes[name]; dup {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", p.Name)
+		}
+		v, err := p.normalize(pairs[i+1])
+		if err != nil {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %w", p.Name, err)
+		}
+		changes[name] = v
+	}
+
+	old := c.swap(changes)
+	var applied []*watcher
+	for _, w := range c.watchers {
+		i := slices.IndexFunc(w.names, func(n string) bool { _, ok := changes[n]; return ok })
+		if i < 0 {
+			continue
+		}
+		if err := w.apply(); err != nil {
+			// Put the previous values back in force.
+			c.swap(old)
+			for _, w := range applied {
+				w.apply()
+			}
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %w", w.names[i], err)
+		}
+		applied = append(applied, w)
+	}
+	return nil
+}
+
+// swap sets the given values and returns the ones they replaced.
+func (c *Config) swap(values map[string]string) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := make(map[string]string, len(values))
+	for name, v := range values {
+		old[name] = c.values[name]
+		c.values[name] = v
+	}
+	return old
+}
+
+// Get returns the names and values of the parameters matching any of the
+// glob patterns, ordered by name.
+func (c *Config) Get(patterns ...string) [][2]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out [][2]string
+	for _, p := range c.params {
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), p.Name) {
+				out = append(out, [2]string{p.Name, c.values[p.Name]})
+				break
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	return out
+}
+
+// value returns the value of a parameter, which must exist.
+func (c *Config) value(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.values[name]
+	if !ok {
+		panic("config: unknown parameter " + name)
+	}
+	return v
+}
+
+// String returns the value of a parameter.
+func (c *Config) String(name string) string {
+	return c.value(name)
+}
+
+// Int returns the value of an Int or Memory parameter.
+func (c *Config) Int(name string) int64 {
+	n, _ := strconv.ParseInt(c.value(name), 10, 64)
+	return n
+}
+
+// Bool returns the value of a Bool parameter.
+func (c *Config) Bool(name string) bool {
+	return c.value(name) == "yes"
+}
@@ -0,0 +1,169 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func params() []*config.Param {
+	return []*config.Param{
+		{Name: "port", Kind: config.Int, Default: "6379", Max: 65535, Immutable: true},
+		{Name: "maxmemory", Kind: config.Memory, Default: "0"},
+		{Name: "appendonly", Kind: config.Bool, Default: "no"},
+		{Name: "appendfsync", Kind: config.Enum, Default: "everysec", Choices: []string{"always", "everysec", "no"}},
+		{Name: "save", Default: "3600 1", Repeat: true},
+		{Name: "requirepass", Check: func(v string) error {
+			if len(v) > 8 {
+				return errors.New("too long")
+			}
+			return nil
+		}},
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for in, want := range map[string]int64{
+		"100": 100, "1k": 1000, "1kb": 1024, "2MB": 2 << 20, "1g": 1e9, "1gb": 1 << 30, "5b": 5,
+	} {
+		got, err := config.ParseMemory(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := config.ParseMemory("lots")
+	assert.Error(t, err)
+}
+
+func TestSet(t *testing.T) {
+	c := config.New(params())
+	assert.Equal(t, int64(6379), c.Int("port"))
+	assert.False(t, c.Bool("appendonly"))
+
+	require.NoError(t, c.Set("PORT", "7000"), "startup may set immutable parameters")
+	assert.Equal(t, int64(7000), c.Int("port"))
+	require.NoError(t, c.Set("maxmemory", "1mb"))
+	assert.Equal(t, int64(1<<20), c.Int("maxmemory"))
+	require.NoError(t, c.Set("appendonly", "true"))
+	assert.Equal(t, "yes", c.String("appendonly"))
+	require.NoError(t, c.Set("appendfsync", "ALWAYS"))
+	assert.Equal(t, "always", c.String("appendfsync"))
+
+	assert.Error(t, c.Set("nosuch", "1"))
+	assert.Error(t, c.Set("port", "70000"))
+	assert.Error(t, c.Set("port", "x"))
+	assert.Error(t, c.Set("appendonly", "maybe"))
+	assert.Error(t, c.Set("appendfsync", "sometimes"))
+	assert.Error(t, c.Set("requirepass", "much too long"))
+}
+
+func TestApply(t *testing.T) {
+	c := config.New(params())
+	var calls int
+	var fail bool
+	c.Watch(func() error {
+		calls++
+		if fail {
+			return errors.New("cannot apply")
+		}
+		return nil
+	}, "maxmemory", "appendfsync")
+
+	require.NoError(t, c.Apply("maxmemory", "10mb", "appendfsync", "no"))
+	assert.Equal(t, 1, calls, "watchers run once per call")
+	assert.Equal(t, int64(10<<20), c.Int("maxmemory"))
+	require.NoError(t, c.Apply("save", ""))
+	assert.Equal(t, 1, calls, "watchers only run for their parameters")
+
+	assert.EqualError(t, c.Apply("port", "1"), "CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")
+	assert.EqualError(t, c.Apply("nosuch", "1"), "Unknown option or number of arguments for CONFIG SET - 'nosuch'")
+	assert.Error(t, c.Apply("maxmemory", "1", "maxmemory", "2"))
+
+	t.Run("all or nothing", func(t *testing.T) {
+		assert.Error(t, c.Apply("appendonly", "yes", "maxmemory", "x"))
+		assert.False(t, c.Bool("appendonly"))
+
+		fail = true
+		err := c.Apply("appendonly", "yes", "maxmemory", "1")
+		assert.EqualError(t, err, "CONFIG SET failed (possibly related to argument 'maxmemory') - cannot apply")
+		assert.False(t, c.Bool("appendonly"))
+		assert.Equal(t, int64(10<<20), c.Int("maxmemory"))
+	})
+}
+
+func TestGet(t *testing.T) {
+	c := config.New(params())
+	assert.Equal(t, [][2]string{{"appendfsync", "everysec"}, {"appendonly", "no"}}, c.Get("append*"))
+	assert.Equal(t, [][2]string{{"port", "6379"}, {"save", "3600 1"}}, c.Get("SAVE", "port", "save"))
+	assert.Empty(t, c.Get("nosuch"))
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`# A comment
+port 7000
+save 900 1
+save 300 10
+unrelated-line-kept-as-is`+"\n"), 0o644))
+
+	c := config.New(params())
+	assert.Error(t, c.Load(path), "unknown parameters are errors")
+
+	require.NoError(t, os.WriteFile(path, []byte(`# A comment
+port 7000
+
+save 900 1
+save 300 10
+requirepass "a b"
+`), 0o644))
+	require.NoError(t, c.Load(path))
+	assert.Equal(t, int64(7000), c.Int("port"))
+	assert.Equal(t, "900 1 300 10", c.String("save"), "repeated lines are joined")
+	assert.Equal(t, "a b", c.String("requirepass"))
+
+	require.NoError(t, c.Apply("save", "60 5", "appendfsync", "always", "requirepass", `"x"`))
+	require.NoError(t, c.Rewrite())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# A comment
+port 7000
+
+save 60 5
+requirepass "\"x\""
+# Generated by CONFIG REWRITE
+appendfsync always
+`, string(data))
+
+	d := config.New(params())
+	require.NoError(t, d.Load(path))
+	assert.Equal(t, c.Get("*"), d.Get("*"), "a rewritten file loads back the same")
+
+	assert.ErrorIs(t, config.New(params()).Rewrite(), config.ErrNoFile)
+
+	require.NoError(t, os.WriteFile(path, []byte("requirepass \"unterminated\n"), 0o644))
+	assert.ErrorContains(t, c.Load(path), ":1:")
+}
+
+func TestParseFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte("port 7000\nappendfsync no\n"), 0o644))
+
+	c := config.New(params())
+	fs := flag.NewFlagSet("kv", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	require.NoError(t, c.ParseFlags(fs, []string{"-config", path, "-port", "7001", "-appendonly", "-save", ""}))
+	assert.Equal(t, int64(7001), c.Int("port"), "flags take precedence over the file")
+	assert.Equal(t, "no", c.String("appendfsync"))
+	assert.True(t, c.Bool("appendonly"))
+	assert.Equal(t, "", c.String("save"))
+
+	fs = flag.NewFlagSet("kv", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	assert.Error(t, config.New(params()).ParseFlags(fs, []string{"-port", "x"}))
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoFile is returned by Rewrite when the configuration was not loaded
+// from a file.
+var ErrNoFile = errors.New("The server is running without a config file")
+
+// Load sets the parameters given in the file at path, one per line as a name
+// followed by its value. Values may be quoted, and blank lines and lines
+// starting with '#' are ignored. Rewrite writes back to the file.
+func (c *Config) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		name, value, ok, err := parseLine(sc.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if !ok {
+			continue
+		}
+		if p, known := c.byName[name]; known && p.Repeat && seen[name] {
+			value = c.String(name) + " " + value
+		}
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		seen[name] = true
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.path = path
+	return nil
+}
+
+// Path returns the file the configuration was loaded from, or "" if none
+// was.
+func (c *Config) Path() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.path
+}
+
+// Rewrite writes the current configuration back to the file it was loaded
+// from. Lines setting parameters are updated in place and everything else in
+// the file is kept. Parameters set to other than their default that the file
+// does not mention are appended.
+func (c *Config) Rewrite() error {
+	path := c.Path()
+	if path == "" {
+		return ErrNoFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var out []string
+	written := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		name, _, ok, err := parseLine(line)
+		if err != nil || !ok || c.byName[name] == nil {
+			out = append(out, line)
+			continue
+		}
+		if !written[name] {
+			out = append(out, c.format(c.byName[name]))
+			written[name] = true
+		}
+	}
+	header := false
+	for _, p := range c.params {
+		def, _ := p.normalize(p.Default)
+		if written[p.Name] || c.String(p.Name) == def {
+			continue
+		}
+		if !header {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		out = append(out, c.format(p))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// format returns the line setting p to its current value.
+func (c *Config) format(p *Param) string {
+	v := c.String(p.Name)
+	// Lists of values read back the same unquoted.
+	if p.Repeat && v != "" {
+		return p.Name + " " + v
+	}
+	return p.Name + " " + quote(v)
+}
+
+// quote quotes s if it would not be read back as a single word.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// parseLine splits a line into a lowercase parameter name and its value,
+// the words after the name joined by spaces. ok is false for blank lines and
+// comments.
+func parseLine(line string) (name, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	words, err := splitWords(line)
+	if err != nil {
+		return "", "", false, err
+	}
+	return strings.ToLower(words[0]), strings.Join(words[1:], " "), true, nil
+}
+
+// splitWords splits a line into words separated by spaces. Words may be
+// quoted with double quotes, within which backslash escapes the next
+// character, or single quotes.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				if line[i] == c {
+					i++
+					break
+				}
+				if c == '"' && line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+				i++
+			}
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, errors.New("closing quote must be followed by a space")
+			}
+			words = append(words, b.String())
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+				j++
+			}
+			words = append(words, line[i:j])
+			i = j
+		}
+	}
+	return words, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import "flag"
+
+// flagValue is the command-line flag of a parameter. It only records the
+// value given, which is set once the configuration file is loaded.
+type flagValue struct {
+	p     *Param
+	value string
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.p == nil {
+		return ""
+	}
+	if f.value == "" {
+		return f.p.Default
+	}
+	return f.value
+}
+
+func (f *flagValue) Set(s string) error {
+	if _, err := f.p.normalize(s); err != nil {
+		return err
+	}
+	f.value = s
+	return nil
+}
+
+// IsBoolFlag lets Bool parameters be given as a bare flag, as in
+// -appendonly.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.p.Kind == Bool
+}
+
+// ParseFlags defines a flag named after each parameter, and a -config flag
+// naming a configuration file, on fs and parses args. Parameters are set
+// from the file, if one is given, and then from the flags, which take
+// precedence.
+func (c *Config) ParseFlags(fs *flag.FlagSet, args []string) error {
+	path := fs.String("config", "", "configuration file to load, and for CONFIG REWRITE to write")
+	values := make(map[string]*flagValue, len(c.params))
+	for _, p := range c.params {
+		values[p.Name] = &flagValue{p: p}
+		fs.Var(values[p.Name], p.Name, p.Usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *path != "" {
+		if err := c.Load(*path); err != nil {
+			return err
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[f.Name]; ok && err == nil {
+			err = c.Set(f.Name, v.value)
+		}
+	})
+	return err
+}
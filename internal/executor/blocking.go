@@ -0,0 +1,108 @@
+package executor
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// waiters tracks the connections blocked until entries are added to a
+// stream.
+type waiters struct {
+	mu sync.Mutex
+	m  map[string]map[chan struct{}]struct{}
+}
+
+// WaitKeys returns a channel that receives a value once entries are added to
+// any of the streams at keys, and a function to stop waiting. Callers must
+// call it before checking for entries, so none can be missed.
+func (e *Executor) WaitKeys(keys []string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	w := &e.waiters
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m == nil {
+		w.m = make(map[string]map[chan struct{}]struct{})
+	}
+	for _, k := range keys {
+		if w.m[k] == nil {
+			w.m[k] = make(map[chan struct{}]struct{})
+		}
+		w.m[k][ch] = struct{}{}
+	}
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, k := range keys {
+			delete(w.m[k], ch)
+			if len(w.m[k]) == 0 {
+				delete(w.m, k)
+			}
+		}
+	}
+}
+
+// wake notifies the connections waiting on the stream written by a
+// successful command.
+func (e *Executor) wake(name string, args []resp.Value) {
+	if name != cmdXAdd {
+		return
+	}
+	w := &e.waiters
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.m[string(args[1].Bytes)] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// BlockingRead is an XREAD or XREADGROUP command with the BLOCK option.
+type BlockingRead struct {
+	// Cmd is the command to run until it replies with something other than
+	// a nil array: the original one without BLOCK, and with the "$" IDs of
+	// XREAD replaced with the last ID of their stream when it was called.
+	Cmd  resp.Value
+	Keys []string
+	// Timeout is how long to wait for, zero meaning forever.
+	Timeout time.Duration
+}
+
+// Blocking reports whether val is an XREAD or XREADGROUP command with the
+// BLOCK option, and if so prepares it to be run repeatedly by the caller.
+// Invalid commands are not blocking, so that running them reports the error.
+func (e *Executor) Blocking(val resp.Value) (BlockingRead, bool) {
+	name, _, ok := parse(val)
+	if !ok || (name != cmdXRead && name != cmdXReadGroup) {
+		return BlockingRead{}, false
+	}
+	x, _, ok := parseXRead(val.Array, name == cmdXReadGroup)
+	if !ok || !x.block {
+		return BlockingRead{}, false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	args := slices.Concat(val.Array[:x.blockAt], val.Array[x.blockAt+2:])
+	ids := args[len(args)-len(x.ids):]
+	for i, id := range ids {
+		if string(id.Bytes) != "$" {
+			continue
+		}
+		last, err := e.storage.XLastID(x.keys[i])
+		if err != nil {
+			// Running the command reports the error.
+			return BlockingRead{}, false
+		}
+		ids[i] = streamID(last)
+	}
+	return BlockingRead{
+		Cmd:     resp.Value{Type: resp.TypeArray, Array: args},
+		Keys:    x.keys,
+		Timeout: x.timeout,
+	}, true
+}
@@ -0,0 +1,1131 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// handler runs a command. args includes the command name, and has been
+// checked against the command's arity.
+type handler func(e *Executor, args []resp.Value) (resp.Value, error)
+
+// execCommand is a command served by the executor.
+type execCommand struct {
+	spec *command.Spec
+	run  handler
+}
+
+// tail adapts a handler taking the arguments after the command name.
+func tail(fn func(e *Executor, args []resp.Value) (resp.Value, error)) handler {
+	return func(e *Executor, args []resp.Value) (resp.Value, error) {
+		return fn(e, args[1:])
+	}
+}
+
+var commandList = []execCommand{
+	// Strings and keys.
+	{
+		spec: &command.Spec{
+			Name: cmdSet, Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0",
+			Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		},
+		run: tail((*Executor).set),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSetEx, Arity: 4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "2.0.0",
+			Summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.setex(args[1:], time.Second, cmdSetEx)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPSetEx, Arity: 4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "2.6.0",
+			Summary: "Sets both string value and expiration time in milliseconds of a key. The key is created if it doesn't exist.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.setex(args[1:], time.Millisecond, cmdPSetEx)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdGet, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0",
+			Summary: "Returns the string value of a key.",
+		},
+		run: tail((*Executor).get),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdDel, Arity: -2, Flags: command.Write, FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "generic", Since: "1.0.0",
+			Summary: "Deletes one or more keys.",
+		},
+		run: tail((*Executor).del),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdIncr, Arity: 2, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0",
+			Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		},
+		run: tail((*Executor).incr),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdEcho, Arity: 2, Flags: command.Fast,
+			Group: "connection", Since: "1.0.0",
+			Summary: "Returns the given string.",
+		},
+		run: tail((*Executor).echo),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPing, Arity: -1, Flags: command.Fast,
+			Group: "connection", Since: "1.0.0",
+			Summary: "Returns the server's liveliness response.",
+		},
+		run: tail((*Executor).ping),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdExpire, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0",
+			Summary: "Sets the expiration time of a key in seconds.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.expire(args[1:], time.Second, false, cmdExpire)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPExpire, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0",
+			Summary: "Sets the expiration time of a key in milliseconds.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.expire(args[1:], time.Millisecond, false, cmdPExpire)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdExpireAt, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.2.0",
+			Summary: "Sets the expiration time of a key to a Unix timestamp.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.expire(args[1:], time.Second, true, cmdExpireAt)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPExpireAt, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0",
+			Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.expire(args[1:], time.Millisecond, true, cmdPExpireAt)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdTTL, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0",
+			Summary: "Returns the expiration time in seconds of a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.ttl(args[1:], time.Second, false)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPTTL, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0",
+			Summary: "Returns the expiration time in milliseconds of a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.ttl(args[1:], time.Millisecond, false)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdExpireTime, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "7.0.0",
+			Summary: "Returns the expiration time of a key as a Unix timestamp.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.ttl(args[1:], time.Second, true)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPExpireTime, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "7.0.0",
+			Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.ttl(args[1:], time.Millisecond, true)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdPersist, Arity: 2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.2.0",
+			Summary: "Removes the expiration time of a key.",
+		},
+		run: tail((*Executor).persist),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdFlushAll, Arity: -1, Flags: command.Write, Categories: []string{"keyspace", "dangerous"},
+			Group: "server", Since: "1.0.0",
+			Summary: "Removes all keys from all databases.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.flushall(args[1:], cmdFlushAll)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdFlushDB, Arity: -1, Flags: command.Write, Categories: []string{"keyspace", "dangerous"},
+			Group: "server", Since: "1.0.0",
+			Summary: "Removes all keys from the current database.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.flushall(args[1:], cmdFlushDB)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdType, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0",
+			Summary: "Determines the type of value stored at a key.",
+		},
+		run: tail((*Executor).typeOf),
+	},
+	// Lists.
+	{
+		spec: &command.Spec{
+			Name: cmdLPush, Arity: -3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.push(args[1:], storage.ListHead, false)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdRPush, Arity: -3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.push(args[1:], storage.ListTail, false)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLPushX, Arity: -3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "2.2.0",
+			Summary: "Prepends one or more elements to a list only when the list exists.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.push(args[1:], storage.ListHead, true)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdRPushX, Arity: -3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "2.2.0",
+			Summary: "Appends an element to a list only when the list exists.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.push(args[1:], storage.ListTail, true)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLPop, Arity: -2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.pop(args[1:], storage.ListHead, cmdLPop)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdRPop, Arity: -2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.pop(args[1:], storage.ListTail, cmdRPop)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLLen, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Returns the length of a list.",
+		},
+		run: tail((*Executor).llen),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLIndex, Arity: 3, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Returns an element from a list by its index.",
+		},
+		run: tail((*Executor).lindex),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLSet, Arity: 4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Sets the value of an element in a list by its index.",
+		},
+		run: tail((*Executor).lset),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLRange, Arity: 4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Returns a range of elements from a list.",
+		},
+		run: tail((*Executor).lrange),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLTrim, Arity: 4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+		},
+		run: tail((*Executor).ltrim),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLRem, Arity: 4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0",
+			Summary: "Removes elements from a list. Deletes the list if the last element was removed.",
+		},
+		run: tail((*Executor).lrem),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLInsert, Arity: 5, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "2.2.0",
+			Summary: "Inserts an element before or after another element in a list.",
+		},
+		run: tail((*Executor).linsert),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdLMove, Arity: 5, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "list", Since: "6.2.0",
+			Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.",
+		},
+		run: tail((*Executor).lmove),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdRPopLPush, Arity: 3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "list", Since: "1.2.0",
+			Summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.",
+		},
+		run: tail((*Executor).rpoplpush),
+	},
+	// Hashes.
+	{
+		spec: &command.Spec{
+			Name: cmdHSet, Arity: -4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Creates or modifies the value of a field in a hash.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hset(args[1:], cmdHSet)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHMSet, Arity: -4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Sets the values of multiple fields.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hset(args[1:], cmdHMSet)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHSetNX, Arity: 4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Sets the value of a field in a hash only when the field doesn't exist.",
+		},
+		run: tail((*Executor).hsetnx),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHGet, Arity: 3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns the value of a field in a hash.",
+		},
+		run: tail((*Executor).hget),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHMGet, Arity: -3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns the values of all fields in a hash.",
+		},
+		run: tail((*Executor).hmget),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHDel, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.",
+		},
+		run: tail((*Executor).hdel),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHGetAll, Arity: 2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns all fields and values in a hash.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hgetall(args[1:], cmdHGetAll)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHKeys, Arity: 2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns all fields in a hash.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hgetall(args[1:], cmdHKeys)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHVals, Arity: 2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns all values in a hash.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hgetall(args[1:], cmdHVals)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHLen, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Returns the number of fields in a hash.",
+		},
+		run: tail((*Executor).hlen),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHExists, Arity: 3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Determines whether a field exists in a hash.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hexists(args[1:], cmdHExists)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHStrLen, Arity: 3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "3.2.0",
+			Summary: "Returns the length of the value of a field.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.hexists(args[1:], cmdHStrLen)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHIncrBy, Arity: 4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0",
+			Summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.",
+		},
+		run: tail((*Executor).hincrby),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHIncrByFloat, Arity: 4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.6.0",
+			Summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.",
+		},
+		run: tail((*Executor).hincrbyfloat),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHScan, Arity: -3, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.8.0",
+			Summary: "Iterates over fields and values of a hash.",
+		},
+		run: tail((*Executor).hscan),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdHRandField, Arity: -2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "6.2.0",
+			Summary: "Returns one or more random fields from a hash.",
+		},
+		run: tail((*Executor).hrandfield),
+	},
+	// Sets.
+	{
+		spec: &command.Spec{
+			Name: cmdSAdd, Arity: -3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.",
+		},
+		run: tail((*Executor).sadd),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSRem, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.",
+		},
+		run: tail((*Executor).srem),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSMembers, Arity: 2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns all members of a set.",
+		},
+		run: tail((*Executor).smembers),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSIsMember, Arity: 3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Determines whether a member belongs to a set.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.sismember(args[1:], cmdSIsMember)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSMIsMember, Arity: -3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "6.2.0",
+			Summary: "Determines whether multiple members belong to a set.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.sismember(args[1:], cmdSMIsMember)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSCard, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns the number of members in a set.",
+		},
+		run: tail((*Executor).scard),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSPop, Arity: -2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.",
+		},
+		run: tail((*Executor).spop),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSRandMember, Arity: -2, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Get one or multiple random members from a set",
+		},
+		run: tail((*Executor).srandmember),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSScan, Arity: -3, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "2.8.0",
+			Summary: "Iterates over members of a set.",
+		},
+		run: tail((*Executor).sscan),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSMove, Arity: 4, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Moves a member from one set to another.",
+		},
+		run: tail((*Executor).smove),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSInter, Arity: -2, Flags: command.ReadOnly, FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns the intersect of multiple sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombine(args[1:], storage.SetInter)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSUnion, Arity: -2, Flags: command.ReadOnly, FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns the union of multiple sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombine(args[1:], storage.SetUnion)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSDiff, Arity: -2, Flags: command.ReadOnly, FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Returns the difference of multiple sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombine(args[1:], storage.SetDiff)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSInterStore, Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: -1, Step: 1, WrittenKeys: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Stores the intersect of multiple sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombineStore(args[1:], storage.SetInter)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSUnionStore, Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: -1, Step: 1, WrittenKeys: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Stores the union of multiple sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombineStore(args[1:], storage.SetUnion)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSDiffStore, Arity: -3, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: -1, Step: 1, WrittenKeys: 1,
+			Group: "set", Since: "1.0.0",
+			Summary: "Stores the difference of multiple sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.scombineStore(args[1:], storage.SetDiff)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdSInterCard, Arity: -3, Flags: command.ReadOnly, Keys: numKeys(1),
+			Group: "set", Since: "7.0.0",
+			Summary: "Returns the number of members of the intersect of multiple sets.",
+		},
+		run: tail((*Executor).sintercard),
+	},
+	// Sorted sets.
+	{
+		spec: &command.Spec{
+			Name: cmdZAdd, Arity: -4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+		},
+		run: tail((*Executor).zadd),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZIncrBy, Arity: 4, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Increments the score of a member in a sorted set.",
+		},
+		run: tail((*Executor).zincrby),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRem, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.",
+		},
+		run: tail((*Executor).zrem),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZCard, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Returns the number of members in a sorted set.",
+		},
+		run: tail((*Executor).zcard),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZScore, Arity: 3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Returns the score of a member in a sorted set.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zscore(args[1:], cmdZScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZMScore, Arity: -3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Returns the score of one or more members in a sorted set.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zscore(args[1:], cmdZMScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRank, Arity: -3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Returns the index of a member in a sorted set ordered by ascending scores.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrank(args[1:], cmdZRank)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRevRank, Arity: -3, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Returns the index of a member in a sorted set ordered by descending scores.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrank(args[1:], cmdZRevRank)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZCount, Arity: 4, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Returns the count of members in a sorted set that have scores within a range.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcount(args[1:], storage.ZByScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZLexCount, Arity: 4, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9",
+			Summary: "Returns the number of members in a sorted set within a lexicographical range.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcount(args[1:], storage.ZByLex)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRange, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Returns members in a sorted set within a range of indexes.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRange)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRangeStore, Arity: -5, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 2, Step: 1, WrittenKeys: 1,
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Stores a range of members from sorted set in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRangeStore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRevRange, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Returns members in a sorted set within a range of indexes in reverse order.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRevRange)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRangeByScore, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.0.5",
+			Summary: "Returns members in a sorted set within a range of scores.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRangeByScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRevRangeByScore, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.2.0",
+			Summary: "Returns members in a sorted set within a range of scores in reverse order.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRevRangeByScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRangeByLex, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9",
+			Summary: "Returns members in a sorted set within a lexicographical range.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRangeByLex)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRevRangeByLex, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9",
+			Summary: "Returns members in a sorted set within a lexicographical range in reverse order.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zrange(args[1:], cmdZRevRangeByLex)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRemRangeByRank, Arity: 4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zremrange(args[1:], storage.ZByRank)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRemRangeByScore, Arity: 4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0",
+			Summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zremrange(args[1:], storage.ZByScore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZRemRangeByLex, Arity: 4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9",
+			Summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zremrange(args[1:], storage.ZByLex)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZPopMin, Arity: -2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "5.0.0",
+			Summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zpop(args[1:], cmdZPopMin)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZPopMax, Arity: -2, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "5.0.0",
+			Summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zpop(args[1:], cmdZPopMax)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZUnion, Arity: -3, Flags: command.ReadOnly, Keys: numKeys(1),
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Returns the union of multiple sorted sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetUnion, false, cmdZUnion)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZInter, Arity: -3, Flags: command.ReadOnly, Keys: numKeys(1),
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Returns the intersect of multiple sorted sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetInter, false, cmdZInter)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZDiff, Arity: -3, Flags: command.ReadOnly, Keys: numKeys(1),
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Returns the difference between multiple sorted sets.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetDiff, false, cmdZDiff)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZUnionStore, Arity: -4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Keys: destNumKeys, WrittenKeys: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Stores the union of multiple sorted sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetUnion, true, cmdZUnionStore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZInterStore, Arity: -4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Keys: destNumKeys, WrittenKeys: 1,
+			Group: "sorted-set", Since: "2.0.0",
+			Summary: "Stores the intersect of multiple sorted sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetInter, true, cmdZInterStore)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdZDiffStore, Arity: -4, Flags: command.Write | command.DenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Keys: destNumKeys, WrittenKeys: 1,
+			Group: "sorted-set", Since: "6.2.0",
+			Summary: "Stores the difference of multiple sorted sets in a key.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.zcombine(args[1:], storage.SetDiff, true, cmdZDiffStore)
+		},
+	},
+	// Streams.
+	{
+		spec: &command.Spec{
+			Name: cmdXAdd, Arity: -5, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Appends a new message to a stream. Creates the key if it doesn't exist.",
+		},
+		run: tail((*Executor).xadd),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXLen, Arity: 2, Flags: command.ReadOnly | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Return the number of messages in a stream.",
+		},
+		run: tail((*Executor).xlen),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXRange, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns the messages from a stream within a range of IDs.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.xrange(args[1:], cmdXRange)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXRevRange, Arity: -4, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns the messages from a stream within a range of IDs in reverse order.",
+		},
+		run: func(e *Executor, args []resp.Value) (resp.Value, error) {
+			return e.xrange(args[1:], cmdXRevRange)
+		},
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXDel, Arity: -3, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns the number of messages after removing them from a stream.",
+		},
+		run: tail((*Executor).xdel),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXTrim, Arity: -4, Flags: command.Write, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Deletes messages from the beginning of a stream.",
+		},
+		run: tail((*Executor).xtrim),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXSetID, Arity: 3, Flags: command.Write | command.DenyOOM | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "An internal command for replicating stream values.",
+		},
+		run: tail((*Executor).xsetid),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXRead, Arity: -4, Flags: command.ReadOnly | command.Blocking, Keys: xreadKeys,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+		},
+		run: (*Executor).xread,
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXReadGroup, Arity: -7, Flags: command.Write | command.Blocking, Keys: xreadKeys,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.",
+		},
+		run: (*Executor).xreadgroup,
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXGroup, Arity: -2,
+			Group: "stream", Since: "5.0.0",
+			Summary:     "A container for consumer groups commands.",
+			Subcommands: xgroupSubcommands,
+		},
+		run: tail((*Executor).xgroup),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXAck, Arity: -4, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.",
+		},
+		run: tail((*Executor).xack),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXPending, Arity: -3, Flags: command.ReadOnly, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Returns the information and entries from a stream consumer group's pending entries list.",
+		},
+		run: tail((*Executor).xpending),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXClaim, Arity: -6, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0",
+			Summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.",
+		},
+		run: tail((*Executor).xclaim),
+	},
+	{
+		spec: &command.Spec{
+			Name: cmdXAutoClaim, Arity: -6, Flags: command.Write | command.Fast, FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "6.2.0",
+			Summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.",
+		},
+		run: tail((*Executor).xautoclaim),
+	},
+}
+
+var xgroupSubcommands = []*command.Spec{
+	{
+		Name: "xgroup|create", Arity: -5, Flags: command.Write | command.DenyOOM, FirstKey: 2, LastKey: 2, Step: 1,
+		Group: "stream", Since: "5.0.0",
+		Summary: "Creates a consumer group.",
+	},
+	{
+		Name: "xgroup|createconsumer", Arity: 5, Flags: command.Write | command.DenyOOM, FirstKey: 2, LastKey: 2, Step: 1,
+		Group: "stream", Since: "6.2.0",
+		Summary: "Creates a consumer in a consumer group.",
+	},
+	{
+		Name: "xgroup|delconsumer", Arity: 5, Flags: command.Write, FirstKey: 2, LastKey: 2, Step: 1,
+		Group: "stream", Since: "5.0.0",
+		Summary: "Deletes a consumer from a consumer group.",
+	},
+	{
+		Name: "xgroup|destroy", Arity: 4, Flags: command.Write, FirstKey: 2, LastKey: 2, Step: 1,
+		Group: "stream", Since: "5.0.0",
+		Summary: "Destroys a consumer group.",
+	},
+	{
+		Name: "xgroup|setid", Arity: 5, Flags: command.Write, FirstKey: 2, LastKey: 2, Step: 1,
+		Group: "stream", Since: "5.0.0",
+		Summary: "Sets the last-delivered ID of a consumer group.",
+	},
+}
+
+// commands indexes commandList by name.
+var commands = func() map[string]execCommand {
+	out := make(map[string]execCommand, len(commandList))
+	for _, c := range commandList {
+		out[c.spec.Name] = c
+	}
+	return out
+}()
+
+// Commands returns the specs of the commands served by the executor.
+func Commands() []*command.Spec {
+	out := make([]*command.Spec, len(commandList))
+	for i, c := range commandList {
+		out[i] = c.spec
+	}
+	return out
+}
+
+// isWrite reports whether args is a command that may modify the keyspace.
+func isWrite(name string, args []resp.Value) bool {
+	c, ok := commands[name]
+	return ok && c.spec.Resolve(args).Flags.Has(command.Write)
+}
+
+// numKeys returns a key finder for commands taking a number of keys at
+// position i followed by the keys themselves.
+func numKeys(i int) func(args []resp.Value) []int {
+	return func(args []resp.Value) []int {
+		if i >= len(args) {
+			return nil
+		}
+		n, err := strconv.Atoi(string(args[i].Bytes))
+		if err != nil || n < 1 || i+n >= len(args) {
+			return nil
+		}
+		out := make([]int, n)
+		for j := range out {
+			out[j] = i + 1 + j
+		}
+		return out
+	}
+}
+
+// destNumKeys finds the keys of commands taking a destination key followed
+// by a number of source keys and the keys themselves.
+func destNumKeys(args []resp.Value) []int {
+	keys := numKeys(2)(args)
+	if keys == nil {
+		return nil
+	}
+	return append([]int{1}, keys...)
+}
+
+// xreadKeys finds the stream keys of XREAD and XREADGROUP.
+func xreadKeys(args []resp.Value) []int {
+	x, _, ok := parseXRead(args, strings.EqualFold(string(args[0].Bytes), cmdXReadGroup))
+	if !ok {
+		return nil
+	}
+	first := len(args) - 2*len(x.keys)
+	out := make([]int, len(x.keys))
+	for i := range out {
+		out[i] = first + i
+	}
+	return out
+}
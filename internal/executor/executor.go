@@ -1,24 +1,199 @@
 package executor
 
 import (
+	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/elmq0022/kv-store/internal/resp"
 	"github.com/elmq0022/kv-store/internal/storage"
 )
 
 const (
-	cmdSet  = "set"
-	cmdGet  = "get"
-	cmdDel  = "del"
-	cmdIncr = "incr"
-	cmdEcho = "echo"
-	cmdPing = "ping"
+	cmdSet              = "set"
+	cmdSetEx            = "setex"
+	cmdPSetEx           = "psetex"
+	cmdGet              = "get"
+	cmdDel              = "del"
+	cmdIncr             = "incr"
+	cmdEcho             = "echo"
+	cmdPing             = "ping"
+	cmdExpire           = "expire"
+	cmdPExpire          = "pexpire"
+	cmdExpireAt         = "expireat"
+	cmdPExpireAt        = "pexpireat"
+	cmdTTL              = "ttl"
+	cmdPTTL             = "pttl"
+	cmdExpireTime       = "expiretime"
+	cmdPExpireTime      = "pexpiretime"
+	cmdPersist          = "persist"
+	cmdFlushAll         = "flushall"
+	cmdFlushDB          = "flushdb"
+	cmdType             = "type"
+	cmdLPush            = "lpush"
+	cmdRPush            = "rpush"
+	cmdLPushX           = "lpushx"
+	cmdRPushX           = "rpushx"
+	cmdLPop             = "lpop"
+	cmdRPop             = "rpop"
+	cmdLLen             = "llen"
+	cmdLIndex           = "lindex"
+	cmdLSet             = "lset"
+	cmdLRange           = "lrange"
+	cmdLTrim            = "ltrim"
+	cmdLRem             = "lrem"
+	cmdLInsert          = "linsert"
+	cmdLMove            = "lmove"
+	cmdRPopLPush        = "rpoplpush"
+	cmdHSet             = "hset"
+	cmdHMSet            = "hmset"
+	cmdHSetNX           = "hsetnx"
+	cmdHGet             = "hget"
+	cmdHMGet            = "hmget"
+	cmdHDel             = "hdel"
+	cmdHGetAll          = "hgetall"
+	cmdHKeys            = "hkeys"
+	cmdHVals            = "hvals"
+	cmdHLen             = "hlen"
+	cmdHExists          = "hexists"
+	cmdHStrLen          = "hstrlen"
+	cmdHIncrBy          = "hincrby"
+	cmdHIncrByFloat     = "hincrbyfloat"
+	cmdHScan            = "hscan"
+	cmdHRandField       = "hrandfield"
+	cmdSAdd             = "sadd"
+	cmdSRem             = "srem"
+	cmdSMembers         = "smembers"
+	cmdSIsMember        = "sismember"
+	cmdSMIsMember       = "smismember"
+	cmdSCard            = "scard"
+	cmdSPop             = "spop"
+	cmdSRandMember      = "srandmember"
+	cmdSScan            = "sscan"
+	cmdSMove            = "smove"
+	cmdSInter           = "sinter"
+	cmdSUnion           = "sunion"
+	cmdSDiff            = "sdiff"
+	cmdSInterStore      = "sinterstore"
+	cmdSUnionStore      = "sunionstore"
+	cmdSDiffStore       = "sdiffstore"
+	cmdSInterCard       = "sintercard"
+	cmdZAdd             = "zadd"
+	cmdZIncrBy          = "zincrby"
+	cmdZRem             = "zrem"
+	cmdZCard            = "zcard"
+	cmdZScore           = "zscore"
+	cmdZMScore          = "zmscore"
+	cmdZRank            = "zrank"
+	cmdZRevRank         = "zrevrank"
+	cmdZCount           = "zcount"
+	cmdZLexCount        = "zlexcount"
+	cmdZRange           = "zrange"
+	cmdZRangeStore      = "zrangestore"
+	cmdZRevRange        = "zrevrange"
+	cmdZRangeByScore    = "zrangebyscore"
+	cmdZRevRangeByScore = "zrevrangebyscore"
+	cmdZRangeByLex      = "zrangebylex"
+	cmdZRevRangeByLex   = "zrevrangebylex"
+	cmdZRemRangeByRank  = "zremrangebyrank"
+	cmdZRemRangeByScore = "zremrangebyscore"
+	cmdZRemRangeByLex   = "zremrangebylex"
+	cmdZPopMin          = "zpopmin"
+	cmdZPopMax          = "zpopmax"
+	cmdZUnion           = "zunion"
+	cmdZInter           = "zinter"
+	cmdZDiff            = "zdiff"
+	cmdZUnionStore      = "zunionstore"
+	cmdZInterStore      = "zinterstore"
+	cmdZDiffStore       = "zdiffstore"
+	cmdXAdd             = "xadd"
+	cmdXLen             = "xlen"
+	cmdXRange           = "xrange"
+	cmdXRevRange        = "xrevrange"
+	cmdXDel             = "xdel"
+	cmdXTrim            = "xtrim"
+	cmdXSetID           = "xsetid"
+	cmdXRead            = "xread"
+	cmdXReadGroup       = "xreadgroup"
+	cmdXGroup           = "xgroup"
+	cmdXAck             = "xack"
+	cmdXPending         = "xpending"
+	cmdXClaim           = "xclaim"
+	cmdXAutoClaim       = "xautoclaim"
 )
 
+var (
+	errSyntax     = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}
+	errNotInteger = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not an integer or out of range")}
+	errNotFloat   = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not a valid float")}
+	errWrongType  = resp.Value{Type: resp.TypeError, Bytes: []byte("WRONGTYPE Operation against a key holding the wrong kind of value")}
+	errNoSuchKey  = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR no such key")}
+	errOverflow   = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR increment or decrement would overflow")}
+	errOOM        = resp.Value{Type: resp.TypeError, Bytes: []byte("OOM command not allowed when used memory > 'maxmemory'.")}
+)
+
+// storageErrors maps the storage errors clients are expected to see to the
+// replies they get for them. Commands replying differently to one of them
+// check for it before calling storageError.
+var storageErrors = []struct {
+	err   error
+	reply resp.Value
+}{
+	{storage.ErrWrongType, errWrongType},
+	{storage.ErrKeyNotFound, errNoSuchKey},
+	{storage.ErrNotInteger, errNotInteger},
+	{storage.ErrIntegerOverflow, errOverflow},
+	{storage.ErrNotFloat, errNotFloat},
+	{storage.ErrNotFinite, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR increment would produce NaN or Infinity")}},
+	{storage.ErrIndexOutOfRange, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR index out of range")}},
+	{storage.ErrOutOfMemory, errOOM},
+}
+
+// storageError turns the storage errors clients are expected to see into
+// replies, and returns any other error as is. The server replies to those
+// with a generic error without closing the connection.
+func storageError(err error) (resp.Value, error) {
+	for _, m := range storageErrors {
+		if errors.Is(err, m.err) {
+			return m.reply, nil
+		}
+	}
+	return resp.Value{}, err
+}
+
+func wrongArgs(name string) resp.Value {
+	return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for '" + name + "' command")}
+}
+
+func integer(n int) resp.Value {
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))}
+}
+
+func bulks(vals [][]byte) resp.Value {
+	out := make([]resp.Value, len(vals))
+	for i, v := range vals {
+		out[i] = resp.Value{Type: resp.TypeBulkString, Bytes: v}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
 type Executor struct {
 	storage storage.Storage
+
+	// mu is held shared by every command and exclusively by Barrier.
+	mu sync.RWMutex
+	// propagateMu serialises write commands while propagators are attached,
+	// so that they observe writes in the order they were applied.
+	propagateMu sync.Mutex
+	propagators []Propagator
+	observer    Observer
+	// dirty counts successful write commands.
+	dirty atomic.Int64
+
+	waiters waiters
 }
 
 func NewExecutor(s storage.Storage) *Executor {
@@ -26,89 +201,197 @@ func NewExecutor(s storage.Storage) *Executor {
 }
 
 func (e *Executor) Execute(val resp.Value) (resp.Value, error) {
+	name, errReply, ok := parse(val)
+	if !ok {
+		return errReply, nil
+	}
+	defer e.observe(name)()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !isWrite(name, val.Array) {
+		return e.dispatch(name, val.Array)
+	}
+
+	if len(e.propagators) > 0 {
+		e.propagateMu.Lock()
+		defer e.propagateMu.Unlock()
+	}
+	out, err := e.dispatch(name, val.Array)
+	if err == nil && out.Type != resp.TypeError {
+		e.dirty.Add(1)
+		if len(e.propagators) > 0 {
+			e.propagate(translate(name, val.Array, out))
+		}
+		e.wake(name, val.Array)
+	}
+	return out, err
+}
+
+// parse returns the lowercased name of the command in val, or the error to
+// reply with if val is not a command.
+func parse(val resp.Value) (string, resp.Value, bool) {
 	if val.Type != resp.TypeArray {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR expected array")}, nil
+		return "", resp.Value{Type: resp.TypeError, Bytes: []byte("ERR expected array")}, false
 	}
 	if len(val.Array) < 1 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR empty command")}, nil
+		return "", resp.Value{Type: resp.TypeError, Bytes: []byte("ERR empty command")}, false
 	}
+	return strings.ToLower(string(val.Array[0].Bytes)), resp.Value{}, true
+}
 
-	cmd := val.Array[0].Bytes
+// Dirty returns the number of write commands executed successfully.
+func (e *Executor) Dirty() int64 {
+	return e.dirty.Load()
+}
 
-	switch strings.ToLower(string(cmd)) {
-	case cmdSet:
-		return e.set(val.Array[1:])
-	case cmdGet:
-		return e.get(val.Array[1:])
-	case cmdDel:
-		return e.del(val.Array[1:])
-	case cmdIncr:
-		return e.incr(val.Array[1:])
-	case cmdEcho:
-		return e.echo(val.Array[1:])
-	case cmdPing:
-		return e.ping(val.Array[1:])
-	default:
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown command '" + string(cmd) + "'")}, nil
+// FreeMemory evicts keys until the keyspace is within its memory limit,
+// propagating their deletion, and returns the number evicted. It fails with
+// storage.ErrOutOfMemory if not enough could be, in which case commands that
+// use more memory must be refused.
+func (e *Executor) FreeMemory() (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.propagators) > 0 {
+		e.propagateMu.Lock()
+		defer e.propagateMu.Unlock()
 	}
+	evicted, err := e.storage.Evict()
+	for _, k := range evicted {
+		e.propagate([]resp.Value{bulk("DEL"), bulk(k)})
+	}
+	return len(evicted), err
 }
 
-func (e *Executor) set(args []resp.Value) (resp.Value, error) {
-	if len(args) != 2 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'set' command")}, nil
+// dispatch runs the command named name after checking its arity, or that of
+// its subcommand.
+func (e *Executor) dispatch(name string, args []resp.Value) (resp.Value, error) {
+	c, ok := commands[name]
+	if !ok {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown command '" + string(args[0].Bytes) + "'")}, nil
 	}
+	if spec := c.spec.Resolve(args); !spec.CheckArity(len(args)) {
+		return wrongArgs(spec.Name), nil
+	}
+	return c.run(e, args)
+}
+
+func (e *Executor) set(args []resp.Value) (resp.Value, error) {
 
 	k := string(args[0].Bytes)
 	v := args[1].Bytes
-	if err := e.storage.Set(k, v); err != nil {
-		return resp.Value{}, err
+
+	var opts storage.SetOptions
+	get, hasExpiry := false, false
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i].Bytes))
+		switch opt {
+		case "NX":
+			if opts.XX {
+				return errSyntax, nil
+			}
+			opts.NX = true
+		case "XX":
+			if opts.NX {
+				return errSyntax, nil
+			}
+			opts.XX = true
+		case "GET":
+			get = true
+			opts.Get = true
+		case "KEEPTTL":
+			if hasExpiry {
+				return errSyntax, nil
+			}
+			opts.KeepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpiry || opts.KeepTTL || i+1 >= len(args) {
+				return errSyntax, nil
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i].Bytes), 10, 64)
+			if err != nil {
+				return errNotInteger, nil
+			}
+			unit := time.Second
+			if opt == "PX" || opt == "PXAT" {
+				unit = time.Millisecond
+			}
+			at, ok := expireAt(n, unit, opt == "EXAT" || opt == "PXAT")
+			if !ok || n <= 0 {
+				return invalidExpireTime(cmdSet), nil
+			}
+			opts.ExpireAt = at
+			hasExpiry = true
+		default:
+			return errSyntax, nil
+		}
+	}
+
+	old, ok, err := e.storage.SetWithOptions(k, v, opts)
+	if err != nil {
+		return storageError(err)
+	}
+	if get {
+		return resp.Value{Type: resp.TypeBulkString, Bytes: old}, nil
+	}
+	if !ok {
+		return resp.Value{Type: resp.TypeBulkString}, nil
 	}
 	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
 }
 
-func (e *Executor) get(args []resp.Value) (resp.Value, error) {
-	if len(args) != 1 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'get' command")}, nil
+func (e *Executor) setex(args []resp.Value, unit time.Duration, name string) (resp.Value, error) {
+	n, err := strconv.ParseInt(string(args[1].Bytes), 10, 64)
+	if err != nil {
+		return errNotInteger, nil
 	}
+	at, ok := expireAt(n, unit, false)
+	if !ok || n <= 0 {
+		return invalidExpireTime(name), nil
+	}
+
+	k := string(args[0].Bytes)
+	if _, _, err := e.storage.SetWithOptions(k, args[2].Bytes, storage.SetOptions{ExpireAt: at}); err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+func (e *Executor) get(args []resp.Value) (resp.Value, error) {
 	k := string(args[0].Bytes)
 	v, err := e.storage.Get(k)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
 	if err != nil {
-		return resp.Value{}, err
+		return storageError(err)
 	}
 	return resp.Value{Type: resp.TypeBulkString, Bytes: v}, nil
 }
 
 func (e *Executor) del(args []resp.Value) (resp.Value, error) {
-	if len(args) < 1 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'del' command")}, nil
-	}
 	keys := make([]string, len(args))
 	for i, a := range args {
 		keys[i] = string(a.Bytes)
 	}
 	n, err := e.storage.Del(keys...)
 	if err != nil {
-		return resp.Value{}, err
+		return storageError(err)
 	}
 	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))}, nil
 }
 
 func (e *Executor) incr(args []resp.Value) (resp.Value, error) {
-	if len(args) != 1 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'incr' command")}, nil
-	}
 	k := string(args[0].Bytes)
 	n, err := e.storage.Incr(k)
 	if err != nil {
-		return resp.Value{}, err
+		return storageError(err)
 	}
 	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(n, 10))}, nil
 }
 
 func (e *Executor) echo(args []resp.Value) (resp.Value, error) {
-	if len(args) != 1 {
-		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'echo' command")}, nil
-	}
 	return args[0], nil
 }
 
@@ -127,3 +410,33 @@ func (e *Executor) ping(args []resp.Value) (resp.Value, error) {
 		Bytes: []byte("pong"),
 	}, nil
 }
+
+func (e *Executor) typeOf(args []resp.Value) (resp.Value, error) {
+	kind, err := e.storage.Type(string(args[0].Bytes))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("none")}, nil
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte(kind.String())}, nil
+}
+
+// flushall implements FLUSHALL and FLUSHDB, which are equivalent with a
+// single database. The ASYNC and SYNC modifiers are accepted and ignored.
+func (e *Executor) flushall(args []resp.Value, name string) (resp.Value, error) {
+	if len(args) > 1 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for '" + name + "' command")}, nil
+	}
+	if len(args) == 1 {
+		switch strings.ToUpper(string(args[0].Bytes)) {
+		case "ASYNC", "SYNC":
+		default:
+			return errSyntax, nil
+		}
+	}
+	if err := e.storage.FlushAll(); err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
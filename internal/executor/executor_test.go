@@ -2,10 +2,16 @@ package executor_test
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/elmq0022/kv-store/internal/command"
 	"github.com/elmq0022/kv-store/internal/executor"
 	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,8 +22,10 @@ type call struct {
 	Args   []any
 }
 
-// spyStorage implements storage.Storage and records every call.
+// spyStorage implements storage.Storage and records every call. Methods it
+// does not stub panic through the nil embedded interface.
 type spyStorage struct {
+	storage.Storage
 	calls []call
 
 	// Return values to stub per method.
@@ -28,6 +36,17 @@ type spyStorage struct {
 	delErr  error
 	incrVal int64
 	incrErr error
+
+	setOptsOld []byte
+	setOptsOK  bool
+	setOptsErr error
+	expireOK   bool
+	expireErr  error
+	persistOK  bool
+	persistErr error
+	expTime    time.Time
+	expTimeErr error
+	flushErr   error
 }
 
 func (s *spyStorage) Set(k string, v []byte) error {
@@ -54,6 +73,41 @@ func (s *spyStorage) Incr(k string) (int64, error) {
 	return s.incrVal, s.incrErr
 }
 
+func (s *spyStorage) SetWithOptions(k string, v []byte, opts storage.SetOptions) ([]byte, bool, error) {
+	s.calls = append(s.calls, call{Method: "SetWithOptions", Args: []any{k, v, opts}})
+	return s.setOptsOld, s.setOptsOK, s.setOptsErr
+}
+
+func (s *spyStorage) Expire(k string, at time.Time, cond storage.ExpireCond) (bool, error) {
+	s.calls = append(s.calls, call{Method: "Expire", Args: []any{k, at, cond}})
+	return s.expireOK, s.expireErr
+}
+
+func (s *spyStorage) Persist(k string) (bool, error) {
+	s.calls = append(s.calls, call{Method: "Persist", Args: []any{k}})
+	return s.persistOK, s.persistErr
+}
+
+func (s *spyStorage) ExpireTime(k string) (time.Time, error) {
+	s.calls = append(s.calls, call{Method: "ExpireTime", Args: []any{k}})
+	return s.expTime, s.expTimeErr
+}
+
+func (s *spyStorage) Snapshot() *storage.Snapshot {
+	s.calls = append(s.calls, call{Method: "Snapshot"})
+	return nil
+}
+
+func (s *spyStorage) FlushAll() error {
+	s.calls = append(s.calls, call{Method: "FlushAll"})
+	return s.flushErr
+}
+
+func (s *spyStorage) Version(k string) uint64 {
+	s.calls = append(s.calls, call{Method: "Version", Args: []any{k}})
+	return 0
+}
+
 // helpers to build resp.Value inputs
 func cmd(args ...string) resp.Value {
 	vals := make([]resp.Value, len(args))
@@ -96,6 +150,33 @@ func TestExecute_UnknownCommand(t *testing.T) {
 	assert.Empty(t, spy.calls)
 }
 
+func TestExecute_WrongArity(t *testing.T) {
+	spy := &spyStorage{}
+	e := executor.NewExecutor(spy)
+
+	for _, args := range [][]string{{"get"}, {"get", "a", "b"}, {"SET", "k"}, {"xgroup"}} {
+		got, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		assert.Equal(t, "ERR wrong number of arguments for '"+strings.ToLower(args[0])+"' command", string(got.Bytes))
+	}
+	got, err := e.Execute(cmd("XGROUP", "DESTROY", "s"))
+	require.NoError(t, err)
+	assert.Equal(t, "ERR wrong number of arguments for 'xgroup|destroy' command", string(got.Bytes))
+	assert.Empty(t, spy.calls)
+}
+
+func TestCommands(t *testing.T) {
+	for _, spec := range executor.Commands() {
+		assert.Equal(t, strings.ToLower(spec.Name), spec.Name)
+		assert.NotZero(t, spec.Arity, spec.Name)
+		assert.NotEmpty(t, spec.Summary, spec.Name)
+		assert.False(t, spec.Flags.Has(command.Write) && spec.Flags.Has(command.ReadOnly), spec.Name)
+		for _, sub := range spec.Subcommands {
+			assert.True(t, strings.HasPrefix(sub.Name, spec.Name+"|"), sub.Name)
+		}
+	}
+}
+
 func TestPing(t *testing.T) {
 	spy := &spyStorage{}
 	e := executor.NewExecutor(spy)
@@ -144,7 +225,7 @@ func TestEcho(t *testing.T) {
 
 func TestSet(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		spy := &spyStorage{}
+		spy := &spyStorage{setOptsOK: true}
 		e := executor.NewExecutor(spy)
 
 		got, err := e.Execute(cmd("set", "mykey", "myval"))
@@ -153,13 +234,14 @@ func TestSet(t *testing.T) {
 		assert.Equal(t, "OK", string(got.Bytes))
 
 		require.Len(t, spy.calls, 1)
-		assert.Equal(t, "Set", spy.calls[0].Method)
+		assert.Equal(t, "SetWithOptions", spy.calls[0].Method)
 		assert.Equal(t, "mykey", spy.calls[0].Args[0])
 		assert.Equal(t, []byte("myval"), spy.calls[0].Args[1])
+		assert.Equal(t, storage.SetOptions{}, spy.calls[0].Args[2])
 	})
 
 	t.Run("storage error", func(t *testing.T) {
-		spy := &spyStorage{setErr: errors.New("disk full")}
+		spy := &spyStorage{setOptsErr: errors.New("disk full")}
 		e := executor.NewExecutor(spy)
 
 		_, err := e.Execute(cmd("set", "k", "v"))
@@ -175,6 +257,116 @@ func TestSet(t *testing.T) {
 		assert.Equal(t, resp.TypeError, got.Type)
 		assert.Empty(t, spy.calls)
 	})
+
+	t.Run("relative expiry", func(t *testing.T) {
+		for _, tc := range []struct {
+			opt  string
+			arg  string
+			want time.Duration
+		}{
+			{"EX", "10", 10 * time.Second},
+			{"px", "1500", 1500 * time.Millisecond},
+		} {
+			spy := &spyStorage{setOptsOK: true}
+			e := executor.NewExecutor(spy)
+
+			got, err := e.Execute(cmd("set", "k", "v", tc.opt, tc.arg))
+			require.NoError(t, err)
+			assert.Equal(t, "OK", string(got.Bytes))
+
+			require.Len(t, spy.calls, 1)
+			opts := spy.calls[0].Args[2].(storage.SetOptions)
+			assert.WithinDuration(t, time.Now().Add(tc.want), opts.ExpireAt, time.Second)
+		}
+	})
+
+	t.Run("absolute expiry", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: true}
+		e := executor.NewExecutor(spy)
+
+		_, err := e.Execute(cmd("set", "k", "v", "PXAT", "4102444800000"))
+		require.NoError(t, err)
+		opts := spy.calls[0].Args[2].(storage.SetOptions)
+		assert.Equal(t, int64(4102444800000), opts.ExpireAt.UnixMilli())
+
+		_, err = e.Execute(cmd("set", "k", "v", "EXAT", "4102444800"))
+		require.NoError(t, err)
+		opts = spy.calls[1].Args[2].(storage.SetOptions)
+		assert.Equal(t, int64(4102444800), opts.ExpireAt.Unix())
+	})
+
+	t.Run("conditional flags", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: true}
+		e := executor.NewExecutor(spy)
+
+		_, err := e.Execute(cmd("set", "k", "v", "nx"))
+		require.NoError(t, err)
+		_, err = e.Execute(cmd("set", "k", "v", "XX", "KEEPTTL"))
+		require.NoError(t, err)
+
+		require.Len(t, spy.calls, 2)
+		assert.Equal(t, storage.SetOptions{NX: true}, spy.calls[0].Args[2])
+		assert.Equal(t, storage.SetOptions{XX: true, KeepTTL: true}, spy.calls[1].Args[2])
+	})
+
+	t.Run("not written replies nil", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: false}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("set", "k", "v", "NX"))
+		require.NoError(t, err)
+		assert.Equal(t, resp.TypeBulkString, got.Type)
+		assert.Nil(t, got.Bytes)
+	})
+
+	t.Run("get returns old value", func(t *testing.T) {
+		spy := &spyStorage{setOptsOld: []byte("old"), setOptsOK: true}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("set", "k", "v", "GET"))
+		require.NoError(t, err)
+		assert.Equal(t, resp.TypeBulkString, got.Type)
+		assert.Equal(t, "old", string(got.Bytes))
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"set", "k", "v", "NX", "XX"},
+			{"set", "k", "v", "EX", "10", "PX", "100"},
+			{"set", "k", "v", "EX", "10", "KEEPTTL"},
+			{"set", "k", "v", "EX"},
+			{"set", "k", "v", "EX", "abc"},
+			{"set", "k", "v", "EX", "0"},
+			{"set", "k", "v", "EX", "-5"},
+			{"set", "k", "v", "BOGUS"},
+		} {
+			spy := &spyStorage{}
+			e := executor.NewExecutor(spy)
+
+			got, err := e.Execute(cmd(args...))
+			require.NoError(t, err)
+			assert.Equal(t, resp.TypeError, got.Type, args)
+			assert.Empty(t, spy.calls, args)
+		}
+	})
+}
+
+func TestSetEx(t *testing.T) {
+	spy := &spyStorage{setOptsOK: true}
+	e := executor.NewExecutor(spy)
+
+	got, err := e.Execute(cmd("setex", "k", "10", "v"))
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(got.Bytes))
+
+	require.Len(t, spy.calls, 1)
+	assert.Equal(t, []byte("v"), spy.calls[0].Args[1])
+	opts := spy.calls[0].Args[2].(storage.SetOptions)
+	assert.WithinDuration(t, time.Now().Add(10*time.Second), opts.ExpireAt, time.Second)
+
+	got, err = e.Execute(cmd("psetex", "k", "0", "v"))
+	require.NoError(t, err)
+	assert.Equal(t, resp.TypeError, got.Type)
 }
 
 func TestGet(t *testing.T) {
@@ -192,6 +384,15 @@ func TestGet(t *testing.T) {
 		assert.Equal(t, "mykey", spy.calls[0].Args[0])
 	})
 
+	t.Run("missing key", func(t *testing.T) {
+		spy := &spyStorage{getErr: storage.ErrKeyNotFound}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("get", "k"))
+		require.NoError(t, err)
+		assert.Equal(t, resp.Value{Type: resp.TypeBulkString}, got)
+	})
+
 	t.Run("storage error", func(t *testing.T) {
 		spy := &spyStorage{getErr: errors.New("not found")}
 		e := executor.NewExecutor(spy)
@@ -290,3 +491,332 @@ func TestIncr(t *testing.T) {
 		assert.Empty(t, spy.calls)
 	})
 }
+
+func TestStorageErrors(t *testing.T) {
+	for err, want := range map[error]string{
+		storage.ErrWrongType:       "WRONGTYPE Operation against a key holding the wrong kind of value",
+		storage.ErrKeyNotFound:     "ERR no such key",
+		storage.ErrNotInteger:      "ERR value is not an integer or out of range",
+		storage.ErrIntegerOverflow: "ERR increment or decrement would overflow",
+		storage.ErrNotFloat:        "ERR value is not a valid float",
+		storage.ErrNotFinite:       "ERR increment would produce NaN or Infinity",
+		storage.ErrIndexOutOfRange: "ERR index out of range",
+		storage.ErrOutOfMemory:     "OOM command not allowed when used memory > 'maxmemory'.",
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			e := executor.NewExecutor(&spyStorage{incrErr: fmt.Errorf("incr: %w", err)})
+			got, execErr := e.Execute(cmd("incr", "k"))
+			require.NoError(t, execErr)
+			assert.Equal(t, resp.TypeError, got.Type)
+			assert.Equal(t, want, string(got.Bytes))
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	t.Run("relative seconds", func(t *testing.T) {
+		spy := &spyStorage{expireOK: true}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("expire", "k", "100"))
+		require.NoError(t, err)
+		assert.Equal(t, resp.TypeInteger, got.Type)
+		assert.Equal(t, "1", string(got.Bytes))
+
+		require.Len(t, spy.calls, 1)
+		assert.Equal(t, "Expire", spy.calls[0].Method)
+		assert.WithinDuration(t, time.Now().Add(100*time.Second), spy.calls[0].Args[1].(time.Time), time.Second)
+		assert.Equal(t, storage.ExpireAlways, spy.calls[0].Args[2])
+	})
+
+	t.Run("absolute milliseconds with condition", func(t *testing.T) {
+		spy := &spyStorage{expireOK: false}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("pexpireat", "k", "4102444800000", "gt"))
+		require.NoError(t, err)
+		assert.Equal(t, "0", string(got.Bytes))
+
+		require.Len(t, spy.calls, 1)
+		assert.Equal(t, int64(4102444800000), spy.calls[0].Args[1].(time.Time).UnixMilli())
+		assert.Equal(t, storage.ExpireGT, spy.calls[0].Args[2])
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"expire", "k"},
+			{"expire", "k", "abc"},
+			{"expire", "k", "10", "YY"},
+			{"pexpire", "k", "10", "NX", "XX"},
+		} {
+			spy := &spyStorage{}
+			e := executor.NewExecutor(spy)
+
+			got, err := e.Execute(cmd(args...))
+			require.NoError(t, err)
+			assert.Equal(t, resp.TypeError, got.Type, args)
+			assert.Empty(t, spy.calls, args)
+		}
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		spy := &spyStorage{expireErr: errors.New("oops")}
+		e := executor.NewExecutor(spy)
+
+		_, err := e.Execute(cmd("expire", "k", "10"))
+		assert.EqualError(t, err, "oops")
+	})
+}
+
+func TestTTL(t *testing.T) {
+	t.Run("missing key", func(t *testing.T) {
+		spy := &spyStorage{expTimeErr: storage.ErrKeyNotFound}
+		e := executor.NewExecutor(spy)
+
+		for _, name := range []string{"ttl", "pttl", "expiretime", "pexpiretime"} {
+			got, err := e.Execute(cmd(name, "k"))
+			require.NoError(t, err)
+			assert.Equal(t, resp.TypeInteger, got.Type)
+			assert.Equal(t, "-2", string(got.Bytes), name)
+		}
+	})
+
+	t.Run("no expiry", func(t *testing.T) {
+		spy := &spyStorage{}
+		e := executor.NewExecutor(spy)
+
+		for _, name := range []string{"ttl", "pttl", "expiretime", "pexpiretime"} {
+			got, err := e.Execute(cmd(name, "k"))
+			require.NoError(t, err)
+			assert.Equal(t, "-1", string(got.Bytes), name)
+		}
+	})
+
+	t.Run("remaining time", func(t *testing.T) {
+		spy := &spyStorage{expTime: time.Now().Add(30 * time.Second)}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("ttl", "k"))
+		require.NoError(t, err)
+		assert.Equal(t, "30", string(got.Bytes))
+
+		got, err = e.Execute(cmd("pttl", "k"))
+		require.NoError(t, err)
+		assert.InDelta(t, 30000, mustAtoi(t, got.Bytes), 1000)
+	})
+
+	t.Run("absolute time", func(t *testing.T) {
+		spy := &spyStorage{expTime: time.UnixMilli(4102444800123)}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd("expiretime", "k"))
+		require.NoError(t, err)
+		assert.Equal(t, "4102444800", string(got.Bytes))
+
+		got, err = e.Execute(cmd("pexpiretime", "k"))
+		require.NoError(t, err)
+		assert.Equal(t, "4102444800123", string(got.Bytes))
+	})
+}
+
+func TestPersist(t *testing.T) {
+	spy := &spyStorage{persistOK: true}
+	e := executor.NewExecutor(spy)
+
+	got, err := e.Execute(cmd("persist", "k"))
+	require.NoError(t, err)
+	assert.Equal(t, resp.TypeInteger, got.Type)
+	assert.Equal(t, "1", string(got.Bytes))
+
+	require.Len(t, spy.calls, 1)
+	assert.Equal(t, "Persist", spy.calls[0].Method)
+
+	got, err = e.Execute(cmd("persist"))
+	require.NoError(t, err)
+	assert.Equal(t, resp.TypeError, got.Type)
+}
+
+func mustAtoi(t *testing.T, b []byte) int {
+	t.Helper()
+	n, err := strconv.Atoi(string(b))
+	require.NoError(t, err)
+	return n
+}
+
+// recorder implements executor.Propagator and records propagated commands.
+type recorder struct {
+	cmds [][]string
+}
+
+func (r *recorder) Propagate(args []resp.Value) {
+	cmd := make([]string, len(args))
+	for i, a := range args {
+		cmd[i] = string(a.Bytes)
+	}
+	r.cmds = append(r.cmds, cmd)
+}
+
+func TestPropagation(t *testing.T) {
+	t.Run("only successful writes", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: true, getVal: []byte("v"), delVal: 1}
+		e := executor.NewExecutor(spy)
+		rec := &recorder{}
+		e.AddPropagator(rec)
+
+		for _, c := range []resp.Value{
+			cmd("SET", "k", "v"),
+			cmd("GET", "k"),
+			cmd("SET", "k"),
+			cmd("ping"),
+			cmd("del", "k"),
+		} {
+			_, err := e.Execute(c)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, [][]string{{"SET", "k", "v"}, {"del", "k"}}, rec.cmds)
+	})
+
+	t.Run("relative expiry becomes absolute", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: true, expireOK: true}
+		e := executor.NewExecutor(spy)
+		rec := &recorder{}
+		e.AddPropagator(rec)
+
+		for _, c := range []resp.Value{
+			cmd("SET", "k", "v", "NX", "EX", "100"),
+			cmd("SETEX", "k", "100", "v"),
+			cmd("EXPIRE", "k", "100", "GT"),
+			cmd("EXPIREAT", "k", "4102444800"),
+		} {
+			_, err := e.Execute(c)
+			require.NoError(t, err)
+		}
+
+		require.Len(t, rec.cmds, 4)
+		assert.Equal(t, []string{"SET", "k", "v", "NX", "PXAT"}, rec.cmds[0][:5])
+		assert.Equal(t, []string{"SET", "k", "v", "PXAT"}, rec.cmds[1][:4])
+		assert.Equal(t, []string{"PEXPIREAT", "k"}, rec.cmds[2][:2])
+		assert.Equal(t, "GT", rec.cmds[2][3])
+		assert.Equal(t, []string{"PEXPIREAT", "k", "4102444800000"}, rec.cmds[3])
+
+		want := time.Now().Add(100 * time.Second).UnixMilli()
+		for _, got := range []string{rec.cmds[0][5], rec.cmds[1][4], rec.cmds[2][2]} {
+			assert.InDelta(t, want, mustAtoi(t, []byte(got)), 1000)
+		}
+
+		// Times that overflow are refused rather than propagated wrapped.
+		got, err := e.Execute(cmd("EXPIREAT", "k", "9223372036854775"))
+		require.NoError(t, err)
+		assert.Equal(t, "ERR invalid expire time in 'expireat' command", string(got.Bytes))
+		assert.Len(t, rec.cmds, 4)
+	})
+}
+
+func TestFlushAll(t *testing.T) {
+	for _, name := range []string{"flushall", "FLUSHDB"} {
+		spy := &spyStorage{}
+		e := executor.NewExecutor(spy)
+
+		got, err := e.Execute(cmd(name))
+		require.NoError(t, err)
+		assert.Equal(t, "OK", string(got.Bytes))
+		got, err = e.Execute(cmd(name, "async"))
+		require.NoError(t, err)
+		assert.Equal(t, "OK", string(got.Bytes))
+		assert.Equal(t, []call{{Method: "FlushAll"}, {Method: "FlushAll"}}, spy.calls)
+
+		got, err = e.Execute(cmd(name, "later"))
+		require.NoError(t, err)
+		assert.Equal(t, resp.TypeError, got.Type)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	t.Run("several writes are wrapped", func(t *testing.T) {
+		spy := &spyStorage{setOptsOK: true, getVal: []byte("v"), incrVal: 1}
+		e := executor.NewExecutor(spy)
+		rec := &recorder{}
+		e.AddPropagator(rec)
+
+		out, err := e.ExecuteAll([]resp.Value{
+			cmd("SET", "k", "v", "EX", "10"),
+			cmd("GET", "k"),
+			cmd("INCR", "n"),
+		})
+		require.NoError(t, err)
+		require.Len(t, out, 3)
+		assert.Equal(t, "v", string(out[1].Bytes))
+
+		require.Len(t, rec.cmds, 4)
+		assert.Equal(t, []string{"MULTI"}, rec.cmds[0])
+		assert.Equal(t, []string{"SET", "k", "v", "PXAT"}, rec.cmds[1][:4])
+		assert.Equal(t, []string{"INCR", "n"}, rec.cmds[2])
+		assert.Equal(t, []string{"EXEC"}, rec.cmds[3])
+		assert.Equal(t, int64(2), e.Dirty())
+	})
+
+	t.Run("a single write is not wrapped", func(t *testing.T) {
+		spy := &spyStorage{getVal: []byte("v"), incrVal: 1}
+		e := executor.NewExecutor(spy)
+		rec := &recorder{}
+		e.AddPropagator(rec)
+
+		e.Atomic(func(tx *executor.Tx) {
+			tx.Execute(cmd("GET", "k"))
+			tx.Execute(cmd("INCR", "n"))
+			tx.Execute(cmd("SET", "k"))
+		})
+		assert.Equal(t, [][]string{{"INCR", "n"}}, rec.cmds)
+	})
+}
+
+// observer implements executor.Observer and records the commands observed.
+type observer struct {
+	names []string
+}
+
+func (o *observer) ObserveCommand(name string, d time.Duration) {
+	o.names = append(o.names, name)
+}
+
+func TestObserver(t *testing.T) {
+	spy := &spyStorage{getVal: []byte("v"), incrVal: 1}
+	e := executor.NewExecutor(spy)
+	obs := &observer{}
+	e.SetObserver(obs)
+
+	e.Execute(cmd("GET", "k"))
+	e.Execute(cmd("NOSUCH"))
+	e.Execute(cmd("SET", "k"))
+	e.Atomic(func(tx *executor.Tx) {
+		tx.Execute(cmd("INCR", "n"))
+	})
+	assert.Equal(t, []string{"get", "set", "incr"}, obs.names)
+}
+
+func TestFreeMemory(t *testing.T) {
+	s := storage.NewInMemoryStorage()
+	e := executor.NewExecutor(s)
+	rec := &recorder{}
+	e.AddPropagator(rec)
+
+	for _, k := range []string{"a", "b"} {
+		_, err := e.Execute(cmd("SET", k, "v"))
+		require.NoError(t, err)
+	}
+	n, err := e.FreeMemory()
+	require.NoError(t, err, "no limit")
+	assert.Zero(t, n)
+
+	rec.cmds = nil
+	s.SetMemoryLimit(storage.MemoryLimit{MaxBytes: s.UsedMemory() - 1, Policy: storage.NoEviction})
+	_, err = e.FreeMemory()
+	assert.ErrorIs(t, err, storage.ErrOutOfMemory)
+
+	s.SetMemoryLimit(storage.MemoryLimit{MaxBytes: s.UsedMemory() - 1, Policy: storage.AllKeysRandom})
+	n, err = e.FreeMemory()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, rec.cmds, 1, "evictions are propagated")
+	assert.Equal(t, "DEL", rec.cmds[0][0])
+}
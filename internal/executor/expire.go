@@ -0,0 +1,108 @@
+package executor
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// expireAt converts n units into an absolute time, either relative to now or
+// as an offset from the unix epoch. It reports false if the result overflows.
+func expireAt(n int64, unit time.Duration, absolute bool) (time.Time, bool) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+	d := time.Duration(n) * unit
+	if absolute {
+		return time.Unix(0, int64(d)), true
+	}
+	return time.Now().Add(d), true
+}
+
+func invalidExpireTime(name string) resp.Value {
+	return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR invalid expire time in '" + name + "' command")}
+}
+
+func (e *Executor) expire(args []resp.Value, unit time.Duration, absolute bool, name string) (resp.Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for '" + name + "' command")}, nil
+	}
+	n, err := strconv.ParseInt(string(args[1].Bytes), 10, 64)
+	if err != nil {
+		return errNotInteger, nil
+	}
+
+	cond := storage.ExpireAlways
+	if len(args) == 3 {
+		switch strings.ToUpper(string(args[2].Bytes)) {
+		case "NX":
+			cond = storage.ExpireNX
+		case "XX":
+			cond = storage.ExpireXX
+		case "GT":
+			cond = storage.ExpireGT
+		case "LT":
+			cond = storage.ExpireLT
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unsupported option " + string(args[2].Bytes))}, nil
+		}
+	}
+
+	at, ok := expireAt(n, unit, absolute)
+	if !ok {
+		return invalidExpireTime(name), nil
+	}
+
+	k := string(args[0].Bytes)
+	changed, err := e.storage.Expire(k, at, cond)
+	if err != nil {
+		return storageError(err)
+	}
+	if !changed {
+		return resp.Value{Type: resp.TypeInteger, Bytes: []byte("0")}, nil
+	}
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte("1")}, nil
+}
+
+// ttl implements TTL, PTTL, EXPIRETIME and PEXPIRETIME. It replies -2 if the
+// key does not exist and -1 if it has no expiry.
+func (e *Executor) ttl(args []resp.Value, unit time.Duration, absolute bool) (resp.Value, error) {
+	k := string(args[0].Bytes)
+	at, err := e.storage.ExpireTime(k)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return resp.Value{Type: resp.TypeInteger, Bytes: []byte("-2")}, nil
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	if at.IsZero() {
+		return resp.Value{Type: resp.TypeInteger, Bytes: []byte("-1")}, nil
+	}
+
+	var n int64
+	if absolute {
+		n = at.UnixMilli() / unit.Milliseconds()
+	} else {
+		remaining := max(time.Until(at), 0)
+		// Round to the nearest unit, as Redis does for TTL.
+		n = int64((remaining + unit/2) / unit)
+	}
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(n, 10))}, nil
+}
+
+func (e *Executor) persist(args []resp.Value) (resp.Value, error) {
+	k := string(args[0].Bytes)
+	removed, err := e.storage.Persist(k)
+	if err != nil {
+		return storageError(err)
+	}
+	if !removed {
+		return resp.Value{Type: resp.TypeInteger, Bytes: []byte("0")}, nil
+	}
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte("1")}, nil
+}
@@ -0,0 +1,274 @@
+package executor
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// hset implements HSET, which replies with the number of fields added, and
+// the deprecated HMSET, which replies OK.
+func (e *Executor) hset(args []resp.Value, name string) (resp.Value, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongArgs(name), nil
+	}
+	n, err := e.storage.HashSet(string(args[0].Bytes), values(args[1:]), false)
+	if err != nil {
+		return storageError(err)
+	}
+	if name == cmdHMSet {
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) hsetnx(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.HashSet(string(args[0].Bytes), values(args[1:]), true)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) hget(args []resp.Value) (resp.Value, error) {
+	vals, err := e.storage.HashGet(string(args[0].Bytes), args[1].Bytes)
+	if err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: vals[0]}, nil
+}
+
+func (e *Executor) hmget(args []resp.Value) (resp.Value, error) {
+	vals, err := e.storage.HashGet(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return bulks(vals), nil
+}
+
+func (e *Executor) hdel(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.HashDel(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// hgetall implements HGETALL, which replies with a map, and HKEYS and HVALS.
+func (e *Executor) hgetall(args []resp.Value, name string) (resp.Value, error) {
+	pairs, err := e.storage.HashGetAll(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	switch name {
+	case cmdHKeys:
+		pairs = every(pairs, 0)
+	case cmdHVals:
+		pairs = every(pairs, 1)
+	default:
+		out := bulks(pairs)
+		out.Type = resp.TypeMap
+		return out, nil
+	}
+	return bulks(pairs), nil
+}
+
+func (e *Executor) hlen(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.HashLen(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// hexists implements HEXISTS and HSTRLEN, which both look at a single field.
+func (e *Executor) hexists(args []resp.Value, name string) (resp.Value, error) {
+	vals, err := e.storage.HashGet(string(args[0].Bytes), args[1].Bytes)
+	if err != nil {
+		return storageError(err)
+	}
+	if name == cmdHStrLen {
+		return integer(len(vals[0])), nil
+	}
+	if vals[0] == nil {
+		return integer(0), nil
+	}
+	return integer(1), nil
+}
+
+func (e *Executor) hincrby(args []resp.Value) (resp.Value, error) {
+	delta, err := strconv.ParseInt(string(args[2].Bytes), 10, 64)
+	if err != nil {
+		return errNotInteger, nil
+	}
+	n, err := e.storage.HashIncrBy(string(args[0].Bytes), args[1].Bytes, delta)
+	switch {
+	case errors.Is(err, storage.ErrNotInteger):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR hash value is not an integer")}, nil
+	case err != nil:
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(n, 10))}, nil
+}
+
+func (e *Executor) hincrbyfloat(args []resp.Value) (resp.Value, error) {
+	delta, err := strconv.ParseFloat(string(args[2].Bytes), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return errNotFloat, nil
+	}
+	v, err := e.storage.HashIncrByFloat(string(args[0].Bytes), args[1].Bytes, delta)
+	switch {
+	case errors.Is(err, storage.ErrNotFloat):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR hash value is not a float")}, nil
+	case err != nil:
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: v}, nil
+}
+
+// hscan implements HSCAN key cursor [MATCH pattern] [COUNT count]
+// [NOVALUES]. As in Redis, MATCH filters the fields after they have been
+// fetched, so a call may return fewer than COUNT fields, or none, before
+// the scan is complete.
+func (e *Executor) hscan(args []resp.Value) (resp.Value, error) {
+	opts, errReply, ok := parseScanArgs(args[1:], true)
+	if !ok {
+		return errReply, nil
+	}
+	next, pairs, err := e.storage.HashScan(string(args[0].Bytes), opts.cursor, opts.count)
+	if err != nil {
+		return storageError(err)
+	}
+
+	out := make([][]byte, 0, len(pairs))
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if opts.match != "" && !glob.Match(opts.match, string(pairs[i])) {
+			continue
+		}
+		out = append(out, pairs[i])
+		if !opts.noValues {
+			out = append(out, pairs[i+1])
+		}
+	}
+	return scanReply(next, out), nil
+}
+
+// scanOptions are the arguments shared by the SCAN family.
+type scanOptions struct {
+	cursor   uint64
+	match    string
+	count    int
+	noValues bool
+}
+
+// parseScanArgs parses "cursor [MATCH pattern] [COUNT count]", plus
+// NOVALUES if allowed. On failure it returns the error to reply with.
+func parseScanArgs(args []resp.Value, allowNoValues bool) (scanOptions, resp.Value, bool) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(string(args[0].Bytes), 10, 64)
+	if err != nil {
+		return opts, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR invalid cursor")}, false
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i].Bytes)); {
+		case opt == "MATCH" && i+1 < len(args):
+			opts.match = string(args[i+1].Bytes)
+			i++
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(string(args[i+1].Bytes))
+			if err != nil {
+				return opts, errNotInteger, false
+			}
+			if n < 1 {
+				return opts, errSyntax, false
+			}
+			opts.count = n
+			i++
+		case opt == "NOVALUES" && allowNoValues:
+			opts.noValues = true
+		default:
+			return opts, errSyntax, false
+		}
+	}
+	// A match-all pattern needs no filtering.
+	if opts.match == "*" {
+		opts.match = ""
+	}
+	return opts, resp.Value{}, true
+}
+
+// scanReply builds the two element reply of the SCAN family.
+func scanReply(next uint64, vals [][]byte) resp.Value {
+	return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+		{Type: resp.TypeBulkString, Bytes: []byte(strconv.FormatUint(next, 10))},
+		bulks(vals),
+	}}
+}
+
+// hrandfield implements HRANDFIELD key [count [WITHVALUES]].
+func (e *Executor) hrandfield(args []resp.Value) (resp.Value, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return wrongArgs(cmdHRandField), nil
+	}
+	k := string(args[0].Bytes)
+	if len(args) == 1 {
+		pairs, err := e.storage.HashRandFields(k, 1)
+		if err != nil {
+			return storageError(err)
+		}
+		if len(pairs) == 0 {
+			return resp.Value{Type: resp.TypeBulkString}, nil
+		}
+		return resp.Value{Type: resp.TypeBulkString, Bytes: pairs[0]}, nil
+	}
+
+	count, err := strconv.Atoi(string(args[1].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	withValues := false
+	if len(args) == 3 {
+		if !strings.EqualFold(string(args[2].Bytes), "WITHVALUES") {
+			return errSyntax, nil
+		}
+		withValues = true
+	}
+	pairs, err := e.storage.HashRandFields(k, count)
+	if err != nil {
+		return storageError(err)
+	}
+	if !withValues {
+		return bulks(every(pairs, 0)), nil
+	}
+	// Each field is paired with its value, flattened in RESP2.
+	out := make([]resp.Value, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		out = append(out, bulks(pairs[i:i+2]))
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out, RESP2: resp.FlatPairs}, nil
+}
+
+// values returns the bulk string payloads of args.
+func values(args []resp.Value) [][]byte {
+	out := make([][]byte, len(args))
+	for i, a := range args {
+		out[i] = a.Bytes
+	}
+	return out
+}
+
+// every returns the fields (offset 0) or the values (offset 1) of pairs.
+func every(pairs [][]byte, offset int) [][]byte {
+	out := make([][]byte, 0, len(pairs)/2)
+	for i := offset; i < len(pairs); i += 2 {
+		out = append(out, pairs[i])
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package executor_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashCommands(t *testing.T) {
+	e := executor.NewExecutor(storage.NewInMemoryStorage())
+	run := func(args ...string) resp.Value {
+		t.Helper()
+		out, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		return out
+	}
+	sorted := func(v resp.Value) []string {
+		out := []string{}
+		for _, el := range v.Array {
+			out = append(out, string(el.Bytes))
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	assert.Equal(t, "2", string(run("HSET", "h", "name", "ada", "lang", "go").Bytes))
+	assert.Equal(t, "OK", string(run("HMSET", "h", "lang", "c", "year", "1843").Bytes))
+	assert.Equal(t, "0", string(run("HSETNX", "h", "name", "bob").Bytes))
+	assert.Equal(t, "hash", string(run("TYPE", "h").Bytes))
+	assert.Equal(t, "ada", string(run("HGET", "h", "name").Bytes))
+	assert.Nil(t, run("HGET", "h", "nope").Bytes)
+	assert.Nil(t, run("HGET", "missing", "nope").Bytes)
+
+	mget := run("HMGET", "h", "lang", "nope")
+	require.Len(t, mget.Array, 2)
+	assert.Equal(t, "c", string(mget.Array[0].Bytes))
+	assert.Nil(t, mget.Array[1].Bytes)
+
+	assert.Equal(t, "3", string(run("HLEN", "h").Bytes))
+	assert.Equal(t, "1", string(run("HEXISTS", "h", "year").Bytes))
+	assert.Equal(t, "0", string(run("HEXISTS", "h", "nope").Bytes))
+	assert.Equal(t, "4", string(run("HSTRLEN", "h", "year").Bytes))
+	assert.Equal(t, []string{"lang", "name", "year"}, sorted(run("HKEYS", "h")))
+	assert.Equal(t, []string{"1843", "ada", "c"}, sorted(run("HVALS", "h")))
+	assert.Equal(t, []string{"1843", "ada", "c", "lang", "name", "year"}, sorted(run("HGETALL", "h")))
+	assert.Empty(t, run("HGETALL", "missing").Array)
+
+	assert.Equal(t, "1853", string(run("HINCRBY", "h", "year", "10").Bytes))
+	assert.Equal(t, "ERR hash value is not an integer", string(run("HINCRBY", "h", "name", "1").Bytes))
+	assert.Equal(t, "1.5", string(run("HINCRBYFLOAT", "h", "score", "1.5").Bytes))
+	assert.Equal(t, "ERR hash value is not a float", string(run("HINCRBYFLOAT", "h", "name", "1").Bytes))
+	assert.Equal(t, "ERR value is not a valid float", string(run("HINCRBYFLOAT", "h", "score", "x").Bytes))
+
+	assert.Equal(t, "2", string(run("HDEL", "h", "score", "year", "nope").Bytes))
+
+	t.Run("hscan", func(t *testing.T) {
+		out := run("HSCAN", "h", "0", "COUNT", "100")
+		require.Len(t, out.Array, 2)
+		assert.Equal(t, "0", string(out.Array[0].Bytes))
+		assert.Equal(t, []string{"ada", "c", "lang", "name"}, sorted(out.Array[1]))
+
+		out = run("HSCAN", "h", "0", "MATCH", "n*", "NOVALUES")
+		assert.Equal(t, []string{"name"}, sorted(out.Array[1]))
+
+		assert.Equal(t, "ERR invalid cursor", string(run("HSCAN", "h", "x").Bytes))
+		assert.Equal(t, "ERR syntax error", string(run("HSCAN", "h", "0", "COUNT", "0").Bytes))
+	})
+
+	t.Run("hrandfield", func(t *testing.T) {
+		v := string(run("HRANDFIELD", "h").Bytes)
+		assert.Contains(t, []string{"name", "lang"}, v)
+		assert.Nil(t, run("HRANDFIELD", "missing").Bytes)
+		assert.Len(t, run("HRANDFIELD", "h", "5").Array, 2)
+		withValues := run("HRANDFIELD", "h", "5", "WITHVALUES")
+		require.Len(t, withValues.Array, 2)
+		assert.Len(t, withValues.Array[0].Array, 2)
+		assert.Equal(t, resp.FlatPairs, withValues.RESP2)
+		assert.Len(t, run("HRANDFIELD", "h", "-5").Array, 5)
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		run("SET", "s", "v")
+		assert.Contains(t, string(run("HSET", "s", "f", "v").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("HGETALL", "s").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("GET", "h").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("LPUSH", "h", "x").Bytes), "WRONGTYPE")
+	})
+
+	t.Run("bad arguments", func(t *testing.T) {
+		assert.Equal(t, resp.TypeError, run("HSET", "h", "f").Type)
+		assert.Equal(t, resp.TypeError, run("HSET", "h", "f", "v", "g").Type)
+		assert.Equal(t, resp.TypeError, run("HINCRBY", "h", "f", "1.5").Type)
+		assert.Equal(t, resp.TypeError, run("HRANDFIELD", "h", "1", "WITHSCORES").Type)
+	})
+}
@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// parseEnd parses the LEFT or RIGHT argument of LMOVE.
+func parseEnd(v resp.Value) (storage.ListEnd, bool) {
+	switch strings.ToUpper(string(v.Bytes)) {
+	case "LEFT":
+		return storage.ListHead, true
+	case "RIGHT":
+		return storage.ListTail, true
+	}
+	return 0, false
+}
+
+// push implements LPUSH, RPUSH, LPUSHX and RPUSHX.
+func (e *Executor) push(args []resp.Value, end storage.ListEnd, existing bool) (resp.Value, error) {
+	n, err := e.storage.ListPush(string(args[0].Bytes), end, values(args[1:]), existing)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// pop implements LPOP and RPOP. Without a count it replies with a single
+// element, with one it replies with an array.
+func (e *Executor) pop(args []resp.Value, end storage.ListEnd, name string) (resp.Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(name), nil
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bytes))
+		if err != nil || n < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is out of range, must be positive")}, nil
+		}
+		count = n
+	}
+
+	vals, err := e.storage.ListPop(string(args[0].Bytes), end, count)
+	if err != nil {
+		return storageError(err)
+	}
+	if len(args) == 2 {
+		if vals == nil {
+			return resp.Value{Type: resp.TypeArray}, nil
+		}
+		return bulks(vals), nil
+	}
+	if len(vals) == 0 {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: vals[0]}, nil
+}
+
+func (e *Executor) llen(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.ListLen(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) lindex(args []resp.Value) (resp.Value, error) {
+	i, err := strconv.Atoi(string(args[1].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	v, err := e.storage.ListIndex(string(args[0].Bytes), i)
+	if err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: v}, nil
+}
+
+func (e *Executor) lset(args []resp.Value) (resp.Value, error) {
+	i, err := strconv.Atoi(string(args[1].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	if err := e.storage.ListSet(string(args[0].Bytes), i, args[2].Bytes); err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// rangeArgs parses the start and stop arguments of LRANGE and LTRIM.
+func rangeArgs(args []resp.Value) (int, int, bool) {
+	start, err := strconv.Atoi(string(args[0].Bytes))
+	if err != nil {
+		return 0, 0, false
+	}
+	stop, err := strconv.Atoi(string(args[1].Bytes))
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func (e *Executor) lrange(args []resp.Value) (resp.Value, error) {
+	start, stop, ok := rangeArgs(args[1:])
+	if !ok {
+		return errNotInteger, nil
+	}
+	vals, err := e.storage.ListRange(string(args[0].Bytes), start, stop)
+	if err != nil {
+		return storageError(err)
+	}
+	return bulks(vals), nil
+}
+
+func (e *Executor) ltrim(args []resp.Value) (resp.Value, error) {
+	start, stop, ok := rangeArgs(args[1:])
+	if !ok {
+		return errNotInteger, nil
+	}
+	if err := e.storage.ListTrim(string(args[0].Bytes), start, stop); err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+func (e *Executor) lrem(args []resp.Value) (resp.Value, error) {
+	count, err := strconv.Atoi(string(args[1].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	n, err := e.storage.ListRem(string(args[0].Bytes), count, args[2].Bytes)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) linsert(args []resp.Value) (resp.Value, error) {
+	var before bool
+	switch strings.ToUpper(string(args[1].Bytes)) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		return errSyntax, nil
+	}
+	n, err := e.storage.ListInsert(string(args[0].Bytes), before, args[2].Bytes, args[3].Bytes)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) lmove(args []resp.Value) (resp.Value, error) {
+	from, ok := parseEnd(args[2])
+	if !ok {
+		return errSyntax, nil
+	}
+	to, ok := parseEnd(args[3])
+	if !ok {
+		return errSyntax, nil
+	}
+	return e.move(string(args[0].Bytes), string(args[1].Bytes), from, to)
+}
+
+// rpoplpush is LMOVE source destination RIGHT LEFT.
+func (e *Executor) rpoplpush(args []resp.Value) (resp.Value, error) {
+	return e.move(string(args[0].Bytes), string(args[1].Bytes), storage.ListTail, storage.ListHead)
+}
+
+func (e *Executor) move(src, dst string, from, to storage.ListEnd) (resp.Value, error) {
+	v, err := e.storage.ListMove(src, dst, from, to)
+	if err != nil {
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: v}, nil
+}
@@ -0,0 +1,88 @@
+package executor_test
+
+import (
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCommands(t *testing.T) {
+	e := executor.NewExecutor(storage.NewInMemoryStorage())
+	run := func(args ...string) resp.Value {
+		t.Helper()
+		out, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		return out
+	}
+	elems := func(v resp.Value) []string {
+		out := []string{}
+		for _, el := range v.Array {
+			out = append(out, string(el.Bytes))
+		}
+		return out
+	}
+
+	assert.Equal(t, "0", string(run("LPUSHX", "l", "a").Bytes))
+	assert.Equal(t, "none", string(run("TYPE", "l").Bytes))
+	assert.Equal(t, "3", string(run("RPUSH", "l", "b", "c", "d").Bytes))
+	assert.Equal(t, "4", string(run("LPUSH", "l", "a").Bytes))
+	assert.Equal(t, "list", string(run("TYPE", "l").Bytes))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, elems(run("LRANGE", "l", "0", "-1")))
+	assert.Equal(t, "4", string(run("LLEN", "l").Bytes))
+	assert.Equal(t, "d", string(run("LINDEX", "l", "-1").Bytes))
+	assert.Nil(t, run("LINDEX", "l", "10").Bytes)
+
+	assert.Equal(t, "OK", string(run("LSET", "l", "1", "B").Bytes))
+	assert.Equal(t, "ERR index out of range", string(run("LSET", "l", "9", "x").Bytes))
+	assert.Equal(t, "ERR no such key", string(run("LSET", "missing", "0", "x").Bytes))
+
+	assert.Equal(t, "5", string(run("LINSERT", "l", "BEFORE", "c", "x").Bytes))
+	assert.Equal(t, "-1", string(run("LINSERT", "l", "AFTER", "nope", "x").Bytes))
+	assert.Equal(t, []string{"a", "B", "x", "c", "d"}, elems(run("LRANGE", "l", "0", "-1")))
+	assert.Equal(t, "1", string(run("LREM", "l", "0", "x").Bytes))
+	assert.Equal(t, "OK", string(run("LTRIM", "l", "1", "-1").Bytes))
+	assert.Equal(t, []string{"B", "c", "d"}, elems(run("LRANGE", "l", "0", "-1")))
+
+	assert.Equal(t, "d", string(run("RPOPLPUSH", "l", "other").Bytes))
+	assert.Equal(t, "B", string(run("LMOVE", "l", "other", "LEFT", "RIGHT").Bytes))
+	assert.Equal(t, []string{"d", "B"}, elems(run("LRANGE", "other", "0", "-1")))
+
+	assert.Equal(t, "c", string(run("LPOP", "l").Bytes))
+	assert.Equal(t, "none", string(run("TYPE", "l").Bytes), "empty lists are deleted")
+	assert.Nil(t, run("LPOP", "l").Bytes)
+	pop := run("RPOP", "l", "2")
+	assert.Equal(t, resp.TypeArray, pop.Type)
+	assert.Nil(t, pop.Array)
+	assert.Equal(t, []string{"B", "d"}, elems(run("RPOP", "other", "5")))
+
+	t.Run("wrong type", func(t *testing.T) {
+		run("SET", "s", "v")
+		run("RPUSH", "list", "v")
+		for _, args := range [][]string{
+			{"LPUSH", "s", "x"},
+			{"LRANGE", "s", "0", "-1"},
+			{"LMOVE", "list", "s", "LEFT", "LEFT"},
+			{"GET", "list"},
+			{"INCR", "list"},
+			{"SET", "list", "v", "GET"},
+		} {
+			out := run(args...)
+			assert.Equal(t, resp.TypeError, out.Type, args)
+			assert.Contains(t, string(out.Bytes), "WRONGTYPE", args)
+		}
+		assert.Equal(t, []string{"v"}, elems(run("LRANGE", "list", "0", "-1")), "failed LMOVE left the source alone")
+		assert.Equal(t, "OK", string(run("SET", "list", "v").Bytes), "SET overwrites any type")
+	})
+
+	t.Run("bad arguments", func(t *testing.T) {
+		assert.Equal(t, resp.TypeError, run("LPUSH", "l").Type)
+		assert.Equal(t, resp.TypeError, run("LINDEX", "l", "x").Type)
+		assert.Equal(t, resp.TypeError, run("LINSERT", "l", "NEAR", "a", "b").Type)
+		assert.Equal(t, resp.TypeError, run("LMOVE", "l", "m", "UP", "LEFT").Type)
+		assert.Equal(t, resp.TypeError, run("LPOP", "l", "-1").Type)
+	})
+}
@@ -0,0 +1,30 @@
+package executor
+
+import "time"
+
+// Observer is told about every command the executor runs, for metrics.
+type Observer interface {
+	// ObserveCommand is called once a command has run, with its lowercase
+	// name and how long it took, waiting for other commands included.
+	ObserveCommand(name string, d time.Duration)
+}
+
+// SetObserver registers o to be told about every command. It must be called
+// before the executor is used concurrently.
+func (e *Executor) SetObserver(o Observer) {
+	e.observer = o
+}
+
+// observe starts timing a command and returns the function that reports it
+// to the observer. Unknown commands are not reported, so that clients cannot
+// make up metrics.
+func (e *Executor) observe(name string) func() {
+	if e.observer == nil {
+		return func() {}
+	}
+	if _, ok := commands[name]; !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { e.observer.ObserveCommand(name, time.Since(start)) }
+}
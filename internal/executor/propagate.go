@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// Propagator receives every successful write command, in the order the
+// writes were applied to storage.
+type Propagator interface {
+	Propagate(args []resp.Value)
+}
+
+// AddPropagator registers p to receive write commands. It must be called
+// before the executor is used concurrently, or else from within Barrier.
+func (e *Executor) AddPropagator(p Propagator) {
+	e.propagators = append(e.propagators, p)
+}
+
+// Barrier runs fn while no command is executing, so that fn observes the
+// keyspace and the propagated command stream at the same instant. fn must not
+// call Execute.
+func (e *Executor) Barrier(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn()
+}
+
+func (e *Executor) propagate(cmds ...[]resp.Value) {
+	for _, args := range cmds {
+		if args == nil {
+			continue
+		}
+		for _, p := range e.propagators {
+			p.Propagate(args)
+		}
+	}
+}
+
+// translate rewrites commands whose effect depends on when or where they run
+// into deterministic equivalents: relative expiry times become absolute ones,
+// random pops become removals of the members popped, generated stream IDs
+// become explicit ones and claims of idle entries become claims of the
+// entries claimed, given the reply out. It returns nil if there is nothing to
+// propagate.
+func translate(name string, args []resp.Value, out resp.Value) []resp.Value {
+	switch name {
+	case cmdXAdd:
+		if out.Bytes == nil {
+			return nil
+		}
+		cmd := slices.Clone(args)
+		cmd[1+xaddIDIndex(args[1:])] = out
+		return cmd
+	case cmdXReadGroup:
+		// Replicas must not block, and a read that delivered nothing
+		// changed nothing worth replaying.
+		if out.Array == nil {
+			return nil
+		}
+		if x, _, _ := parseXRead(args, true); x.block {
+			return slices.Concat(args[:x.blockAt], args[x.blockAt+2:])
+		}
+		return args
+	case cmdXClaim:
+		return translateClaim(args, out)
+	case cmdXAutoClaim:
+		claimed, deleted := out.Array[1].Array, out.Array[2].Array
+		if len(claimed) == 0 && len(deleted) == 0 {
+			return nil
+		}
+		cmd := []resp.Value{bulk("XCLAIM"), args[1], args[2], args[3], bulk("0")}
+		cmd = append(cmd, claimedIDs(claimed)...)
+		cmd = append(cmd, deleted...)
+		cmd = append(cmd, bulk("TIME"), bulk(strconv.FormatInt(time.Now().UnixMilli(), 10)))
+		for _, a := range args[6:] {
+			if strings.EqualFold(string(a.Bytes), "JUSTID") {
+				cmd = append(cmd, bulk("JUSTID"))
+			}
+		}
+		return cmd
+	case cmdSPop:
+		popped := out.Array
+		if out.Type != resp.TypeArray {
+			popped = []resp.Value{out}
+		}
+		if len(popped) == 0 || popped[0].Bytes == nil {
+			return nil
+		}
+		return append([]resp.Value{bulk("SREM"), args[1]}, popped...)
+	case cmdSet:
+		out := make([]resp.Value, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			opt := strings.ToUpper(string(args[i].Bytes))
+			if i >= 3 && (opt == "EX" || opt == "PX") && i+1 < len(args) {
+				unit := time.Second
+				if opt == "PX" {
+					unit = time.Millisecond
+				}
+				out = append(out, bulk("PXAT"), absoluteMillis(args[i+1], unit, false))
+				i++
+				continue
+			}
+			out = append(out, args[i])
+		}
+		return out
+	case cmdSetEx, cmdPSetEx:
+		unit := time.Second
+		if name == cmdPSetEx {
+			unit = time.Millisecond
+		}
+		return []resp.Value{bulk("SET"), args[1], args[3], bulk("PXAT"), absoluteMillis(args[2], unit, false)}
+	case cmdExpire, cmdPExpire:
+		unit := time.Second
+		if name == cmdPExpire {
+			unit = time.Millisecond
+		}
+		out := []resp.Value{bulk("PEXPIREAT"), args[1], absoluteMillis(args[2], unit, false)}
+		return append(out, args[3:]...)
+	case cmdExpireAt:
+		out := []resp.Value{bulk("PEXPIREAT"), args[1], absoluteMillis(args[2], time.Second, true)}
+		return append(out, args[3:]...)
+	}
+	return args
+}
+
+// translateClaim rewrites XCLAIM into a claim of the entries that were idle
+// long enough, at an absolute delivery time.
+func translateClaim(args []resp.Value, out resp.Value) []resp.Value {
+	if len(out.Array) == 0 {
+		return nil
+	}
+	cmd := []resp.Value{args[0], args[1], args[2], args[3], bulk("0")}
+	cmd = append(cmd, claimedIDs(out.Array)...)
+	i := 5
+	for i < len(args) {
+		if _, ok := parseStreamID(args[i].Bytes, 0); !ok {
+			break
+		}
+		i++
+	}
+	timed := false
+	for ; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i].Bytes)); opt {
+		case "IDLE":
+			idle, _ := strconv.ParseInt(string(args[i+1].Bytes), 10, 64)
+			at := time.Now().UnixMilli() - max(idle, 0)
+			cmd = append(cmd, bulk("TIME"), bulk(strconv.FormatInt(at, 10)))
+			timed = true
+			i++
+		case "TIME", "RETRYCOUNT", "LASTID":
+			cmd = append(cmd, args[i], args[i+1])
+			timed = timed || opt == "TIME"
+			i++
+		default:
+			cmd = append(cmd, args[i])
+		}
+	}
+	if !timed {
+		cmd = append(cmd, bulk("TIME"), bulk(strconv.FormatInt(time.Now().UnixMilli(), 10)))
+	}
+	return cmd
+}
+
+// claimedIDs returns the IDs of the entries in a claim reply, which are
+// either IDs or [id, fields] pairs.
+func claimedIDs(claimed []resp.Value) []resp.Value {
+	ids := make([]resp.Value, len(claimed))
+	for i, c := range claimed {
+		if c.Type == resp.TypeArray {
+			c = c.Array[0]
+		}
+		ids[i] = c
+	}
+	return ids
+}
+
+// absoluteMillis converts a time argument in unit, relative to now unless
+// absolute, into a unix timestamp in milliseconds. It applies the same range
+// check as the command handler, which refuses the times it cannot represent.
+func absoluteMillis(v resp.Value, unit time.Duration, absolute bool) resp.Value {
+	n, _ := strconv.ParseInt(string(v.Bytes), 10, 64)
+	at, ok := expireAt(n, unit, absolute)
+	if !ok {
+		return v
+	}
+	return bulk(strconv.FormatInt(at.UnixMilli(), 10))
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
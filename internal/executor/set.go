@@ -0,0 +1,209 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+func (e *Executor) sadd(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.SetAdd(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) srem(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.SetRem(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) smembers(args []resp.Value) (resp.Value, error) {
+	members, err := e.storage.SetMembers(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return set(members), nil
+}
+
+// sismember implements SISMEMBER, which replies with an integer, and
+// SMISMEMBER, which replies with an array of them.
+func (e *Executor) sismember(args []resp.Value, name string) (resp.Value, error) {
+	found, err := e.storage.SetIsMember(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	out := make([]resp.Value, len(found))
+	for i, ok := range found {
+		out[i] = integer(0)
+		if ok {
+			out[i] = integer(1)
+		}
+	}
+	if name == cmdSIsMember {
+		return out[0], nil
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
+
+func (e *Executor) scard(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.SetCard(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// spop implements SPOP key [count]. Without a count it replies with a single
+// member, with one it replies with an array.
+func (e *Executor) spop(args []resp.Value) (resp.Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(cmdSPop), nil
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bytes))
+		if err != nil || n < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is out of range, must be positive")}, nil
+		}
+		count = n
+	}
+	members, err := e.storage.SetPop(string(args[0].Bytes), count)
+	if err != nil {
+		return storageError(err)
+	}
+	if len(args) == 2 {
+		return bulks(members), nil
+	}
+	if len(members) == 0 {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: members[0]}, nil
+}
+
+// srandmember implements SRANDMEMBER key [count].
+func (e *Executor) srandmember(args []resp.Value) (resp.Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(cmdSRandMember), nil
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bytes))
+		if err != nil {
+			return errNotInteger, nil
+		}
+		count = n
+	}
+	members, err := e.storage.SetRandMembers(string(args[0].Bytes), count)
+	if err != nil {
+		return storageError(err)
+	}
+	if len(args) == 2 {
+		return bulks(members), nil
+	}
+	if len(members) == 0 {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
+	return resp.Value{Type: resp.TypeBulkString, Bytes: members[0]}, nil
+}
+
+// sscan implements SSCAN key cursor [MATCH pattern] [COUNT count].
+func (e *Executor) sscan(args []resp.Value) (resp.Value, error) {
+	opts, errReply, ok := parseScanArgs(args[1:], false)
+	if !ok {
+		return errReply, nil
+	}
+	next, members, err := e.storage.SetScan(string(args[0].Bytes), opts.cursor, opts.count)
+	if err != nil {
+		return storageError(err)
+	}
+	out := members[:0]
+	for _, m := range members {
+		if opts.match == "" || glob.Match(opts.match, string(m)) {
+			out = append(out, m)
+		}
+	}
+	return scanReply(next, out), nil
+}
+
+func (e *Executor) smove(args []resp.Value) (resp.Value, error) {
+	moved, err := e.storage.SetMove(string(args[0].Bytes), string(args[1].Bytes), args[2].Bytes)
+	if err != nil {
+		return storageError(err)
+	}
+	if moved {
+		return integer(1), nil
+	}
+	return integer(0), nil
+}
+
+// scombine implements SINTER, SUNION and SDIFF.
+func (e *Executor) scombine(args []resp.Value, op storage.SetOp) (resp.Value, error) {
+	members, err := e.storage.SetCombine(op, keys(args)...)
+	if err != nil {
+		return storageError(err)
+	}
+	return set(members), nil
+}
+
+// scombineStore implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE.
+func (e *Executor) scombineStore(args []resp.Value, op storage.SetOp) (resp.Value, error) {
+	n, err := e.storage.SetCombineStore(op, string(args[0].Bytes), keys(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// sintercard implements SINTERCARD numkeys key [key ...] [LIMIT limit].
+func (e *Executor) sintercard(args []resp.Value) (resp.Value, error) {
+	numKeys, err := strconv.Atoi(string(args[0].Bytes))
+	if err != nil || numKeys < 1 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR numkeys should be greater than 0")}, nil
+	}
+	if numKeys > len(args)-1 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Number of keys can't be greater than number of args")}, nil
+	}
+
+	limit := 0
+	rest := args[1+numKeys:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(string(rest[0].Bytes), "LIMIT"):
+		limit, err = strconv.Atoi(string(rest[1].Bytes))
+		if err != nil || limit < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR LIMIT can't be negative")}, nil
+		}
+	default:
+		return errSyntax, nil
+	}
+
+	n, err := e.storage.SetInterCard(limit, keys(args[1:1+numKeys])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// keys returns args as key names.
+func keys(args []resp.Value) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = string(a.Bytes)
+	}
+	return out
+}
+
+// set replies with members, as a set under RESP3 and an array under RESP2.
+func set(members [][]byte) resp.Value {
+	out := bulks(members)
+	out.Type = resp.TypeSet
+	return out
+}
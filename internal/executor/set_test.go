@@ -0,0 +1,96 @@
+package executor_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCommands(t *testing.T) {
+	s := storage.NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+	e := executor.NewExecutor(s)
+	rec := &recorder{}
+	e.AddPropagator(rec)
+	run := func(args ...string) resp.Value {
+		t.Helper()
+		out, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		return out
+	}
+	sorted := func(v resp.Value) []string {
+		out := []string{}
+		for _, el := range v.Array {
+			out = append(out, string(el.Bytes))
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	assert.Equal(t, "3", string(run("SADD", "a", "x", "y", "z").Bytes))
+	assert.Equal(t, "0", string(run("SADD", "a", "x").Bytes))
+	run("SADD", "b", "y", "z", "w")
+	assert.Equal(t, "set", string(run("TYPE", "a").Bytes))
+	assert.Equal(t, "3", string(run("SCARD", "a").Bytes))
+	assert.Equal(t, "1", string(run("SISMEMBER", "a", "x").Bytes))
+	assert.Equal(t, "0", string(run("SISMEMBER", "a", "w").Bytes))
+	mis := run("SMISMEMBER", "a", "x", "w")
+	require.Len(t, mis.Array, 2)
+	assert.Equal(t, "1", string(mis.Array[0].Bytes))
+	assert.Equal(t, "0", string(mis.Array[1].Bytes))
+	assert.Equal(t, []string{"x", "y", "z"}, sorted(run("SMEMBERS", "a")))
+
+	assert.Equal(t, []string{"y", "z"}, sorted(run("SINTER", "a", "b")))
+	assert.Equal(t, []string{"w", "x", "y", "z"}, sorted(run("SUNION", "a", "b")))
+	assert.Equal(t, []string{"x"}, sorted(run("SDIFF", "a", "b")))
+	assert.Equal(t, "2", string(run("SINTERSTORE", "dst", "a", "b").Bytes))
+	assert.Equal(t, []string{"y", "z"}, sorted(run("SMEMBERS", "dst")))
+	assert.Equal(t, "4", string(run("SUNIONSTORE", "dst", "a", "b").Bytes))
+	assert.Equal(t, "1", string(run("SDIFFSTORE", "dst", "a", "b").Bytes))
+	assert.Equal(t, "2", string(run("SINTERCARD", "2", "a", "b").Bytes))
+	assert.Equal(t, "1", string(run("SINTERCARD", "2", "a", "b", "LIMIT", "1").Bytes))
+	assert.Equal(t, resp.TypeError, run("SINTERCARD", "3", "a", "b").Type)
+	assert.Equal(t, resp.TypeError, run("SINTERCARD", "0", "a").Type)
+	assert.Equal(t, resp.TypeError, run("SINTERCARD", "1", "a", "LIMIT").Type)
+
+	assert.Equal(t, "1", string(run("SMOVE", "a", "b", "x").Bytes))
+	assert.Equal(t, "0", string(run("SMOVE", "a", "b", "x").Bytes))
+	assert.Equal(t, "1", string(run("SREM", "b", "x", "nope").Bytes))
+
+	out := run("SSCAN", "b", "0", "MATCH", "[wy]", "COUNT", "100")
+	require.Len(t, out.Array, 2)
+	assert.Equal(t, "0", string(out.Array[0].Bytes))
+	assert.Equal(t, []string{"w", "y"}, sorted(out.Array[1]))
+
+	assert.Contains(t, []string{"y", "z"}, string(run("SRANDMEMBER", "a").Bytes))
+	assert.Len(t, run("SRANDMEMBER", "a", "5").Array, 2)
+	assert.Len(t, run("SRANDMEMBER", "a", "-5").Array, 5)
+	assert.Nil(t, run("SRANDMEMBER", "missing").Bytes)
+
+	t.Run("spop propagates the members removed", func(t *testing.T) {
+		rec.cmds = nil
+		v := string(run("SPOP", "a").Bytes)
+		popped := run("SPOP", "a", "5")
+		require.Len(t, popped.Array, 1)
+		assert.Nil(t, run("SPOP", "a").Bytes)
+		assert.Empty(t, run("SPOP", "a", "2").Array)
+
+		assert.Equal(t, [][]string{
+			{"SREM", "a", v},
+			{"SREM", "a", string(popped.Array[0].Bytes)},
+		}, rec.cmds)
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		run("SET", "s", "v")
+		assert.Contains(t, string(run("SADD", "s", "x").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("SUNION", "b", "s").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("GET", "b").Bytes), "WRONGTYPE")
+		assert.Equal(t, "3", string(run("SUNIONSTORE", "s", "b").Bytes), "stores overwrite any type")
+	})
+}
@@ -0,0 +1,791 @@
+package executor
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+var (
+	errInvalidStreamID = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid stream ID specified as stream command argument")}
+	errXGroupNoKey     = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")}
+	errBusyGroup       = resp.Value{Type: resp.TypeError, Bytes: []byte("BUSYGROUP Consumer Group name already exists")}
+)
+
+// streamAddError turns the errors of XAdd into replies.
+func streamAddError(err error) (resp.Value, error) {
+	switch {
+	case errors.Is(err, storage.ErrStreamIDTooSmall):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The ID specified in XADD is equal or smaller than the target stream top item")}, nil
+	case errors.Is(err, storage.ErrStreamIDZero):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The ID specified in XADD must be greater than 0-0")}, nil
+	case errors.Is(err, storage.ErrStreamExhausted):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The stream has exhausted the last possible ID, unable to add more items")}, nil
+	}
+	return storageError(err)
+}
+
+// groupError is storageError for commands naming a consumer group.
+func groupError(err error, k, group string) (resp.Value, error) {
+	if errors.Is(err, storage.ErrNoGroup) {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("NOGROUP No such key '" + k + "' or consumer group '" + group + "'")}, nil
+	}
+	return storageError(err)
+}
+
+// parseStreamID parses an ID of the form "ms-seq", or "ms" alone, in which
+// case the sequence number is seq.
+func parseStreamID(b []byte, seq uint64) (storage.StreamID, bool) {
+	s := string(b)
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return storage.StreamID{}, false
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return storage.StreamID{}, false
+		}
+	}
+	return storage.StreamID{Ms: ms, Seq: seq}, true
+}
+
+// parseRangeID parses one end of an XRANGE interval: "-", "+", or an ID,
+// exclusive if prefixed with "(". An end given as a bare millisecond time
+// includes every sequence number in it.
+func parseRangeID(v resp.Value, end bool) (storage.StreamID, resp.Value, bool) {
+	switch string(v.Bytes) {
+	case "-":
+		return storage.StreamID{}, resp.Value{}, true
+	case "+":
+		return storage.MaxStreamID, resp.Value{}, true
+	}
+	b := v.Bytes
+	exclusive := len(b) > 0 && b[0] == '('
+	if exclusive {
+		b = b[1:]
+	}
+	var seq uint64
+	if end {
+		seq = math.MaxUint64
+	}
+	id, ok := parseStreamID(b, seq)
+	if !ok {
+		return id, errInvalidStreamID, false
+	}
+	if !exclusive {
+		return id, resp.Value{}, true
+	}
+	if end {
+		if id, ok = id.Prev(); !ok {
+			return id, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR invalid end ID for the interval")}, false
+		}
+	} else if id, ok = id.Next(); !ok {
+		return id, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR invalid start ID for the interval")}, false
+	}
+	return id, resp.Value{}, true
+}
+
+// parseIDs parses a list of IDs.
+func parseIDs(args []resp.Value) ([]storage.StreamID, bool) {
+	ids := make([]storage.StreamID, len(args))
+	for i, a := range args {
+		id, ok := parseStreamID(a.Bytes, 0)
+		if !ok {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
+
+func streamID(id storage.StreamID) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(id.String())}
+}
+
+func streamIDs(ids []storage.StreamID) resp.Value {
+	out := make([]resp.Value, len(ids))
+	for i, id := range ids {
+		out[i] = streamID(id)
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
+// entriesReply replies with entries as [id, [field, value, ...]] pairs. The
+// fields of deleted entries are a nil array.
+func entriesReply(entries []storage.StreamEntry) resp.Value {
+	out := make([]resp.Value, len(entries))
+	for i, e := range entries {
+		fields := resp.Value{Type: resp.TypeArray}
+		if e.Fields != nil {
+			fields = bulks(e.Fields)
+		}
+		out[i] = resp.Value{Type: resp.TypeArray, Array: []resp.Value{streamID(e.ID), fields}}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
+// parseTrim parses the trimming options of XADD and XTRIM starting at
+// args[i], and returns the index of the first argument after them.
+func parseTrim(args []resp.Value, i int) (storage.StreamTrim, int, resp.Value, bool) {
+	var t storage.StreamTrim
+	strategy := strings.ToUpper(string(args[i].Bytes))
+	switch strategy {
+	case "MAXLEN":
+		t.By = storage.TrimMaxLen
+	case "MINID":
+		t.By = storage.TrimMinID
+	default:
+		return t, i, errSyntax, false
+	}
+	i++
+	approx := false
+	if i < len(args) && (string(args[i].Bytes) == "~" || string(args[i].Bytes) == "=") {
+		approx = string(args[i].Bytes) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return t, i, errSyntax, false
+	}
+	if t.By == storage.TrimMaxLen {
+		n, err := strconv.Atoi(string(args[i].Bytes))
+		if err != nil {
+			return t, i, errNotInteger, false
+		}
+		if n < 0 {
+			return t, i, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The MAXLEN argument must be >= 0.")}, false
+		}
+		t.MaxLen = n
+	} else {
+		id, ok := parseStreamID(args[i].Bytes, 0)
+		if !ok {
+			return t, i, errInvalidStreamID, false
+		}
+		t.MinID = id
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(string(args[i].Bytes), "LIMIT") {
+		if !approx {
+			return t, i, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error, LIMIT cannot be used without the special ~ option")}, false
+		}
+		n, err := strconv.Atoi(string(args[i+1].Bytes))
+		if err != nil || n < 0 {
+			return t, i, errNotInteger, false
+		}
+		t.Limit = n
+		i += 2
+	}
+	return t, i, resp.Value{}, true
+}
+
+// xaddIDIndex returns the index in args, a validated XADD command without
+// its name, of the ID of the new entry.
+func xaddIDIndex(args []resp.Value) int {
+	i := 1
+	if strings.EqualFold(string(args[i].Bytes), "NOMKSTREAM") {
+		i++
+	}
+	if opt := strings.ToUpper(string(args[i].Bytes)); opt == "MAXLEN" || opt == "MINID" {
+		_, i, _, _ = parseTrim(args, i)
+	}
+	return i
+}
+
+func (e *Executor) xadd(args []resp.Value) (resp.Value, error) {
+	var opts storage.XAddOptions
+	i := 1
+	if strings.EqualFold(string(args[i].Bytes), "NOMKSTREAM") {
+		opts.NoMkStream = true
+		i++
+	}
+	if opt := strings.ToUpper(string(args[i].Bytes)); opt == "MAXLEN" || opt == "MINID" {
+		trim, next, errReply, ok := parseTrim(args, i)
+		if !ok {
+			return errReply, nil
+		}
+		opts.Trim, i = trim, next
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return wrongArgs(cmdXAdd), nil
+	}
+
+	id := args[i].Bytes
+	switch {
+	case string(id) == "*":
+		opts.AutoID = true
+	case strings.HasSuffix(string(id), "-*"):
+		ms, err := strconv.ParseUint(string(id[:len(id)-2]), 10, 64)
+		if err != nil {
+			return errInvalidStreamID, nil
+		}
+		opts.ID.Ms, opts.AutoSeq = ms, true
+	default:
+		var ok bool
+		if opts.ID, ok = parseStreamID(id, 0); !ok {
+			return errInvalidStreamID, nil
+		}
+	}
+
+	added, ok, err := e.storage.XAdd(string(args[0].Bytes), values(args[i+1:]), opts)
+	if err != nil {
+		return streamAddError(err)
+	}
+	if !ok {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
+	return streamID(added), nil
+}
+
+func (e *Executor) xlen(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.XLen(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// xrange serves XRANGE and XREVRANGE, which takes the end of the interval
+// first.
+func (e *Executor) xrange(args []resp.Value, name string) (resp.Value, error) {
+	if len(args) != 3 && len(args) != 5 {
+		return wrongArgs(name), nil
+	}
+	rev := name == cmdXRevRange
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply, ok := parseRangeID(startArg, false)
+	if !ok {
+		return errReply, nil
+	}
+	end, errReply, ok := parseRangeID(endArg, true)
+	if !ok {
+		return errReply, nil
+	}
+	count := -1
+	if len(args) == 5 {
+		if !strings.EqualFold(string(args[3].Bytes), "COUNT") {
+			return errSyntax, nil
+		}
+		n, err := strconv.Atoi(string(args[4].Bytes))
+		if err != nil {
+			return errNotInteger, nil
+		}
+		count = max(n, 0)
+	}
+
+	entries, err := e.storage.XRange(string(args[0].Bytes), start, end, count, rev)
+	if err != nil {
+		return storageError(err)
+	}
+	return entriesReply(entries), nil
+}
+
+func (e *Executor) xdel(args []resp.Value) (resp.Value, error) {
+	ids, ok := parseIDs(args[1:])
+	if !ok {
+		return errInvalidStreamID, nil
+	}
+	n, err := e.storage.XDel(string(args[0].Bytes), ids...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) xtrim(args []resp.Value) (resp.Value, error) {
+	trim, next, errReply, ok := parseTrim(args, 1)
+	if !ok {
+		return errReply, nil
+	}
+	if next != len(args) {
+		return errSyntax, nil
+	}
+	n, err := e.storage.XTrim(string(args[0].Bytes), trim)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) xsetid(args []resp.Value) (resp.Value, error) {
+	id, ok := parseStreamID(args[1].Bytes, 0)
+	if !ok {
+		return errInvalidStreamID, nil
+	}
+	switch err := e.storage.XSetID(string(args[0].Bytes), id); {
+	case errors.Is(err, storage.ErrKeyNotFound):
+		return errNoSuchKey, nil
+	case errors.Is(err, storage.ErrStreamIDTooSmall):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The ID specified in XSETID is smaller than the target stream top item")}, nil
+	case err != nil:
+		return storageError(err)
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// xreadArgs are the arguments of XREAD and XREADGROUP.
+type xreadArgs struct {
+	group, consumer string
+	count           int
+	// block is set with the BLOCK option, and timeout is its value, zero
+	// meaning forever.
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	// ids holds the ID argument of each key, unparsed since "$" and ">"
+	// are resolved at the time of the read.
+	ids []resp.Value
+	// blockAt is the index of the BLOCK option in the command, if any.
+	blockAt int
+}
+
+// parseXRead parses the arguments of XREAD, or XREADGROUP if group is set.
+// args includes the command name.
+func parseXRead(args []resp.Value, group bool) (xreadArgs, resp.Value, bool) {
+	var x xreadArgs
+	name := cmdXRead
+	if group {
+		name = cmdXReadGroup
+	}
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i].Bytes))
+		if opt == "STREAMS" {
+			break
+		}
+		switch {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(string(args[i+1].Bytes))
+			if err != nil {
+				return x, errNotInteger, false
+			}
+			x.count = max(n, 0)
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			ms, err := strconv.ParseInt(string(args[i+1].Bytes), 10, 64)
+			if err != nil {
+				return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR timeout is not an integer or out of range")}, false
+			}
+			if ms < 0 {
+				return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR timeout is negative")}, false
+			}
+			x.block, x.timeout, x.blockAt = true, time.Duration(ms)*time.Millisecond, i
+			i++
+		case opt == "GROUP" && group && i+2 < len(args):
+			x.group, x.consumer = string(args[i+1].Bytes), string(args[i+2].Bytes)
+			i += 2
+		case opt == "NOACK" && group:
+			x.noAck = true
+		default:
+			return x, errSyntax, false
+		}
+	}
+	if group && x.group == "" {
+		return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Missing GROUP option for XREADGROUP")}, false
+	}
+	streams := args[min(i+1, len(args)):]
+	if i >= len(args) || len(streams) == 0 {
+		return x, errSyntax, false
+	}
+	if len(streams)%2 != 0 {
+		want := "'$'"
+		if group {
+			want = "'>'"
+		}
+		return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or " + want + " must be specified.")}, false
+	}
+	n := len(streams) / 2
+	x.keys = keys(streams[:n])
+	x.ids = streams[n:]
+	for _, id := range x.ids {
+		switch s := string(id.Bytes); {
+		case s == "$" && group:
+			return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")}, false
+		case s == ">" && !group:
+			return x, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")}, false
+		case s == "$" || s == ">":
+		default:
+			if _, ok := parseStreamID(id.Bytes, 0); !ok {
+				return x, errInvalidStreamID, false
+			}
+		}
+	}
+	return x, resp.Value{}, true
+}
+
+// xread serves XREAD without blocking: BLOCK is honoured by the server,
+// which runs the command again as entries are added. It replies with the
+// entries of each stream that has any after the given ID, or a nil array if
+// none has. "$" stands for the last ID of the stream, so there is never
+// anything after it.
+func (e *Executor) xread(args []resp.Value) (resp.Value, error) {
+	x, errReply, ok := parseXRead(args, false)
+	if !ok {
+		return errReply, nil
+	}
+	count := x.count
+	if count == 0 {
+		count = -1
+	}
+
+	var out []resp.Value
+	for i, k := range x.keys {
+		if string(x.ids[i].Bytes) == "$" {
+			continue
+		}
+		id, _ := parseStreamID(x.ids[i].Bytes, 0)
+		start, ok := id.Next()
+		if !ok {
+			continue
+		}
+		entries, err := e.storage.XRange(k, start, storage.MaxStreamID, count, false)
+		if err != nil {
+			return storageError(err)
+		}
+		if len(entries) > 0 {
+			out = append(out, bulk(k), entriesReply(entries))
+		}
+	}
+	return streamsReply(out), nil
+}
+
+// xreadgroup serves XREADGROUP without blocking, like xread. Streams read
+// with ">" are only included in the reply if new entries were delivered, and
+// the reply is a nil array if none are included.
+func (e *Executor) xreadgroup(args []resp.Value) (resp.Value, error) {
+	x, errReply, ok := parseXRead(args, true)
+	if !ok {
+		return errReply, nil
+	}
+
+	var out []resp.Value
+	for i, k := range x.keys {
+		history := string(x.ids[i].Bytes) != ">"
+		var after storage.StreamID
+		if history {
+			after, _ = parseStreamID(x.ids[i].Bytes, 0)
+		}
+		entries, err := e.storage.XReadGroup(k, x.group, x.consumer, after, history, x.count, x.noAck)
+		if errors.Is(err, storage.ErrNoGroup) {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("NOGROUP No such key '" + k + "' or consumer group '" + x.group + "' in XREADGROUP with GROUP option")}, nil
+		}
+		if err != nil {
+			return storageError(err)
+		}
+		if history || len(entries) > 0 {
+			out = append(out, bulk(k), entriesReply(entries))
+		}
+	}
+	return streamsReply(out), nil
+}
+
+// streamsReply replies with a map of stream names to their entries, which
+// is an array of pairs in RESP2, or with a nil array if there are none.
+func streamsReply(streams []resp.Value) resp.Value {
+	if streams == nil {
+		return resp.Value{Type: resp.TypeArray}
+	}
+	return resp.Value{Type: resp.TypeMap, Array: streams, RESP2: resp.MapPairs}
+}
+
+func (e *Executor) xgroup(args []resp.Value) (resp.Value, error) {
+	sub := strings.ToUpper(string(args[0].Bytes))
+	unknown := resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand or wrong number of arguments for '" + string(args[0].Bytes) + "'. Try XGROUP HELP.")}
+	if len(args) < 3 {
+		return unknown, nil
+	}
+	k, group := string(args[1].Bytes), string(args[2].Bytes)
+
+	// parseGroupID parses the ID a group was delivered entries up to.
+	parseGroupID := func(v resp.Value) (storage.StreamID, bool, bool) {
+		if string(v.Bytes) == "$" {
+			return storage.StreamID{}, true, true
+		}
+		id, ok := parseStreamID(v.Bytes, 0)
+		return id, false, ok
+	}
+
+	switch {
+	case sub == "CREATE" && (len(args) == 4 || len(args) == 5):
+		mkStream := len(args) == 5
+		if mkStream && !strings.EqualFold(string(args[4].Bytes), "MKSTREAM") {
+			return errSyntax, nil
+		}
+		id, latest, ok := parseGroupID(args[3])
+		if !ok {
+			return errInvalidStreamID, nil
+		}
+		switch err := e.storage.XGroupCreate(k, group, id, latest, mkStream); {
+		case errors.Is(err, storage.ErrKeyNotFound):
+			return errXGroupNoKey, nil
+		case errors.Is(err, storage.ErrGroupExists):
+			return errBusyGroup, nil
+		case err != nil:
+			return storageError(err)
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case sub == "SETID" && len(args) == 4:
+		id, latest, ok := parseGroupID(args[3])
+		if !ok {
+			return errInvalidStreamID, nil
+		}
+		if err := e.storage.XGroupSetID(k, group, id, latest); err != nil {
+			return groupError(err, k, group)
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case sub == "DESTROY" && len(args) == 3:
+		ok, err := e.storage.XGroupDestroy(k, group)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return errXGroupNoKey, nil
+		}
+		if err != nil {
+			return storageError(err)
+		}
+		if ok {
+			return integer(1), nil
+		}
+		return integer(0), nil
+	case sub == "CREATECONSUMER" && len(args) == 4:
+		ok, err := e.storage.XGroupCreateConsumer(k, group, string(args[3].Bytes))
+		if err != nil {
+			return groupError(err, k, group)
+		}
+		if ok {
+			return integer(1), nil
+		}
+		return integer(0), nil
+	case sub == "DELCONSUMER" && len(args) == 4:
+		n, err := e.storage.XGroupDelConsumer(k, group, string(args[3].Bytes))
+		if err != nil {
+			return groupError(err, k, group)
+		}
+		return integer(n), nil
+	}
+	return unknown, nil
+}
+
+func (e *Executor) xack(args []resp.Value) (resp.Value, error) {
+	ids, ok := parseIDs(args[2:])
+	if !ok {
+		return errInvalidStreamID, nil
+	}
+	n, err := e.storage.XAck(string(args[0].Bytes), string(args[1].Bytes), ids...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// xpending replies with a summary of the pending entries of a group, or with
+// the details of those in a range.
+func (e *Executor) xpending(args []resp.Value) (resp.Value, error) {
+	k, group := string(args[0].Bytes), string(args[1].Bytes)
+	if len(args) == 2 {
+		sum, err := e.storage.XPendingSummary(k, group)
+		if err != nil {
+			return groupError(err, k, group)
+		}
+		if sum.Count == 0 {
+			return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+				integer(0), {Type: resp.TypeBulkString}, {Type: resp.TypeBulkString}, {Type: resp.TypeArray},
+			}}, nil
+		}
+		names := make([]string, 0, len(sum.Consumers))
+		for name := range sum.Consumers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		consumers := make([]resp.Value, len(names))
+		for i, name := range names {
+			consumers[i] = resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+				bulk(name), bulk(strconv.Itoa(sum.Consumers[name])),
+			}}
+		}
+		return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			integer(sum.Count), streamID(sum.Min), streamID(sum.Max), {Type: resp.TypeArray, Array: consumers},
+		}}, nil
+	}
+
+	var r storage.PendingRange
+	rest := args[2:]
+	if strings.EqualFold(string(rest[0].Bytes), "IDLE") {
+		if len(rest) < 2 {
+			return errSyntax, nil
+		}
+		ms, err := strconv.ParseInt(string(rest[1].Bytes), 10, 64)
+		if err != nil {
+			return errNotInteger, nil
+		}
+		r.MinIdle = time.Duration(ms) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return errSyntax, nil
+	}
+	var errReply resp.Value
+	var ok bool
+	if r.Start, errReply, ok = parseRangeID(rest[0], false); !ok {
+		return errReply, nil
+	}
+	if r.End, errReply, ok = parseRangeID(rest[1], true); !ok {
+		return errReply, nil
+	}
+	count, err := strconv.Atoi(string(rest[2].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	r.Count = count
+	if len(rest) == 4 {
+		r.Consumer = string(rest[3].Bytes)
+	}
+
+	pending, err := e.storage.XPending(k, group, r)
+	if err != nil {
+		return groupError(err, k, group)
+	}
+	out := make([]resp.Value, len(pending))
+	for i, p := range pending {
+		idle := max(time.Since(p.DeliveredAt).Milliseconds(), 0)
+		out[i] = resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			streamID(p.ID),
+			bulk(p.Consumer),
+			{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(idle, 10))},
+			integer(p.Deliveries),
+		}}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
+
+// parseMinIdle parses a min-idle-time argument in milliseconds. Negative
+// values count as zero.
+func parseMinIdle(v resp.Value) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(string(v.Bytes), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(max(ms, 0)) * time.Millisecond, true
+}
+
+func (e *Executor) xclaim(args []resp.Value) (resp.Value, error) {
+	k, group, consumer := string(args[0].Bytes), string(args[1].Bytes), string(args[2].Bytes)
+	minIdle, ok := parseMinIdle(args[3])
+	if !ok {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid min-idle-time argument for XCLAIM")}, nil
+	}
+	i := 4
+	var ids []storage.StreamID
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i].Bytes, 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errInvalidStreamID, nil
+	}
+
+	opts := storage.XClaimOptions{RetryCount: -1}
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i].Bytes))
+		switch {
+		case opt == "FORCE":
+			opts.Force = true
+		case opt == "JUSTID":
+			opts.JustID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(string(args[i].Bytes), 10, 64)
+			if err != nil {
+				return errNotInteger, nil
+			}
+			switch opt {
+			case "IDLE":
+				opts.DeliveredAt = time.Now().Add(-time.Duration(max(n, 0)) * time.Millisecond)
+			case "TIME":
+				opts.DeliveredAt = time.UnixMilli(n)
+			default:
+				if n < 0 || n > math.MaxInt32 {
+					return errNotInteger, nil
+				}
+				opts.RetryCount = int(n)
+			}
+		case opt == "LASTID" && i+1 < len(args):
+			i++
+			id, ok := parseStreamID(args[i].Bytes, 0)
+			if !ok {
+				return errInvalidStreamID, nil
+			}
+			opts.LastID = id
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unrecognized XCLAIM option '" + string(args[i].Bytes) + "'")}, nil
+		}
+	}
+
+	claimed, err := e.storage.XClaim(k, group, consumer, minIdle, ids, opts)
+	if err != nil {
+		return groupError(err, k, group)
+	}
+	if opts.JustID {
+		return streamIDs(entryIDs(claimed)), nil
+	}
+	return entriesReply(claimed), nil
+}
+
+func (e *Executor) xautoclaim(args []resp.Value) (resp.Value, error) {
+	k, group, consumer := string(args[0].Bytes), string(args[1].Bytes), string(args[2].Bytes)
+	minIdle, ok := parseMinIdle(args[3])
+	if !ok {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid min-idle-time argument for XAUTOCLAIM")}, nil
+	}
+	start, errReply, ok := parseRangeID(args[4], false)
+	if !ok {
+		return errReply, nil
+	}
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i].Bytes)); {
+		case opt == "JUSTID":
+			justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(string(args[i].Bytes))
+			if err != nil {
+				return errNotInteger, nil
+			}
+			if n < 1 || n > math.MaxInt32/10 {
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR COUNT must be > 0")}, nil
+			}
+			count = n
+		default:
+			return errSyntax, nil
+		}
+	}
+
+	next, claimed, deleted, err := e.storage.XAutoClaim(k, group, consumer, minIdle, start, count, justID)
+	if err != nil {
+		return groupError(err, k, group)
+	}
+	reply := entriesReply(claimed)
+	if justID {
+		reply = streamIDs(entryIDs(claimed))
+	}
+	return resp.Value{Type: resp.TypeArray, Array: []resp.Value{streamID(next), reply, streamIDs(deleted)}}, nil
+}
+
+func entryIDs(entries []storage.StreamEntry) []storage.StreamID {
+	ids := make([]storage.StreamID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
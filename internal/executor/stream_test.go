@@ -0,0 +1,159 @@
+package executor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCommands(t *testing.T) {
+	s := storage.NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+	e := executor.NewExecutor(s)
+	rec := &recorder{}
+	e.AddPropagator(rec)
+	run := func(args ...string) resp.Value {
+		t.Helper()
+		out, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		return out
+	}
+	// ids returns the IDs of the entries in an entries reply.
+	ids := func(v resp.Value) []string {
+		out := []string{}
+		for _, el := range v.Array {
+			out = append(out, string(el.Array[0].Bytes))
+		}
+		return out
+	}
+
+	assert.Equal(t, "1-1", string(run("XADD", "s", "1-1", "a", "1").Bytes))
+	assert.Equal(t, "1-2", string(run("XADD", "s", "1-*", "b", "2").Bytes))
+	assert.Equal(t, "5-0", string(run("XADD", "s", "5", "c", "3").Bytes))
+	assert.Equal(t, "stream", string(run("TYPE", "s").Bytes))
+	assert.Equal(t, "3", string(run("XLEN", "s").Bytes))
+	assert.Nil(t, run("XADD", "none", "NOMKSTREAM", "*", "a", "1").Bytes)
+	assert.Equal(t, "none", string(run("TYPE", "none").Bytes))
+
+	generated := string(run("XADD", "s", "*", "d", "4").Bytes)
+	assert.Equal(t, []string{"XADD", "s", generated, "d", "4"}, rec.cmds[len(rec.cmds)-1])
+
+	t.Run("range", func(t *testing.T) {
+		all := run("XRANGE", "s", "-", "+")
+		assert.Equal(t, []string{"1-1", "1-2", "5-0", generated}, ids(all))
+		assert.Equal(t, "a", string(all.Array[0].Array[1].Array[0].Bytes))
+		assert.Equal(t, []string{"1-2", "5-0"}, ids(run("XRANGE", "s", "(1-1", "5")))
+		assert.Equal(t, []string{generated, "5-0"}, ids(run("XREVRANGE", "s", "+", "-", "COUNT", "2")))
+		assert.Empty(t, run("XRANGE", "missing", "-", "+").Array)
+		assert.Equal(t, "ERR invalid start ID for the interval", string(run("XRANGE", "s", "(18446744073709551615-18446744073709551615", "+").Bytes))
+	})
+
+	t.Run("read", func(t *testing.T) {
+		out := run("XREAD", "COUNT", "1", "STREAMS", "s", "missing", "1-1", "0")
+		assert.Equal(t, resp.TypeMap, out.Type)
+		require.Len(t, out.Array, 2)
+		assert.Equal(t, "s", string(out.Array[0].Bytes))
+		assert.Equal(t, []string{"1-2"}, ids(out.Array[1]))
+		assert.Nil(t, run("XREAD", "STREAMS", "s", "$").Array)
+		assert.Equal(t, resp.TypeError, run("XREAD", "STREAMS", "s", ">").Type)
+		assert.Equal(t, resp.TypeError, run("XREAD", "STREAMS", "s").Type)
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		assert.Equal(t, "OK", string(run("XGROUP", "CREATE", "s", "g", "0").Bytes))
+		assert.Equal(t, "BUSYGROUP Consumer Group name already exists", string(run("XGROUP", "CREATE", "s", "g", "$").Bytes))
+		assert.Equal(t, resp.TypeError, run("XGROUP", "CREATE", "nokey", "g", "$").Type)
+
+		out := run("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "BLOCK", "10", "STREAMS", "s", ">")
+		assert.Equal(t, []string{"1-1", "1-2"}, ids(out.Array[1]))
+		assert.Equal(t, []string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}, rec.cmds[len(rec.cmds)-1])
+		out = run("XREADGROUP", "GROUP", "g", "bob", "COUNT", "1", "STREAMS", "s", ">")
+		assert.Equal(t, []string{"5-0"}, ids(out.Array[1]))
+
+		// The history of a consumer is its own pending entries.
+		out = run("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+		assert.Equal(t, []string{"1-1", "1-2"}, ids(out.Array[1]))
+
+		summary := run("XPENDING", "s", "g")
+		assert.Equal(t, "3", string(summary.Array[0].Bytes))
+		assert.Equal(t, "1-1", string(summary.Array[1].Bytes))
+		assert.Equal(t, "5-0", string(summary.Array[2].Bytes))
+		require.Len(t, summary.Array[3].Array, 2)
+		assert.Equal(t, "alice", string(summary.Array[3].Array[0].Array[0].Bytes))
+		assert.Equal(t, "2", string(summary.Array[3].Array[0].Array[1].Bytes))
+
+		detail := run("XPENDING", "s", "g", "-", "+", "10", "bob")
+		require.Len(t, detail.Array, 1)
+		assert.Equal(t, "5-0", string(detail.Array[0].Array[0].Bytes))
+		assert.Equal(t, "bob", string(detail.Array[0].Array[1].Bytes))
+		assert.Equal(t, "1", string(detail.Array[0].Array[3].Bytes))
+
+		assert.Equal(t, "1", string(run("XACK", "s", "g", "1-1", "9-9").Bytes))
+		assert.Equal(t, "NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option",
+			string(run("XREADGROUP", "GROUP", "nope", "c", "STREAMS", "s", ">").Bytes))
+		assert.Equal(t, resp.TypeError, run("XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", "$").Type)
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		// Nothing has been idle for an hour.
+		assert.Empty(t, run("XCLAIM", "s", "g", "carol", "3600000", "1-2").Array)
+
+		n := len(rec.cmds)
+		claimed := run("XCLAIM", "s", "g", "carol", "0", "1-2", "JUSTID")
+		assert.Equal(t, "1-2", string(claimed.Array[0].Bytes))
+		require.Len(t, rec.cmds, n+1)
+		assert.Equal(t, []string{"XCLAIM", "s", "g", "carol", "0", "1-2", "JUSTID", "TIME"}, rec.cmds[n][:8])
+		assert.InDelta(t, time.Now().UnixMilli(), mustAtoi(t, []byte(rec.cmds[n][8])), 1000)
+
+		run("XDEL", "s", "5-0")
+		out := run("XAUTOCLAIM", "s", "g", "dave", "0", "0", "COUNT", "10")
+		assert.Equal(t, "0-0", string(out.Array[0].Bytes))
+		assert.Equal(t, []string{"1-2"}, ids(out.Array[1]))
+		require.Len(t, out.Array[2].Array, 1)
+		assert.Equal(t, "5-0", string(out.Array[2].Array[0].Bytes))
+		assert.Equal(t, []string{"XCLAIM", "s", "g", "dave", "0", "1-2", "5-0", "TIME"}, rec.cmds[len(rec.cmds)-1][:8])
+
+		assert.Equal(t, "1", string(run("XPENDING", "s", "g").Array[0].Bytes))
+		assert.Equal(t, "ERR Invalid min-idle-time argument for XCLAIM", string(run("XCLAIM", "s", "g", "c", "x", "1-2").Bytes))
+	})
+
+	t.Run("consumers", func(t *testing.T) {
+		assert.Equal(t, "1", string(run("XGROUP", "CREATECONSUMER", "s", "g", "erin").Bytes))
+		assert.Equal(t, "0", string(run("XGROUP", "CREATECONSUMER", "s", "g", "erin").Bytes))
+		assert.Equal(t, "1", string(run("XGROUP", "DELCONSUMER", "s", "g", "dave").Bytes))
+		assert.Equal(t, "0", string(run("XPENDING", "s", "g").Array[0].Bytes))
+		assert.Equal(t, "OK", string(run("XGROUP", "SETID", "s", "g", "$").Bytes))
+		assert.Equal(t, "1", string(run("XGROUP", "DESTROY", "s", "g").Bytes))
+		assert.Equal(t, "0", string(run("XGROUP", "DESTROY", "s", "g").Bytes))
+	})
+
+	t.Run("trim and delete", func(t *testing.T) {
+		assert.Equal(t, "1", string(run("XTRIM", "s", "MAXLEN", "2").Bytes))
+		assert.Equal(t, []string{"1-2", generated}, ids(run("XRANGE", "s", "-", "+")))
+		assert.Equal(t, "1", string(run("XDEL", "s", "1-2", "1-2").Bytes))
+		assert.Equal(t, "1", string(run("XTRIM", "s", "MINID", "=", "999999999999999").Bytes))
+		assert.Equal(t, "0", string(run("XLEN", "s").Bytes))
+		// An empty stream keeps its last ID.
+		assert.Equal(t, "stream", string(run("TYPE", "s").Bytes))
+		assert.Equal(t, resp.TypeError, run("XADD", "s", "1-1", "a", "1").Type)
+		assert.Equal(t, "OK", string(run("XSETID", "s", "99999999999999-0").Bytes))
+		assert.Equal(t, "ERR The ID specified in XADD is equal or smaller than the target stream top item",
+			string(run("XADD", "s", "99999999999999-0", "a", "1").Bytes))
+		assert.Equal(t, "ERR The MAXLEN argument must be >= 0.", string(run("XADD", "s", "MAXLEN", "-1", "*", "a", "1").Bytes))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		run("SET", "str", "v")
+		assert.Contains(t, string(run("XADD", "str", "*", "a", "1").Bytes), "WRONGTYPE")
+		assert.Contains(t, string(run("XLEN", "str").Bytes), "WRONGTYPE")
+		assert.Equal(t, "ERR The ID specified in XADD must be greater than 0-0", string(run("XADD", "x", "0-0", "a", "1").Bytes))
+		assert.Equal(t, "ERR Invalid stream ID specified as stream command argument", string(run("XADD", "x", "abc", "a", "1").Bytes))
+		assert.Equal(t, resp.TypeError, run("XADD", "x", "*", "a").Type)
+		assert.Equal(t, resp.TypeError, run("XGROUP", "BOGUS").Type)
+	})
+}
@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// Tx executes commands inside Atomic.
+type Tx struct {
+	e *Executor
+	// writes are the successful write commands, already translated for
+	// propagation.
+	writes [][]resp.Value
+}
+
+// Atomic runs fn while no other command is executing. The commands fn runs
+// through tx are applied as one unit: no other command observes their
+// intermediate state, and if more than one of them writes they are
+// propagated wrapped in MULTI and EXEC. fn must not call Execute or Barrier.
+func (e *Executor) Atomic(fn func(tx *Tx)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tx := &Tx{e: e}
+	fn(tx)
+	switch len(tx.writes) {
+	case 0:
+	case 1:
+		e.propagate(tx.writes[0])
+	default:
+		e.propagate([]resp.Value{bulk("MULTI")})
+		e.propagate(tx.writes...)
+		e.propagate([]resp.Value{bulk("EXEC")})
+	}
+}
+
+// Execute runs a single command as part of the transaction.
+func (tx *Tx) Execute(val resp.Value) (resp.Value, error) {
+	name, errReply, ok := parse(val)
+	if !ok {
+		return errReply, nil
+	}
+	defer tx.e.observe(name)()
+	out, err := tx.e.dispatch(name, val.Array)
+	if isWrite(name, val.Array) && err == nil && out.Type != resp.TypeError {
+		tx.e.dirty.Add(1)
+		if len(tx.e.propagators) > 0 {
+			if cmd := translate(name, val.Array, out); cmd != nil {
+				tx.writes = append(tx.writes, cmd)
+			}
+		}
+		tx.e.wake(name, val.Array)
+	}
+	return out, err
+}
+
+// ExecuteAll runs cmds atomically and returns their replies. It replays
+// transactions read back from the command stream, as propagated by Atomic.
+func (e *Executor) ExecuteAll(cmds []resp.Value) ([]resp.Value, error) {
+	out := make([]resp.Value, 0, len(cmds))
+	var err error
+	e.Atomic(func(tx *Tx) {
+		for _, cmd := range cmds {
+			var v resp.Value
+			if v, err = tx.Execute(cmd); err != nil {
+				return
+			}
+			out = append(out, v)
+		}
+	})
+	return out, err
+}
@@ -0,0 +1,478 @@
+package executor
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+var (
+	errNaNScore     = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR resulting score is not a number (NaN)")}
+	errMinMaxFloat  = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR min or max is not a float")}
+	errMinMaxString = resp.Value{Type: resp.TypeError, Bytes: []byte("ERR min or max not valid string range item")}
+)
+
+// formatScore formats a score the way Redis replies with it.
+func formatScore(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// double replies with a score, as a double under RESP3 and a bulk string
+// under RESP2.
+func double(f float64) resp.Value {
+	return resp.Value{Type: resp.TypeDouble, Bytes: formatScore(f)}
+}
+
+// parseScore parses a score, which may be infinite but not NaN.
+func parseScore(v resp.Value) (float64, bool) {
+	f, err := strconv.ParseFloat(string(v.Bytes), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// parseScoreBound parses one end of a score range, exclusive if prefixed
+// with "(".
+func parseScoreBound(v resp.Value) (storage.ScoreBound, bool) {
+	var b storage.ScoreBound
+	s := v.Bytes
+	if len(s) > 0 && s[0] == '(' {
+		b.Exclusive = true
+		s = s[1:]
+	}
+	f, ok := parseScore(resp.Value{Bytes: s})
+	b.Value = f
+	return b, ok
+}
+
+// parseLexBound parses one end of a lex range: "-", "+", or a member
+// prefixed with "[" (inclusive) or "(" (exclusive).
+func parseLexBound(v resp.Value) (storage.LexBound, bool) {
+	s := v.Bytes
+	switch {
+	case string(s) == "-":
+		return storage.LexBound{Inf: -1}, true
+	case string(s) == "+":
+		return storage.LexBound{Inf: 1}, true
+	case len(s) > 0 && s[0] == '[':
+		return storage.LexBound{Value: s[1:]}, true
+	case len(s) > 0 && s[0] == '(':
+		return storage.LexBound{Value: s[1:], Exclusive: true}, true
+	}
+	return storage.LexBound{}, false
+}
+
+// parseRange fills in the ends of r from two arguments. Reversed score and
+// lex ranges take the upper end first.
+func parseRange(a, b resp.Value, r *storage.ZRange) (resp.Value, bool) {
+	switch r.By {
+	case storage.ZByRank:
+		start, err1 := strconv.Atoi(string(a.Bytes))
+		stop, err2 := strconv.Atoi(string(b.Bytes))
+		if err1 != nil || err2 != nil {
+			return errNotInteger, false
+		}
+		r.Start, r.Stop = start, stop
+	case storage.ZByScore:
+		if r.Rev {
+			a, b = b, a
+		}
+		lo, ok1 := parseScoreBound(a)
+		hi, ok2 := parseScoreBound(b)
+		if !ok1 || !ok2 {
+			return errMinMaxFloat, false
+		}
+		r.Min, r.Max = lo, hi
+	case storage.ZByLex:
+		if r.Rev {
+			a, b = b, a
+		}
+		lo, ok1 := parseLexBound(a)
+		hi, ok2 := parseLexBound(b)
+		if !ok1 || !ok2 {
+			return errMinMaxString, false
+		}
+		r.LexMin, r.LexMax = lo, hi
+	}
+	return resp.Value{}, true
+}
+
+// scoredReply replies with members or, if withScores is set, with pairs of
+// a member and its score, flattened in RESP2.
+func scoredReply(sms []storage.ScoredMember, withScores bool) resp.Value {
+	out := make([]resp.Value, len(sms))
+	for i, sm := range sms {
+		out[i] = resp.Value{Type: resp.TypeBulkString, Bytes: sm.Member}
+		if withScores {
+			out[i] = resp.Value{Type: resp.TypeArray, Array: []resp.Value{out[i], double(sm.Score)}}
+		}
+	}
+	v := resp.Value{Type: resp.TypeArray, Array: out}
+	if withScores {
+		v.RESP2 = resp.FlatPairs
+	}
+	return v
+}
+
+// zadd implements ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member ...
+func (e *Executor) zadd(args []resp.Value) (resp.Value, error) {
+	var opts storage.ZAddOptions
+	var ch, incr bool
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Bytes)) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	switch {
+	case len(pairs) == 0 || len(pairs)%2 != 0:
+		return errSyntax, nil
+	case opts.NX && opts.XX:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR XX and NX options at the same time are not compatible")}, nil
+	case (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)):
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR GT, LT, and/or NX options at the same time are not compatible")}, nil
+	case incr && len(pairs) != 2:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR INCR option supports a single increment-element pair")}, nil
+	}
+
+	members := make([]storage.ScoredMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			return errNotFloat, nil
+		}
+		members = append(members, storage.ScoredMember{Member: pairs[j+1].Bytes, Score: score})
+	}
+
+	k := string(args[0].Bytes)
+	if incr {
+		return e.zincr(k, opts, members[0].Member, members[0].Score)
+	}
+	added, updated, err := e.storage.ZAdd(k, opts, members)
+	if err != nil {
+		return storageError(err)
+	}
+	if ch {
+		return integer(added + updated), nil
+	}
+	return integer(added), nil
+}
+
+func (e *Executor) zincrby(args []resp.Value) (resp.Value, error) {
+	delta, ok := parseScore(args[1])
+	if !ok {
+		return errNotFloat, nil
+	}
+	return e.zincr(string(args[0].Bytes), storage.ZAddOptions{}, args[2].Bytes, delta)
+}
+
+// zincr implements ZINCRBY and ZADD INCR, which replies with a nil if the
+// options prevented the update.
+func (e *Executor) zincr(k string, opts storage.ZAddOptions, member []byte, delta float64) (resp.Value, error) {
+	score, ok, err := e.storage.ZIncrBy(k, opts, member, delta)
+	if errors.Is(err, storage.ErrNotFinite) {
+		return errNaNScore, nil
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	if !ok {
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	}
+	return double(score), nil
+}
+
+func (e *Executor) zrem(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.ZRem(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+func (e *Executor) zcard(args []resp.Value) (resp.Value, error) {
+	n, err := e.storage.ZCard(string(args[0].Bytes))
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// zscore implements ZSCORE, which replies with a single score, and ZMSCORE,
+// which replies with an array of them.
+func (e *Executor) zscore(args []resp.Value, name string) (resp.Value, error) {
+	scores, err := e.storage.ZScores(string(args[0].Bytes), values(args[1:])...)
+	if err != nil {
+		return storageError(err)
+	}
+	out := make([]resp.Value, len(scores))
+	for i, score := range scores {
+		out[i] = resp.Value{Type: resp.TypeBulkString}
+		if score != nil {
+			out[i] = double(*score)
+		}
+	}
+	if name == cmdZScore {
+		return out[0], nil
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
+
+// zrank implements ZRANK and ZREVRANK key member [WITHSCORE].
+func (e *Executor) zrank(args []resp.Value, name string) (resp.Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return wrongArgs(name), nil
+	}
+	withScore := len(args) == 3
+	if withScore && !strings.EqualFold(string(args[2].Bytes), "WITHSCORE") {
+		return errSyntax, nil
+	}
+	rank, score, err := e.storage.ZRank(string(args[0].Bytes), args[1].Bytes, name == cmdZRevRank)
+	if err != nil {
+		return storageError(err)
+	}
+	switch {
+	case rank < 0 && withScore:
+		return resp.Value{Type: resp.TypeArray}, nil
+	case rank < 0:
+		return resp.Value{Type: resp.TypeBulkString}, nil
+	case withScore:
+		return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			integer(rank),
+			double(score),
+		}}, nil
+	}
+	return integer(rank), nil
+}
+
+// zcount implements ZCOUNT and ZLEXCOUNT.
+func (e *Executor) zcount(args []resp.Value, by storage.ZRangeBy) (resp.Value, error) {
+	r := storage.ZRange{By: by}
+	if errReply, ok := parseRange(args[1], args[2], &r); !ok {
+		return errReply, nil
+	}
+	n, err := e.storage.ZCount(string(args[0].Bytes), r)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// zrangeOptions lists the options each command of the ZRANGE family takes.
+var zrangeOptions = map[string]map[string]bool{
+	cmdZRange:           {"BYSCORE": true, "BYLEX": true, "REV": true, "LIMIT": true, "WITHSCORES": true},
+	cmdZRangeStore:      {"BYSCORE": true, "BYLEX": true, "REV": true, "LIMIT": true},
+	cmdZRevRange:        {"WITHSCORES": true},
+	cmdZRangeByScore:    {"WITHSCORES": true, "LIMIT": true},
+	cmdZRevRangeByScore: {"WITHSCORES": true, "LIMIT": true},
+	cmdZRangeByLex:      {"LIMIT": true},
+	cmdZRevRangeByLex:   {"LIMIT": true},
+}
+
+// zrange implements ZRANGE, ZRANGESTORE and the older ZREVRANGE,
+// ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX, which
+// are ZRANGE with some of its options implied.
+func (e *Executor) zrange(args []resp.Value, name string) (resp.Value, error) {
+	var dst string
+	if name == cmdZRangeStore {
+		dst, args = string(args[0].Bytes), args[1:]
+	}
+
+	var r storage.ZRange
+	switch name {
+	case cmdZRevRange:
+		r.Rev = true
+	case cmdZRangeByScore:
+		r.By = storage.ZByScore
+	case cmdZRevRangeByScore:
+		r.By, r.Rev = storage.ZByScore, true
+	case cmdZRangeByLex:
+		r.By = storage.ZByLex
+	case cmdZRevRangeByLex:
+		r.By, r.Rev = storage.ZByLex, true
+	}
+	withScores := false
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i].Bytes))
+		if !zrangeOptions[name][opt] {
+			return errSyntax, nil
+		}
+		switch opt {
+		case "BYSCORE":
+			r.By = storage.ZByScore
+		case "BYLEX":
+			r.By = storage.ZByLex
+		case "REV":
+			r.Rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return errSyntax, nil
+			}
+			offset, err1 := strconv.Atoi(string(args[i+1].Bytes))
+			count, err2 := strconv.Atoi(string(args[i+2].Bytes))
+			if err1 != nil || err2 != nil {
+				return errNotInteger, nil
+			}
+			r.Limit, r.Offset, r.Count = true, offset, count
+			i += 2
+		}
+	}
+	if r.Limit && r.By == storage.ZByRank {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")}, nil
+	}
+	if withScores && r.By == storage.ZByLex {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error, WITHSCORES not supported in combination with BYLEX")}, nil
+	}
+	if errReply, ok := parseRange(args[1], args[2], &r); !ok {
+		return errReply, nil
+	}
+
+	k := string(args[0].Bytes)
+	if name == cmdZRangeStore {
+		n, err := e.storage.ZRangeStore(dst, k, r)
+		if err != nil {
+			return storageError(err)
+		}
+		return integer(n), nil
+	}
+	sms, err := e.storage.ZRange(k, r)
+	if err != nil {
+		return storageError(err)
+	}
+	return scoredReply(sms, withScores), nil
+}
+
+// zremrange implements ZREMRANGEBYRANK, ZREMRANGEBYSCORE and
+// ZREMRANGEBYLEX.
+func (e *Executor) zremrange(args []resp.Value, by storage.ZRangeBy) (resp.Value, error) {
+	r := storage.ZRange{By: by}
+	if errReply, ok := parseRange(args[1], args[2], &r); !ok {
+		return errReply, nil
+	}
+	n, err := e.storage.ZRemRange(string(args[0].Bytes), r)
+	if err != nil {
+		return storageError(err)
+	}
+	return integer(n), nil
+}
+
+// zpop implements ZPOPMIN and ZPOPMAX key [count].
+func (e *Executor) zpop(args []resp.Value, name string) (resp.Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(name), nil
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bytes))
+		if err != nil || n < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is out of range, must be positive")}, nil
+		}
+		count = n
+	}
+	sms, err := e.storage.ZPop(string(args[0].Bytes), count, name == cmdZPopMax)
+	if err != nil {
+		return storageError(err)
+	}
+	if len(args) == 1 && len(sms) == 1 {
+		// Without a count, the member and its score are not nested even in
+		// RESP3, as in Redis.
+		return resp.Value{Type: resp.TypeArray, Array: []resp.Value{{Type: resp.TypeBulkString, Bytes: sms[0].Member}, double(sms[0].Score)}}, nil
+	}
+	return scoredReply(sms, true), nil
+}
+
+// zcombine implements ZUNION, ZINTER and ZDIFF, and their STORE variants:
+// [destination] numkeys key [key ...] [WEIGHTS weight ...]
+// [AGGREGATE SUM|MIN|MAX] [WITHSCORES]. ZDIFF takes neither WEIGHTS nor
+// AGGREGATE, and the STORE variants do not take WITHSCORES.
+func (e *Executor) zcombine(args []resp.Value, op storage.SetOp, store bool, name string) (resp.Value, error) {
+	var dst string
+	if store {
+		dst, args = string(args[0].Bytes), args[1:]
+	}
+	numKeys, err := strconv.Atoi(string(args[0].Bytes))
+	if err != nil {
+		return errNotInteger, nil
+	}
+	if numKeys < 1 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR at least 1 input key is needed for '" + name + "' command")}, nil
+	}
+	if numKeys > len(args)-1 {
+		return errSyntax, nil
+	}
+	keyArgs := keys(args[1 : 1+numKeys])
+
+	var weights []float64
+	agg := storage.ZAggSum
+	withScores := false
+	rest := args[1+numKeys:]
+	for i := 0; i < len(rest); i++ {
+		switch opt := strings.ToUpper(string(rest[i].Bytes)); {
+		case opt == "WEIGHTS" && op != storage.SetDiff && i+numKeys < len(rest):
+			weights = make([]float64, numKeys)
+			for j := range weights {
+				w, ok := parseScore(rest[i+1+j])
+				if !ok {
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR weight value is not a float")}, nil
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case opt == "AGGREGATE" && op != storage.SetDiff && i+1 < len(rest):
+			switch strings.ToUpper(string(rest[i+1].Bytes)) {
+			case "SUM":
+				agg = storage.ZAggSum
+			case "MIN":
+				agg = storage.ZAggMin
+			case "MAX":
+				agg = storage.ZAggMax
+			default:
+				return errSyntax, nil
+			}
+			i++
+		case opt == "WITHSCORES" && !store:
+			withScores = true
+		default:
+			return errSyntax, nil
+		}
+	}
+
+	if store {
+		n, err := e.storage.ZCombineStore(op, dst, keyArgs, weights, agg)
+		if err != nil {
+			return storageError(err)
+		}
+		return integer(n), nil
+	}
+	sms, err := e.storage.ZCombine(op, keyArgs, weights, agg)
+	if err != nil {
+		return storageError(err)
+	}
+	return scoredReply(sms, withScores), nil
+}
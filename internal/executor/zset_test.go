@@ -0,0 +1,123 @@
+package executor_test
+
+import (
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortedSetCommands(t *testing.T) {
+	s := storage.NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+	e := executor.NewExecutor(s)
+	run := func(args ...string) resp.Value {
+		t.Helper()
+		out, err := e.Execute(cmd(args...))
+		require.NoError(t, err)
+		return out
+	}
+	// strs returns the strings of a reply, with pairs flattened as in RESP2.
+	var strs func(v resp.Value) []string
+	strs = func(v resp.Value) []string {
+		out := []string{}
+		for _, el := range v.Array {
+			if el.Type == resp.TypeArray {
+				out = append(out, strs(el)...)
+				continue
+			}
+			out = append(out, string(el.Bytes))
+		}
+		return out
+	}
+
+	assert.Equal(t, "3", string(run("ZADD", "board", "10", "ann", "20", "bob", "30", "cat").Bytes))
+	assert.Equal(t, "zset", string(run("TYPE", "board").Bytes))
+	assert.Equal(t, "0", string(run("ZADD", "board", "NX", "99", "ann").Bytes))
+	assert.Equal(t, "1", string(run("ZADD", "board", "CH", "GT", "25", "bob", "5", "cat").Bytes))
+	assert.Nil(t, run("ZADD", "board", "LT", "INCR", "1", "ann").Bytes)
+	assert.Equal(t, "15", string(run("ZADD", "board", "XX", "INCR", "5", "ann").Bytes))
+	assert.Equal(t, "17.5", string(run("ZINCRBY", "board", "2.5", "ann").Bytes))
+	assert.Equal(t, "3", string(run("ZCARD", "board").Bytes))
+	assert.Equal(t, "25", string(run("ZSCORE", "board", "bob").Bytes))
+	assert.Nil(t, run("ZSCORE", "board", "nobody").Bytes)
+	assert.Equal(t, []string{"17.5", ""}, strs(run("ZMSCORE", "board", "ann", "nobody")))
+
+	scored := run("ZRANGE", "board", "0", "-1", "WITHSCORES")
+	assert.Equal(t, []string{"ann", "17.5", "bob", "25", "cat", "30"}, strs(scored))
+	assert.Equal(t, resp.FlatPairs, scored.RESP2)
+	assert.Equal(t, resp.TypeDouble, scored.Array[0].Array[1].Type)
+	assert.Equal(t, []string{"cat", "bob"}, strs(run("ZRANGE", "board", "0", "1", "REV")))
+	assert.Equal(t, []string{"cat", "bob"}, strs(run("ZREVRANGE", "board", "0", "1")))
+	assert.Equal(t, []string{"bob", "cat"}, strs(run("ZRANGE", "board", "(17.5", "+inf", "BYSCORE")))
+	assert.Equal(t, []string{"cat"}, strs(run("ZRANGE", "board", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "0", "1")))
+	assert.Equal(t, []string{"bob", "25"}, strs(run("ZRANGEBYSCORE", "board", "20", "29", "WITHSCORES")))
+	assert.Equal(t, []string{"bob", "ann"}, strs(run("ZREVRANGEBYSCORE", "board", "29", "-inf")))
+	assert.Equal(t, "2", string(run("ZCOUNT", "board", "-inf", "(30").Bytes))
+
+	assert.Equal(t, "0", string(run("ZRANK", "board", "ann").Bytes))
+	assert.Equal(t, "2", string(run("ZREVRANK", "board", "ann").Bytes))
+	assert.Equal(t, []string{"1", "25"}, strs(run("ZRANK", "board", "bob", "WITHSCORE")))
+	assert.Nil(t, run("ZRANK", "board", "nobody").Bytes)
+
+	t.Run("lex", func(t *testing.T) {
+		run("ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+		assert.Equal(t, []string{"b", "c"}, strs(run("ZRANGE", "lex", "(a", "[c", "BYLEX")))
+		assert.Equal(t, []string{"d", "c"}, strs(run("ZREVRANGEBYLEX", "lex", "+", "-", "LIMIT", "0", "2")))
+		assert.Equal(t, []string{"a", "b"}, strs(run("ZRANGEBYLEX", "lex", "-", "(c")))
+		assert.Equal(t, "4", string(run("ZLEXCOUNT", "lex", "-", "+").Bytes))
+		assert.Equal(t, "2", string(run("ZREMRANGEBYLEX", "lex", "[c", "+").Bytes))
+		assert.Equal(t, "ERR min or max not valid string range item", string(run("ZRANGEBYLEX", "lex", "a", "+").Bytes))
+	})
+
+	t.Run("store and combine", func(t *testing.T) {
+		run("ZADD", "other", "1", "bob", "2", "dan")
+		assert.Equal(t, "4", string(run("ZUNIONSTORE", "u", "2", "board", "other", "WEIGHTS", "1", "10").Bytes))
+		assert.Equal(t, []string{"ann", "17.5", "dan", "20", "cat", "30", "bob", "35"}, strs(run("ZRANGE", "u", "0", "-1", "WITHSCORES")))
+		assert.Equal(t, "1", string(run("ZINTERSTORE", "i", "2", "board", "other", "AGGREGATE", "MIN").Bytes))
+		assert.Equal(t, []string{"bob", "1"}, strs(run("ZRANGE", "i", "0", "-1", "WITHSCORES")))
+		assert.Equal(t, []string{"ann", "cat"}, strs(run("ZDIFF", "2", "board", "other")))
+		assert.Equal(t, []string{"bob", "26"}, strs(run("ZINTER", "2", "board", "other", "WITHSCORES")))
+		assert.Equal(t, "2", string(run("ZDIFFSTORE", "d", "2", "board", "other").Bytes))
+		assert.Equal(t, "2", string(run("ZRANGESTORE", "top", "board", "0", "1", "REV").Bytes))
+		assert.Equal(t, []string{"bob", "cat"}, strs(run("ZRANGE", "top", "0", "-1")))
+
+		run("SADD", "plain", "ann")
+		assert.Equal(t, []string{"ann", "18.5"}, strs(run("ZINTER", "2", "board", "plain", "WITHSCORES")))
+
+		assert.Equal(t, resp.TypeError, run("ZUNIONSTORE", "u", "0", "board").Type)
+		assert.Equal(t, resp.TypeError, run("ZUNIONSTORE", "u", "3", "board", "other").Type)
+		assert.Equal(t, resp.TypeError, run("ZUNION", "1", "board", "WEIGHTS", "x").Type)
+		assert.Equal(t, resp.TypeError, run("ZDIFF", "1", "board", "AGGREGATE", "MIN").Type)
+	})
+
+	t.Run("pop and remove", func(t *testing.T) {
+		assert.Equal(t, []string{"ann", "17.5"}, strs(run("ZPOPMIN", "board")))
+		assert.Equal(t, []string{"cat", "30"}, strs(run("ZPOPMAX", "board", "1")))
+		assert.Equal(t, "1", string(run("ZREMRANGEBYSCORE", "board", "-inf", "+inf").Bytes))
+		assert.Equal(t, "none", string(run("TYPE", "board").Bytes))
+		assert.Empty(t, run("ZPOPMIN", "board").Array)
+		assert.Equal(t, "1", string(run("ZREMRANGEBYRANK", "other", "0", "0").Bytes))
+		assert.Equal(t, "1", string(run("ZREM", "other", "dan", "nobody").Bytes))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		run("SET", "str", "v")
+		assert.Contains(t, string(run("ZADD", "str", "1", "a").Bytes), "WRONGTYPE")
+		assert.Equal(t, "ERR value is not a valid float", string(run("ZADD", "z", "x", "a").Bytes))
+		assert.Equal(t, "ERR value is not a valid float", string(run("ZADD", "z", "nan", "a").Bytes))
+		assert.Equal(t, resp.TypeError, run("ZADD", "z", "NX", "XX", "1", "a").Type)
+		assert.Equal(t, resp.TypeError, run("ZADD", "z", "GT", "LT", "1", "a").Type)
+		assert.Equal(t, resp.TypeError, run("ZADD", "z", "INCR", "1", "a", "2", "b").Type)
+		assert.Equal(t, resp.TypeError, run("ZADD", "z", "1").Type)
+		assert.Equal(t, "ERR min or max is not a float", string(run("ZCOUNT", "z", "a", "1").Bytes))
+		assert.Equal(t, resp.TypeError, run("ZRANGE", "z", "0", "1", "LIMIT", "0", "1").Type)
+		assert.Equal(t, resp.TypeError, run("ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES").Type)
+		assert.Equal(t, resp.TypeError, run("ZRANGEBYLEX", "z", "-", "+", "WITHSCORES").Type)
+		run("ZADD", "inf", "+inf", "a")
+		assert.Equal(t, "ERR resulting score is not a number (NaN)", string(run("ZINCRBY", "inf", "-inf", "a").Bytes))
+	})
+}
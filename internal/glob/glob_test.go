@@ -0,0 +1,42 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"news", "news", true},
+		{"news", "new", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news.*", "news.sport", true},
+		{"news.*", "news", false},
+		{"n*s", "news", true},
+		{"n**s", "ns", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"h[ab", "ha", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, glob.Match(c.pattern, c.s), "Match(%q, %q)", c.pattern, c.s)
+	}
+}
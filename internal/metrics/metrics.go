@@ -0,0 +1,301 @@
+// Package metrics keeps counters, gauges and histograms and exposes them
+// over HTTP in the Prometheus text format, for scraping without an
+// exporter.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// contentType is the media type of the text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family is a metric and its samples.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them out.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric, in the order they were registered.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	n atomic.Int64
+}
+
+func (c *Counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.n.Add(n)
+}
+
+// CounterVec is a counter per value of a label.
+type CounterVec struct {
+	name, help, label string
+	vec               vec[*Counter]
+}
+
+// NewCounterVec registers a counter per value of label.
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{name: name, help: help, label: label}
+	v.vec.new = func() *Counter { return &Counter{} }
+	r.register(v)
+	return v
+}
+
+// With returns the counter of a label value, creating it if needed.
+func (v *CounterVec) With(value string) *Counter {
+	return v.vec.with(value)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	header(w, v.name, v.help, "counter")
+	for _, e := range v.vec.entries() {
+		sample(w, v.name, []string{v.label, e.value}, float64(e.m.n.Load()))
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	// bounds are the upper bounds of the buckets, in increasing order. The
+	// last bucket, +Inf, is implicit.
+	bounds []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// HistogramVec is a histogram per value of a label.
+type HistogramVec struct {
+	name, help, label string
+	vec               vec[*Histogram]
+}
+
+// NewHistogramVec registers a histogram per value of label, with buckets of
+// the given upper bounds, in increasing order.
+func (r *Registry) NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	v := &HistogramVec{name: name, help: help, label: label}
+	v.vec.new = func() *Histogram { return newHistogram(buckets) }
+	r.register(v)
+	return v
+}
+
+// With returns the histogram of a label value, creating it if needed.
+func (v *HistogramVec) With(value string) *Histogram {
+	return v.vec.with(value)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	header(w, v.name, v.help, "histogram")
+	for _, e := range v.vec.entries() {
+		h := e.m
+		// Buckets are cumulative. The total is read first so that it is
+		// never less than the buckets' counts.
+		count := h.count.Load()
+		sum := math.Float64frombits(h.sum.Load())
+		var cum uint64
+		for i, bound := range h.bounds {
+			cum += h.counts[i].Load()
+			sample(w, v.name+"_bucket", []string{v.label, e.value, "le", formatFloat(bound)}, float64(min(cum, count)))
+		}
+		sample(w, v.name+"_bucket", []string{v.label, e.value, "le", "+Inf"}, float64(count))
+		sample(w, v.name+"_sum", []string{v.label, e.value}, sum)
+		sample(w, v.name+"_count", []string{v.label, e.value}, float64(count))
+	}
+}
+
+// funcFamily is a metric whose samples are read when it is written out.
+type funcFamily struct {
+	name, help, typ, label string
+	collect                func(emit func(value string, v float64))
+}
+
+// NewGaugeFunc registers a gauge whose value is f's.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&funcFamily{name: name, help: help, typ: "gauge", collect: func(emit func(string, float64)) {
+		emit("", f())
+	}})
+}
+
+// NewCounterFunc registers a counter whose value is f's.
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(&funcFamily{name: name, help: help, typ: "counter", collect: func(emit func(string, float64)) {
+		emit("", f())
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge per value of label, whose values f
+// emits.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, f func(emit func(value string, v float64))) {
+	r.register(&funcFamily{name: name, help: help, typ: "gauge", label: label, collect: f})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	header(w, f.name, f.help, f.typ)
+	f.collect(func(value string, v float64) {
+		var labels []string
+		if f.label != "" {
+			labels = []string{f.label, value}
+		}
+		sample(w, f.name, labels, v)
+	})
+}
+
+// vec holds a metric per label value.
+type vec[M any] struct {
+	new func() M
+	mu  sync.RWMutex
+	m   map[string]M
+}
+
+func (v *vec[M]) with(value string) M {
+	v.mu.RLock()
+	m, ok := v.m[value]
+	v.mu.RUnlock()
+	if ok {
+		return m
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if m, ok := v.m[value]; ok {
+		return m
+	}
+	if v.m == nil {
+		v.m = make(map[string]M)
+	}
+	m = v.new()
+	v.m[value] = m
+	return m
+}
+
+type entry[M any] struct {
+	value string
+	m     M
+}
+
+// entries returns the metrics ordered by label value.
+func (v *vec[M]) entries() []entry[M] {
+	v.mu.RLock()
+	out := make([]entry[M], 0, len(v.m))
+	for value, m := range v.m {
+		out = append(out, entry[M]{value, m})
+	}
+	v.mu.RUnlock()
+	slices.SortFunc(out, func(a, b entry[M]) int { return strings.Compare(a.value, b.value) })
+	return out
+}
+
+func header(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// sample writes a sample with labels given as alternating names and values.
+func sample(w *bufio.Writer, name string, labels []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,70 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	calls := r.NewCounterVec("calls_total", "Calls made.", "name")
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", "name", []float64{0.1, 1})
+	r.NewGaugeFunc("open", "Open things,\nover lines.", func() float64 { return 3 })
+	r.NewCounterFunc("seen_total", "Things seen.", func() float64 { return 1.5 })
+	r.NewGaugeVecFunc("size", "Sizes.", "part", func(emit func(string, float64)) {
+		emit("0", 1)
+		emit("1", 2)
+	})
+
+	calls.With("b").Inc()
+	calls.With("a").Add(2)
+	calls.With(`q"\`).Inc()
+	latency.With("a").Observe(0.05)
+	latency.With("a").Observe(0.5)
+	latency.With("a").Observe(5)
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP calls_total Calls made.
+# TYPE calls_total counter
+calls_total{name="a"} 2
+calls_total{name="b"} 1
+calls_total{name="q\"\\"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{name="a",le="0.1"} 1
+latency_seconds_bucket{name="a",le="1"} 2
+latency_seconds_bucket{name="a",le="+Inf"} 3
+latency_seconds_sum{name="a"} 5.55
+latency_seconds_count{name="a"} 3
+# HELP open Open things,\nover lines.
+# TYPE open gauge
+open 3
+# HELP seen_total Things seen.
+# TYPE seen_total counter
+seen_total 1.5
+# HELP size Sizes.
+# TYPE size gauge
+size{part="0"} 1
+size{part="1"} 2
+`, b.String())
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.RegisterRuntime()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE go_goroutines gauge\ngo_goroutines ")
+	assert.Contains(t, body, "# TYPE go_memstats_alloc_bytes gauge\n")
+	assert.Contains(t, body, `go_info{version="go`)
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+)
+
+// RegisterRuntime registers metrics of the Go runtime: goroutines, memory
+// and garbage collection, under the names the official Prometheus client
+// uses.
+func (r *Registry) RegisterRuntime() {
+	r.register(runtimeFamily{})
+}
+
+// runtimeFamily writes several metrics from a single read of the runtime's
+// memory statistics, which stops the world.
+type runtimeFamily struct{}
+
+func (runtimeFamily) write(w *bufio.Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	for _, g := range []struct {
+		name, help, typ string
+		v               float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge", float64(m.HeapAlloc)},
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(m.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge", float64(m.Sys)},
+		{"go_memstats_mallocs_total", "Total number of mallocs.", "counter", float64(m.Mallocs)},
+		{"go_memstats_frees_total", "Total number of frees.", "counter", float64(m.Frees)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(m.HeapInuse)},
+		{"go_memstats_heap_idle_bytes", "Number of heap bytes waiting to be used.", "gauge", float64(m.HeapIdle)},
+		{"go_memstats_heap_released_bytes", "Number of heap bytes released to OS.", "gauge", float64(m.HeapReleased)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(m.HeapObjects)},
+		{"go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", "gauge", float64(m.StackInuse)},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", "gauge", float64(m.NextGC)},
+		{"go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", "gauge", float64(m.LastGC) / 1e9},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(m.NumGC)},
+		{"go_gc_pause_seconds_total", "Total time the world was stopped for garbage collection.", "counter", float64(m.PauseTotalNs) / 1e9},
+	} {
+		header(w, g.name, g.help, g.typ)
+		sample(w, g.name, nil, g.v)
+	}
+	header(w, "go_info", "Information about the Go environment.", "gauge")
+	sample(w, "go_info", []string{"version", runtime.Version()}, 1)
+}
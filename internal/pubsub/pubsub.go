@@ -0,0 +1,271 @@
+// Package pubsub routes published messages to the subscribers of a channel
+// and of every pattern matching it.
+package pubsub
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// Subscriber receives messages and the confirmations of its own
+// subscription changes. Deliver is called with the hub's lock held and must
+// not block; a subscriber that cannot keep up should drop the message or its
+// connection.
+type Subscriber interface {
+	Deliver(msg resp.Value)
+}
+
+type subscriptions struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (s *subscriptions) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[Subscriber]struct{}
+	patterns map[string]map[Subscriber]struct{}
+	subs     map[Subscriber]*subscriptions
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[Subscriber]struct{}),
+		patterns: make(map[string]map[Subscriber]struct{}),
+		subs:     make(map[Subscriber]*subscriptions),
+	}
+}
+
+// Subscribe subscribes s to channel and returns the number of channels and
+// patterns s is now subscribed to.
+func (h *Hub) Subscribe(s Subscriber, channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subscriptions(s)
+	add(h.channels, channel, s)
+	sub.channels[channel] = struct{}{}
+	n := sub.count()
+	s.Deliver(Confirmation("subscribe", []byte(channel), n))
+	return n
+}
+
+// Unsubscribe removes the subscription of s to channel, if any, and returns
+// the number of subscriptions s has left. Like every subscription change it
+// is confirmed to s through Deliver, so the confirmation is ordered with the
+// messages s receives.
+func (h *Hub) Unsubscribe(s Subscriber, channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subscriptions(s)
+	remove(h.channels, channel, s)
+	delete(sub.channels, channel)
+	n := h.release(s, sub)
+	s.Deliver(Confirmation("unsubscribe", []byte(channel), n))
+	return n
+}
+
+// PSubscribe subscribes s to every channel matching pattern.
+func (h *Hub) PSubscribe(s Subscriber, pattern string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subscriptions(s)
+	add(h.patterns, pattern, s)
+	sub.patterns[pattern] = struct{}{}
+	n := sub.count()
+	s.Deliver(Confirmation("psubscribe", []byte(pattern), n))
+	return n
+}
+
+// PUnsubscribe removes the subscription of s to pattern.
+func (h *Hub) PUnsubscribe(s Subscriber, pattern string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.subscriptions(s)
+	remove(h.patterns, pattern, s)
+	delete(sub.patterns, pattern)
+	n := h.release(s, sub)
+	s.Deliver(Confirmation("punsubscribe", []byte(pattern), n))
+	return n
+}
+
+// Channels returns the channels s is subscribed to, sorted.
+func (h *Hub) Channels(s Subscriber) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if sub, ok := h.subs[s]; ok {
+		return sorted(sub.channels)
+	}
+	return nil
+}
+
+// Patterns returns the patterns s is subscribed to, sorted.
+func (h *Hub) Patterns(s Subscriber) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if sub, ok := h.subs[s]; ok {
+		return sorted(sub.patterns)
+	}
+	return nil
+}
+
+// Counts returns the number of channels and of patterns s is subscribed
+// to.
+func (h *Hub) Counts(s Subscriber) (channels, patterns int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if sub, ok := h.subs[s]; ok {
+		return len(sub.channels), len(sub.patterns)
+	}
+	return 0, 0
+}
+
+// RemoveAll drops every subscription of s, typically when its connection
+// closes.
+func (h *Hub) RemoveAll(s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[s]
+	if !ok {
+		return
+	}
+	for ch := range sub.channels {
+		remove(h.channels, ch, s)
+	}
+	for p := range sub.patterns {
+		remove(h.patterns, p, s)
+	}
+	delete(h.subs, s)
+}
+
+// Publish sends message to the subscribers of channel and of the patterns
+// matching it, and returns the number of deliveries.
+func (h *Hub) Publish(channel string, message []byte) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := 0
+	if subs := h.channels[channel]; len(subs) > 0 {
+		msg := resp.Value{Type: resp.TypePush, Array: []resp.Value{
+			bulk("message"), bulk(channel), {Type: resp.TypeBulkString, Bytes: message},
+		}}
+		for s := range subs {
+			s.Deliver(msg)
+			n++
+		}
+	}
+	for pattern, subs := range h.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		msg := resp.Value{Type: resp.TypePush, Array: []resp.Value{
+			bulk("pmessage"), bulk(pattern), bulk(channel), {Type: resp.TypeBulkString, Bytes: message},
+		}}
+		for s := range subs {
+			s.Deliver(msg)
+			n++
+		}
+	}
+	return n
+}
+
+// ActiveChannels returns the channels with at least one subscriber that
+// match pattern, sorted. An empty pattern matches every channel.
+func (h *Hub) ActiveChannels(pattern string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var out []string
+	for ch := range h.channels {
+		if pattern == "" || glob.Match(pattern, ch) {
+			out = append(out, ch)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NumSub returns the number of subscribers of channel, not counting pattern
+// subscribers.
+func (h *Hub) NumSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel])
+}
+
+// NumPat returns the number of distinct patterns subscribed to.
+func (h *Hub) NumPat() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.patterns)
+}
+
+// subscriptions returns the subscriptions of s, creating them if needed.
+// Callers must hold mu.
+func (h *Hub) subscriptions(s Subscriber) *subscriptions {
+	sub, ok := h.subs[s]
+	if !ok {
+		sub = &subscriptions{channels: make(map[string]struct{}), patterns: make(map[string]struct{})}
+		h.subs[s] = sub
+	}
+	return sub
+}
+
+// release forgets s once it has no subscriptions left and returns its
+// subscription count. Callers must hold mu.
+func (h *Hub) release(s Subscriber, sub *subscriptions) int {
+	n := sub.count()
+	if n == 0 {
+		delete(h.subs, s)
+	}
+	return n
+}
+
+func add(m map[string]map[Subscriber]struct{}, name string, s Subscriber) {
+	subs, ok := m[name]
+	if !ok {
+		subs = make(map[Subscriber]struct{})
+		m[name] = subs
+	}
+	subs[s] = struct{}{}
+}
+
+func remove(m map[string]map[Subscriber]struct{}, name string, s Subscriber) {
+	subs, ok := m[name]
+	if !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(m, name)
+	}
+}
+
+func sorted(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Confirmation returns the reply confirming a subscription change, which
+// carries the number of subscriptions left. Like messages it is a push, which
+// RESP2 clients receive as an array.
+func Confirmation(kind string, name []byte, count int) resp.Value {
+	return resp.Value{Type: resp.TypePush, Array: []resp.Value{
+		bulk(kind),
+		{Type: resp.TypeBulkString, Bytes: name},
+		{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(count))},
+	}}
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
@@ -0,0 +1,107 @@
+package pubsub_test
+
+import (
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/pubsub"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/stretchr/testify/assert"
+)
+
+type inbox struct {
+	msgs []resp.Value
+}
+
+func (i *inbox) Deliver(msg resp.Value) {
+	i.msgs = append(i.msgs, msg)
+}
+
+// take returns and forgets the messages received so far.
+func (i *inbox) take() []resp.Value {
+	msgs := i.msgs
+	i.msgs = nil
+	return msgs
+}
+
+func strs(v resp.Value) []string {
+	out := make([]string, len(v.Array))
+	for i, e := range v.Array {
+		out[i] = string(e.Bytes)
+	}
+	return out
+}
+
+func TestPublish(t *testing.T) {
+	h := pubsub.NewHub()
+	a, b := &inbox{}, &inbox{}
+
+	assert.Equal(t, 1, h.Subscribe(a, "news"))
+	assert.Equal(t, 2, h.PSubscribe(a, "n*"))
+	assert.Equal(t, 1, h.PSubscribe(b, "n*"))
+	if got := a.take(); assert.Len(t, got, 2) {
+		assert.Equal(t, []string{"subscribe", "news", "1"}, strs(got[0]))
+		assert.Equal(t, []string{"psubscribe", "n*", "2"}, strs(got[1]))
+	}
+	b.take()
+
+	assert.Equal(t, 3, h.Publish("news", []byte("hi")))
+	assert.Equal(t, 2, h.Publish("nope", []byte("x")))
+	assert.Equal(t, 0, h.Publish("other", []byte("x")))
+
+	if assert.Len(t, a.msgs, 3) {
+		assert.Equal(t, []string{"message", "news", "hi"}, strs(a.msgs[0]))
+		assert.Equal(t, []string{"pmessage", "n*", "news", "hi"}, strs(a.msgs[1]))
+		assert.Equal(t, []string{"pmessage", "n*", "nope", "x"}, strs(a.msgs[2]))
+	}
+	assert.Len(t, b.msgs, 2)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := pubsub.NewHub()
+	a := &inbox{}
+
+	h.Subscribe(a, "x")
+	h.Subscribe(a, "y")
+	h.PSubscribe(a, "z*")
+	assert.Equal(t, []string{"x", "y"}, h.Channels(a))
+	assert.Equal(t, []string{"z*"}, h.Patterns(a))
+
+	a.take()
+
+	assert.Equal(t, 2, h.Unsubscribe(a, "x"))
+	assert.Equal(t, 2, h.Unsubscribe(a, "unknown"))
+	assert.Equal(t, 1, h.PUnsubscribe(a, "z*"))
+	assert.Equal(t, 0, h.Publish("x", []byte("m")))
+	if got := a.take(); assert.Len(t, got, 3) {
+		assert.Equal(t, []string{"unsubscribe", "x", "2"}, strs(got[0]))
+		assert.Equal(t, []string{"unsubscribe", "unknown", "2"}, strs(got[1]))
+		assert.Equal(t, []string{"punsubscribe", "z*", "1"}, strs(got[2]))
+	}
+
+	h.RemoveAll(a)
+	assert.Empty(t, h.Channels(a))
+	assert.Equal(t, 0, h.Publish("y", []byte("m")))
+}
+
+func TestIntrospection(t *testing.T) {
+	h := pubsub.NewHub()
+	a, b := &inbox{}, &inbox{}
+
+	h.Subscribe(a, "news.sport")
+	h.Subscribe(b, "news.sport")
+	h.Subscribe(b, "weather")
+	h.PSubscribe(a, "news.*")
+	h.PSubscribe(b, "news.*")
+
+	assert.Equal(t, []string{"news.sport", "weather"}, h.ActiveChannels(""))
+	assert.Equal(t, []string{"news.sport"}, h.ActiveChannels("news.*"))
+	assert.Equal(t, 2, h.NumSub("news.sport"))
+	assert.Equal(t, 0, h.NumSub("missing"))
+	assert.Equal(t, 1, h.NumPat())
+
+	channels, patterns := h.Counts(b)
+	assert.Equal(t, 2, channels)
+	assert.Equal(t, 1, patterns)
+	channels, patterns = h.Counts(&inbox{})
+	assert.Zero(t, channels+patterns)
+}
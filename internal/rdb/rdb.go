@@ -0,0 +1,540 @@
+// Package rdb reads and writes point-in-time snapshot files of the keyspace.
+//
+// A file starts with the magic string "KVRDB" followed by a four digit
+// format version, then a sequence of records, each introduced by an opcode:
+//
+//	opAux      key, value            metadata such as the creation time
+//	opExpireMs 8 byte little-endian  expiry of the next key, unix ms
+//	opType*    key, value            one key of the given type
+//	opEOF      8 byte little-endian  CRC-64 (ECMA) of every preceding byte
+//
+// Strings are encoded as a uvarint length followed by the raw bytes. A string
+// key's value is a single string; a list's is a uvarint element count
+// followed by the elements. Sets are encoded the same way, and so are hashes,
+// their fields and values alternating, and sorted sets, their scores, in
+// decimal, and members alternating.
+//
+// A stream is encoded as its entries, each an ID followed by its fields and
+// values as a list of strings, then its last ID and its consumer groups.
+// Each group is its name, last delivered ID, consumers and pending entries.
+// IDs are two uvarints, times are uvarint unix milliseconds and every count
+// is a uvarint.
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+const (
+	magic = "KVRDB"
+	// Version is the current file format version. Files written by a newer
+	// version are rejected.
+	Version = 1
+
+	opAux        byte = 0xFA
+	opExpireMs   byte = 0xFC
+	opEOF        byte = 0xFF
+	opTypeString byte = 0x00
+	opTypeList   byte = 0x01
+	opTypeSet    byte = 0x02
+	opTypeZSet   byte = 0x03
+	opTypeHash   byte = 0x04
+	opTypeStream byte = 0x05
+)
+
+// collectionOps maps the kinds encoded as a list of strings to their opcode.
+var collectionOps = map[storage.Kind]byte{
+	storage.KindList: opTypeList,
+	storage.KindSet:  opTypeSet,
+	storage.KindZSet: opTypeZSet,
+	storage.KindHash: opTypeHash,
+}
+
+var (
+	ErrBadMagic    = errors.New("rdb: not a snapshot file")
+	ErrBadChecksum = errors.New("rdb: checksum mismatch")
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Save writes snap to path. The file is written to a temporary file in the
+// same directory and renamed into place, so path always holds a complete
+// snapshot.
+func Save(path string, snap *storage.Snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if err := Write(w, snap); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write encodes snap to w.
+func Write(w io.Writer, snap *storage.Snapshot) error {
+	e := &encoder{w: w, crc: crc64.New(crcTable)}
+	e.raw([]byte(fmt.Sprintf("%s%04d", magic, Version)))
+	e.aux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+
+	err := snap.Walk(func(ent storage.Entry) error {
+		if !ent.ExpireAt.IsZero() {
+			e.byte(opExpireMs)
+			e.uint64(uint64(ent.ExpireAt.UnixMilli()))
+		}
+		switch ent.Kind {
+		case storage.KindString:
+			e.byte(opTypeString)
+			e.string([]byte(ent.Key))
+			e.string(ent.Value)
+		case storage.KindList, storage.KindSet, storage.KindZSet, storage.KindHash:
+			e.byte(collectionOps[ent.Kind])
+			e.string([]byte(ent.Key))
+			e.strings(ent.Elems)
+		case storage.KindStream:
+			e.byte(opTypeStream)
+			e.string([]byte(ent.Key))
+			e.stream(ent.Stream)
+		default:
+			return fmt.Errorf("rdb: cannot encode %s: unsupported kind %s", ent.Key, ent.Kind)
+		}
+		return e.err
+	})
+	if err != nil {
+		return err
+	}
+
+	e.byte(opEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc.Sum64())
+	if e.err == nil {
+		_, e.err = w.Write(sum[:])
+	}
+	return e.err
+}
+
+type encoder struct {
+	w   io.Writer
+	crc hash.Hash64
+	err error
+}
+
+func (e *encoder) raw(b []byte) {
+	if e.err != nil {
+		return
+	}
+	if _, e.err = e.w.Write(b); e.err == nil {
+		e.crc.Write(b)
+	}
+}
+
+func (e *encoder) byte(b byte) {
+	e.raw([]byte{b})
+}
+
+func (e *encoder) uint64(n uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], n)
+	e.raw(buf[:])
+}
+
+func (e *encoder) string(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.raw(b)
+}
+
+func (e *encoder) uvarint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.raw(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func (e *encoder) strings(vals [][]byte) {
+	e.uvarint(uint64(len(vals)))
+	for _, v := range vals {
+		e.string(v)
+	}
+}
+
+func (e *encoder) id(id storage.StreamID) {
+	e.uvarint(id.Ms)
+	e.uvarint(id.Seq)
+}
+
+func (e *encoder) time(t time.Time) {
+	e.uvarint(uint64(max(t.UnixMilli(), 0)))
+}
+
+func (e *encoder) stream(st *storage.StreamValue) {
+	e.uvarint(uint64(len(st.Entries)))
+	for _, ent := range st.Entries {
+		e.id(ent.ID)
+		e.strings(ent.Fields)
+	}
+	e.id(st.LastID)
+	e.uvarint(uint64(len(st.Groups)))
+	for _, g := range st.Groups {
+		e.string([]byte(g.Name))
+		e.id(g.LastID)
+		e.uvarint(uint64(len(g.Consumers)))
+		for _, c := range g.Consumers {
+			e.string([]byte(c.Name))
+			e.time(c.SeenAt)
+		}
+		e.uvarint(uint64(len(g.Pending)))
+		for _, p := range g.Pending {
+			e.id(p.ID)
+			e.string([]byte(p.Consumer))
+			e.time(p.DeliveredAt)
+			e.uvarint(uint64(p.Deliveries))
+		}
+	}
+}
+
+func (e *encoder) aux(k, v string) {
+	e.byte(opAux)
+	e.string([]byte(k))
+	e.string([]byte(v))
+}
+
+// Load restores the snapshot at path into s and returns the number of keys
+// loaded. A missing file is not an error. Keys whose expiry has passed are
+// skipped. The checksum is only verified once the whole file has been read,
+// so on error s may hold a partial keyspace and should be discarded.
+func Load(path string, s storage.Storage) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Read(bufio.NewReader(f), s)
+}
+
+// Read decodes a snapshot from r into s and returns the number of keys loaded.
+func Read(r io.Reader, s storage.Storage) (int, error) {
+	d := &decoder{r: r, crc: crc64.New(crcTable)}
+
+	header := make([]byte, len(magic)+4)
+	if err := d.full(header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return 0, ErrBadMagic
+	}
+	version, err := strconv.Atoi(string(header[len(magic):]))
+	if err != nil {
+		return 0, ErrBadMagic
+	}
+	if version > Version {
+		return 0, fmt.Errorf("rdb: unsupported format version %d", version)
+	}
+
+	n := 0
+	var expireAt time.Time
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return n, err
+		}
+
+		switch op {
+		case opEOF:
+			want := d.crc.Sum64()
+			var sum [8]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return n, err
+			}
+			if binary.LittleEndian.Uint64(sum[:]) != want {
+				return n, ErrBadChecksum
+			}
+			return n, nil
+		case opAux:
+			if _, err := d.string(); err != nil {
+				return n, err
+			}
+			if _, err := d.string(); err != nil {
+				return n, err
+			}
+		case opExpireMs:
+			ms, err := d.uint64()
+			if err != nil {
+				return n, err
+			}
+			expireAt = time.UnixMilli(int64(ms))
+		case opTypeString, opTypeList, opTypeSet, opTypeZSet, opTypeHash, opTypeStream:
+			k, err := d.string()
+			if err != nil {
+				return n, err
+			}
+			ent := storage.Entry{Key: string(k), ExpireAt: expireAt}
+			switch op {
+			case opTypeString:
+				ent.Kind = storage.KindString
+				ent.Value, err = d.string()
+			case opTypeList:
+				ent.Kind = storage.KindList
+				ent.Elems, err = d.strings()
+			case opTypeSet:
+				ent.Kind = storage.KindSet
+				ent.Elems, err = d.strings()
+			case opTypeZSet, opTypeHash:
+				ent.Kind = storage.KindHash
+				if op == opTypeZSet {
+					ent.Kind = storage.KindZSet
+				}
+				ent.Elems, err = d.strings()
+				if err == nil && len(ent.Elems)%2 != 0 {
+					err = fmt.Errorf("rdb: %s %s has an odd number of strings", ent.Kind, k)
+				}
+			case opTypeStream:
+				ent.Kind = storage.KindStream
+				ent.Stream, err = d.stream()
+			}
+			if err != nil {
+				return n, err
+			}
+			if expireAt.IsZero() || expireAt.After(time.Now()) {
+				if err := s.Restore(ent); err != nil {
+					return n, err
+				}
+				n++
+			}
+			expireAt = time.Time{}
+		default:
+			return n, fmt.Errorf("rdb: unknown opcode 0x%02x", op)
+		}
+	}
+}
+
+type decoder struct {
+	r   io.Reader
+	crc hash.Hash64
+}
+
+func (d *decoder) full(b []byte) error {
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.crc.Write(b)
+	return nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	var b [1]byte
+	err := d.full(b[:])
+	return b[0], err
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	var b [8]byte
+	if err := d.full(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (d *decoder) string() ([]byte, error) {
+	n, err := binary.ReadUvarint(byteReader{d})
+	if err != nil {
+		return nil, err
+	}
+	if n > 512*1024*1024 {
+		return nil, errors.New("rdb: string length exceeds maximum")
+	}
+	b := make([]byte, n)
+	err = d.full(b)
+	return b, err
+}
+
+func (d *decoder) strings() ([][]byte, error) {
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, min(n, 1024))
+	for range n {
+		v, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(byteReader{d})
+}
+
+// count reads a uvarint element count, rejecting absurd ones before they
+// are used to size allocations.
+func (d *decoder) count() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > 1<<32 {
+		return 0, errors.New("rdb: element count exceeds maximum")
+	}
+	return int(n), nil
+}
+
+func (d *decoder) id() (storage.StreamID, error) {
+	ms, err := d.uvarint()
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	seq, err := d.uvarint()
+	return storage.StreamID{Ms: ms, Seq: seq}, err
+}
+
+func (d *decoder) time() (time.Time, error) {
+	ms, err := d.uvarint()
+	return time.UnixMilli(int64(ms)), err
+}
+
+func (d *decoder) stream() (*storage.StreamValue, error) {
+	st := &storage.StreamValue{}
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	for range n {
+		var ent storage.StreamEntry
+		if ent.ID, err = d.id(); err != nil {
+			return nil, err
+		}
+		if ent.Fields, err = d.strings(); err != nil {
+			return nil, err
+		}
+		st.Entries = append(st.Entries, ent)
+	}
+	if st.LastID, err = d.id(); err != nil {
+		return nil, err
+	}
+
+	if n, err = d.count(); err != nil {
+		return nil, err
+	}
+	for range n {
+		var g storage.StreamGroup
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		g.Name = string(name)
+		if g.LastID, err = d.id(); err != nil {
+			return nil, err
+		}
+		consumers, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		for range consumers {
+			var c storage.StreamConsumer
+			name, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			c.Name = string(name)
+			if c.SeenAt, err = d.time(); err != nil {
+				return nil, err
+			}
+			g.Consumers = append(g.Consumers, c)
+		}
+		pending, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		for range pending {
+			var p storage.PendingEntry
+			if p.ID, err = d.id(); err != nil {
+				return nil, err
+			}
+			consumer, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			p.Consumer = string(consumer)
+			if p.DeliveredAt, err = d.time(); err != nil {
+				return nil, err
+			}
+			deliveries, err := d.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			p.Deliveries = int(deliveries)
+			g.Pending = append(g.Pending, p)
+		}
+		st.Groups = append(st.Groups, g)
+	}
+	return st, nil
+}
+
+// byteReader adapts a decoder to io.ByteReader so varints are checksummed.
+type byteReader struct{ d *decoder }
+
+func (b byteReader) ReadByte() (byte, error) {
+	return b.d.byte()
+}
+
+// SaveRule triggers an automatic snapshot once at least Changes writes have
+// happened and at least Seconds have passed since the last snapshot.
+type SaveRule struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSaveRules parses a "save" setting of the form
+// "<seconds> <changes> [<seconds> <changes> ...]". An empty string disables
+// automatic snapshots.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save rules %q", s)
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		secs, err := strconv.Atoi(fields[i])
+		if err != nil || secs < 1 {
+			return nil, fmt.Errorf("invalid save rules %q", s)
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save rules %q", s)
+		}
+		rules = append(rules, SaveRule{Seconds: secs, Changes: changes})
+	}
+	return rules, nil
+}
@@ -0,0 +1,191 @@
+package rdb_test
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := storage.NewInMemoryShardedStorage()
+	defer src.Close()
+	require.NoError(t, src.Set("plain", []byte("value")))
+	require.NoError(t, src.Set("empty", []byte{}))
+	require.NoError(t, src.Set("binary", []byte{0, 0xff, '\r', '\n'}))
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	_, _, err := src.SetWithOptions("session", []byte("abc"), storage.SetOptions{ExpireAt: at})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	require.NoError(t, rdb.Save(path, src.Snapshot()))
+
+	dst := storage.NewInMemoryStorage()
+	n, err := rdb.Load(path, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	for k, want := range map[string][]byte{
+		"plain":   []byte("value"),
+		"empty":   {},
+		"binary":  {0, 0xff, '\r', '\n'},
+		"session": []byte("abc"),
+	} {
+		got, err := dst.Get(k)
+		require.NoError(t, err, k)
+		assert.Equal(t, want, got, k)
+	}
+	got, err := dst.ExpireTime("session")
+	require.NoError(t, err)
+	assert.Equal(t, at.UnixMilli(), got.UnixMilli())
+}
+
+func TestRoundTripCollections(t *testing.T) {
+	src := storage.NewInMemoryStorage()
+	small := [][]byte{[]byte("a"), {}, []byte("c")}
+	_, err := src.ListPush("small", storage.ListTail, small, false)
+	require.NoError(t, err)
+	var large [][]byte
+	for i := range 1000 {
+		large = append(large, bytes.Repeat([]byte{byte(i)}, i%50))
+	}
+	_, err = src.ListPush("large", storage.ListTail, large, false)
+	require.NoError(t, err)
+
+	_, err = src.HashSet("hash", [][]byte{[]byte("f"), []byte("v"), []byte("empty"), {}}, false)
+	require.NoError(t, err)
+	_, err = src.SetAdd("set", []byte("a"), []byte("b"))
+	require.NoError(t, err)
+	zset := []storage.ScoredMember{{Member: []byte("low"), Score: math.Inf(-1)}, {Member: []byte("mid"), Score: 0.1}, {Member: []byte("high"), Score: 1e300}}
+	_, _, err = src.ZAdd("zset", storage.ZAddOptions{}, zset)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, rdb.Write(&buf, src.Snapshot()))
+	dst := storage.NewInMemoryStorage()
+	n, err := rdb.Read(&buf, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	gotZ, err := dst.ZRange("zset", storage.ZRange{Start: 0, Stop: -1})
+	require.NoError(t, err)
+	assert.Equal(t, zset, gotZ)
+
+	found, err := dst.SetIsMember("set", []byte("a"), []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false}, found)
+
+	kind, err := dst.Type("hash")
+	require.NoError(t, err)
+	assert.Equal(t, storage.KindHash, kind)
+	got, err := dst.HashGet("hash", []byte("f"), []byte("empty"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v"), {}}, got)
+
+	for k, want := range map[string][][]byte{"small": small, "large": large} {
+		kind, err := dst.Type(k)
+		require.NoError(t, err)
+		assert.Equal(t, storage.KindList, kind)
+		got, err := dst.ListRange(k, 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, k)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	n, err := rdb.Load(filepath.Join(t.TempDir(), "nope.rdb"), storage.NewInMemoryStorage())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestReadRejectsCorruption(t *testing.T) {
+	src := storage.NewInMemoryStorage()
+	require.NoError(t, src.Set("k", []byte("value")))
+	var buf bytes.Buffer
+	require.NoError(t, rdb.Write(&buf, src.Snapshot()))
+	good := buf.Bytes()
+
+	t.Run("flipped byte", func(t *testing.T) {
+		bad := bytes.Clone(good)
+		bad[len(bad)-12] ^= 0x01
+		_, err := rdb.Read(bytes.NewReader(bad), storage.NewInMemoryStorage())
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := rdb.Read(bytes.NewReader(good[:len(good)-4]), storage.NewInMemoryStorage())
+		assert.Error(t, err)
+	})
+
+	t.Run("bad magic", func(t *testing.T) {
+		_, err := rdb.Read(bytes.NewReader([]byte("REDIS0011")), storage.NewInMemoryStorage())
+		assert.ErrorIs(t, err, rdb.ErrBadMagic)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		_, err := rdb.Read(bytes.NewReader([]byte("KVRDB9999")), storage.NewInMemoryStorage())
+		assert.ErrorContains(t, err, "unsupported format version")
+	})
+}
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := rdb.ParseSaveRules("3600 1 300 100")
+	require.NoError(t, err)
+	assert.Equal(t, []rdb.SaveRule{{Seconds: 3600, Changes: 1}, {Seconds: 300, Changes: 100}}, rules)
+
+	rules, err = rdb.ParseSaveRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, bad := range []string{"60", "x 1", "60 -1", "0 1"} {
+		_, err := rdb.ParseSaveRules(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// streamOf returns the captured value of the stream at k.
+func streamOf(t *testing.T, s storage.Storage, k string) *storage.StreamValue {
+	t.Helper()
+	var st *storage.StreamValue
+	require.NoError(t, s.Snapshot().Walk(func(e storage.Entry) error {
+		if e.Key == k {
+			st = e.Stream
+		}
+		return nil
+	}))
+	require.NotNil(t, st, k)
+	return st
+}
+
+func TestRoundTripStream(t *testing.T) {
+	src := storage.NewInMemoryStorage()
+	for i := range 5 {
+		_, _, err := src.XAdd("s", [][]byte{[]byte("n"), []byte(strconv.Itoa(i)), []byte("empty"), {}}, storage.XAddOptions{AutoID: true})
+		require.NoError(t, err)
+	}
+	require.NoError(t, src.XGroupCreate("s", "g", storage.StreamID{}, false, false))
+	_, err := src.XReadGroup("s", "g", "alice", storage.StreamID{}, false, 3, false)
+	require.NoError(t, err)
+	_, err = src.XGroupCreateConsumer("s", "g", "idle")
+	require.NoError(t, err)
+	require.NoError(t, src.XGroupCreate("s", "other", storage.StreamID{}, true, false))
+	require.NoError(t, src.XGroupCreate("empty", "g", storage.StreamID{}, false, true))
+
+	var buf bytes.Buffer
+	require.NoError(t, rdb.Write(&buf, src.Snapshot()))
+	dst := storage.NewInMemoryStorage()
+	n, err := rdb.Read(&buf, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	for _, k := range []string{"s", "empty"} {
+		assert.Equal(t, streamOf(t, src, k), streamOf(t, dst, k), k)
+	}
+}
@@ -0,0 +1,101 @@
+package replication
+
+import (
+	"errors"
+	"sync"
+)
+
+var errOffsetTooOld = errors.New("offset no longer in the replication backlog")
+
+// backlog is a fixed-size ring buffer holding the most recent bytes of the
+// replication stream. Replicas stream from it by offset, so a replica that
+// reconnects after a short disconnect can resume where it left off. Its
+// buffer is only allocated once something is written to it.
+type backlog struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+	// offset is the total number of bytes ever written to the stream, which
+	// is the offset of the next byte.
+	offset int64
+	// histlen is the number of valid bytes in buf, ending at offset.
+	histlen int64
+	// changed is closed and replaced whenever bytes are appended while
+	// someone is waiting on it.
+	changed chan struct{}
+	waiting bool
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{size: size, changed: make(chan struct{})}
+}
+
+// write appends p to the stream.
+func (b *backlog) write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.buf == nil {
+		b.buf = make([]byte, b.size)
+	}
+	size := int64(len(b.buf))
+	for len(p) > 0 {
+		i := b.offset % size
+		n := copy(b.buf[i:], p)
+		p = p[n:]
+		b.offset += int64(n)
+		b.histlen = min(b.histlen+int64(n), size)
+	}
+	if b.waiting {
+		close(b.changed)
+		b.changed = make(chan struct{})
+		b.waiting = false
+	}
+}
+
+// reset discards the history and restarts the stream at offset.
+func (b *backlog) reset(offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offset = offset
+	b.histlen = 0
+}
+
+// active reports whether anything was ever written to the backlog.
+func (b *backlog) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf != nil
+}
+
+// bounds returns the offset of the first byte still held and the offset of
+// the next byte to be written.
+func (b *backlog) bounds() (first, next int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offset - b.histlen, b.offset
+}
+
+// readFrom copies up to len(p) bytes starting at offset into p. If no bytes
+// are available yet it returns a channel that is closed once more arrive.
+func (b *backlog) readFrom(offset int64, p []byte) (int, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset < b.offset-b.histlen || offset > b.offset {
+		return 0, nil, errOffsetTooOld
+	}
+	if offset == b.offset {
+		b.waiting = true
+		return 0, b.changed, nil
+	}
+
+	size := int64(len(b.buf))
+	avail := min(b.offset-offset, int64(len(p)))
+	i := offset % size
+	n := copy(p[:avail], b.buf[i:])
+	if int64(n) < avail {
+		n += copy(p[n:avail], b.buf)
+	}
+	return n, nil, nil
+}
@@ -0,0 +1,73 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBacklog(t *testing.T) {
+	t.Run("reads what was written", func(t *testing.T) {
+		b := newBacklog(16)
+		b.write([]byte("hello"))
+		b.write([]byte("world"))
+
+		first, next := b.bounds()
+		assert.Equal(t, int64(0), first)
+		assert.Equal(t, int64(10), next)
+
+		p := make([]byte, 16)
+		n, _, err := b.readFrom(3, p)
+		require.NoError(t, err)
+		assert.Equal(t, "loworld", string(p[:n]))
+	})
+
+	t.Run("wraps around", func(t *testing.T) {
+		b := newBacklog(8)
+		b.write([]byte("abcdef"))
+		b.write([]byte("ghijkl"))
+
+		first, next := b.bounds()
+		assert.Equal(t, int64(4), first)
+		assert.Equal(t, int64(12), next)
+
+		p := make([]byte, 16)
+		n, _, err := b.readFrom(4, p)
+		require.NoError(t, err)
+		assert.Equal(t, "efghijkl", string(p[:n]))
+
+		_, _, err = b.readFrom(3, p)
+		assert.ErrorIs(t, err, errOffsetTooOld)
+	})
+
+	t.Run("waits for new data", func(t *testing.T) {
+		b := newBacklog(8)
+		b.write([]byte("ab"))
+
+		p := make([]byte, 8)
+		n, wait, err := b.readFrom(2, p)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		b.write([]byte("c"))
+		select {
+		case <-wait:
+		default:
+			t.Fatal("reader was not woken up")
+		}
+		n, _, err = b.readFrom(2, p)
+		require.NoError(t, err)
+		assert.Equal(t, "c", string(p[:n]))
+	})
+
+	t.Run("reset restarts the stream", func(t *testing.T) {
+		b := newBacklog(8)
+		b.write([]byte("abc"))
+		b.reset(100)
+
+		first, next := b.bounds()
+		assert.Equal(t, int64(100), first)
+		assert.Equal(t, int64(100), next)
+	})
+}
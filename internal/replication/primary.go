@@ -0,0 +1,235 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// replica is a connected replica as seen from its primary.
+type replica struct {
+	conn net.Conn
+	ip   string
+	port int
+
+	online    atomic.Bool
+	ackOffset atomic.Int64
+	lastAck   atomic.Int64 // unix nanoseconds
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *replica) state() string {
+	if r.online.Load() {
+		return "online"
+	}
+	return "wait_bgsave"
+}
+
+// lag returns the number of seconds since the replica last acknowledged.
+func (r *replica) lag() int {
+	return int(time.Since(time.Unix(0, r.lastAck.Load())).Seconds())
+}
+
+func (r *replica) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.conn.Close()
+	})
+}
+
+// disconnectReplicas drops every replica. Callers must hold mu.
+func (m *Manager) disconnectReplicas() {
+	for r := range m.replicas {
+		r.close()
+		delete(m.replicas, r)
+	}
+}
+
+// ServeReplica takes over conn after a replica sent PSYNC (or the legacy
+// SYNC, with no arguments) and streams the replication stream to it until
+// the link breaks. dec must be the decoder the command was read from, since
+// it may hold buffered acknowledgements. listeningPort is the port the
+// replica announced with REPLCONF.
+func (m *Manager) ServeReplica(conn net.Conn, dec *resp.Decoder, listeningPort int, args []resp.Value) {
+	defer conn.Close()
+	if m.replica.Load() && !m.linkUp() {
+		conn.Write([]byte("-NOMASTERLINK Can't SYNC while not connected with my master\r\n"))
+		return
+	}
+
+	r := &replica{conn: conn, port: listeningPort, done: make(chan struct{})}
+	r.ip, _ = splitHostPort(conn.RemoteAddr().String())
+	r.lastAck.Store(time.Now().UnixNano())
+
+	m.feed()
+	offset, ok := m.tryPartialResync(args)
+	if ok {
+		if _, err := fmt.Fprintf(conn, "+CONTINUE %s\r\n", m.currentReplID()); err != nil {
+			return
+		}
+	} else {
+		var err error
+		if offset, err = m.fullResync(conn); err != nil {
+			log.Printf("replication: full resync with %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	r.online.Store(true)
+	r.ackOffset.Store(offset)
+
+	m.mu.Lock()
+	m.replicas[r] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.replicas, r)
+		m.mu.Unlock()
+		r.close()
+	}()
+
+	go m.readAcks(r, dec)
+	if err := m.stream(r, offset); err != nil {
+		log.Printf("replication: lost replica %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (m *Manager) currentReplID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.replID
+}
+
+// tryPartialResync returns the offset to continue streaming from if the
+// replica's history is still covered by the backlog.
+func (m *Manager) tryPartialResync(args []resp.Value) (int64, bool) {
+	if len(args) != 2 {
+		return 0, false
+	}
+	id := string(args[0].Bytes)
+	offset, err := strconv.ParseInt(string(args[1].Bytes), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id != m.replID && (id != m.replID2 || offset > m.secondOffset) {
+		return 0, false
+	}
+	first, next := m.backlog.bounds()
+	if offset < first || offset > next {
+		return 0, false
+	}
+	return offset, true
+}
+
+// fullResync sends the replica a snapshot of the keyspace and returns the
+// stream offset the snapshot corresponds to.
+func (m *Manager) fullResync(conn net.Conn) (int64, error) {
+	var snap *storage.Snapshot
+	var id string
+	var offset int64
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.exe.Barrier(func() {
+		snap = m.storage.Snapshot()
+		id = m.currentReplID()
+		_, offset = m.backlog.bounds()
+	})
+
+	if _, err := fmt.Fprintf(conn, "+FULLRESYNC %s %d\r\n", id, offset); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	if err := rdb.Write(&buf, snap); err != nil {
+		return 0, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	// The payload is not followed by CRLF, unlike a regular bulk string.
+	if _, err := fmt.Fprintf(conn, "$%d\r\n", buf.Len()); err != nil {
+		return 0, err
+	}
+	if _, err := buf.WriteTo(conn); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// stream copies the backlog to the replica from offset onwards. A replica
+// that falls so far behind that its offset leaves the backlog is dropped,
+// and will come back with a full resync.
+func (m *Manager) stream(r *replica, offset int64) error {
+	buf := make([]byte, 16*1024)
+	for {
+		n, wait, err := m.backlog.readFrom(offset, buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			select {
+			case <-wait:
+				continue
+			case <-r.done:
+				return nil
+			}
+		}
+		r.conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := r.conn.Write(buf[:n]); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+}
+
+// readAcks records the offsets the replica acknowledges with REPLCONF ACK.
+func (m *Manager) readAcks(r *replica, dec *resp.Decoder) {
+	defer r.close()
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(timeout))
+		v, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		if len(v.Array) == 3 &&
+			strings.EqualFold(string(v.Array[0].Bytes), "replconf") &&
+			strings.EqualFold(string(v.Array[1].Bytes), "ack") {
+			if n, err := strconv.ParseInt(string(v.Array[2].Bytes), 10, 64); err == nil {
+				r.ackOffset.Store(n)
+				r.lastAck.Store(time.Now().UnixNano())
+			}
+		}
+	}
+}
+
+// pingReplicas periodically writes a PING into the stream while replicas
+// are attached, so they can detect a dead link.
+func (m *Manager) pingReplicas() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	ping := encode([]resp.Value{bulk("PING")})
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			attached := len(m.replicas) > 0
+			m.mu.Unlock()
+			if attached && !m.replica.Load() {
+				m.backlog.write(ping)
+			}
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// retryInterval is how long a replica waits before reconnecting to its
+// primary after the link broke.
+const retryInterval = time.Second
+
+// upstream is the link from a replica to its primary.
+type upstream struct {
+	addr string
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	up        bool
+	syncing   bool
+	synced    bool // a full resync has completed at least once
+	lastIO    time.Time
+	downSince time.Time
+}
+
+type linkState struct {
+	up, syncing       bool
+	lastIO, downSince time.Time
+}
+
+func newUpstream(addr string) *upstream {
+	return &upstream{addr: addr, done: make(chan struct{}), downSince: time.Now()}
+}
+
+func (u *upstream) stop() {
+	u.once.Do(func() { close(u.done) })
+}
+
+func (u *upstream) status() linkState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return linkState{up: u.up, syncing: u.syncing, lastIO: u.lastIO, downSince: u.downSince}
+}
+
+func (u *upstream) update(fn func(u *upstream)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fn(u)
+}
+
+// linkUp reports whether the server is a replica with a working link.
+func (m *Manager) linkUp() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.upstream != nil && m.upstream.status().up
+}
+
+// follow keeps the link to the primary alive until u is stopped.
+func (m *Manager) follow(u *upstream) {
+	for {
+		err := m.sync(u)
+		u.update(func(u *upstream) {
+			if u.up {
+				u.downSince = time.Now()
+			}
+			u.up, u.syncing = false, false
+		})
+
+		select {
+		case <-u.done:
+			return
+		default:
+		}
+		log.Printf("replication: link with primary %s lost: %v", u.addr, err)
+
+		select {
+		case <-u.done:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// sync connects to the primary, resynchronises and applies the replication
+// stream until the link breaks.
+func (m *Manager) sync(u *upstream) error {
+	conn, err := net.DialTimeout("tcp", u.addr, timeout)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-u.done:
+		case <-stop:
+		}
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	dec := resp.NewDecoder(br)
+	enc := resp.NewEncoder(conn)
+	request := func(args ...string) (resp.Value, error) {
+		vals := make([]resp.Value, len(args))
+		for i, a := range args {
+			vals[i] = bulk(a)
+		}
+		if err := enc.Encode(resp.Value{Type: resp.TypeArray, Array: vals}); err != nil {
+			return resp.Value{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		v, err := dec.Decode()
+		if err == nil && v.Type == resp.TypeError {
+			err = fmt.Errorf("primary replied %q to %s", v.Bytes, strings.Join(args, " "))
+		}
+		return v, err
+	}
+
+	if _, err := request("PING"); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "listening-port", strconv.FormatInt(m.port.Load(), 10)); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	id, offset := "?", int64(-1)
+	u.mu.Lock()
+	synced := u.synced
+	u.mu.Unlock()
+	if synced {
+		id = m.currentReplID()
+		_, offset = m.backlog.bounds()
+	}
+	reply, err := request("PSYNC", id, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(reply.Bytes))
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC reply %q", reply.Bytes)
+		}
+		u.update(func(u *upstream) { u.syncing = true })
+		if err := m.loadSnapshot(conn, br, fields[1], offset); err != nil {
+			return err
+		}
+		u.update(func(u *upstream) { u.synced = true })
+		log.Printf("replication: full resync with primary %s at offset %d", u.addr, offset)
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		if len(fields) == 2 {
+			m.mu.Lock()
+			if fields[1] != m.replID {
+				m.replID2, m.secondOffset = m.replID, offset
+				m.replID = fields[1]
+			}
+			m.mu.Unlock()
+		}
+		log.Printf("replication: partial resync with primary %s from offset %d", u.addr, offset)
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %q", reply.Bytes)
+	}
+
+	now := time.Now()
+	u.update(func(u *upstream) {
+		u.up, u.syncing, u.lastIO = true, false, now
+	})
+	return m.apply(u, conn, dec, enc)
+}
+
+// loadSnapshot reads the snapshot that follows a FULLRESYNC reply and
+// replaces the keyspace with it.
+func (m *Manager) loadSnapshot(conn net.Conn, br *bufio.Reader, id string, offset int64) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "$") || !strings.HasSuffix(line, "\r\n") {
+		return fmt.Errorf("bad snapshot header %q", line)
+	}
+	n, err := strconv.ParseInt(line[1:len(line)-2], 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad snapshot header %q", line)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return err
+	}
+
+	m.exe.Barrier(func() {
+		if err = m.storage.FlushAll(); err != nil {
+			return
+		}
+		_, err = rdb.Read(bytes.NewReader(payload), m.storage)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.replID = id
+	m.replID2, m.secondOffset = strings.Repeat("0", 40), -1
+	m.mu.Unlock()
+	m.backlog.reset(offset)
+
+	if m.opts.OnFullSync != nil {
+		m.opts.OnFullSync()
+	}
+	return nil
+}
+
+// apply executes the replication stream. Every command is also appended to
+// this server's own backlog verbatim, so its offset tracks the primary's and
+// its own replicas can be fed from it.
+func (m *Manager) apply(u *upstream, conn net.Conn, dec *resp.Decoder, enc *resp.Encoder) error {
+	var encMu sync.Mutex
+	ack := func() error {
+		_, offset := m.backlog.bounds()
+		encMu.Lock()
+		defer encMu.Unlock()
+		return enc.Encode(resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			bulk("REPLCONF"), bulk("ACK"), bulk(strconv.FormatInt(offset, 10)),
+		}})
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if ack() != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// Transactions are buffered from MULTI to EXEC and applied as a whole,
+	// and only then enter the backlog.
+	var tx []resp.Value
+	inTx := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		cmd, err := dec.Decode()
+		if err != nil {
+			return err
+		}
+		if cmd.Type != resp.TypeArray || len(cmd.Array) == 0 {
+			return errors.New("malformed command in replication stream")
+		}
+		u.update(func(u *upstream) { u.lastIO = time.Now() })
+
+		name := strings.ToLower(string(cmd.Array[0].Bytes))
+		getAck := name == "replconf" && len(cmd.Array) >= 2 &&
+			strings.EqualFold(string(cmd.Array[1].Bytes), "getack")
+		switch {
+		case name == "multi" && !inTx:
+			inTx = true
+			tx = append(tx[:0], cmd)
+			continue
+		case inTx && name != "exec":
+			tx = append(tx, cmd)
+			continue
+		}
+
+		// Applying commands and advancing the offset happen together, so a
+		// snapshot taken for one of our own replicas matches its offset.
+		m.applyMu.Lock()
+		switch {
+		case inTx:
+			outs, err := m.exe.ExecuteAll(tx[1:])
+			logFailures("transaction", outs, err)
+			for _, c := range append(tx, cmd) {
+				m.backlog.write(encode(c.Array))
+			}
+			tx, inTx = tx[:0], false
+		default:
+			if !getAck {
+				out, err := m.exe.Execute(cmd)
+				logFailures(string(cmd.Array[0].Bytes), []resp.Value{out}, err)
+			}
+			m.backlog.write(encode(cmd.Array))
+		}
+		m.applyMu.Unlock()
+
+		if getAck {
+			if err := ack(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func logFailures(what string, outs []resp.Value, err error) {
+	if err != nil {
+		log.Printf("replication: applying %s: %v", what, err)
+		return
+	}
+	for _, out := range outs {
+		if out.Type == resp.TypeError {
+			log.Printf("replication: applying %s: %s", what, out.Bytes)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package replication implements primary/replica replication. A primary
+// streams every write command to its replicas over the RESP transport and
+// keeps a backlog of the stream, so a replica that briefly loses its link
+// resumes with a partial resync instead of a full copy of the keyspace.
+package replication
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+const (
+	DefaultBacklogSize = 1 << 20
+
+	// pingPeriod is how often a primary pings its replicas, so that they can
+	// tell an idle link from a dead one.
+	pingPeriod = 10 * time.Second
+	// timeout is how long either side of a link waits for data before
+	// considering the link dead.
+	timeout = 60 * time.Second
+)
+
+type Options struct {
+	// BacklogSize is the size in bytes of the replication backlog. Zero
+	// means DefaultBacklogSize.
+	BacklogSize int
+	// ReplicaWritable lets clients write to the server while it replicates
+	// from a primary. Such writes are not propagated.
+	ReplicaWritable bool
+	// OnFullSync, if set, is called after the keyspace has been replaced by
+	// a full resynchronisation from the primary.
+	OnFullSync func()
+}
+
+// Manager tracks the replication role of a server. As a primary it feeds its
+// replicas from the backlog; as a replica it maintains the link to its primary.
+type Manager struct {
+	storage storage.Storage
+	exe     *executor.Executor
+	opts    Options
+	backlog *backlog
+
+	// replica is set while the server replicates from a primary. Propagated
+	// commands are ignored then, because the backlog is fed with the
+	// primary's stream instead.
+	replica atomic.Bool
+	port    atomic.Int64
+	// writable lets clients write while the server replicates. It starts
+	// out as opts.ReplicaWritable.
+	writable atomic.Bool
+	// applyMu is held by a replica while it applies a command from its
+	// primary and by full resyncs of its own replicas.
+	applyMu sync.Mutex
+
+	mu sync.Mutex
+	// replID identifies the current replication history. replID2 is the
+	// history this server followed before being promoted, valid up to
+	// secondOffset, so replicas of the old primary can still resync
+	// partially.
+	replID       string
+	replID2      string
+	secondOffset int64
+	replicas     map[*replica]struct{}
+	upstream     *upstream
+
+	// feedOnce registers the manager as a propagator of exe when the first
+	// replica attaches.
+	feedOnce sync.Once
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns the replication manager of a server running commands with
+// exe. It only registers itself as a propagator of exe once a replica
+// attaches, so that until then writes are neither serialised nor encoded
+// for a stream nobody reads.
+func New(s storage.Storage, exe *executor.Executor, opts Options) *Manager {
+	if opts.BacklogSize <= 0 {
+		opts.BacklogSize = DefaultBacklogSize
+	}
+	m := &Manager{
+		storage:      s,
+		exe:          exe,
+		opts:         opts,
+		backlog:      newBacklog(opts.BacklogSize),
+		replID:       newReplID(),
+		replID2:      strings.Repeat("0", 40),
+		secondOffset: -1,
+		replicas:     make(map[*replica]struct{}),
+		done:         make(chan struct{}),
+	}
+	m.writable.Store(opts.ReplicaWritable)
+	go m.pingReplicas()
+	return m
+}
+
+func newReplID() string {
+	var b [20]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// SetListeningPort records the port announced to a primary, so that it can
+// list this server among its replicas.
+func (m *Manager) SetListeningPort(port int) {
+	m.port.Store(int64(port))
+}
+
+// Close disconnects from the primary and from every replica.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstream != nil {
+		m.upstream.stop()
+		m.upstream = nil
+	}
+	m.disconnectReplicas()
+	return nil
+}
+
+// feed starts feeding the backlog with the writes made from now on, if it
+// was not already.
+func (m *Manager) feed() {
+	m.feedOnce.Do(func() {
+		m.exe.Barrier(func() { m.exe.AddPropagator(m) })
+	})
+}
+
+// Propagate appends a write command to the replication stream.
+func (m *Manager) Propagate(args []resp.Value) {
+	if m.replica.Load() {
+		return
+	}
+	m.backlog.write(encode(args))
+}
+
+// IsReplica reports whether the server replicates from a primary.
+func (m *Manager) IsReplica() bool {
+	return m.replica.Load()
+}
+
+// RejectsWrites reports whether client writes must be refused because the
+// server is a read-only replica.
+func (m *Manager) RejectsWrites() bool {
+	return m.replica.Load() && !m.writable.Load()
+}
+
+// SetReplicaWritable sets whether clients may write to the server while it
+// replicates from a primary.
+func (m *Manager) SetReplicaWritable(writable bool) {
+	m.writable.Store(writable)
+}
+
+// ReplicaOf makes the server replicate from the primary at addr. An empty
+// addr promotes the server to a primary. It reports false if the server
+// already replicates from addr.
+func (m *Manager) ReplicaOf(addr string) bool {
+	if addr != "" {
+		// The backlog fed from the primary goes on with our own writes
+		// if we are promoted.
+		m.feed()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if addr == "" {
+		if m.upstream == nil {
+			return true
+		}
+		m.upstream.stop()
+		m.upstream = nil
+		// Keep the old history around so replicas that followed the same
+		// primary can continue from us with a partial resync.
+		m.replID2 = m.replID
+		_, m.secondOffset = m.backlog.bounds()
+		m.replID = newReplID()
+		m.replica.Store(false)
+		return true
+	}
+
+	if m.upstream != nil {
+		if m.upstream.addr == addr {
+			return false
+		}
+		m.upstream.stop()
+	}
+	// Our own replicas must resync against the new history.
+	m.disconnectReplicas()
+	m.replica.Store(true)
+	m.upstream = newUpstream(addr)
+	go m.follow(m.upstream)
+	return true
+}
+
+// Info returns the replication section of the INFO reply.
+func (m *Manager) Info() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	first, next := m.backlog.bounds()
+	if u := m.upstream; u != nil {
+		host, port := splitHostPort(u.addr)
+		st := u.status()
+		fmt.Fprintf(&b, "role:slave\r\n")
+		fmt.Fprintf(&b, "master_host:%s\r\n", host)
+		fmt.Fprintf(&b, "master_port:%s\r\n", port)
+		fmt.Fprintf(&b, "master_link_status:%s\r\n", linkStatus(st.up))
+		lastIO := -1
+		if !st.lastIO.IsZero() {
+			lastIO = int(time.Since(st.lastIO).Seconds())
+		}
+		fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\r\n", lastIO)
+		fmt.Fprintf(&b, "master_sync_in_progress:%d\r\n", boolInt(st.syncing))
+		fmt.Fprintf(&b, "slave_read_repl_offset:%d\r\n", next)
+		fmt.Fprintf(&b, "slave_repl_offset:%d\r\n", next)
+		if !st.up {
+			down := 0
+			if !st.downSince.IsZero() {
+				down = int(time.Since(st.downSince).Seconds())
+			}
+			fmt.Fprintf(&b, "master_link_down_since_seconds:%d\r\n", down)
+		}
+		fmt.Fprintf(&b, "slave_read_only:%d\r\n", boolInt(!m.writable.Load()))
+	} else {
+		fmt.Fprintf(&b, "role:master\r\n")
+	}
+
+	fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(m.replicas))
+	i := 0
+	for r := range m.replicas {
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, r.ip, r.port, r.state(), r.ackOffset.Load(), r.lag())
+		i++
+	}
+	fmt.Fprintf(&b, "master_replid:%s\r\n", m.replID)
+	fmt.Fprintf(&b, "master_replid2:%s\r\n", m.replID2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\r\n", next)
+	fmt.Fprintf(&b, "second_repl_offset:%d\r\n", m.secondOffset)
+	fmt.Fprintf(&b, "repl_backlog_active:%d\r\n", boolInt(m.backlog.active()))
+	fmt.Fprintf(&b, "repl_backlog_size:%d\r\n", m.opts.BacklogSize)
+	fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\r\n", first)
+	fmt.Fprintf(&b, "repl_backlog_histlen:%d\r\n", next-first)
+	return b.String()
+}
+
+// Role returns the reply to ROLE.
+func (m *Manager) Role() resp.Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, next := m.backlog.bounds()
+	if u := m.upstream; u != nil {
+		host, port := splitHostPort(u.addr)
+		p, _ := strconv.Atoi(port)
+		st := u.status()
+		state := "connect"
+		switch {
+		case st.up:
+			state = "connected"
+		case st.syncing:
+			state = "sync"
+		}
+		return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			bulk("slave"), bulk(host), integer(int64(p)), bulk(state), integer(next),
+		}}
+	}
+
+	replicas := make([]resp.Value, 0, len(m.replicas))
+	for r := range m.replicas {
+		replicas = append(replicas, resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			bulk(r.ip), bulk(strconv.Itoa(r.port)), bulk(strconv.FormatInt(r.ackOffset.Load(), 10)),
+		}})
+	}
+	return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+		bulk("master"), integer(next), {Type: resp.TypeArray, Array: replicas},
+	}}
+}
+
+func linkStatus(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func splitHostPort(addr string) (string, string) {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return addr, ""
+	}
+	return addr[:i], addr[i+1:]
+}
+
+// encode renders a command as it appears on the replication stream.
+func encode(args []resp.Value) []byte {
+	var buf bytes.Buffer
+	resp.NewEncoder(&buf).Encode(resp.Value{Type: resp.TypeArray, Array: args})
+	return buf.Bytes()
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
+
+func integer(n int64) resp.Value {
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(n, 10))}
+}
@@ -0,0 +1,98 @@
+package replication
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func command(args ...string) []resp.Value {
+	vals := make([]resp.Value, len(args))
+	for i, a := range args {
+		vals[i] = bulk(a)
+	}
+	return vals
+}
+
+func newManager(t *testing.T) (*Manager, *executor.Executor) {
+	t.Helper()
+	st := storage.NewInMemoryStorage()
+	exe := executor.NewExecutor(st)
+	m := New(st, exe, Options{BacklogSize: 1024})
+	t.Cleanup(func() { m.Close() })
+	return m, exe
+}
+
+// psync connects a fake replica to m with the given PSYNC arguments and
+// returns its end of the link along with the first reply line.
+func psync(t *testing.T, m *Manager, args ...string) (*bufio.Reader, string) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go m.ServeReplica(server, resp.NewDecoder(server), 0, command(args...))
+
+	br := bufio.NewReader(client)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	return br, strings.TrimSuffix(line, "\r\n")
+}
+
+func TestPartialResync(t *testing.T) {
+	m, exe := newManager(t)
+	_, err := exe.Execute(resp.Value{Type: resp.TypeArray, Array: command("SET", "unfed", "1")})
+	require.NoError(t, err)
+	assert.False(t, m.backlog.active(), "writes are not fed to the backlog until a replica attaches")
+
+	_, line := psync(t, m, "?", "-1")
+	require.Equal(t, fmt.Sprintf("+FULLRESYNC %s 0", m.currentReplID()), line)
+	_, err = exe.Execute(resp.Value{Type: resp.TypeArray, Array: command("SET", "a", "1")})
+	require.NoError(t, err)
+	_, offset := m.backlog.bounds()
+	_, err = exe.Execute(resp.Value{Type: resp.TypeArray, Array: command("SET", "b", "2")})
+	require.NoError(t, err)
+
+	br, line := psync(t, m, m.currentReplID(), fmt.Sprint(offset))
+	assert.Equal(t, "+CONTINUE "+m.currentReplID(), line)
+
+	// The stream resumes with the write the replica missed.
+	got, err := resp.NewDecoder(br).Decode()
+	require.NoError(t, err)
+	assert.Equal(t, command("SET", "b", "2"), got.Array)
+}
+
+func TestFullResync(t *testing.T) {
+	m, _ := newManager(t)
+
+	for name, args := range map[string][]string{
+		"first sync":      {"?", "-1"},
+		"unknown history": {strings.Repeat("a", 40), "0"},
+		"offset too new":  {m.currentReplID(), "1000"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, line := psync(t, m, args...)
+			assert.Equal(t, fmt.Sprintf("+FULLRESYNC %s 0", m.currentReplID()), line)
+		})
+	}
+}
+
+func TestPromotionKeepsHistory(t *testing.T) {
+	m, _ := newManager(t)
+	m.ReplicaOf("127.0.0.1:1")
+	old := m.currentReplID()
+	m.ReplicaOf("")
+
+	assert.False(t, m.IsReplica())
+	assert.NotEqual(t, old, m.currentReplID())
+
+	// A sibling replica of the old primary continues from us.
+	_, line := psync(t, m, old, "0")
+	assert.Equal(t, "+CONTINUE "+m.currentReplID(), line)
+}
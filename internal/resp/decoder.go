@@ -3,7 +3,9 @@ package resp
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -14,19 +16,86 @@ const (
 	TypeInteger      byte = ':'
 	TypeError        byte = '-'
 
-	maxBulkStringLen = 512 * 1024 * 1024 // 512 MB
-	maxArrayLen      = 100_000           // 1M elements
-	maxLineLen       = 64 * 1024         // 64 KB
+	// RESP3 types. Maps and attributes hold their keys and values
+	// alternately in Array, and verbatim strings hold their three letter
+	// format, a colon and the text in Bytes.
+	TypeNull           byte = '_'
+	TypeBoolean        byte = '#'
+	TypeDouble         byte = ','
+	TypeBigNumber      byte = '('
+	TypeBlobError      byte = '!'
+	TypeVerbatimString byte = '='
+	TypeMap            byte = '%'
+	TypeSet            byte = '~'
+	TypePush           byte = '>'
+	TypeAttribute      byte = '|'
 )
 
+// Limits bound the sizes a Decoder accepts, so that a peer cannot make it
+// allocate arbitrary amounts of memory by announcing huge values.
+type Limits struct {
+	// MaxBulkLen is the longest bulk string, in bytes.
+	MaxBulkLen int
+	// MaxArrayLen is the most elements of an aggregate, counting both keys
+	// and values of maps.
+	MaxArrayLen int
+	// MaxLineLen is the longest line, in bytes, of simple values and
+	// inline commands.
+	MaxLineLen int
+}
+
+// DefaultLimits are the limits of new decoders.
+var DefaultLimits = Limits{
+	MaxBulkLen:  512 * 1024 * 1024, // 512 MB
+	MaxArrayLen: 100_000,
+	MaxLineLen:  64 * 1024, // 64 KB
+}
+
+// NoLimits lets a Decoder accept values of any size, for input that is
+// trusted, such as a file written by the server itself.
+var NoLimits = Limits{
+	MaxBulkLen:  math.MaxInt,
+	MaxArrayLen: math.MaxInt,
+	MaxLineLen:  math.MaxInt,
+}
+
+// ErrProtocol is wrapped by the errors returned for malformed input, as
+// opposed to I/O errors.
+var ErrProtocol = errors.New("Protocol error")
+
+func protocolError(msg string) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, msg)
+}
+
 type Value struct {
 	Type  byte
 	Bytes []byte
 	Array []Value
+	// Attrs holds the keys and values, alternately, of the RESP3 attribute
+	// sent ahead of the value, if any.
+	Attrs []Value
+	// RESP2 is the form of an aggregate in RESP2, if not the usual one of
+	// its type. It is never set by a Decoder.
+	RESP2 Shape
 }
 
+// Shape is a form an aggregate takes in RESP2 rather than the usual one of
+// its type, as some Redis replies do.
+type Shape byte
+
+const (
+	// FlatPairs writes an array of pairs as one array of their elements,
+	// as ZRANGE WITHSCORES replies are in RESP2.
+	FlatPairs Shape = iota + 1
+	// MapPairs writes a map as an array of key and value pairs rather than
+	// of keys and values alternately, as XREAD replies are in RESP2.
+	MapPairs
+)
+
 type Decoder struct {
 	reader *bufio.Reader
+	inline bool
+	limits Limits
 }
 
 func NewDecoder(r io.Reader) *Decoder {
@@ -36,10 +105,45 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 	return &Decoder{
 		reader: br,
+		limits: DefaultLimits,
 	}
 }
 
+// SetLimits changes the limits values decoded from now on must respect.
+func (p *Decoder) SetLimits(l Limits) {
+	p.limits = l
+}
+
+// AcceptInline makes the decoder accept commands in the inline format as
+// well, as servers do for clients such as telnet: words separated by spaces
+// on a line ending in LF or CRLF.
+func (p *Decoder) AcceptInline() {
+	p.inline = true
+}
+
+// Decode reads the next value. Inline commands, if accepted, are returned as
+// arrays of bulk strings, and blank lines between commands are skipped.
 func (p *Decoder) Decode() (Value, error) {
+	for p.inline {
+		b, err := p.reader.Peek(1)
+		if err != nil {
+			return Value{}, err
+		}
+		if isTypePrefix(b[0]) {
+			break
+		}
+		args, err := p.parseInline()
+		if err != nil {
+			return Value{}, err
+		}
+		if len(args) > 0 {
+			return Value{Type: TypeArray, Array: args}, nil
+		}
+	}
+	return p.decode()
+}
+
+func (p *Decoder) decode() (Value, error) {
 	bytecode, err := p.reader.ReadByte()
 	if err != nil {
 		return Value{}, err
@@ -49,14 +153,22 @@ func (p *Decoder) Decode() (Value, error) {
 	val.Type = bytecode
 
 	switch bytecode {
-	case TypeBulkString:
+	case TypeBulkString, TypeBlobError, TypeVerbatimString:
 		val.Bytes, err = p.parseBulkString()
-	case TypeSimpleString, TypeInteger, TypeError:
+	case TypeSimpleString, TypeInteger, TypeError, TypeDouble, TypeBigNumber:
 		val.Bytes, err = p.readLine()
-	case TypeArray:
-		val.Array, err = p.parseArray()
+	case TypeArray, TypeSet, TypePush:
+		val.Array, err = p.parseArray(1)
+	case TypeMap:
+		val.Array, err = p.parseArray(2)
+	case TypeNull:
+		err = p.parseNull()
+	case TypeBoolean:
+		val.Bytes, err = p.parseBoolean()
+	case TypeAttribute:
+		return p.parseAttribute()
 	default:
-		return Value{}, errors.New("unknown type prefix: " + string(bytecode))
+		return Value{}, protocolError("unknown type prefix: " + string(bytecode))
 	}
 
 	return val, err
@@ -70,7 +182,7 @@ func (p *Decoder) parseBulkString() ([]byte, error) {
 
 	nWant, err := strconv.Atoi(string(b))
 	if err != nil {
-		return nil, err
+		return nil, protocolError("invalid bulk length")
 	}
 
 	if nWant == -1 {
@@ -78,11 +190,11 @@ func (p *Decoder) parseBulkString() ([]byte, error) {
 	}
 
 	if nWant < -1 {
-		return nil, errors.New("invalid length for bulk string")
+		return nil, protocolError("invalid length for bulk string")
 	}
 
-	if nWant > maxBulkStringLen {
-		return nil, errors.New("bulk string length exceeds maximum")
+	if nWant > p.limits.MaxBulkLen {
+		return nil, protocolError("bulk string length exceeds maximum")
 	}
 
 	buf := make([]byte, nWant)
@@ -95,14 +207,14 @@ func (p *Decoder) parseBulkString() ([]byte, error) {
 		return nil, err
 	}
 	if code != '\r' {
-		return nil, errors.New("expected CRLF after bulk string data")
+		return nil, protocolError("expected CRLF after bulk string data")
 	}
 	code, err = p.reader.ReadByte()
 	if err != nil {
 		return nil, err
 	}
 	if code != '\n' {
-		return nil, errors.New("expected CRLF after bulk string data")
+		return nil, protocolError("expected CRLF after bulk string data")
 	}
 
 	return buf, nil
@@ -117,42 +229,80 @@ func (p *Decoder) readLine() ([]byte, error) {
 		}
 		if b == '\n' {
 			if len(buf) == 0 || buf[len(buf)-1] != '\r' {
-				return nil, errors.New("expected CRLF line terminator")
+				return nil, protocolError("expected CRLF line terminator")
 			}
 			return buf[:len(buf)-1], nil
 		}
 		buf = append(buf, b)
-		if len(buf) > maxLineLen {
-			return nil, errors.New("line length exceeds maximum")
+		if len(buf) > p.limits.MaxLineLen {
+			return nil, protocolError("line length exceeds maximum")
 		}
 	}
 }
 
-func (p *Decoder) parseArray() ([]Value, error) {
+func (p *Decoder) parseNull() error {
+	l, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if len(l) != 0 {
+		return protocolError("invalid null")
+	}
+	return nil
+}
+
+func (p *Decoder) parseBoolean() ([]byte, error) {
 	l, err := p.readLine()
 	if err != nil {
 		return nil, err
 	}
-	n, err := strconv.Atoi(string(l))
+	if string(l) != "t" && string(l) != "f" {
+		return nil, protocolError("invalid boolean")
+	}
+	return l, nil
+}
+
+// parseAttribute reads an attribute and the value it applies to.
+func (p *Decoder) parseAttribute() (Value, error) {
+	attrs, err := p.parseArray(2)
+	if err != nil {
+		return Value{}, err
+	}
+	val, err := p.decode()
+	if err != nil {
+		return Value{}, err
+	}
+	val.Attrs = attrs
+	return val, nil
+}
+
+// parseArray reads an aggregate of n elements of the given width each: 1 for
+// arrays, sets and pushes, and 2 for maps and attributes.
+func (p *Decoder) parseArray(width int) ([]Value, error) {
+	l, err := p.readLine()
 	if err != nil {
 		return nil, err
 	}
+	n, err := strconv.Atoi(string(l))
+	if err != nil {
+		return nil, protocolError("invalid multibulk length")
+	}
 
 	if n == -1 {
 		return nil, nil
 	}
 
 	if n < -1 {
-		return nil, errors.New("invalid int for array size")
+		return nil, protocolError("invalid int for array size")
 	}
 
-	if n > maxArrayLen {
-		return nil, errors.New("array size exceeds maximum")
+	if n > p.limits.MaxArrayLen/width {
+		return nil, protocolError("array size exceeds maximum")
 	}
 
-	vals := make([]Value, n)
-	for i := range n {
-		vals[i], err = p.Decode()
+	vals := make([]Value, n*width)
+	for i := range vals {
+		vals[i], err = p.decode()
 		if err != nil {
 			return nil, err
 		}
@@ -117,6 +117,68 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestDecoderRESP3(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want resp.Value
+	}{
+		{"null", "_\r\n", resp.Value{Type: resp.TypeNull}},
+		{"boolean", "#t\r\n", resp.Value{Type: resp.TypeBoolean, Bytes: []byte("t")}},
+		{"double", ",-1.5e10\r\n", resp.Value{Type: resp.TypeDouble, Bytes: []byte("-1.5e10")}},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n", resp.Value{Type: resp.TypeBigNumber, Bytes: []byte("3492890328409238509324850943850943825024385")}},
+		{"blob error", "!21\r\nSYNTAX invalid syntax\r\n", resp.Value{Type: resp.TypeBlobError, Bytes: []byte("SYNTAX invalid syntax")}},
+		{"verbatim string", "=15\r\ntxt:Some string\r\n", resp.Value{Type: resp.TypeVerbatimString, Bytes: []byte("txt:Some string")}},
+		{
+			name: "map",
+			msg:  "%2\r\n+first\r\n:1\r\n+second\r\n_\r\n",
+			want: resp.Value{Type: resp.TypeMap, Array: []resp.Value{
+				{Type: resp.TypeSimpleString, Bytes: []byte("first")},
+				{Type: resp.TypeInteger, Bytes: []byte("1")},
+				{Type: resp.TypeSimpleString, Bytes: []byte("second")},
+				{Type: resp.TypeNull},
+			}},
+		},
+		{
+			name: "set",
+			msg:  "~2\r\n$1\r\na\r\n#f\r\n",
+			want: resp.Value{Type: resp.TypeSet, Array: []resp.Value{
+				{Type: resp.TypeBulkString, Bytes: []byte("a")},
+				{Type: resp.TypeBoolean, Bytes: []byte("f")},
+			}},
+		},
+		{
+			name: "push",
+			msg:  ">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n",
+			want: resp.Value{Type: resp.TypePush, Array: []resp.Value{
+				{Type: resp.TypeBulkString, Bytes: []byte("message")},
+				{Type: resp.TypeBulkString, Bytes: []byte("hi")},
+			}},
+		},
+		{
+			name: "attribute applies to the next value",
+			msg:  "|1\r\n+ttl\r\n:3600\r\n$3\r\nval\r\n",
+			want: resp.Value{
+				Type:  resp.TypeBulkString,
+				Bytes: []byte("val"),
+				Attrs: []resp.Value{
+					{Type: resp.TypeSimpleString, Bytes: []byte("ttl")},
+					{Type: resp.TypeInteger, Bytes: []byte("3600")},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resp.NewDecoder(strings.NewReader(tt.msg)).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestParserErrors(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -125,8 +187,8 @@ func TestParserErrors(t *testing.T) {
 	}{
 		{
 			name:    "unknown type prefix",
-			msg:     []byte("!invalid\r\n"),
-			wantErr: "unknown type prefix: !",
+			msg:     []byte("@invalid\r\n"),
+			wantErr: "unknown type prefix: @",
 		},
 		{
 			name:    "bulk string truncated data",
@@ -153,6 +215,21 @@ func TestParserErrors(t *testing.T) {
 			msg:     []byte("*-1\r\n"),
 			wantErr: "",
 		},
+		{
+			name:    "null with data",
+			msg:     []byte("_x\r\n"),
+			wantErr: "invalid null",
+		},
+		{
+			name:    "invalid boolean",
+			msg:     []byte("#x\r\n"),
+			wantErr: "invalid boolean",
+		},
+		{
+			name:    "map truncated",
+			msg:     []byte("%1\r\n+key\r\n"),
+			wantErr: "EOF",
+		},
 		{
 			name:    "line exceeds max length",
 			msg:     []byte("+" + strings.Repeat("a", 65*1024) + "\r\n"),
@@ -170,3 +247,93 @@ func TestParserErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoderLimits(t *testing.T) {
+	limits := resp.Limits{MaxBulkLen: 4, MaxArrayLen: 2, MaxLineLen: 4}
+	for msg, wantErr := range map[string]string{
+		"$5\r\nhello\r\n":                "bulk string length exceeds maximum",
+		"*3\r\n:1\r\n:2\r\n:3\r\n":       "array size exceeds maximum",
+		"%2\r\n+a\r\n+b\r\n+c\r\n+d\r\n": "array size exceeds maximum",
+		"+12345\r\n":                     "line length exceeds maximum",
+	} {
+		dec := resp.NewDecoder(strings.NewReader(msg))
+		dec.SetLimits(limits)
+		_, err := dec.Decode()
+		assert.ErrorContains(t, err, wantErr, msg)
+	}
+
+	dec := resp.NewDecoder(strings.NewReader("*2\r\n$4\r\nabcd\r\n+123\r\n"))
+	dec.SetLimits(limits)
+	_, err := dec.Decode()
+	assert.NoError(t, err, "values within the limits are accepted")
+}
+
+func TestDecoderInline(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want []string
+	}{
+		{"single word", "PING\r\n", []string{"PING"}},
+		{"LF only", "PING\n", []string{"PING"}},
+		{"extra whitespace", "  SET \t k   v \r\n", []string{"SET", "k", "v"}},
+		{"blank lines skipped", "\r\n   \n\nPING\r\n", []string{"PING"}},
+		{"double quotes", `SET k "hello world"` + "\r\n", []string{"SET", "k", "hello world"}},
+		{"escapes", `ECHO "a\tb\n\"c\" \x41\\"` + "\n", []string{"ECHO", "a\tb\n\"c\" A\\"}},
+		{"bad hex is literal", `ECHO "\xZZ"` + "\n", []string{"ECHO", "xZZ"}},
+		{"single quotes", `ECHO 'it\'s "raw" \n'` + "\n", []string{"ECHO", `it's "raw" \n`}},
+		{"empty quoted word", `SET k ""` + "\n", []string{"SET", "k", ""}},
+		{"quote inside a word", `ECHO a"b c"` + "\n", []string{"ECHO", "ab c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := resp.NewDecoder(strings.NewReader(tt.msg))
+			dec.AcceptInline()
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := resp.Value{Type: resp.TypeArray}
+			for _, w := range tt.want {
+				want.Array = append(want.Array, resp.Value{Type: resp.TypeBulkString, Bytes: []byte(w)})
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+
+	t.Run("mixed with RESP", func(t *testing.T) {
+		dec := resp.NewDecoder(strings.NewReader("PING\r\n*1\r\n$4\r\nPING\r\n\r\nECHO x\r\n"))
+		dec.AcceptInline()
+		for _, want := range [][]string{{"PING"}, {"PING"}, {"ECHO", "x"}} {
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			words := []string{}
+			for _, a := range got.Array {
+				words = append(words, string(a.Bytes))
+			}
+			assert.Equal(t, want, words)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for msg, wantErr := range map[string]string{
+			`ECHO "open` + "\n":          "unbalanced quotes",
+			`ECHO 'open` + "\n":          "unbalanced quotes",
+			`ECHO "a"b` + "\n":           "unbalanced quotes",
+			"ECHO x":                     "EOF",
+			strings.Repeat("a", 65*1024): "too big inline request",
+		} {
+			dec := resp.NewDecoder(strings.NewReader(msg))
+			dec.AcceptInline()
+			_, err := dec.Decode()
+			assert.ErrorContains(t, err, wantErr, msg)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		_, err := resp.NewDecoder(strings.NewReader("PING\r\n")).Decode()
+		assert.ErrorContains(t, err, "unknown type prefix: P")
+	})
+}
@@ -2,13 +2,21 @@ package resp
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"strconv"
 )
 
+// Encoder writes values in RESP2 unless switched to RESP3 with SetProtocol.
+// In RESP2, values of the RESP3 types are written as their closest RESP2
+// equivalent: maps are flattened into arrays, sets and pushes become arrays,
+// doubles, big numbers and verbatim strings become bulk strings, booleans
+// become integers and attributes are left out. Values with a RESP2 shape
+// are written in that shape instead.
 type Encoder struct {
 	writer *bufio.Writer
+	resp3  bool
 }
 
 func NewEncoder(w io.Writer) *Encoder {
@@ -21,6 +29,11 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// SetProtocol selects RESP2 or RESP3 for the values encoded from now on.
+func (e *Encoder) SetProtocol(version int) {
+	e.resp3 = version == 3
+}
+
 func (e *Encoder) Encode(v Value) error {
 	err := e.encode(v)
 	if err != nil {
@@ -30,22 +43,101 @@ func (e *Encoder) Encode(v Value) error {
 }
 
 func (e *Encoder) encode(v Value) error {
+	if !e.resp3 {
+		return e.encodeRESP2(v)
+	}
+	if v.Attrs != nil {
+		if err := e.encodeArray(Value{Type: TypeAttribute, Array: v.Attrs}); err != nil {
+			return err
+		}
+	}
+
 	bytecode := v.Type
 	var err error
 
 	switch bytecode {
-	case TypeSimpleString, TypeError, TypeInteger:
+	case TypeSimpleString, TypeError, TypeInteger, TypeBoolean, TypeDouble, TypeBigNumber:
 		err = e.encodeBytes(v)
-	case TypeBulkString:
+	case TypeBulkString, TypeBlobError, TypeVerbatimString:
+		if v.Bytes == nil {
+			return e.encodeNull()
+		}
 		err = e.encodeBytesWithCount(v)
-	case TypeArray:
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		if v.Array == nil && v.Type == TypeArray {
+			return e.encodeNull()
+		}
 		err = e.encodeArray(v)
+	case TypeNull:
+		err = e.encodeNull()
 	default:
 		return errors.New("Not implemented")
 	}
 
 	return err
+}
 
+// encodeRESP2 writes v with the RESP3 types translated to RESP2 ones.
+func (e *Encoder) encodeRESP2(v Value) error {
+	switch v.Type {
+	case TypeSimpleString, TypeError, TypeInteger:
+		return e.encodeBytes(v)
+	case TypeBulkString:
+		return e.encodeBytesWithCount(v)
+	case TypeArray:
+		if v.RESP2 == FlatPairs {
+			var flat []Value
+			for _, pair := range v.Array {
+				flat = append(flat, pair.Array...)
+			}
+			if flat == nil {
+				flat = []Value{}
+			}
+			return e.encodeArray(Value{Type: TypeArray, Array: flat})
+		}
+		return e.encodeArray(v)
+	case TypeNull:
+		return e.encodeBytesWithCount(Value{Type: TypeBulkString})
+	case TypeBoolean:
+		n := "0"
+		if string(v.Bytes) == "t" {
+			n = "1"
+		}
+		return e.encodeBytes(Value{Type: TypeInteger, Bytes: []byte(n)})
+	case TypeDouble, TypeBigNumber:
+		return e.encodeBytesWithCount(Value{Type: TypeBulkString, Bytes: v.Bytes})
+	case TypeVerbatimString:
+		text := v.Bytes
+		if len(text) >= 4 && text[3] == ':' {
+			text = text[4:]
+		}
+		return e.encodeBytesWithCount(Value{Type: TypeBulkString, Bytes: text})
+	case TypeBlobError:
+		// Simple errors cannot span lines.
+		msg := bytes.ReplaceAll(v.Bytes, []byte("\r\n"), []byte(" "))
+		return e.encodeBytes(Value{Type: TypeError, Bytes: msg})
+	case TypeMap, TypeSet, TypePush:
+		if v.Type == TypeMap && v.RESP2 == MapPairs {
+			pairs := make([]Value, 0, len(v.Array)/2)
+			for i := 0; i+1 < len(v.Array); i += 2 {
+				pairs = append(pairs, Value{Type: TypeArray, Array: v.Array[i : i+2]})
+			}
+			return e.encodeArray(Value{Type: TypeArray, Array: pairs})
+		}
+		arr := v.Array
+		if arr == nil {
+			// Unlike arrays these are never null.
+			arr = []Value{}
+		}
+		return e.encodeArray(Value{Type: TypeArray, Array: arr})
+	default:
+		return errors.New("Not implemented")
+	}
+}
+
+func (e *Encoder) encodeNull() error {
+	_, err := e.writer.Write([]byte("_\r\n"))
+	return err
 }
 
 func (e *Encoder) encodeBytes(v Value) error {
@@ -93,9 +185,12 @@ func (e *Encoder) encodeArray(v Value) error {
 	}
 
 	var n int
-	if v.Array == nil {
+	switch {
+	case v.Array == nil && v.Type == TypeArray:
 		n = -1
-	} else {
+	case v.Type == TypeMap || v.Type == TypeAttribute:
+		n = len(v.Array) / 2
+	default:
 		n = len(v.Array)
 	}
 	if _, err := e.writer.Write([]byte(strconv.Itoa(n))); err != nil {
@@ -105,7 +200,7 @@ func (e *Encoder) encodeArray(v Value) error {
 		return err
 	}
 
-	for i := range n {
+	for i := range v.Array {
 		if err := e.encode(v.Array[i]); err != nil {
 			return err
 		}
@@ -199,6 +199,126 @@ func TestEncoder(t *testing.T) {
 	}
 }
 
+func TestEncoderProtocols(t *testing.T) {
+	str := func(s string) resp.Value { return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)} }
+	tests := []struct {
+		name  string
+		value resp.Value
+		resp2 string
+		resp3 string
+	}{
+		{
+			name:  "null bulk string",
+			value: resp.Value{Type: resp.TypeBulkString},
+			resp2: "$-1\r\n",
+			resp3: "_\r\n",
+		},
+		{
+			name:  "null array",
+			value: resp.Value{Type: resp.TypeArray},
+			resp2: "*-1\r\n",
+			resp3: "_\r\n",
+		},
+		{
+			name:  "null",
+			value: resp.Value{Type: resp.TypeNull},
+			resp2: "$-1\r\n",
+			resp3: "_\r\n",
+		},
+		{
+			name:  "boolean",
+			value: resp.Value{Type: resp.TypeBoolean, Bytes: []byte("t")},
+			resp2: ":1\r\n",
+			resp3: "#t\r\n",
+		},
+		{
+			name:  "double",
+			value: resp.Value{Type: resp.TypeDouble, Bytes: []byte("1.5")},
+			resp2: "$3\r\n1.5\r\n",
+			resp3: ",1.5\r\n",
+		},
+		{
+			name:  "big number",
+			value: resp.Value{Type: resp.TypeBigNumber, Bytes: []byte("12345678901234567890")},
+			resp2: "$20\r\n12345678901234567890\r\n",
+			resp3: "(12345678901234567890\r\n",
+		},
+		{
+			name:  "verbatim string",
+			value: resp.Value{Type: resp.TypeVerbatimString, Bytes: []byte("txt:hi")},
+			resp2: "$2\r\nhi\r\n",
+			resp3: "=6\r\ntxt:hi\r\n",
+		},
+		{
+			name:  "blob error",
+			value: resp.Value{Type: resp.TypeBlobError, Bytes: []byte("ERR a\r\nb")},
+			resp2: "-ERR a b\r\n",
+			resp3: "!8\r\nERR a\r\nb\r\n",
+		},
+		{
+			name:  "map",
+			value: resp.Value{Type: resp.TypeMap, Array: []resp.Value{str("k"), {Type: resp.TypeDouble, Bytes: []byte("2")}}},
+			resp2: "*2\r\n$1\r\nk\r\n$1\r\n2\r\n",
+			resp3: "%1\r\n$1\r\nk\r\n,2\r\n",
+		},
+		{
+			name:  "empty map",
+			value: resp.Value{Type: resp.TypeMap},
+			resp2: "*0\r\n",
+			resp3: "%0\r\n",
+		},
+		{
+			name:  "set",
+			value: resp.Value{Type: resp.TypeSet, Array: []resp.Value{str("a")}},
+			resp2: "*1\r\n$1\r\na\r\n",
+			resp3: "~1\r\n$1\r\na\r\n",
+		},
+		{
+			name:  "push",
+			value: resp.Value{Type: resp.TypePush, Array: []resp.Value{str("message")}},
+			resp2: "*1\r\n$7\r\nmessage\r\n",
+			resp3: ">1\r\n$7\r\nmessage\r\n",
+		},
+		{
+			name: "flat pairs",
+			value: resp.Value{Type: resp.TypeArray, RESP2: resp.FlatPairs, Array: []resp.Value{
+				{Type: resp.TypeArray, Array: []resp.Value{str("m"), {Type: resp.TypeDouble, Bytes: []byte("2")}}},
+			}},
+			resp2: "*2\r\n$1\r\nm\r\n$1\r\n2\r\n",
+			resp3: "*1\r\n*2\r\n$1\r\nm\r\n,2\r\n",
+		},
+		{
+			name:  "no flat pairs",
+			value: resp.Value{Type: resp.TypeArray, RESP2: resp.FlatPairs, Array: []resp.Value{}},
+			resp2: "*0\r\n",
+			resp3: "*0\r\n",
+		},
+		{
+			name:  "map of pairs",
+			value: resp.Value{Type: resp.TypeMap, RESP2: resp.MapPairs, Array: []resp.Value{str("k"), str("v")}},
+			resp2: "*1\r\n*2\r\n$1\r\nk\r\n$1\r\nv\r\n",
+			resp3: "%1\r\n$1\r\nk\r\n$1\r\nv\r\n",
+		},
+		{
+			name:  "attribute",
+			value: resp.Value{Type: resp.TypeInteger, Bytes: []byte("1"), Attrs: []resp.Value{str("a"), str("b")}},
+			resp2: ":1\r\n",
+			resp3: "|1\r\n$1\r\na\r\n$1\r\nb\r\n:1\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for version, want := range map[int]string{2: tt.resp2, 3: tt.resp3} {
+				var buf bytes.Buffer
+				enc := resp.NewEncoder(&buf)
+				enc.SetProtocol(version)
+				require.NoError(t, enc.Encode(tt.value))
+				assert.Equal(t, want, buf.String(), "RESP%d", version)
+			}
+		})
+	}
+}
+
 func TestEncoderErrors(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -208,7 +328,7 @@ func TestEncoderErrors(t *testing.T) {
 		{
 			name: "unknown type",
 			value: resp.Value{
-				Type:  '!',
+				Type:  '@',
 				Bytes: []byte("bad"),
 			},
 			wantErr: "Not implemented",
@@ -0,0 +1,135 @@
+package resp
+
+import (
+	"strconv"
+)
+
+var errUnbalancedQuotes = protocolError("unbalanced quotes in request")
+
+func isTypePrefix(b byte) bool {
+	switch b {
+	case TypeBulkString, TypeArray, TypeSimpleString, TypeInteger, TypeError,
+		TypeNull, TypeBoolean, TypeDouble, TypeBigNumber, TypeBlobError,
+		TypeVerbatimString, TypeMap, TypeSet, TypePush, TypeAttribute:
+		return true
+	}
+	return false
+}
+
+// parseInline reads an inline command and returns its words as bulk
+// strings, or none for a blank line.
+func (p *Decoder) parseInline() ([]Value, error) {
+	line := make([]byte, 0, 64)
+	for {
+		b, err := p.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '\n' {
+			break
+		}
+		line = append(line, b)
+		if len(line) > p.limits.MaxLineLen {
+			return nil, protocolError("too big inline request")
+		}
+	}
+	// A trailing CR is whitespace like any other.
+	words, err := splitInline(line)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]Value, len(words))
+	for i, w := range words {
+		args[i] = Value{Type: TypeBulkString, Bytes: w}
+	}
+	return args, nil
+}
+
+// splitInline splits a line into words the way Redis does. Words are
+// separated by whitespace and may be quoted. Double quoted words may contain
+// the escapes \n, \r, \t, \b, \a and \xHH, and a backslash before any other
+// character stands for that character. Single quoted words only recognize \'.
+// A closing quote must end its word.
+func splitInline(line []byte) ([][]byte, error) {
+	var words [][]byte
+	for i := 0; ; {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return words, nil
+		}
+
+		word := []byte{}
+		var quote byte
+	scan:
+		for ; ; i++ {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case quote == 0 && isSpace(c):
+				break scan
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+			case c == quote:
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, errUnbalancedQuotes
+				}
+				i++
+				break scan
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				if b, ok := hexEscape(line[i+1:]); ok {
+					word = append(word, b)
+					i += 3
+					continue
+				}
+				i++
+				word = append(word, unescape(line[i]))
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+				word = append(word, '\'')
+			default:
+				word = append(word, c)
+			}
+		}
+		words = append(words, word)
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// hexEscape decodes the escape xHH at the start of b.
+func hexEscape(b []byte) (byte, bool) {
+	if len(b) < 3 || b[0] != 'x' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(b[1:3]), 16, 8)
+	return byte(n), err == nil
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
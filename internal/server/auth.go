@@ -0,0 +1,265 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const (
+	cmdAuth = "auth"
+	cmdACL  = "acl"
+)
+
+var errNoACLFile = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// LoadACL loads the users from the ACL file, if one is configured.
+func (s *Server) LoadACL() error {
+	if s.aclFile == "" {
+		return nil
+	}
+	return s.acl.Load(s.aclFile)
+}
+
+// checkPermissions returns an *acl.Denial if the client's user may not run
+// the command args invoke, and logs the denial. It returns acl.ErrNoSuchUser
+// if the user was deleted since the client authenticated.
+func (s *Server) checkPermissions(c *client, spec *command.Spec, args []resp.Value) error {
+	err := s.acl.Check(c.user, spec, args)
+	if err == nil {
+		switch spec.Name {
+		case cmdPublish:
+			err = s.acl.CheckChannels(c.user, []string{string(args[1].Bytes)}, false)
+		case cmdSubscribe, cmdPSubscribe:
+			channels := make([]string, len(args)-1)
+			for i, arg := range args[1:] {
+				channels[i] = string(arg.Bytes)
+			}
+			err = s.acl.CheckChannels(c.user, channels, spec.Name == cmdPSubscribe)
+		}
+	}
+	var d *acl.Denial
+	if errors.As(err, &d) {
+		context := "toplevel"
+		if c.multi {
+			context = "multi"
+		}
+		s.acl.LogDenial(d.Reason, context, d.Object, c.user, s.clientInfo(c))
+	}
+	return err
+}
+
+// clientInfo describes a connection for the ACL log.
+func (s *Server) clientInfo(c *client) string {
+	return fmt.Sprintf("id=%d addr=%s name=%s user=%s", c.id, c.conn.RemoteAddr(), c.name, c.user)
+}
+
+// authenticate logs the client in as the named user if password is one of
+// its passwords, and logs the failure otherwise.
+func (s *Server) authenticate(c *client, user, password string) bool {
+	if !s.acl.Authenticate(user, password) {
+		s.acl.LogDenial("auth", "toplevel", cmdAuth, user, s.clientInfo(c))
+		return false
+	}
+	c.user, c.authenticated = user, true
+	return true
+}
+
+// auth implements AUTH [username] password. Without a username it
+// authenticates the default user.
+func (s *Server) auth(c *client, args []resp.Value) (resp.Value, error) {
+	if len(args) > 2 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+	}
+	user, password := acl.DefaultUser, string(args[len(args)-1].Bytes)
+	if len(args) == 2 {
+		user = string(args[0].Bytes)
+	} else if s.acl.DefaultLogin() {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")}, nil
+	}
+	if !s.authenticate(c, user, password) {
+		return wrongPass(), nil
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+func wrongPass() resp.Value {
+	return resp.Value{Type: resp.TypeError, Bytes: []byte("WRONGPASS invalid username-password pair or user is disabled.")}
+}
+
+// aclCommand serves ACL and its subcommands.
+func (s *Server) aclCommand(c *client, args []resp.Value) (resp.Value, error) {
+	sub := strings.ToLower(string(args[0].Bytes))
+	args = args[1:]
+	switch sub {
+	case "setuser":
+		rules := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			rules[i] = string(arg.Bytes)
+		}
+		if err := s.acl.SetUser(string(args[0].Bytes), rules...); err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "getuser":
+		return s.aclGetUser(string(args[0].Bytes)), nil
+	case "deluser":
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = string(arg.Bytes)
+		}
+		n, err := s.acl.DelUser(names...)
+		if err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+		}
+		return integer(n), nil
+	case "list", "users":
+		users := s.acl.Users()
+		out := make([]resp.Value, len(users))
+		for i, u := range users {
+			if sub == "list" {
+				out[i] = bulk("user " + u.Name + " " + u.Rules())
+			} else {
+				out[i] = bulk(u.Name)
+			}
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}, nil
+	case "whoami":
+		return bulk(c.user), nil
+	case "cat":
+		return s.aclCat(args), nil
+	case "log":
+		return s.aclLog(args), nil
+	case "load", "save":
+		if s.aclFile == "" {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + errNoACLFile.Error())}, nil
+		}
+		var err error
+		if sub == "load" {
+			err = s.acl.Load(s.aclFile)
+		} else {
+			err = s.acl.Save(s.aclFile)
+		}
+		if err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "genpass":
+		return aclGenPass(args), nil
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand '" + sub + "'. Try ACL HELP.")}, nil
+	}
+}
+
+// aclGetUser describes the named user, or replies with a nil value if there
+// is no such user.
+func (s *Server) aclGetUser(name string) resp.Value {
+	u, ok := s.acl.User(name)
+	if !ok {
+		return resp.Value{Type: resp.TypeNull}
+	}
+	flags := u.Flags()
+	flagVals := make([]resp.Value, len(flags))
+	for i, f := range flags {
+		flagVals[i] = bulk(f)
+	}
+	passwords := u.Passwords()
+	passwordVals := make([]resp.Value, len(passwords))
+	for i, p := range passwords {
+		passwordVals[i] = bulk(p)
+	}
+	return resp.Value{Type: resp.TypeMap, Array: []resp.Value{
+		bulk("flags"), {Type: resp.TypeArray, Array: flagVals},
+		bulk("passwords"), {Type: resp.TypeArray, Array: passwordVals},
+		bulk("commands"), bulk(u.Commands()),
+		bulk("keys"), bulk(u.Keys()),
+		bulk("channels"), bulk(u.Channels()),
+		bulk("selectors"), {Type: resp.TypeArray, Array: []resp.Value{}},
+	}}
+}
+
+// aclCat lists the ACL categories, or the commands in the given one.
+func (s *Server) aclCat(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		out := make([]resp.Value, len(command.Categories))
+		for i, c := range command.Categories {
+			out[i] = bulk(c)
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}
+	}
+	cat := strings.ToLower(string(args[0].Bytes))
+	if !slices.Contains(command.Categories, cat) {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unknown category '" + string(args[0].Bytes) + "'")}
+	}
+	var out []resp.Value
+	for _, spec := range s.commands.All() {
+		for _, c := range append([]*command.Spec{spec}, spec.Subcommands...) {
+			if slices.Contains(c.ACLCategories(), cat) {
+				out = append(out, bulk(c.Name))
+			}
+		}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
+// aclLog replies with the latest entries of the ACL log, ten unless a count
+// is given, or clears it with ACL LOG RESET.
+func (s *Server) aclLog(args []resp.Value) resp.Value {
+	n := 10
+	if len(args) > 0 {
+		if strings.EqualFold(string(args[0].Bytes), "RESET") {
+			s.acl.ResetLog()
+			return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}
+		}
+		v, err := strconv.Atoi(string(args[0].Bytes))
+		if err != nil || v < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is out of range, must be positive")}
+		}
+		n = v
+	}
+	now := time.Now()
+	entries := s.acl.Log(n)
+	out := make([]resp.Value, len(entries))
+	for i, e := range entries {
+		age := strconv.FormatFloat(now.Sub(e.Created).Seconds(), 'f', 3, 64)
+		out[i] = resp.Value{Type: resp.TypeMap, Array: []resp.Value{
+			bulk("count"), integer(e.Count),
+			bulk("reason"), bulk(e.Reason),
+			bulk("context"), bulk(e.Context),
+			bulk("object"), bulk(e.Object),
+			bulk("username"), bulk(e.Username),
+			bulk("age-seconds"), {Type: resp.TypeDouble, Bytes: []byte(age)},
+			bulk("client-info"), bulk(e.ClientInfo),
+			bulk("entry-id"), integer(int(e.ID)),
+			bulk("timestamp-created"), integer(int(e.Created.UnixMilli())),
+			bulk("timestamp-last-updated"), integer(int(e.Updated.UnixMilli())),
+		}}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
+// aclGenPass replies with a random password of the given number of bits,
+// 256 by default, in hex.
+func aclGenPass(args []resp.Value) resp.Value {
+	bits := 256
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0].Bytes))
+		if err != nil || v <= 0 || v > 4096 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096")}
+		}
+		bits = v
+	}
+	chars := (bits + 3) / 4
+	b := make([]byte, (chars+1)/2)
+	rand.Read(b)
+	return bulk(hex.EncodeToString(b)[:chars])
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// block serves XREAD and XREADGROUP with the BLOCK option: the read is run
+// again each time entries are added to one of its streams, until it returns
+// some, the timeout expires or the client goes away.
+func (s *Server) block(c *client, b executor.BlockingRead) (resp.Value, error) {
+	var expired <-chan time.Time
+	if b.Timeout > 0 {
+		t := time.NewTimer(b.Timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	closed, stop := c.watchClose()
+	defer stop()
+	s.blockedClients.Add(1)
+	defer s.blockedClients.Add(-1)
+	c.blocked.Store(true)
+	defer c.blocked.Store(false)
+
+	for {
+		out, done, err := s.tryRead(b, expired, closed)
+		if done {
+			return out, err
+		}
+	}
+}
+
+// tryRead runs the read once and, if it returned nothing, waits for a reason
+// to run it again. It reports whether the command is done.
+func (s *Server) tryRead(b executor.BlockingRead, expired <-chan time.Time, closed <-chan struct{}) (resp.Value, bool, error) {
+	// Wait before reading, so entries added in between are not missed.
+	ready, cancel := s.exe.WaitKeys(b.Keys)
+	defer cancel()
+	out, err := s.exe.Execute(b.Cmd)
+	if err != nil || out.Type != resp.TypeArray || out.Array != nil {
+		return out, true, err
+	}
+	select {
+	case <-ready:
+		return resp.Value{}, false, nil
+	case <-expired:
+		return resp.Value{Type: resp.TypeArray}, true, nil
+	case <-closed:
+		return resp.Value{}, true, errDisconnected
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// outputQueueSize bounds the replies and pushed messages waiting to be
+// written to a client. A client that lets pushed messages pile up beyond it
+// is disconnected rather than slowing down publishers.
+const outputQueueSize = 1024
+
+// nextClientID numbers connections from 1.
+var nextClientID atomic.Int64
+
+// outgoing is a value queued for a client, with the protocol version to
+// encode it in as of when it was queued, and its estimated encoded size.
+type outgoing struct {
+	v     resp.Value
+	proto int
+	size  int64
+}
+
+// encodedSize estimates the number of bytes v takes once encoded: its
+// contents plus a type prefix, a length and line endings for each value.
+func encodedSize(v resp.Value) int64 {
+	n := int64(len(v.Bytes)) + 16
+	for _, e := range v.Array {
+		n += encodedSize(e)
+	}
+	for _, e := range v.Attrs {
+		n += encodedSize(e)
+	}
+	return n
+}
+
+// client holds the state of a single connection. Everything written to it
+// goes through a queue drained by a dedicated goroutine, so that messages
+// pushed from other connections are never interleaved with a reply.
+type client struct {
+	id   int64
+	conn net.Conn
+	br   *bufio.Reader
+	dec  *resp.Decoder
+
+	// proto is the protocol version, 2 or 3, negotiated with HELLO. Only the
+	// connection's goroutine changes it.
+	proto atomic.Int32
+	// name is set with HELLO SETNAME.
+	name string
+	// user is the ACL user the client runs commands as. Until authenticated
+	// is set only commands allowed before authentication are accepted.
+	user          string
+	authenticated bool
+
+	mu     sync.Mutex
+	out    chan outgoing
+	closed bool
+	// outBytes is the estimated size of the values queued in out, for
+	// CLIENT LIST.
+	outBytes atomic.Int64
+	// written is closed once the writer goroutine has exited.
+	written chan struct{}
+
+	// replListeningPort is announced by a replica with REPLCONF
+	// listening-port before it sends PSYNC.
+	replListeningPort int
+	// subscriptions is the number of channels and patterns the client is
+	// subscribed to. While positive only subscribe-family commands are
+	// accepted.
+	subscriptions int
+	// monitoring is set by MONITOR. The connection then only receives the
+	// commands other clients run.
+	monitoring bool
+	// replica is set once the connection turned into a replication link.
+	replica bool
+
+	// multi is set between MULTI and EXEC or DISCARD, while commands are
+	// queued. txAborted records that one of them was rejected.
+	multi     bool
+	queued    []resp.Value
+	txAborted bool
+	// watched maps the keys passed to WATCH to their version at the time.
+	watched map[string]uint64
+
+	// created is when the connection was accepted, and cmd the last command
+	// it ran.
+	created time.Time
+	cmd     string
+	// replyOff and skipReply are set with CLIENT REPLY, to drop the
+	// replies to every command or to the next one.
+	replyOff  bool
+	skipReply bool
+	// closeAfterReply is set when the client killed itself with CLIENT
+	// KILL.
+	closeAfterReply bool
+	// blocked is set while the client waits in a blocking command.
+	blocked atomic.Bool
+	// noEvict is set with CLIENT NO-EVICT, to keep the connection open when
+	// its output queue is full.
+	noEvict atomic.Bool
+
+	// stateMu guards state, what other connections see of the client.
+	stateMu sync.Mutex
+	state   clientState
+}
+
+// clientState describes the fields of a client that only its connection's
+// goroutine may read, for CLIENT LIST. That goroutine publishes it after
+// every command.
+type clientState struct {
+	name, user, cmd    string
+	lastActive         time.Time
+	channels, patterns int
+	// multi is the number of commands queued, or -1 outside a transaction.
+	multi          int
+	qbuf, qbufFree int
+	monitoring     bool
+	replica        bool
+}
+
+func newClient(conn net.Conn) *client {
+	br := bufio.NewReader(conn)
+	dec := resp.NewDecoder(br)
+	dec.AcceptInline()
+	c := &client{
+		id:      nextClientID.Add(1),
+		user:    acl.DefaultUser,
+		created: time.Now(),
+		cmd:     "NULL",
+		conn:    conn,
+		br:      br,
+		dec:     dec,
+		out:     make(chan outgoing, outputQueueSize),
+		written: make(chan struct{}),
+	}
+	c.proto.Store(2)
+	go c.writeLoop()
+	return c
+}
+
+func (c *client) writeLoop() {
+	defer close(c.written)
+	enc := resp.NewEncoder(c.conn)
+	var err error
+	for o := range c.out {
+		// Keep draining after a failed write so senders never block.
+		if err == nil {
+			enc.SetProtocol(o.proto)
+			if err = enc.Encode(o.v); err != nil {
+				c.conn.Close()
+			}
+		}
+		c.outBytes.Add(-o.size)
+	}
+}
+
+// reply queues v for the client, waiting for room if necessary. Only the
+// connection's own goroutine may call it.
+func (c *client) reply(v resp.Value) {
+	o := outgoing{v, c.protocol(), encodedSize(v)}
+	c.outBytes.Add(o.size)
+	c.out <- o
+}
+
+// protocol returns the protocol version of the connection.
+func (c *client) protocol() int {
+	return int(c.proto.Load())
+}
+
+// Deliver queues a message pushed from another connection. It implements
+// pubsub.Subscriber. A client whose queue is full is disconnected, unless
+// it set CLIENT NO-EVICT, in which case Deliver waits for room.
+func (c *client) Deliver(msg resp.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	o := outgoing{msg, c.protocol(), encodedSize(msg)}
+	c.outBytes.Add(o.size)
+	if c.noEvict.Load() {
+		c.out <- o
+		return
+	}
+	select {
+	case c.out <- o:
+	default:
+		c.outBytes.Add(-o.size)
+		// Too slow to keep up: drop the connection, which also ends the
+		// connection's goroutine.
+		c.conn.Close()
+	}
+}
+
+// flush stops the writer once everything queued so far has been written.
+// Nothing may be queued afterwards.
+func (c *client) flush() {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.out)
+	}
+	c.mu.Unlock()
+	<-c.written
+}
+
+// snapshot returns the state last published.
+func (c *client) snapshot() clientState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// abortTransaction makes EXEC fail if a transaction is open.
+func (c *client) abortTransaction() {
+	if c.multi {
+		c.txAborted = true
+	}
+}
+
+// resetTransaction ends the open transaction and forgets watched keys.
+func (c *client) resetTransaction() {
+	c.multi, c.queued, c.txAborted, c.watched = false, nil, false, nil
+}
+
+// watchClose returns a channel that is closed if the peer closes the
+// connection, for use while the connection's goroutine waits on something
+// other than the client. Input arriving meanwhile is left to be decoded
+// afterwards. The returned function stops watching, and must be called
+// before the connection is read from again.
+func (c *client) watchClose() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.br.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(closed)
+		}
+	}()
+	return closed, func() {
+		// Interrupt the Peek, which leaves anything read so far buffered.
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const cmdClient = "client"
+
+// Client types, as filtered on by CLIENT LIST and CLIENT KILL.
+const (
+	clientNormal  = "normal"
+	clientReplica = "replica"
+	clientPubSub  = "pubsub"
+	clientMaster  = "master"
+)
+
+// clientRegistry holds the open connections, by ID.
+type clientRegistry struct {
+	mu      sync.Mutex
+	clients map[int64]*client
+}
+
+func (r *clientRegistry) add(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[int64]*client)
+	}
+	r.clients[c.id] = c
+}
+
+func (r *clientRegistry) remove(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// all returns the clients ordered by ID, which is the order they connected
+// in.
+func (r *clientRegistry) all() []*client {
+	r.mu.Lock()
+	out := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		out = append(out, c)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(out, func(a, b *client) int { return int(a.id - b.id) })
+	return out
+}
+
+// publishState makes what the client's connection knows about it visible
+// to other connections.
+func (s *Server) publishState(c *client) {
+	st := clientState{
+		name:       c.name,
+		user:       c.user,
+		cmd:        c.cmd,
+		lastActive: time.Now(),
+		multi:      -1,
+		qbuf:       c.br.Buffered(),
+		qbufFree:   c.br.Size() - c.br.Buffered(),
+		monitoring: c.monitoring,
+		replica:    c.replica,
+	}
+	if c.subscriptions > 0 {
+		st.channels, st.patterns = s.pubsub.Counts(c)
+	}
+	if c.multi {
+		st.multi = len(c.queued)
+	}
+	c.stateMu.Lock()
+	c.state = st
+	c.stateMu.Unlock()
+}
+
+// clientType returns the type of a client in the given state.
+func clientType(st clientState) string {
+	switch {
+	case st.replica:
+		return clientReplica
+	case st.channels+st.patterns > 0:
+		return clientPubSub
+	default:
+		return clientNormal
+	}
+}
+
+// describeClient returns the line describing c in CLIENT LIST.
+func describeClient(c *client) string {
+	st := c.snapshot()
+	var flags string
+	if st.monitoring {
+		flags += "O"
+	}
+	if st.replica {
+		flags += "S"
+	}
+	if st.channels+st.patterns > 0 {
+		flags += "P"
+	}
+	if st.multi >= 0 {
+		flags += "x"
+	}
+	if c.blocked.Load() {
+		flags += "b"
+	}
+	if c.noEvict.Load() {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	now := time.Now()
+	omem := c.outBytes.Load()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d multi=%d qbuf=%d qbuf-free=%d oll=%d omem=%d tot-mem=%d cmd=%s user=%s resp=%d",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), st.name,
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(st.lastActive).Seconds()),
+		flags, st.channels, st.patterns, st.multi, st.qbuf, st.qbufFree, len(c.out),
+		omem, int64(st.qbuf+st.qbufFree)+omem, st.cmd, st.user, c.protocol())
+}
+
+// clientPause is the state of CLIENT PAUSE.
+type clientPause struct {
+	mu sync.Mutex
+	// done is closed when the pause ends. It is nil while clients are not
+	// paused.
+	done  chan struct{}
+	all   bool
+	end   time.Time
+	timer *time.Timer
+}
+
+// pause pauses clients for d, or until unpause, from running every command
+// if all is set or else commands that write. A pause while clients are
+// already paused lasts until the later of the two ends, and pauses every
+// command if either does.
+func (p *clientPause) pause(d time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	end := time.Now().Add(d)
+	if p.done == nil {
+		p.done, p.all, p.end = make(chan struct{}), all, end
+	} else {
+		p.all = p.all || all
+		if end.After(p.end) {
+			p.end = end
+		}
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(time.Until(p.end), p.unpause)
+}
+
+// unpause ends the pause, letting the paused commands run.
+func (p *clientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done == nil {
+		return
+	}
+	p.timer.Stop()
+	close(p.done)
+	p.done = nil
+}
+
+// holds returns a channel closed when the pause ends if it applies to a
+// command, or nil if the command may run.
+func (p *clientPause) holds(write bool) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done == nil || !(p.all || write) {
+		return nil
+	}
+	return p.done
+}
+
+// waitUnpaused holds a command while CLIENT PAUSE applies to it. EXEC
+// counts as a write if a write is queued, and commands are only queued
+// meanwhile. The CLIENT commands are never held, so that the pause can be
+// lifted.
+func (s *Server) waitUnpaused(c *client, spec *command.Spec) error {
+	if strings.HasPrefix(spec.Name, cmdClient+"|") || (c.multi && spec.Name != cmdExec) {
+		return nil
+	}
+	write := s.writes(c, spec)
+	for {
+		done := s.pause.holds(write)
+		if done == nil {
+			return nil
+		}
+		closed, stop := c.watchClose()
+		select {
+		case <-done:
+			stop()
+		case <-closed:
+			stop()
+			return errDisconnected
+		}
+	}
+}
+
+// writes reports whether spec is a write command, or EXEC of a transaction
+// with one.
+func (s *Server) writes(c *client, spec *command.Spec) bool {
+	if spec.Name != cmdExec {
+		return spec.Flags.Has(command.Write)
+	}
+	for _, val := range c.queued {
+		if queued, ok := s.commands.Find(val.Array); ok && queued.Flags.Has(command.Write) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCommand serves CLIENT and its subcommands.
+func (s *Server) clientCommand(c *client, args []resp.Value) (resp.Value, error) {
+	sub := strings.ToLower(string(args[0].Bytes))
+	args = args[1:]
+	switch sub {
+	case "id":
+		return integer(int(c.id)), nil
+	case "info":
+		s.publishState(c)
+		return bulk(describeClient(c) + "\n"), nil
+	case "list":
+		return s.clientList(c, args), nil
+	case "kill":
+		return s.clientKill(c, args), nil
+	case "setname":
+		name := string(args[0].Bytes)
+		if !validClientName(name) {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Client names cannot contain spaces, newlines or special characters.")}, nil
+		}
+		c.name = name
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "getname":
+		if c.name == "" {
+			return resp.Value{Type: resp.TypeNull}, nil
+		}
+		return bulk(c.name), nil
+	case "pause":
+		ms, err := strconv.ParseInt(string(args[0].Bytes), 10, 64)
+		if err != nil || ms < 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR timeout is not an integer or out of range")}, nil
+		}
+		all := true
+		if len(args) > 2 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+		}
+		if len(args) == 2 {
+			switch strings.ToUpper(string(args[1].Bytes)) {
+			case "WRITE":
+				all = false
+			case "ALL":
+			default:
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+			}
+		}
+		s.pause.pause(time.Duration(ms)*time.Millisecond, all)
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "unpause":
+		s.pause.unpause()
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "no-evict":
+		switch strings.ToUpper(string(args[0].Bytes)) {
+		case "ON":
+			c.noEvict.Store(true)
+		case "OFF":
+			c.noEvict.Store(false)
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "reply":
+		switch strings.ToUpper(string(args[0].Bytes)) {
+		case "ON":
+			c.replyOff = false
+		case "OFF":
+			c.replyOff = true
+			return resp.Value{}, errReplied
+		case "SKIP":
+			c.skipReply = true
+			return resp.Value{}, errReplied
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand '" + sub + "'. Try CLIENT HELP.")}, nil
+	}
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id ...].
+func (s *Server) clientList(c *client, args []resp.Value) resp.Value {
+	s.publishState(c)
+	var typ string
+	var ids []int64
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i].Bytes)); {
+		case opt == "TYPE" && i+1 < len(args):
+			i++
+			var ok bool
+			if typ, ok = parseClientType(string(args[i].Bytes)); !ok {
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unknown client type '" + string(args[i].Bytes) + "'")}
+			}
+		case opt == "ID" && i+1 < len(args):
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(string(args[i].Bytes), 10, 64)
+				if err != nil || id <= 0 {
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid client ID")}
+				}
+				ids = append(ids, id)
+			}
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}
+		}
+	}
+
+	var b strings.Builder
+	for _, other := range s.clients.all() {
+		if typ != "" && clientType(other.snapshot()) != typ {
+			continue
+		}
+		if ids != nil && !slices.Contains(ids, other.id) {
+			continue
+		}
+		b.WriteString(describeClient(other))
+		b.WriteByte('\n')
+	}
+	return bulk(b.String())
+}
+
+// parseClientType returns the client type named by s.
+func parseClientType(s string) (string, bool) {
+	switch strings.ToLower(s) {
+	case clientNormal:
+		return clientNormal, true
+	case clientReplica, "slave":
+		return clientReplica, true
+	case clientPubSub:
+		return clientPubSub, true
+	case clientMaster:
+		return clientMaster, true
+	}
+	return "", false
+}
+
+// clientFilter selects the clients CLIENT KILL kills.
+type clientFilter struct {
+	id          int64
+	addr, laddr string
+	user, typ   string
+	maxAge      time.Duration
+	skipMe      bool
+}
+
+func (f *clientFilter) match(self, c *client) bool {
+	st := c.snapshot()
+	switch {
+	case f.skipMe && c == self,
+		f.id != 0 && c.id != f.id,
+		f.addr != "" && c.conn.RemoteAddr().String() != f.addr,
+		f.laddr != "" && c.conn.LocalAddr().String() != f.laddr,
+		f.user != "" && st.user != f.user,
+		f.typ != "" && clientType(st) != f.typ,
+		f.maxAge != 0 && time.Since(c.created) < f.maxAge:
+		return false
+	}
+	return true
+}
+
+// clientKill implements CLIENT KILL addr and CLIENT KILL with filters. The
+// first form replies OK or an error, the second the number of clients
+// killed.
+func (s *Server) clientKill(c *client, args []resp.Value) resp.Value {
+	f := clientFilter{skipMe: true}
+	if len(args) == 1 {
+		f.addr, f.skipMe = string(args[0].Bytes), false
+	} else {
+		if len(args)%2 != 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}
+		}
+		for i := 0; i < len(args); i += 2 {
+			v := string(args[i+1].Bytes)
+			switch strings.ToUpper(string(args[i].Bytes)) {
+			case "ID":
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || id <= 0 {
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR client-id should be greater than 0")}
+				}
+				f.id = id
+			case "ADDR":
+				f.addr = v
+			case "LADDR":
+				f.laddr = v
+			case "USER":
+				f.user = v
+			case "TYPE":
+				typ, ok := parseClientType(v)
+				if !ok {
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unknown client type '" + v + "'")}
+				}
+				f.typ = typ
+			case "MAXAGE":
+				secs, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || secs <= 0 {
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not an integer or out of range")}
+				}
+				f.maxAge = time.Duration(secs) * time.Second
+			case "SKIPME":
+				switch strings.ToLower(v) {
+				case "yes":
+					f.skipMe = true
+				case "no":
+					f.skipMe = false
+				default:
+					return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}
+				}
+			default:
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}
+			}
+		}
+	}
+
+	killed := 0
+	for _, other := range s.clients.all() {
+		if !f.match(c, other) {
+			continue
+		}
+		if other == c {
+			// Closing now would lose the reply.
+			c.closeAfterReply = true
+		} else {
+			other.conn.Close()
+		}
+		killed++
+	}
+	if len(args) == 1 {
+		if killed == 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR No such client")}
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}
+	}
+	return integer(killed)
+}
@@ -0,0 +1,511 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/glob"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const cmdCommand = "command"
+
+// serverCommands describes the commands the server handles itself.
+var serverCommands = []*command.Spec{
+	// Persistence.
+	{
+		Name: cmdBgRewriteAOF, Arity: 1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "Asynchronously rewrites the append-only file to disk.",
+	},
+	{
+		Name: cmdSave, Arity: 1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "Synchronously saves the database(s) to disk.",
+	},
+	{
+		Name: cmdBgSave, Arity: 1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "Asynchronously saves the database(s) to disk.",
+	},
+	{
+		Name: cmdLastSave, Arity: 1, Flags: command.Fast,
+		Group: "server", Since: "1.0.0",
+		Summary:    "Returns the Unix timestamp of the last successful save to disk.",
+		Categories: []string{"admin", "dangerous"},
+	},
+
+	// Configuration.
+	{
+		Name: cmdConfig, Arity: -2,
+		Group: "server", Since: "2.0.0",
+		Summary: "A container for server configuration commands.",
+		Subcommands: []*command.Spec{
+			{
+				Name: "config|get", Arity: -3, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "2.0.0",
+				Summary: "Returns the effective values of configuration parameters.",
+			},
+			{
+				Name: "config|resetstat", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "2.0.0",
+				Summary: "Resets the server's statistics.",
+			},
+			{
+				Name: "config|rewrite", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "2.8.0",
+				Summary: "Persists the effective configuration to file.",
+			},
+			{
+				Name: "config|set", Arity: -4, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "2.0.0",
+				Summary: "Sets configuration parameters in-flight.",
+			},
+		},
+	},
+
+	// Monitoring.
+	{
+		Name: cmdMonitor, Arity: 1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "Listens for all requests received by the server in real-time.",
+	},
+	{
+		Name: cmdSlowlog, Arity: -2,
+		Group: "server", Since: "2.2.12",
+		Summary: "A container for slow log commands.",
+		Subcommands: []*command.Spec{
+			{
+				Name: "slowlog|get", Arity: -2, Flags: command.Admin,
+				Group: "server", Since: "2.2.12",
+				Summary: "Returns the slow log's entries.",
+			},
+			{
+				Name: "slowlog|len", Arity: 2, Flags: command.Admin,
+				Group: "server", Since: "2.2.12",
+				Summary: "Returns the number of entries in the slow log.",
+			},
+			{
+				Name: "slowlog|reset", Arity: 2, Flags: command.Admin,
+				Group: "server", Since: "2.2.12",
+				Summary: "Clears all entries from the slow log.",
+			},
+		},
+	},
+
+	// Replication.
+	{
+		Name: cmdReplicaOf, Arity: 3, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "5.0.0",
+		Summary: "Configures a server as replica of another, or promotes it to a master.",
+	},
+	{
+		Name: cmdSlaveOf, Arity: 3, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.",
+	},
+	{
+		Name: cmdReplConf, Arity: -1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "3.0.0",
+		Summary: "An internal command for configuring the replication stream.",
+	},
+	{
+		Name: cmdPSync, Arity: 3, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "2.8.0",
+		Summary: "An internal command used in replication.",
+	},
+	{
+		Name: cmdSync, Arity: 1, Flags: command.Admin | command.NoScript | command.NoMulti,
+		Group: "server", Since: "1.0.0",
+		Summary: "An internal command used in replication.",
+	},
+	{
+		Name: cmdRole, Arity: 1, Flags: command.NoScript | command.Fast,
+		Group: "server", Since: "2.8.12",
+		Summary:    "Returns the replication role.",
+		Categories: []string{"admin", "dangerous"},
+	},
+	{
+		Name: cmdInfo, Arity: -1,
+		Group: "server", Since: "1.0.0",
+		Summary:    "Returns information and statistics about the server.",
+		Categories: []string{"dangerous"},
+	},
+
+	// Publish/subscribe.
+	{
+		Name: cmdSubscribe, Arity: -2, Flags: command.PubSub | command.NoScript | command.NoMulti,
+		Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels.",
+	},
+	{
+		Name: cmdUnsubscribe, Arity: -1, Flags: command.PubSub | command.NoScript | command.NoMulti,
+		Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages posted to channels.",
+	},
+	{
+		Name: cmdPSubscribe, Arity: -2, Flags: command.PubSub | command.NoScript | command.NoMulti,
+		Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels that match one or more patterns.",
+	},
+	{
+		Name: cmdPUnsubscribe, Arity: -1, Flags: command.PubSub | command.NoScript | command.NoMulti,
+		Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages published to channels that match one or more patterns.",
+	},
+	{
+		Name: cmdPublish, Arity: 3, Flags: command.PubSub | command.Fast,
+		Group: "pubsub", Since: "2.0.0",
+		Summary: "Posts a message to a channel.",
+	},
+	{
+		Name: cmdPubSub, Arity: -2,
+		Group: "pubsub", Since: "2.8.0",
+		Summary: "A container for Pub/Sub commands.",
+		Subcommands: []*command.Spec{
+			{
+				Name: "pubsub|channels", Arity: -2, Flags: command.PubSub,
+				Group: "pubsub", Since: "2.8.0",
+				Summary: "Returns the active channels.",
+			},
+			{
+				Name: "pubsub|numpat", Arity: 2, Flags: command.PubSub,
+				Group: "pubsub", Since: "2.8.0",
+				Summary: "Returns a count of unique pattern subscriptions.",
+			},
+			{
+				Name: "pubsub|numsub", Arity: -2, Flags: command.PubSub,
+				Group: "pubsub", Since: "2.8.0",
+				Summary: "Returns a count of subscribers to channels.",
+			},
+		},
+	},
+
+	// Transactions.
+	{
+		Name: cmdMulti, Arity: 1, Flags: command.NoScript | command.Fast,
+		Group: "transactions", Since: "1.2.0",
+		Summary: "Starts a transaction.",
+	},
+	{
+		Name: cmdExec, Arity: 1, Flags: command.NoScript | command.SkipSlowlog,
+		Group: "transactions", Since: "1.2.0",
+		Summary: "Executes all commands in a transaction.",
+	},
+	{
+		Name: cmdDiscard, Arity: 1, Flags: command.NoScript | command.Fast,
+		Group: "transactions", Since: "2.0.0",
+		Summary: "Discards a transaction.",
+	},
+	{
+		Name: cmdWatch, Arity: -2, Flags: command.NoScript | command.Fast, FirstKey: 1, LastKey: -1, Step: 1,
+		Group: "transactions", Since: "2.2.0",
+		Summary: "Monitors changes to keys to determine the execution of a transaction.",
+	},
+	{
+		Name: cmdUnwatch, Arity: 1, Flags: command.NoScript | command.Fast,
+		Group: "transactions", Since: "2.2.0",
+		Summary: "Forgets about watched keys of a transaction.",
+	},
+
+	// Connection and introspection.
+	{
+		Name: cmdAuth, Arity: -2, Flags: command.NoScript | command.Fast | command.NoAuth,
+		Group: "connection", Since: "1.0.0",
+		Summary: "Authenticates the connection.",
+	},
+	{
+		Name: cmdACL, Arity: -2,
+		Group: "server", Since: "6.0.0",
+		Summary: "A container for Access List Control commands.",
+		Subcommands: []*command.Spec{
+			{
+				Name: "acl|cat", Arity: -2,
+				Group: "server", Since: "6.0.0",
+				Summary: "Lists the ACL categories, or the commands inside a category.",
+			},
+			{
+				Name: "acl|deluser", Arity: -3, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Deletes ACL users, and terminates their connections.",
+			},
+			{
+				Name: "acl|genpass", Arity: -2,
+				Group: "server", Since: "6.0.0",
+				Summary: "Generates a pseudorandom, secure password that can be used to identify ACL users.",
+			},
+			{
+				Name: "acl|getuser", Arity: 3, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Lists the ACL rules of a user.",
+			},
+			{
+				Name: "acl|list", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Dumps the effective rules in ACL file format.",
+			},
+			{
+				Name: "acl|load", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Reloads the rules from the configured ACL file.",
+			},
+			{
+				Name: "acl|log", Arity: -2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Lists recent security events generated due to ACL rules.",
+			},
+			{
+				Name: "acl|save", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Saves the effective ACL rules in the configured ACL file.",
+			},
+			{
+				Name: "acl|setuser", Arity: -3, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Creates and modifies an ACL user and its rules.",
+			},
+			{
+				Name: "acl|users", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "server", Since: "6.0.0",
+				Summary: "Lists all ACL users.",
+			},
+			{
+				Name: "acl|whoami", Arity: 2,
+				Group: "server", Since: "6.0.0",
+				Summary: "Returns the authenticated username of the current connection.",
+			},
+		},
+	},
+	{
+		Name: cmdHello, Arity: -1, Flags: command.NoScript | command.Fast | command.NoAuth,
+		Group: "connection", Since: "6.0.0",
+		Summary: "Handshakes with the Redis server.",
+	},
+	{
+		Name: cmdClient, Arity: -2,
+		Group: "connection", Since: "2.4.0",
+		Summary:    "A container for client connection commands.",
+		Categories: []string{"connection"},
+		Subcommands: []*command.Spec{
+			{
+				Name: "client|id", Arity: 2, Flags: command.NoScript,
+				Group: "connection", Since: "5.0.0",
+				Summary:    "Returns the unique client ID of the connection.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|getname", Arity: 2, Flags: command.NoScript,
+				Group: "connection", Since: "2.6.9",
+				Summary:    "Returns the name of the connection.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|info", Arity: 2, Flags: command.NoScript,
+				Group: "connection", Since: "6.2.0",
+				Summary:    "Returns information about the connection.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|kill", Arity: -3, Flags: command.Admin | command.NoScript,
+				Group: "connection", Since: "2.4.0",
+				Summary:    "Terminates open connections.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|list", Arity: -2, Flags: command.Admin | command.NoScript,
+				Group: "connection", Since: "2.4.0",
+				Summary:    "Lists open connections.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|no-evict", Arity: 3, Flags: command.Admin | command.NoScript,
+				Group: "connection", Since: "7.0.0",
+				Summary:    "Sets the client eviction mode of the connection.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|pause", Arity: -3, Flags: command.Admin | command.NoScript,
+				Group: "connection", Since: "3.0.0",
+				Summary:    "Suspends commands processing.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|reply", Arity: 3, Flags: command.NoScript,
+				Group: "connection", Since: "3.2.0",
+				Summary:    "Instructs the server whether to reply to commands.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|setname", Arity: 3, Flags: command.NoScript,
+				Group: "connection", Since: "2.6.9",
+				Summary:    "Sets the connection name.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "client|unpause", Arity: 2, Flags: command.Admin | command.NoScript,
+				Group: "connection", Since: "6.2.0",
+				Summary:    "Resumes processing of clients that were paused.",
+				Categories: []string{"connection"},
+			},
+		},
+	},
+	{
+		Name: cmdCommand, Arity: -1,
+		Group: "server", Since: "2.8.13",
+		Summary:    "Returns detailed information about all commands.",
+		Categories: []string{"connection"},
+		Subcommands: []*command.Spec{
+			{
+				Name: "command|count", Arity: 2,
+				Group: "server", Since: "2.8.13",
+				Summary:    "Returns a count of commands.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "command|docs", Arity: -2,
+				Group: "server", Since: "7.0.0",
+				Summary:    "Returns documentary information about one, multiple or all commands.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "command|getkeys", Arity: -3,
+				Group: "server", Since: "2.8.13",
+				Summary:    "Extracts the key names from an arbitrary command.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "command|info", Arity: -2,
+				Group: "server", Since: "2.8.13",
+				Summary:    "Returns information about one, multiple or all commands.",
+				Categories: []string{"connection"},
+			},
+			{
+				Name: "command|list", Arity: -2,
+				Group: "server", Since: "7.0.0",
+				Summary:    "Returns a list of command names.",
+				Categories: []string{"connection"},
+			},
+		},
+	},
+}
+
+// command serves COMMAND and its subcommands, which describe the commands of
+// the server to clients.
+func (s *Server) command(args []resp.Value) (resp.Value, error) {
+	if len(args) == 0 {
+		return s.commandInfo(nil), nil
+	}
+	switch strings.ToLower(string(args[0].Bytes)) {
+	case "count":
+		return integer(s.commands.Len()), nil
+	case "info":
+		return s.commandInfo(args[1:]), nil
+	case "docs":
+		return s.commandDocs(args[1:]), nil
+	case "list":
+		return s.commandList(args[1:])
+	case "getkeys":
+		return s.commandGetKeys(args[1:])
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand '" + string(args[0].Bytes) + "'. Try COMMAND HELP.")}, nil
+	}
+}
+
+// commandInfo describes the named commands, or all of them if none are
+// named. Unknown commands are described with a nil array.
+func (s *Server) commandInfo(names []resp.Value) resp.Value {
+	if len(names) == 0 {
+		all := s.commands.All()
+		out := make([]resp.Value, len(all))
+		for i, spec := range all {
+			out[i] = spec.Info()
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}
+	}
+	out := make([]resp.Value, len(names))
+	for i, name := range names {
+		out[i] = resp.Value{Type: resp.TypeArray}
+		if spec, ok := s.commands.Lookup(string(name.Bytes)); ok {
+			out[i] = spec.Info()
+		}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}
+}
+
+// commandDocs maps the named commands, or all of them if none are named, to
+// their documentation. Unknown commands are left out.
+func (s *Server) commandDocs(names []resp.Value) resp.Value {
+	var specs []*command.Spec
+	if len(names) == 0 {
+		specs = s.commands.All()
+	}
+	for _, name := range names {
+		if spec, ok := s.commands.Lookup(string(name.Bytes)); ok {
+			specs = append(specs, spec)
+		}
+	}
+	out := make([]resp.Value, 0, 2*len(specs))
+	for _, spec := range specs {
+		out = append(out, bulk(spec.Name), spec.Docs())
+	}
+	return resp.Value{Type: resp.TypeMap, Array: out}
+}
+
+// commandList lists the names of the commands, not their subcommands,
+// optionally filtered with FILTERBY ACLCAT category or FILTERBY PATTERN
+// pattern.
+func (s *Server) commandList(args []resp.Value) (resp.Value, error) {
+	keep := func(*command.Spec) bool { return true }
+	switch {
+	case len(args) == 0:
+	case len(args) == 3 && strings.EqualFold(string(args[0].Bytes), "FILTERBY"):
+		arg := string(args[2].Bytes)
+		switch strings.ToUpper(string(args[1].Bytes)) {
+		case "ACLCAT":
+			keep = func(spec *command.Spec) bool {
+				for _, c := range spec.ACLCategories() {
+					if strings.EqualFold(c, arg) {
+						return true
+					}
+				}
+				return false
+			}
+		case "PATTERN":
+			keep = func(spec *command.Spec) bool { return glob.Match(strings.ToLower(arg), spec.Name) }
+		case "MODULE":
+			keep = func(*command.Spec) bool { return false }
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+		}
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR syntax error")}, nil
+	}
+
+	var out []resp.Value
+	for _, spec := range s.commands.All() {
+		if keep(spec) {
+			out = append(out, bulk(spec.Name))
+		}
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
+
+// commandGetKeys replies with the keys of the command in args.
+func (s *Server) commandGetKeys(args []resp.Value) (resp.Value, error) {
+	spec, ok := s.commands.Find(args)
+	if !ok {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid command specified")}, nil
+	}
+	if !spec.CheckArity(len(args)) {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid number of arguments specified for command")}, nil
+	}
+	idx := spec.KeyIndexes(args)
+	if len(idx) == 0 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR The command has no key arguments")}, nil
+	}
+	out := make([]resp.Value, len(idx))
+	for i, n := range idx {
+		out[i] = args[n]
+	}
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/aof"
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const cmdConfig = "config"
+
+// watchConfig puts the parameters that can change while the server runs
+// in force whenever CONFIG SET changes them.
+func (s *Server) watchConfig() {
+	s.applyLimits()
+	s.config.Watch(func() error {
+		s.applyLimits()
+		return nil
+	}, "proto-max-bulk-len", "proto-max-multibulk-len", "proto-inline-max-size")
+
+	s.config.Watch(func() error {
+		if pw := s.config.String("requirepass"); pw != "" {
+			return s.acl.SetUser(acl.DefaultUser, "resetpass", ">"+pw)
+		}
+		return s.acl.SetUser(acl.DefaultUser, "nopass")
+	}, "requirepass")
+
+	s.config.Watch(func() error {
+		rules, err := rdb.ParseSaveRules(s.config.String("save"))
+		if err != nil {
+			return err
+		}
+		s.rdbMu.Lock()
+		defer s.rdbMu.Unlock()
+		s.saveRules = rules
+		return nil
+	}, "save")
+
+	s.config.Watch(func() error {
+		if s.aof == nil {
+			return nil
+		}
+		policy, err := aof.ParseFsyncPolicy(s.config.String("appendfsync"))
+		if err != nil {
+			return err
+		}
+		s.aof.SetPolicy(policy)
+		return nil
+	}, "appendfsync")
+
+	s.config.Watch(func() error {
+		s.repl.SetReplicaWritable(!s.config.Bool("replica-read-only"))
+		return nil
+	}, "replica-read-only")
+
+	s.applyMemoryLimit()
+	s.config.Watch(func() error {
+		s.applyMemoryLimit()
+		return nil
+	}, "maxmemory", "maxmemory-policy", "maxmemory-samples")
+
+	s.applyKeyspaceEvents()
+	s.config.Watch(func() error {
+		s.applyKeyspaceEvents()
+		return nil
+	}, "notify-keyspace-events")
+
+	s.slowlogThreshold.Store(s.config.Int("slowlog-log-slower-than"))
+	s.config.Watch(func() error {
+		s.slowlogThreshold.Store(s.config.Int("slowlog-log-slower-than"))
+		s.slowlog.SetMaxLen(int(s.config.Int("slowlog-max-len")))
+		return nil
+	}, "slowlog-log-slower-than", "slowlog-max-len")
+}
+
+// applyLimits sets the limits clients' input is decoded with from the
+// configuration.
+func (s *Server) applyLimits() {
+	s.limits.Store(&resp.Limits{
+		MaxBulkLen:  int(s.config.Int("proto-max-bulk-len")),
+		MaxArrayLen: int(s.config.Int("proto-max-multibulk-len")),
+		MaxLineLen:  int(s.config.Int("proto-inline-max-size")),
+	})
+}
+
+// configCommand serves CONFIG GET, SET, REWRITE and RESETSTAT.
+func (s *Server) configCommand(args []resp.Value) (resp.Value, error) {
+	sub := args[0]
+	args = args[1:]
+	switch strings.ToLower(string(sub.Bytes)) {
+	case "get":
+		patterns := make([]string, len(args))
+		for i, arg := range args {
+			patterns[i] = string(arg.Bytes)
+		}
+		params := s.config.Get(patterns...)
+		out := make([]resp.Value, 0, 2*len(params))
+		for _, p := range params {
+			out = append(out, bulk(p[0]), bulk(p[1]))
+		}
+		return resp.Value{Type: resp.TypeMap, Array: out}, nil
+	case "set":
+		if len(args)%2 != 0 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'config|set' command")}, nil
+		}
+		pairs := make([]string, len(args))
+		for i, arg := range args {
+			pairs[i] = string(arg.Bytes)
+		}
+		if err := s.config.Apply(pairs...); err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "rewrite":
+		if err := s.config.Rewrite(); err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+		}
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	case "resetstat":
+		s.stats.reset()
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand '" + string(sub.Bytes) + "'. Try CONFIG HELP.")}, nil
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// hello implements HELLO [protover [AUTH username password] [SETNAME name]],
+// which switches the connection to the given protocol version and replies
+// with details about the server and the connection.
+func (s *Server) hello(c *client, args []resp.Value) (resp.Value, error) {
+	proto := c.protocol()
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0].Bytes))
+		if err != nil {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Protocol version is not an integer or out of range")}, nil
+		}
+		if v != 2 && v != 3 {
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("NOPROTO unsupported protocol version")}, nil
+		}
+		proto = v
+	}
+
+	name, setName := "", false
+	user, password, auth := "", "", false
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i].Bytes)); {
+		case opt == "AUTH" && i+2 < len(args):
+			user, password, auth = string(args[i+1].Bytes), string(args[i+2].Bytes), true
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			name, setName = string(args[i+1].Bytes), true
+			if !validClientName(name) {
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Client names cannot contain spaces, newlines or special characters.")}, nil
+			}
+			i++
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Syntax error in HELLO option '" + string(args[i].Bytes) + "'")}, nil
+		}
+	}
+
+	if auth {
+		if !s.authenticate(c, user, password) {
+			return wrongPass(), nil
+		}
+	} else if !c.authenticated {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")}, nil
+	}
+
+	c.proto.Store(int32(proto))
+	if setName {
+		c.name = name
+	}
+	role := "master"
+	if s.repl.IsReplica() {
+		role = "replica"
+	}
+	return resp.Value{Type: resp.TypeMap, Array: []resp.Value{
+		bulk("server"), bulk("redis"),
+		bulk("version"), bulk(Version),
+		bulk("proto"), integer(proto),
+		bulk("id"), integer(int(c.id)),
+		bulk("mode"), bulk("standalone"),
+		bulk("role"), bulk(role),
+		bulk("modules"), {Type: resp.TypeArray, Array: []resp.Value{}},
+	}}, nil
+}
+
+// validClientName reports whether name is made of printable characters
+// other than spaces.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const (
+	// opsSampleInterval is how often the command count is sampled for
+	// instantaneous_ops_per_sec, which averages the last opsSamples.
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+)
+
+// stats are the counters reset by CONFIG RESETSTAT.
+type stats struct {
+	connectionsReceived atomic.Int64
+	commandsProcessed   atomic.Int64
+	// keyspaceHits and keyspaceMisses count the keys read-only commands
+	// looked up that existed and that did not.
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+	// evictedKeys counts the keys deleted to stay within maxmemory.
+	evictedKeys atomic.Int64
+	// commands holds the counters of every command and subcommand, by name.
+	// The map itself never changes.
+	commands map[string]*commandStats
+}
+
+// commandStats are the counters of a command reported by INFO
+// COMMANDSTATS.
+type commandStats struct {
+	calls atomic.Int64
+	usec  atomic.Int64
+	// rejected counts the calls refused before the command ran, and failed
+	// those it replied to with an error.
+	rejected atomic.Int64
+	failed   atomic.Int64
+}
+
+func newStats(commands *command.Table) *stats {
+	st := &stats{commands: make(map[string]*commandStats)}
+	for _, spec := range commands.All() {
+		st.commands[spec.Name] = &commandStats{}
+		for _, sub := range spec.Subcommands {
+			st.commands[sub.Name] = &commandStats{}
+		}
+	}
+	return st
+}
+
+func (st *stats) reset() {
+	st.connectionsReceived.Store(0)
+	st.commandsProcessed.Store(0)
+	st.keyspaceHits.Store(0)
+	st.keyspaceMisses.Store(0)
+	st.evictedKeys.Store(0)
+	for _, cs := range st.commands {
+		cs.calls.Store(0)
+		cs.usec.Store(0)
+		cs.rejected.Store(0)
+		cs.failed.Store(0)
+	}
+}
+
+// record counts a call of the command spec that took d.
+func (st *stats) record(spec *command.Spec, d time.Duration, failed bool) {
+	st.commandsProcessed.Add(1)
+	cs := st.commands[spec.Name]
+	cs.calls.Add(1)
+	cs.usec.Add(d.Microseconds())
+	if failed {
+		cs.failed.Add(1)
+	}
+}
+
+// reject counts a call of the command spec refused before it ran.
+func (st *stats) reject(spec *command.Spec) {
+	st.commands[spec.Name].rejected.Add(1)
+}
+
+// failed reports whether a command's reply or error counts as a failed
+// call. Commands that replied by other means did not fail.
+func failed(out resp.Value, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errReplied) && !errors.Is(err, errHijacked)
+	}
+	return out.Type == resp.TypeError
+}
+
+// countLookups counts the keys of a read-only command as keyspace hits or
+// misses.
+func (s *Server) countLookups(spec *command.Spec, args []resp.Value) {
+	if !spec.Flags.Has(command.ReadOnly) {
+		return
+	}
+	for _, i := range spec.KeyIndexes(args) {
+		if _, err := s.storage.Type(string(args[i].Bytes)); err == nil {
+			s.stats.keyspaceHits.Add(1)
+		} else {
+			s.stats.keyspaceMisses.Add(1)
+		}
+	}
+}
+
+// sampleOps keeps instantaneous_ops_per_sec up to date until the server
+// closes.
+func (s *Server) sampleOps() {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+	var samples [opsSamples]int64
+	var n int
+	last, lastAt := s.stats.commandsProcessed.Load(), time.Now()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			ops := s.stats.commandsProcessed.Load()
+			// A reset makes the count go backwards.
+			delta := max(ops-last, 0)
+			samples[n%opsSamples] = delta * int64(time.Second) / int64(now.Sub(lastAt))
+			n++
+			last, lastAt = ops, now
+
+			var sum int64
+			for _, v := range samples[:min(n, opsSamples)] {
+				sum += v
+			}
+			s.opsPerSec.Store(sum / int64(min(n, opsSamples)))
+		}
+	}
+}
+
+// newRunID returns a random identifier for this run of the server.
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// infoSection writes the fields of an INFO section.
+type infoSection struct {
+	name  string
+	write func(s *Server, b *strings.Builder)
+	// extra sections are only reported when asked for by name or with all
+	// or everything.
+	extra bool
+}
+
+// infoSections are the INFO sections, in the order they are reported.
+var infoSections = []infoSection{
+	{name: "server", write: (*Server).infoServer},
+	{name: "clients", write: (*Server).infoClients},
+	{name: "memory", write: (*Server).infoMemory},
+	{name: "persistence", write: (*Server).infoPersistence},
+	{name: "stats", write: (*Server).infoStats},
+	{name: "replication", write: (*Server).infoReplication},
+	{name: "commandstats", write: (*Server).infoCommandStats, extra: true},
+	{name: "keyspace", write: (*Server).infoKeyspace},
+}
+
+// info implements INFO [section ...]. Without sections it reports the
+// default ones, which are all but commandstats.
+func (s *Server) info(args []resp.Value) (resp.Value, error) {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(string(arg.Bytes))] = true
+	}
+	all := wanted["all"] || wanted["everything"]
+	defaults := len(args) == 0 || wanted["default"] || all
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !wanted[sec.name] && !(defaults && !sec.extra) && !(all && sec.extra) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(sec.name[:1]) + sec.name[1:] + "\r\n")
+		sec.write(s, &b)
+	}
+	return bulk(b.String()), nil
+}
+
+func (s *Server) infoServer(b *strings.Builder) {
+	uptime := time.Since(s.started)
+	fmt.Fprintf(b, "redis_version:%s\r\n", Version)
+	fmt.Fprintf(b, "redis_mode:standalone\r\n")
+	fmt.Fprintf(b, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(b, "arch_bits:%d\r\n", strconv.IntSize)
+	fmt.Fprintf(b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(b, "run_id:%s\r\n", s.runID)
+	fmt.Fprintf(b, "tcp_port:%d\r\n", s.port.Load())
+	fmt.Fprintf(b, "server_time_usec:%d\r\n", time.Now().UnixMicro())
+	fmt.Fprintf(b, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(b, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	fmt.Fprintf(b, "goroutines:%d\r\n", runtime.NumGoroutine())
+	fmt.Fprintf(b, "config_file:%s\r\n", s.config.Path())
+}
+
+func (s *Server) infoClients(b *strings.Builder) {
+	fmt.Fprintf(b, "connected_clients:%d\r\n", s.connectedClients.Load())
+	fmt.Fprintf(b, "blocked_clients:%d\r\n", s.blockedClients.Load())
+}
+
+// infoMemory reports the Go runtime's memory statistics. The heap in use
+// stands for Redis's used_memory, and the memory obtained from the operating
+// system for used_memory_rss. maxmemory limits the storage's estimate of the
+// memory used by keys, used_memory_dataset.
+func (s *Server) infoMemory(b *strings.Builder) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Fprintf(b, "used_memory:%d\r\n", m.HeapAlloc)
+	fmt.Fprintf(b, "used_memory_human:%s\r\n", humanBytes(m.HeapAlloc))
+	fmt.Fprintf(b, "used_memory_rss:%d\r\n", m.Sys)
+	fmt.Fprintf(b, "used_memory_rss_human:%s\r\n", humanBytes(m.Sys))
+	fmt.Fprintf(b, "used_memory_dataset:%d\r\n", s.storage.UsedMemory())
+	fmt.Fprintf(b, "used_memory_dataset_human:%s\r\n", humanBytes(uint64(s.storage.UsedMemory())))
+	fmt.Fprintf(b, "maxmemory:%d\r\n", s.config.Int("maxmemory"))
+	fmt.Fprintf(b, "maxmemory_human:%s\r\n", humanBytes(uint64(s.config.Int("maxmemory"))))
+	fmt.Fprintf(b, "maxmemory_policy:%s\r\n", s.config.String("maxmemory-policy"))
+	fmt.Fprintf(b, "heap_inuse:%d\r\n", m.HeapInuse)
+	fmt.Fprintf(b, "heap_idle:%d\r\n", m.HeapIdle)
+	fmt.Fprintf(b, "heap_released:%d\r\n", m.HeapReleased)
+	fmt.Fprintf(b, "heap_objects:%d\r\n", m.HeapObjects)
+	fmt.Fprintf(b, "stack_inuse:%d\r\n", m.StackInuse)
+	fmt.Fprintf(b, "total_alloc:%d\r\n", m.TotalAlloc)
+	fmt.Fprintf(b, "mallocs:%d\r\n", m.Mallocs)
+	fmt.Fprintf(b, "frees:%d\r\n", m.Frees)
+	fmt.Fprintf(b, "gc_runs:%d\r\n", m.NumGC)
+	fmt.Fprintf(b, "gc_next:%d\r\n", m.NextGC)
+	fmt.Fprintf(b, "gc_pause_total_usec:%d\r\n", m.PauseTotalNs/1000)
+	fmt.Fprintf(b, "gc_last_pause_usec:%d\r\n", m.PauseNs[(m.NumGC+255)%256]/1000)
+}
+
+// humanBytes formats n like Redis's *_human fields.
+func humanBytes(n uint64) string {
+	switch {
+	case n < 1<<10:
+		return strconv.FormatUint(n, 10) + "B"
+	case n < 1<<20:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	}
+}
+
+func (s *Server) infoPersistence(b *strings.Builder) {
+	s.rdbMu.Lock()
+	bgSaving, lastSave, lastSaveDirty, lastBgSaveErr := s.bgSaving, s.lastSave, s.lastSaveDirty, s.lastBgSaveErr
+	s.rdbMu.Unlock()
+	fmt.Fprintf(b, "loading:0\r\n")
+	fmt.Fprintf(b, "rdb_changes_since_last_save:%d\r\n", s.exe.Dirty()-lastSaveDirty)
+	fmt.Fprintf(b, "rdb_bgsave_in_progress:%d\r\n", boolInt(bgSaving))
+	fmt.Fprintf(b, "rdb_last_save_time:%d\r\n", lastSave.Unix())
+	fmt.Fprintf(b, "rdb_last_bgsave_status:%s\r\n", status(lastBgSaveErr))
+	fmt.Fprintf(b, "aof_enabled:%d\r\n", boolInt(s.aof != nil))
+	if s.aof != nil {
+		fmt.Fprintf(b, "aof_last_write_status:%s\r\n", status(s.aof.Err()))
+	}
+}
+
+func (s *Server) infoStats(b *strings.Builder) {
+	fmt.Fprintf(b, "total_connections_received:%d\r\n", s.stats.connectionsReceived.Load())
+	fmt.Fprintf(b, "total_commands_processed:%d\r\n", s.stats.commandsProcessed.Load())
+	fmt.Fprintf(b, "instantaneous_ops_per_sec:%d\r\n", s.opsPerSec.Load())
+	fmt.Fprintf(b, "keyspace_hits:%d\r\n", s.stats.keyspaceHits.Load())
+	fmt.Fprintf(b, "keyspace_misses:%d\r\n", s.stats.keyspaceMisses.Load())
+	fmt.Fprintf(b, "evicted_keys:%d\r\n", s.stats.evictedKeys.Load())
+	fmt.Fprintf(b, "pubsub_channels:%d\r\n", len(s.pubsub.ActiveChannels("")))
+	fmt.Fprintf(b, "pubsub_patterns:%d\r\n", s.pubsub.NumPat())
+}
+
+func (s *Server) infoReplication(b *strings.Builder) {
+	b.WriteString(s.repl.Info())
+}
+
+func (s *Server) infoCommandStats(b *strings.Builder) {
+	names := make([]string, 0, len(s.stats.commands))
+	for name := range s.stats.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		cs := s.stats.commands[name]
+		calls, rejected := cs.calls.Load(), cs.rejected.Load()
+		if calls == 0 && rejected == 0 {
+			continue
+		}
+		usec := cs.usec.Load()
+		var perCall float64
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			name, calls, usec, perCall, rejected, cs.failed.Load())
+	}
+}
+
+// infoKeyspace reports the size of the keyspace as Redis's single database,
+// followed by that of each shard, unless it is empty.
+func (s *Server) infoKeyspace(b *strings.Builder) {
+	shards := s.storage.KeyCounts()
+	var keys, expires int
+	for _, c := range shards {
+		keys += c.Keys
+		expires += c.Expires
+	}
+	if keys == 0 {
+		return
+	}
+	fmt.Fprintf(b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+	for i, c := range shards {
+		fmt.Fprintf(b, "shard%d:keys=%d,expires=%d\r\n", i, c.Keys, c.Expires)
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func status(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// errOOM is the reply to commands refused because memory could not be
+// freed.
+var errOOM = resp.Value{Type: resp.TypeError, Bytes: []byte("OOM command not allowed when used memory > 'maxmemory'.")}
+
+// applyMemoryLimit sets the storage's memory limit from the configuration.
+func (s *Server) applyMemoryLimit() {
+	// The configuration only accepts valid policies.
+	policy, _ := storage.ParseEvictionPolicy(s.config.String("maxmemory-policy"))
+	s.storage.SetMemoryLimit(storage.MemoryLimit{
+		MaxBytes: s.config.Int("maxmemory"),
+		Policy:   policy,
+		Samples:  int(s.config.Int("maxmemory-samples")),
+	})
+}
+
+// freeMemory evicts keys before a command that may use more memory, or
+// before EXEC if such a command is queued, and reports whether the command
+// may run. Other commands run regardless of the memory used, so that
+// clients can still read and delete keys.
+func (s *Server) freeMemory(c *client, spec *command.Spec) (bool, error) {
+	denyOOM := spec.Flags.Has(command.DenyOOM)
+	if spec.Name == cmdExec {
+		for _, val := range c.queued {
+			if queued, ok := s.commands.Find(val.Array); ok && queued.Flags.Has(command.DenyOOM) {
+				denyOOM = true
+				break
+			}
+		}
+	}
+	if !denyOOM {
+		return true, nil
+	}
+	n, err := s.exe.FreeMemory()
+	s.stats.evictedKeys.Add(int64(n))
+	if errors.Is(err, storage.ErrOutOfMemory) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/metrics"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// command latency histograms: most commands take microseconds.
+var latencyBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// commandMetrics counts the commands the executor runs and how long they
+// take, by name.
+type commandMetrics struct {
+	calls   *metrics.CounterVec
+	latency *metrics.HistogramVec
+}
+
+func (m *commandMetrics) ObserveCommand(name string, d time.Duration) {
+	m.calls.With(name).Inc()
+	m.latency.With(name).Observe(d.Seconds())
+}
+
+// RegisterMetrics registers the server's metrics in r: the commands run by
+// the executor, client connections, the number of keys in each shard of the
+// keyspace, memory usage and evictions, and those of the Go runtime. It must
+// be called before the server serves clients.
+func (s *Server) RegisterMetrics(r *metrics.Registry) {
+	s.exe.SetObserver(&commandMetrics{
+		calls:   r.NewCounterVec("kv_commands_total", "Number of commands run, by command.", "command"),
+		latency: r.NewHistogramVec("kv_command_duration_seconds", "Time taken to run commands, by command.", "command", latencyBuckets),
+	})
+
+	r.NewGaugeFunc("kv_connected_clients", "Number of client connections open.", func() float64 {
+		return float64(s.connectedClients.Load())
+	})
+	r.NewCounterFunc("kv_connections_received_total", "Number of client connections accepted.", func() float64 {
+		return float64(s.stats.connectionsReceived.Load())
+	})
+
+	r.NewGaugeVecFunc("kv_keys", "Number of keys, by shard.", "shard", func(emit func(string, float64)) {
+		for i, c := range s.storage.KeyCounts() {
+			emit(strconv.Itoa(i), float64(c.Keys))
+		}
+	})
+	r.NewGaugeVecFunc("kv_expiring_keys", "Number of keys with an expiry, by shard.", "shard", func(emit func(string, float64)) {
+		for i, c := range s.storage.KeyCounts() {
+			emit(strconv.Itoa(i), float64(c.Expires))
+		}
+	})
+
+	r.NewGaugeFunc("kv_dataset_bytes", "Estimated memory used by keys and their values, in bytes.", func() float64 {
+		return float64(s.storage.UsedMemory())
+	})
+	r.NewCounterFunc("kv_evicted_keys_total", "Number of keys evicted to stay within maxmemory.", func() float64 {
+		return float64(s.stats.evictedKeys.Load())
+	})
+
+	r.RegisterRuntime()
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const cmdMonitor = "monitor"
+
+// monitorHub fans the commands clients run out to the clients in MONITOR
+// mode.
+type monitorHub struct {
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+	// n is the number of monitors, so that feeding none of them costs a
+	// single atomic load.
+	n atomic.Int32
+}
+
+func (h *monitorHub) add(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = make(map[*client]struct{})
+	}
+	h.clients[c] = struct{}{}
+	h.n.Store(int32(len(h.clients)))
+}
+
+func (h *monitorHub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+	h.n.Store(int32(len(h.clients)))
+}
+
+// feed sends a command run by c at the given time to every monitor. It
+// never blocks: monitors too slow to keep up are disconnected.
+func (h *monitorHub) feed(at time.Time, c *client, args []resp.Value) {
+	if h.n.Load() == 0 {
+		return
+	}
+	line := resp.Value{Type: resp.TypeSimpleString, Bytes: []byte(monitorLine(at, c, args))}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for m := range h.clients {
+		m.Deliver(line)
+	}
+}
+
+// monitorLine formats a command as Redis's MONITOR does, as in
+// 1700000000.123456 [0 127.0.0.1:50000] "set" "k" "v".
+func monitorLine(at time.Time, c *client, args []resp.Value) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [0 %s]", at.Unix(), at.Nanosecond()/1000, c.conn.RemoteAddr())
+	for _, arg := range args {
+		b.WriteByte(' ')
+		b.WriteString(quote(arg.Bytes))
+	}
+	return b.String()
+}
+
+// quote returns p in double quotes, with quotes, backslashes and
+// non-printable bytes escaped.
+func quote(p []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range p {
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// monitored reports whether monitors are shown the command spec.
+// Administrative commands are not, like those flagged to skip them.
+func monitored(spec *command.Spec) bool {
+	return !spec.Flags.Has(command.SkipMonitor) && !spec.Flags.Has(command.Admin)
+}
+
+// monitor implements MONITOR. The client is fed commands from right after
+// the reply, and its connection stops serving commands, see serveMonitor.
+func (s *Server) monitor(c *client) (resp.Value, error) {
+	c.reply(resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")})
+	s.monitors.add(c)
+	c.monitoring = true
+	return resp.Value{}, errReplied
+}
+
+// serveMonitor keeps a client in MONITOR mode until it disconnects. Its
+// input is read and discarded, only to notice the disconnection.
+func (s *Server) serveMonitor(c *client) {
+	defer s.monitors.remove(c)
+	io.Copy(io.Discard, c.br)
+}
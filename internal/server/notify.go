@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// eventClasses maps the characters of notify-keyspace-events to the classes
+// of events they enable, as in Redis.
+var eventClasses = map[rune]storage.EventClass{
+	'g': storage.EventGeneric,
+	'$': storage.EventString,
+	'l': storage.EventList,
+	's': storage.EventSet,
+	'h': storage.EventHash,
+	'z': storage.EventZSet,
+	't': storage.EventStream,
+	'x': storage.EventExpired,
+	'e': storage.EventEvicted,
+	'n': storage.EventNew,
+}
+
+// allEventClasses are the classes 'A' stands for: all of them but n, which
+// is only enabled explicitly.
+const allEventClasses = storage.EventGeneric | storage.EventString | storage.EventList |
+	storage.EventSet | storage.EventHash | storage.EventZSet | storage.EventStream |
+	storage.EventExpired | storage.EventEvicted
+
+// keyspaceEvents is a notify-keyspace-events setting: the channels events
+// are published to, and the classes of events published.
+type keyspaceEvents struct {
+	keyspace bool // K: __keyspace@0__:<key>, with the event as the message
+	keyevent bool // E: __keyevent@0__:<event>, with the key as the message
+	classes  storage.EventClass
+}
+
+// parseKeyspaceEvents parses a notify-keyspace-events setting, such as
+// "KEA" or "Ex".
+func parseKeyspaceEvents(v string) (keyspaceEvents, error) {
+	var ev keyspaceEvents
+	for _, r := range v {
+		switch r {
+		case 'K':
+			ev.keyspace = true
+		case 'E':
+			ev.keyevent = true
+		case 'A':
+			ev.classes |= allEventClasses
+		default:
+			class, ok := eventClasses[r]
+			if !ok {
+				return keyspaceEvents{}, fmt.Errorf("invalid event class %q", r)
+			}
+			ev.classes |= class
+		}
+	}
+	return ev, nil
+}
+
+// applyKeyspaceEvents installs a notifier publishing the keyspace events
+// selected by the configuration, or none if they select nothing.
+func (s *Server) applyKeyspaceEvents() {
+	// The configuration only accepts valid settings.
+	ev, _ := parseKeyspaceEvents(s.config.String("notify-keyspace-events"))
+	if !(ev.keyspace || ev.keyevent) || ev.classes == 0 {
+		s.storage.SetNotifier(nil)
+		return
+	}
+	s.storage.SetNotifier(func(class storage.EventClass, event, key string) {
+		if class&ev.classes == 0 {
+			return
+		}
+		if ev.keyspace {
+			s.pubsub.Publish("__keyspace@0__:"+key, []byte(event))
+		}
+		if ev.keyevent {
+			s.pubsub.Publish("__keyevent@0__:"+event, []byte(key))
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/config"
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/replication"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+// params are the server's configuration parameters.
+var params = []*config.Param{
+	// Network.
+	{
+		Name: "port", Kind: config.Int, Default: "6379", Max: 65535, Immutable: true,
+		Usage: "port to accept plain TCP connections on, or 0 to disable",
+	},
+	{
+		Name: "tls-port", Kind: config.Int, Default: "0", Max: 65535, Immutable: true,
+		Usage: "port to accept TLS connections on, or 0 to disable",
+	},
+	{
+		Name: "metrics-port", Kind: config.Int, Default: "0", Max: 65535, Immutable: true,
+		Usage: "port to serve Prometheus metrics on over HTTP, at /metrics, or 0 to disable",
+	},
+	{
+		Name:  "tls-cert-file",
+		Usage: "server certificate for TLS connections, in PEM format",
+	},
+	{
+		Name:  "tls-key-file",
+		Usage: "private key of the server certificate, in PEM format",
+	},
+	{
+		Name:  "tls-ca-cert-file",
+		Usage: "certificates of the authorities client certificates are verified against",
+	},
+	{
+		Name: "tls-auth-clients", Kind: config.Enum, Default: "yes", Choices: []string{"yes", "no", "optional"},
+		Usage: "whether TLS clients must present a certificate: yes, no or optional",
+	},
+	{
+		Name: "tls-auth-clients-user", Kind: config.Bool, Default: "no", Immutable: true,
+		Usage: "authenticate TLS clients as the ACL user named by the common name of their certificate",
+	},
+	{
+		Name: "proto-max-bulk-len", Kind: config.Memory, Default: "512mb", Min: 1 << 20,
+		Usage: "longest bulk string accepted from clients, in bytes",
+	},
+	{
+		Name: "proto-max-multibulk-len", Kind: config.Int, Default: strconv.Itoa(resp.DefaultLimits.MaxArrayLen), Min: 1,
+		Usage: "most elements accepted in an aggregate from clients",
+	},
+	{
+		Name: "proto-inline-max-size", Kind: config.Memory, Default: "64kb", Min: 1 << 10,
+		Usage: "longest line accepted from clients, in bytes, as in inline commands",
+	},
+	{
+		Name: "storage-shards", Kind: config.Int, Default: strconv.Itoa(storage.DefaultShards), Min: 1, Max: 1 << 16, Immutable: true,
+		Usage: "number of independently locked shards the keyspace is spread over",
+	},
+
+	// Memory management.
+	{
+		Name: "maxmemory", Kind: config.Memory, Default: "0",
+		Usage: "most memory keys may use, in bytes, before maxmemory-policy applies, or 0 for no limit",
+	},
+	{
+		Name: "maxmemory-policy", Kind: config.Enum, Default: storage.NoEviction.String(), Choices: storage.EvictionPolicies(),
+		Usage: "keys evicted once maxmemory is reached, or noeviction to refuse writes instead",
+	},
+	{
+		Name: "maxmemory-samples", Kind: config.Int, Default: strconv.Itoa(storage.DefaultEvictionSamples), Min: 1, Max: 64,
+		Usage: "keys sampled per shard to pick one to evict: more samples approximate the policy better",
+	},
+
+	// Security.
+	{
+		Name:  "requirepass",
+		Usage: "password of the default user",
+	},
+	{
+		Name: "aclfile", Immutable: true,
+		Usage: "file to load users from at startup, and for ACL LOAD and ACL SAVE",
+	},
+
+	// Persistence.
+	{
+		Name: "dir", Default: ".", Immutable: true,
+		Usage: "working directory for persistence files",
+	},
+	{
+		Name: "dbfilename", Default: "dump.rdb", Immutable: true,
+		Usage: "name of the snapshot file",
+	},
+	{
+		Name: "save", Default: "3600 1 300 100 60 10000", Repeat: true,
+		Usage: `automatic snapshot rules as "<seconds> <changes> ...", or "" to disable`,
+		Check: func(v string) error {
+			_, err := rdb.ParseSaveRules(v)
+			return err
+		},
+	},
+	{
+		Name: "appendonly", Kind: config.Bool, Default: "no", Immutable: true,
+		Usage: "enable append-only file persistence",
+	},
+	{
+		Name: "appendfilename", Default: "appendonly.aof", Immutable: true,
+		Usage: "name of the append-only file",
+	},
+	{
+		Name: "appendfsync", Kind: config.Enum, Default: "everysec", Choices: []string{"always", "everysec", "no"},
+		Usage: "fsync policy for the append-only file: always, everysec or no",
+	},
+
+	// Replication.
+	{
+		Name: "replicaof", Immutable: true,
+		Usage: `primary to replicate from as "<host>:<port>" or "<host> <port>"`,
+		Check: func(v string) error {
+			if v != "" && ReplicaOfAddr(v) == "" {
+				return errors.New("argument must be <host> <port>")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "replica-read-only", Kind: config.Bool, Default: "yes",
+		Usage: "reject client writes while replicating",
+	},
+	{
+		Name: "repl-backlog-size", Kind: config.Memory, Default: strconv.Itoa(replication.DefaultBacklogSize), Min: 1, Immutable: true,
+		Usage: "size in bytes of the replication backlog",
+	},
+
+	// Event notification.
+	{
+		Name: "notify-keyspace-events",
+		Usage: `keyspace events published to subscribers, as characters: K and E for the __keyspace@0__ and ` +
+			`__keyevent@0__ channels, and g$lshztxen or A for the classes of events, or "" to disable`,
+		Check: func(v string) error {
+			_, err := parseKeyspaceEvents(v)
+			return err
+		},
+	},
+
+	// Monitoring.
+	{
+		Name: "slowlog-log-slower-than", Kind: config.Int, Default: "10000", Min: -1,
+		Usage: "log commands taking at least this many microseconds in the slow log, or -1 to disable",
+	},
+	{
+		Name: "slowlog-max-len", Kind: config.Int, Default: "128",
+		Usage: "most commands kept in the slow log",
+	},
+}
+
+// Params returns the server's configuration parameters, for config.New.
+func Params() []*config.Param {
+	return params
+}
+
+// ReplicaOfAddr returns the address of the primary a replicaof parameter
+// names, or "" if it is malformed.
+func ReplicaOfAddr(v string) string {
+	if host, port, ok := strings.Cut(strings.TrimSpace(v), " "); ok {
+		v = net.JoinHostPort(host, strings.TrimSpace(port))
+	}
+	if _, port, err := net.SplitHostPort(v); err != nil || port == "" {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+func (s *Server) bgRewriteAOF(args []resp.Value) (resp.Value, error) {
+	if s.aof == nil {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR append only file is not enabled")}, nil
+	}
+
+	if err := s.startAOFRewrite(); err != nil {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("Background append only file rewriting started")}, nil
+}
+
+// startAOFRewrite snapshots the keyspace and compacts the append-only file
+// from it in the background.
+func (s *Server) startAOFRewrite() error {
+	var snap *storage.Snapshot
+	var err error
+	s.exe.Barrier(func() {
+		if err = s.aof.StartRewrite(); err == nil {
+			snap = s.storage.Snapshot()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.aof.FinishRewrite(snap); err != nil {
+			log.Println("background append only file rewrite failed:", err)
+			return
+		}
+		log.Println("background append only file rewrite finished")
+	}()
+	return nil
+}
+
+// snapshot takes a point-in-time snapshot together with the number of writes
+// it reflects.
+func (s *Server) snapshot() (*storage.Snapshot, int64) {
+	var snap *storage.Snapshot
+	var dirty int64
+	s.exe.Barrier(func() {
+		snap = s.storage.Snapshot()
+		dirty = s.exe.Dirty()
+	})
+	return snap, dirty
+}
+
+func (s *Server) save(args []resp.Value) (resp.Value, error) {
+	if s.rdbPath == "" {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR snapshots are not enabled")}, nil
+	}
+
+	s.rdbMu.Lock()
+	defer s.rdbMu.Unlock()
+	if s.bgSaving {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Background save already in progress")}, nil
+	}
+	snap, dirty := s.snapshot()
+	if err := rdb.Save(s.rdbPath, snap); err != nil {
+		log.Println("save failed:", err)
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}, nil
+	}
+	s.lastSave = time.Now()
+	s.lastSaveDirty = dirty
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+func (s *Server) bgSave(args []resp.Value) (resp.Value, error) {
+	if s.rdbPath == "" {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR snapshots are not enabled")}, nil
+	}
+	if !s.startBgSave() {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Background save already in progress")}, nil
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("Background saving started")}, nil
+}
+
+// startBgSave snapshots the keyspace and writes it in the background. It
+// returns false if a background save is already running.
+func (s *Server) startBgSave() bool {
+	s.rdbMu.Lock()
+	if s.bgSaving {
+		s.rdbMu.Unlock()
+		return false
+	}
+	s.bgSaving = true
+	s.lastBgSaveTry = time.Now()
+	s.rdbMu.Unlock()
+
+	snap, dirty := s.snapshot()
+	go func() {
+		err := rdb.Save(s.rdbPath, snap)
+
+		s.rdbMu.Lock()
+		defer s.rdbMu.Unlock()
+		s.bgSaving = false
+		s.lastBgSaveErr = err
+		if err != nil {
+			log.Println("background save failed:", err)
+			return
+		}
+		s.lastSave = time.Now()
+		s.lastSaveDirty = dirty
+		log.Println("background saving terminated with success")
+	}()
+	return true
+}
+
+func (s *Server) lastSaveTime(args []resp.Value) (resp.Value, error) {
+	s.rdbMu.Lock()
+	defer s.rdbMu.Unlock()
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.FormatInt(s.lastSave.Unix(), 10))}, nil
+}
+
+// saveCron starts a BGSAVE whenever one of the save rules is satisfied.
+func (s *Server) saveCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.saveDue() {
+				s.startBgSave()
+			}
+		}
+	}
+}
+
+func (s *Server) saveDue() bool {
+	s.rdbMu.Lock()
+	defer s.rdbMu.Unlock()
+	if s.bgSaving {
+		return false
+	}
+	// Back off for a while after a failed save rather than retrying every
+	// second.
+	if s.lastBgSaveErr != nil && time.Since(s.lastBgSaveTry) < 5*time.Second {
+		return false
+	}
+	elapsed := time.Since(s.lastSave)
+	changes := s.exe.Dirty() - s.lastSaveDirty
+	for _, r := range s.saveRules {
+		if changes >= r.Changes && elapsed >= time.Duration(r.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/pubsub"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+// subscribedCommands are the only commands accepted from a client with
+// active subscriptions.
+var subscribedCommands = map[string]bool{
+	cmdSubscribe:    true,
+	cmdUnsubscribe:  true,
+	cmdPSubscribe:   true,
+	cmdPUnsubscribe: true,
+	cmdPing:         true,
+}
+
+// subscribe adds subscriptions. The hub confirms each one with its own
+// reply, ordered with the messages delivered to the client.
+func (s *Server) subscribe(c *client, args []resp.Value, name string) (resp.Value, error) {
+	for _, arg := range args {
+		if name == cmdSubscribe {
+			c.subscriptions = s.pubsub.Subscribe(c, string(arg.Bytes))
+		} else {
+			c.subscriptions = s.pubsub.PSubscribe(c, string(arg.Bytes))
+		}
+	}
+	return resp.Value{}, errReplied
+}
+
+// unsubscribe removes the given subscriptions, or all of them of the kind if
+// none are given.
+func (s *Server) unsubscribe(c *client, args []resp.Value, name string) (resp.Value, error) {
+	var names []string
+	for _, arg := range args {
+		names = append(names, string(arg.Bytes))
+	}
+	if len(args) == 0 {
+		if name == cmdUnsubscribe {
+			names = s.pubsub.Channels(c)
+		} else {
+			names = s.pubsub.Patterns(c)
+		}
+	}
+
+	if len(names) == 0 {
+		return pubsub.Confirmation(name, nil, c.subscriptions), nil
+	}
+	for _, n := range names {
+		if name == cmdUnsubscribe {
+			c.subscriptions = s.pubsub.Unsubscribe(c, n)
+		} else {
+			c.subscriptions = s.pubsub.PUnsubscribe(c, n)
+		}
+	}
+	return resp.Value{}, errReplied
+}
+
+func (s *Server) publish(args []resp.Value) (resp.Value, error) {
+	n := s.pubsub.Publish(string(args[0].Bytes), args[1].Bytes)
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))}, nil
+}
+
+func (s *Server) pubsubCommand(args []resp.Value) (resp.Value, error) {
+
+	sub := strings.ToLower(string(args[0].Bytes))
+	switch {
+	case sub == "channels" && len(args) <= 2:
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1].Bytes)
+		}
+		channels := s.pubsub.ActiveChannels(pattern)
+		out := make([]resp.Value, len(channels))
+		for i, ch := range channels {
+			out[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(ch)}
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}, nil
+	case sub == "numsub":
+		out := make([]resp.Value, 0, 2*(len(args)-1))
+		for _, ch := range args[1:] {
+			n := s.pubsub.NumSub(string(ch.Bytes))
+			out = append(out,
+				resp.Value{Type: resp.TypeBulkString, Bytes: ch.Bytes},
+				resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))},
+			)
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}, nil
+	case sub == "numpat" && len(args) == 1:
+		return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(s.pubsub.NumPat()))}, nil
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand or wrong number of arguments for '" + string(args[0].Bytes) + "'. Try PUBSUB HELP.")}, nil
+	}
+}
+
+// subscribedPing replies to PING in subscribed mode, where replies must have
+// the shape of a message.
+func subscribedPing(args []resp.Value) (resp.Value, error) {
+	if len(args) > 1 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'ping' command")}, nil
+	}
+	msg := []byte{}
+	if len(args) == 1 {
+		msg = args[0].Bytes
+	}
+	return resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+		{Type: resp.TypeBulkString, Bytes: []byte("pong")},
+		{Type: resp.TypeBulkString, Bytes: msg},
+	}}, nil
+}
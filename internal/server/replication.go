@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+func (s *Server) replicaOf(args []resp.Value) (resp.Value, error) {
+	host, port := string(args[0].Bytes), string(args[1].Bytes)
+
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		s.repl.ReplicaOf("")
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Invalid master port")}, nil
+	}
+	if !s.repl.ReplicaOf(net.JoinHostPort(host, port)) {
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK Already connected to specified master")}, nil
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// replConf handles the options a replica announces before PSYNC. Only the
+// listening port is remembered; capabilities are accepted and ignored.
+func (s *Server) replConf(c *client, args []resp.Value) (resp.Value, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for 'replconf' command")}, nil
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i].Bytes)) {
+		case "listening-port":
+			port, err := strconv.Atoi(string(args[i+1].Bytes))
+			if err != nil || port < 0 || port > 65535 {
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not an integer or out of range")}, nil
+			}
+			c.replListeningPort = port
+		case "capa", "ip-address", "ack", "getack":
+		default:
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Unrecognized REPLCONF option: " + string(args[i].Bytes))}, nil
+		}
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// psync turns the connection into a replication link and serves it until the
+// link breaks.
+func (s *Server) psync(c *client, args []resp.Value) (resp.Value, error) {
+	c.replica = true
+	s.publishState(c)
+	c.flush()
+	s.repl.ServeReplica(c.conn, c.dec, c.replListeningPort, args)
+	return resp.Value{}, errHijacked
+}
+
+func (s *Server) role(args []resp.Value) (resp.Value, error) {
+	return s.repl.Role(), nil
+}
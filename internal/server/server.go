@@ -0,0 +1,524 @@
+// Package server accepts client connections and dispatches their commands,
+// handling server-level commands itself and passing the rest to the executor.
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/aof"
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/config"
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/pubsub"
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/replication"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/slowlog"
+	"github.com/elmq0022/kv-store/internal/storage"
+)
+
+const (
+	cmdBgRewriteAOF = "bgrewriteaof"
+	cmdSave         = "save"
+	cmdBgSave       = "bgsave"
+	cmdLastSave     = "lastsave"
+	cmdReplicaOf    = "replicaof"
+	cmdSlaveOf      = "slaveof"
+	cmdReplConf     = "replconf"
+	cmdPSync        = "psync"
+	cmdSync         = "sync"
+	cmdRole         = "role"
+	cmdInfo         = "info"
+	cmdSubscribe    = "subscribe"
+	cmdUnsubscribe  = "unsubscribe"
+	cmdPSubscribe   = "psubscribe"
+	cmdPUnsubscribe = "punsubscribe"
+	cmdPublish      = "publish"
+	cmdPubSub       = "pubsub"
+	cmdPing         = "ping"
+	cmdMulti        = "multi"
+	cmdExec         = "exec"
+	cmdDiscard      = "discard"
+	cmdWatch        = "watch"
+	cmdUnwatch      = "unwatch"
+	cmdHello        = "hello"
+)
+
+// Version is the Redis version the server is compatible with, as reported to
+// clients.
+const Version = "7.2.0"
+
+var (
+	// errHijacked is returned by execute when a command took over the
+	// connection and it must not be read from or written to again.
+	errHijacked = errors.New("connection hijacked")
+	// errReplied is returned by execute when the command's replies were
+	// already queued, as the subscribe family does with one per argument.
+	errReplied = errors.New("reply already sent")
+	// errDisconnected is returned by execute when the client went away
+	// while the command was waiting.
+	errDisconnected = errors.New("client disconnected")
+)
+
+type Options struct {
+	// AOF, if set, is the append-only log compacted by BGREWRITEAOF. It must
+	// already be registered as a propagator of the executor.
+	AOF *aof.AOF
+	// RDBPath is the snapshot file written by SAVE and BGSAVE. Empty
+	// disables snapshots.
+	RDBPath string
+	// SaveRules trigger a BGSAVE automatically.
+	SaveRules []rdb.SaveRule
+	// ReplicaOf is the host:port of a primary to replicate from at startup.
+	ReplicaOf string
+	// Replication configures the replication backlog and replica behaviour.
+	Replication replication.Options
+	// RequirePass, if set, is the password of the default user, which
+	// otherwise needs none.
+	RequirePass string
+	// ACLFile is the file ACL LOAD and ACL SAVE read users from and write
+	// them to.
+	ACLFile string
+	// TLSAuthClientsUser authenticates TLS clients presenting a certificate
+	// as the ACL user named by its common name.
+	TLSAuthClientsUser bool
+	// Config holds the parameters of Params, as read and changed by CONFIG.
+	// Nil means the defaults.
+	Config *config.Config
+}
+
+type Server struct {
+	storage  storage.Storage
+	exe      *executor.Executor
+	aof      *aof.AOF
+	commands *command.Table
+	acl      *acl.ACL
+	aclFile  string
+	config   *config.Config
+	// limits bound the input decoded from clients.
+	limits atomic.Pointer[resp.Limits]
+
+	// started, runID and port identify the server in INFO.
+	started time.Time
+	runID   string
+	port    atomic.Int64
+
+	stats            *stats
+	connectedClients atomic.Int64
+	blockedClients   atomic.Int64
+	opsPerSec        atomic.Int64
+
+	clients  clientRegistry
+	pause    clientPause
+	monitors monitorHub
+
+	slowlog          *slowlog.Log
+	slowlogThreshold atomic.Int64 // microseconds
+
+	tlsAuthClientsUser bool
+
+	rdbPath   string
+	saveRules []rdb.SaveRule
+
+	repl   *replication.Manager
+	pubsub *pubsub.Hub
+
+	// rdbMu guards the snapshot state below.
+	rdbMu         sync.Mutex
+	bgSaving      bool
+	lastSave      time.Time
+	lastSaveDirty int64
+	lastBgSaveTry time.Time
+	lastBgSaveErr error
+
+	// conns are the connections being served and handlers counts their
+	// goroutines, so that Close can end them. Once closed is set new
+	// connections are turned away.
+	connsMu  sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	handlers sync.WaitGroup
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(s storage.Storage, exe *executor.Executor, opts Options) *Server {
+	srv := &Server{
+		storage:   s,
+		exe:       exe,
+		aof:       opts.AOF,
+		commands:  command.NewTable(executor.Commands(), serverCommands),
+		aclFile:   opts.ACLFile,
+		rdbPath:   opts.RDBPath,
+		saveRules: opts.SaveRules,
+		pubsub:    pubsub.NewHub(),
+		lastSave:  time.Now(),
+		started:   time.Now(),
+		runID:     newRunID(),
+		done:      make(chan struct{}),
+
+		tlsAuthClientsUser: opts.TLSAuthClientsUser,
+	}
+	srv.acl = acl.New(srv.commands)
+	srv.stats = newStats(srv.commands)
+	if opts.RequirePass != "" {
+		if err := srv.acl.SetUser(acl.DefaultUser, "resetpass", ">"+opts.RequirePass); err != nil {
+			panic(err)
+		}
+	}
+	srv.config = opts.Config
+	if srv.config == nil {
+		srv.config = config.New(Params())
+	}
+	srv.slowlog = slowlog.New(int(srv.config.Int("slowlog-max-len")))
+	srv.watchConfig()
+
+	replOpts := opts.Replication
+	if srv.aof != nil && replOpts.OnFullSync == nil {
+		// The log no longer describes the keyspace after a full resync.
+		replOpts.OnFullSync = func() {
+			if err := srv.startAOFRewrite(); err != nil {
+				log.Println("append only file rewrite after full resync:", err)
+			}
+		}
+	}
+	srv.repl = replication.New(s, exe, replOpts)
+	if opts.ReplicaOf != "" {
+		srv.repl.ReplicaOf(opts.ReplicaOf)
+	}
+
+	// The save rules can change at any time.
+	if srv.rdbPath != "" {
+		go srv.saveCron()
+	}
+	go srv.sampleOps()
+	return srv
+}
+
+// Close stops the server's background tasks and replication links, then
+// closes every connection and waits for the commands they were running, so
+// that none runs once it returns. It does not close listeners passed to
+// Serve.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	err := s.repl.Close()
+	s.connsMu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+	s.handlers.Wait()
+	return err
+}
+
+// track registers conn as being served, unless the server is closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+	s.handlers.Done()
+}
+
+// Serve accepts connections on ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		s.repl.SetListeningPort(addr.Port)
+		s.port.Store(int64(addr.Port))
+	}
+	return s.serve(ln)
+}
+
+func (s *Server) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Println("accept error:", err)
+			continue
+		}
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.handleConn(conn)
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	var cn string
+	if tc, ok := conn.(*tls.Conn); ok {
+		var err error
+		if cn, err = handshake(tc); err != nil {
+			log.Println("tls handshake:", err)
+			return
+		}
+	}
+	c := newClient(conn)
+	c.authenticated = s.acl.DefaultLogin()
+	if cn != "" && s.tlsAuthClientsUser {
+		s.authenticateCertificate(c, cn)
+	}
+	defer func() {
+		s.pubsub.RemoveAll(c)
+		s.unwatchKeys(c.watched)
+		c.flush()
+	}()
+	s.publishState(c)
+	s.clients.add(c)
+	defer s.clients.remove(c)
+
+	s.stats.connectionsReceived.Add(1)
+	s.connectedClients.Add(1)
+	defer s.connectedClients.Add(-1)
+	for {
+		if c.monitoring {
+			s.serveMonitor(c)
+			return
+		}
+		c.dec.SetLimits(*s.limits.Load())
+		input, err := c.dec.Decode()
+		if err != nil {
+			// Malformed input leaves the connection out of sync, so it is
+			// the one kind of error that ends it.
+			if errors.Is(err, resp.ErrProtocol) {
+				c.reply(resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())})
+			}
+			return
+		}
+
+		output, err := s.execute(c, input)
+		s.publishState(c)
+		switch {
+		case errors.Is(err, errHijacked), errors.Is(err, errDisconnected):
+			return
+		case errors.Is(err, errReplied):
+			continue
+		case err != nil:
+			output = unexpectedError(err)
+		}
+		if c.replyOff || c.skipReply {
+			c.skipReply = false
+			continue
+		}
+		c.reply(output)
+		if c.closeAfterReply {
+			return
+		}
+	}
+}
+
+// execute runs a single command, handling server-level commands before
+// falling through to the executor.
+func (s *Server) execute(c *client, val resp.Value) (resp.Value, error) {
+	if val.Type != resp.TypeArray || len(val.Array) == 0 {
+		return s.exe.Execute(val)
+	}
+
+	name := strings.ToLower(string(val.Array[0].Bytes))
+	spec, ok := s.commands.Find(val.Array)
+	if !ok {
+		c.abortTransaction()
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown command '" + string(val.Array[0].Bytes) + "'")}, nil
+	}
+	c.cmd = spec.Name
+	if !spec.CheckArity(len(val.Array)) {
+		c.abortTransaction()
+		s.stats.reject(spec)
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR wrong number of arguments for '" + spec.Name + "' command")}, nil
+	}
+	// Authorization is checked here, before any command runs, so that
+	// neither the server's commands nor the executor's need to.
+	if !spec.Flags.Has(command.NoAuth) {
+		if !c.authenticated {
+			c.abortTransaction()
+			s.stats.reject(spec)
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("NOAUTH Authentication required.")}, nil
+		}
+		if err := s.checkPermissions(c, spec, val.Array); err != nil {
+			var d *acl.Denial
+			if !errors.As(err, &d) {
+				// The user was deleted: log the client out for good.
+				return resp.Value{}, errDisconnected
+			}
+			c.abortTransaction()
+			s.stats.reject(spec)
+			return resp.Value{Type: resp.TypeError, Bytes: []byte(d.Error())}, nil
+		}
+	}
+	// RESP3 clients can tell pushed messages from replies, so they may run
+	// any command while subscribed.
+	if c.subscriptions > 0 && c.protocol() == 2 {
+		if !subscribedCommands[name] {
+			c.abortTransaction()
+			s.stats.reject(spec)
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Can't execute '" + name + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")}, nil
+		}
+		if name == cmdPing {
+			return subscribedPing(val.Array[1:])
+		}
+	}
+	if spec.Flags.Has(command.Write) && s.repl.RejectsWrites() {
+		c.abortTransaction()
+		s.stats.reject(spec)
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("READONLY You can't write against a read only replica.")}, nil
+	}
+	// A write that cannot be logged would be acknowledged but lost on
+	// restart.
+	if s.aof != nil && s.writes(c, spec) {
+		if err := s.aof.Err(); err != nil {
+			c.abortTransaction()
+			s.stats.reject(spec)
+			return resp.Value{Type: resp.TypeError, Bytes: []byte("MISCONF Errors writing to the AOF file: " + err.Error())}, nil
+		}
+	}
+	if err := s.waitUnpaused(c, spec); err != nil {
+		return resp.Value{}, err
+	}
+	if ok, err := s.freeMemory(c, spec); err != nil {
+		return resp.Value{}, err
+	} else if !ok {
+		c.abortTransaction()
+		s.stats.reject(spec)
+		return errOOM, nil
+	}
+
+	switch name {
+	case cmdMulti:
+		return s.multi(c, val.Array[1:])
+	case cmdExec:
+		return s.exec(c, val.Array[1:])
+	case cmdDiscard:
+		return s.discard(c, val.Array[1:])
+	case cmdWatch:
+		return s.watch(c, val.Array[1:])
+	case cmdUnwatch:
+		return s.unwatch(c, val.Array[1:])
+	}
+	if c.multi {
+		return s.queue(c, spec, val)
+	}
+	return s.call(c, spec, name, val, s.exe)
+}
+
+// runner executes the commands the server does not handle itself: the
+// executor, or a transaction while EXEC runs.
+type runner interface {
+	Execute(val resp.Value) (resp.Value, error)
+}
+
+// call runs a single command and records it in the statistics.
+func (s *Server) call(c *client, spec *command.Spec, name string, val resp.Value, r runner) (resp.Value, error) {
+	s.countLookups(spec, val.Array)
+	start := time.Now()
+	if monitored(spec) {
+		s.monitors.feed(start, c, redacted(spec, val.Array))
+	}
+	out, err := s.dispatch(c, name, val, r)
+	d := time.Since(start)
+	s.stats.record(spec, d, failed(out, err))
+	s.logIfSlow(c, spec, val.Array, d)
+	return out, err
+}
+
+// dispatch runs a single command.
+func (s *Server) dispatch(c *client, name string, val resp.Value, r runner) (resp.Value, error) {
+	switch name {
+	case cmdBgRewriteAOF:
+		return s.bgRewriteAOF(val.Array[1:])
+	case cmdSave:
+		return s.save(val.Array[1:])
+	case cmdBgSave:
+		return s.bgSave(val.Array[1:])
+	case cmdLastSave:
+		return s.lastSaveTime(val.Array[1:])
+	case cmdReplicaOf, cmdSlaveOf:
+		return s.replicaOf(val.Array[1:])
+	case cmdReplConf:
+		return s.replConf(c, val.Array[1:])
+	case cmdPSync, cmdSync:
+		return s.psync(c, val.Array[1:])
+	case cmdRole:
+		return s.role(val.Array[1:])
+	case cmdInfo:
+		return s.info(val.Array[1:])
+	case cmdSubscribe, cmdPSubscribe:
+		return s.subscribe(c, val.Array[1:], name)
+	case cmdUnsubscribe, cmdPUnsubscribe:
+		return s.unsubscribe(c, val.Array[1:], name)
+	case cmdPublish:
+		return s.publish(val.Array[1:])
+	case cmdPubSub:
+		return s.pubsubCommand(val.Array[1:])
+	case cmdHello:
+		return s.hello(c, val.Array[1:])
+	case cmdAuth:
+		return s.auth(c, val.Array[1:])
+	case cmdACL:
+		return s.aclCommand(c, val.Array[1:])
+	case cmdCommand:
+		return s.command(val.Array[1:])
+	case cmdConfig:
+		return s.configCommand(val.Array[1:])
+	case cmdSlowlog:
+		return s.slowlogCommand(val.Array[1:])
+	case cmdMonitor:
+		return s.monitor(c)
+	case cmdClient:
+		return s.clientCommand(c, val.Array[1:])
+	default:
+		// Commands never block inside a transaction.
+		if r == s.exe {
+			if b, ok := s.exe.Blocking(val); ok {
+				return s.block(c, b)
+			}
+		}
+		return r.Execute(val)
+	}
+}
+
+// unexpectedError replies to an error returned by a command. Commands reply
+// to the errors clients are expected to see themselves, so anything else
+// fails the command without ending the connection.
+func unexpectedError(err error) resp.Value {
+	log.Println("command error:", err)
+	return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR " + err.Error())}
+}
+
+func bulk(s string) resp.Value {
+	return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+}
+
+func integer(n int) resp.Value {
+	return resp.Value{Type: resp.TypeInteger, Bytes: []byte(strconv.Itoa(n))}
+}
@@ -0,0 +1,1348 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/aof"
+	"github.com/elmq0022/kv-store/internal/certs/certstest"
+	"github.com/elmq0022/kv-store/internal/config"
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/metrics"
+	"github.com/elmq0022/kv-store/internal/rdb"
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/server"
+	"github.com/elmq0022/kv-store/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client is a minimal RESP client for driving a server over a socket.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *resp.Encoder
+	dec  *resp.Decoder
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, enc: resp.NewEncoder(conn), dec: resp.NewDecoder(conn)}
+}
+
+// do sends a command and returns the reply.
+func (c *client) do(args ...string) resp.Value {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+func (c *client) send(args ...string) {
+	c.t.Helper()
+	vals := make([]resp.Value, len(args))
+	for i, a := range args {
+		vals[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(a)}
+	}
+	require.NoError(c.t, c.enc.Encode(resp.Value{Type: resp.TypeArray, Array: vals}))
+}
+
+func (c *client) read() resp.Value {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	v, err := c.dec.Decode()
+	require.NoError(c.t, err)
+	return v
+}
+
+// start serves s on a loopback port for the duration of the test and returns
+// its address.
+func start(t *testing.T, s *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func TestBgRewriteAOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	st := storage.NewInMemoryShardedStorage()
+	t.Cleanup(func() { st.Close() })
+	exe := executor.NewExecutor(st)
+	log, err := aof.Open(path, aof.FsyncAlways)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+	exe.AddPropagator(log)
+
+	c := dial(t, start(t, server.New(st, exe, server.Options{AOF: log})))
+	for range 50 {
+		c.do("INCR", "n")
+	}
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	got := c.do("BGREWRITEAOF")
+	require.Equal(t, resp.TypeSimpleString, got.Type, string(got.Bytes))
+
+	assert.Eventually(t, func() bool {
+		after, err := os.Stat(path)
+		return err == nil && after.Size() < before.Size()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c.do("INCR", "n")
+	replayed := storage.NewInMemoryStorage()
+	_, err = aof.Load(path, executor.NewExecutor(replayed))
+	require.NoError(t, err)
+	v, err := replayed.Get("n")
+	require.NoError(t, err)
+	assert.Equal(t, "51", string(v))
+}
+
+func TestAOFWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	st := storage.NewInMemoryStorage()
+	exe := executor.NewExecutor(st)
+	log, err := aof.Open(path, aof.FsyncAlways)
+	require.NoError(t, err)
+	exe.AddPropagator(log)
+	c := dial(t, start(t, server.New(st, exe, server.Options{AOF: log})))
+
+	// Writes fail to reach the log once it is closed.
+	require.NoError(t, log.Close())
+	c.do("SET", "k", "v")
+	require.Error(t, log.Err())
+
+	got := c.do("SET", "k", "w")
+	assert.Equal(t, "MISCONF Errors writing to the AOF file: "+log.Err().Error(), string(got.Bytes))
+	assert.Equal(t, "v", string(c.do("GET", "k").Bytes), "reads are still served")
+	c.do("MULTI")
+	c.do("GET", "k")
+	assert.Equal(t, resp.TypeError, c.do("INCR", "n").Type)
+	assert.Contains(t, string(c.do("EXEC").Bytes), "EXECABORT")
+}
+
+func TestClose(t *testing.T) {
+	st := storage.NewInMemoryStorage()
+	srv := server.New(st, executor.NewExecutor(st), server.Options{})
+	addr := start(t, srv)
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+
+	require.NoError(t, srv.Close())
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.dec.Decode()
+	assert.ErrorIs(t, err, io.EOF, "connections are closed")
+
+	late := dial(t, addr)
+	late.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = late.dec.Decode()
+	assert.ErrorIs(t, err, io.EOF, "new connections are turned away")
+}
+
+func TestBgRewriteAOFDisabled(t *testing.T) {
+	st := storage.NewInMemoryStorage()
+	c := dial(t, start(t, server.New(st, executor.NewExecutor(st), server.Options{})))
+
+	got := c.do("bgrewriteaof")
+	assert.Equal(t, resp.TypeError, got.Type)
+}
+
+func TestSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	st := storage.NewInMemoryStorage()
+	srv := server.New(st, executor.NewExecutor(st), server.Options{RDBPath: path})
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "a", "1")
+	before := c.do("LASTSAVE")
+	require.Equal(t, resp.TypeInteger, before.Type)
+
+	got := c.do("SAVE")
+	require.Equal(t, "OK", string(got.Bytes))
+	restored := storage.NewInMemoryStorage()
+	n, err := rdb.Load(path, restored)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	c.do("SET", "b", "2")
+	got = c.do("BGSAVE")
+	require.Equal(t, "Background saving started", string(got.Bytes))
+	assert.Eventually(t, func() bool {
+		restored := storage.NewInMemoryStorage()
+		n, err := rdb.Load(path, restored)
+		return err == nil && n == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAutomaticSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	st := storage.NewInMemoryStorage()
+	srv := server.New(st, executor.NewExecutor(st), server.Options{
+		RDBPath:   path,
+		SaveRules: []rdb.SaveRule{{Seconds: 1, Changes: 2}},
+	})
+	t.Cleanup(func() { srv.Close() })
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "a", "1")
+	c.do("SET", "b", "2")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func newServer(t *testing.T, opts server.Options) (*server.Server, storage.Storage) {
+	t.Helper()
+	st := storage.NewInMemoryStorage()
+	srv := server.New(st, executor.NewExecutor(st), opts)
+	t.Cleanup(func() { srv.Close() })
+	return srv, st
+}
+
+func TestReplication(t *testing.T) {
+	primary, _ := newServer(t, server.Options{})
+	primaryAddr := start(t, primary)
+	p := dial(t, primaryAddr)
+	p.do("SET", "before", "1")
+
+	replica, replicaStorage := newServer(t, server.Options{})
+	r := dial(t, start(t, replica))
+	host, port, err := net.SplitHostPort(primaryAddr)
+	require.NoError(t, err)
+	got := r.do("REPLICAOF", host, port)
+	require.Equal(t, "OK", string(got.Bytes))
+	got = r.do("REPLICAOF", host, port)
+	assert.Equal(t, "OK Already connected to specified master", string(got.Bytes))
+
+	p.do("SET", "after", "2")
+	p.do("SET", "ttl", "3", "EX", "100")
+	assert.Eventually(t, func() bool {
+		v, err := replicaStorage.Get("after")
+		return err == nil && string(v) == "2"
+	}, 5*time.Second, 10*time.Millisecond)
+	v, err := replicaStorage.Get("before")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(v))
+
+	assert.Eventually(t, func() bool {
+		_, err := replicaStorage.ExpireTime("ttl")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	at, err := replicaStorage.ExpireTime("ttl")
+	require.NoError(t, err)
+	assert.False(t, at.IsZero())
+
+	got = r.do("SET", "x", "1")
+	assert.Equal(t, "READONLY You can't write against a read only replica.", string(got.Bytes))
+	got = r.do("GET", "after")
+	assert.Equal(t, "2", string(got.Bytes))
+
+	assert.Eventually(t, func() bool {
+		info := string(r.do("INFO", "replication").Bytes)
+		return strings.Contains(info, "role:slave") && strings.Contains(info, "master_link_status:up")
+	}, 5*time.Second, 10*time.Millisecond)
+	info := string(p.do("INFO").Bytes)
+	assert.Contains(t, info, "connected_slaves:1")
+	role := p.do("ROLE")
+	require.Len(t, role.Array, 3)
+	assert.Equal(t, "master", string(role.Array[0].Bytes))
+	assert.Len(t, role.Array[2].Array, 1)
+
+	// Once promoted the replica accepts writes again.
+	got = r.do("REPLICAOF", "NO", "ONE")
+	require.Equal(t, "OK", string(got.Bytes))
+	got = r.do("SET", "x", "1")
+	assert.Equal(t, "OK", string(got.Bytes))
+	assert.Contains(t, string(r.do("INFO").Bytes), "role:master")
+}
+
+// strs flattens an array reply into strings.
+func strs(v resp.Value) []string {
+	out := make([]string, len(v.Array))
+	for i, e := range v.Array {
+		out[i] = string(e.Bytes)
+	}
+	return out
+}
+
+func TestPubSub(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	sub, pub := dial(t, addr), dial(t, addr)
+
+	sub.send("SUBSCRIBE", "news", "weather")
+	assert.Equal(t, []string{"subscribe", "news", "1"}, strs(sub.read()))
+	assert.Equal(t, []string{"subscribe", "weather", "2"}, strs(sub.read()))
+	sub.send("PSUBSCRIBE", "news.*")
+	assert.Equal(t, []string{"psubscribe", "news.*", "3"}, strs(sub.read()))
+
+	got := pub.do("PUBLISH", "news", "hello")
+	assert.Equal(t, "1", string(got.Bytes))
+	assert.Equal(t, []string{"message", "news", "hello"}, strs(sub.read()))
+	got = pub.do("PUBLISH", "news.sport", "goal")
+	assert.Equal(t, "1", string(got.Bytes))
+	assert.Equal(t, []string{"pmessage", "news.*", "news.sport", "goal"}, strs(sub.read()))
+
+	assert.Equal(t, []string{"news", "weather"}, strs(pub.do("PUBSUB", "CHANNELS")))
+	assert.Equal(t, []string{"weather"}, strs(pub.do("PUBSUB", "CHANNELS", "w*")))
+	assert.Equal(t, []string{"news", "1", "other", "0"}, strs(pub.do("PUBSUB", "NUMSUB", "news", "other")))
+	assert.Equal(t, "1", string(pub.do("PUBSUB", "NUMPAT").Bytes))
+
+	// Only subscribe-family commands are accepted while subscribed.
+	sub.send("GET", "a")
+	got = sub.read()
+	assert.Equal(t, resp.TypeError, got.Type)
+	_, fields := infoFields(t, pub.do("INFO", "commandstats"))
+	assert.Regexp(t, `^calls=0,.*,rejected_calls=1,failed_calls=0$`, fields["cmdstat_get"])
+	sub.send("PING")
+	assert.Equal(t, []string{"pong", ""}, strs(sub.read()))
+
+	sub.send("UNSUBSCRIBE")
+	assert.Equal(t, []string{"unsubscribe", "news", "2"}, strs(sub.read()))
+	assert.Equal(t, []string{"unsubscribe", "weather", "1"}, strs(sub.read()))
+	sub.send("PUNSUBSCRIBE")
+	assert.Equal(t, []string{"punsubscribe", "news.*", "0"}, strs(sub.read()))
+
+	got = sub.do("PING")
+	assert.Equal(t, "pong", string(got.Bytes))
+	got = pub.do("PUBLISH", "news", "nobody")
+	assert.Equal(t, "0", string(got.Bytes))
+}
+
+func TestPubSubDisconnect(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	sub, pub := dial(t, addr), dial(t, addr)
+
+	sub.send("SUBSCRIBE", "news")
+	sub.read()
+	sub.conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return string(pub.do("PUBLISH", "news", "x").Bytes) == "0"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	sub, c := dial(t, addr), dial(t, addr)
+
+	sub.send("PSUBSCRIBE", "__key*__:*")
+	sub.read()
+	// next returns the channel and the message of the next notification.
+	next := func() []string {
+		t.Helper()
+		return strs(sub.read())[2:]
+	}
+
+	// Nothing is published by default.
+	c.do("SET", "k", "v")
+	assert.Equal(t, "OK", string(c.do("CONFIG", "SET", "notify-keyspace-events", "KEA").Bytes))
+	c.do("SET", "k", "v", "EX", "100")
+	assert.Equal(t, []string{"__keyspace@0__:k", "set"}, next())
+	assert.Equal(t, []string{"__keyevent@0__:set", "k"}, next())
+	assert.Equal(t, []string{"__keyspace@0__:k", "expire"}, next())
+	assert.Equal(t, []string{"__keyevent@0__:expire", "k"}, next())
+	c.do("INCR", "n")
+	assert.Equal(t, []string{"__keyspace@0__:n", "incrby"}, next())
+	assert.Equal(t, []string{"__keyevent@0__:incrby", "n"}, next())
+	c.do("DEL", "k", "missing")
+	assert.Equal(t, []string{"__keyspace@0__:k", "del"}, next())
+	assert.Equal(t, []string{"__keyevent@0__:del", "k"}, next())
+
+	// Only the selected channels and classes are published.
+	assert.Equal(t, "OK", string(c.do("CONFIG", "SET", "notify-keyspace-events", "Elg").Bytes))
+	c.do("SET", "k", "v")
+	c.do("RPUSH", "l", "a")
+	assert.Equal(t, []string{"__keyevent@0__:rpush", "l"}, next())
+	c.do("LPOP", "l")
+	assert.Equal(t, []string{"__keyevent@0__:lpop", "l"}, next())
+	assert.Equal(t, []string{"__keyevent@0__:del", "l"}, next(), "emptied lists are deleted")
+
+	assert.Equal(t, resp.TypeError, c.do("CONFIG", "SET", "notify-keyspace-events", "Kq").Type)
+	assert.Equal(t, []string{"notify-keyspace-events", "Elg"}, strs(c.do("CONFIG", "GET", "notify-keyspace-events")))
+}
+
+func TestTransactions(t *testing.T) {
+	srv, st := newServer(t, server.Options{})
+	addr := start(t, srv)
+	c, other := dial(t, addr), dial(t, addr)
+
+	t.Run("exec runs queued commands", func(t *testing.T) {
+		assert.Equal(t, "OK", string(c.do("MULTI").Bytes))
+		assert.Equal(t, "QUEUED", string(c.do("SET", "a", "1").Bytes))
+		assert.Equal(t, "QUEUED", string(c.do("INCR", "a").Bytes))
+		assert.Equal(t, "QUEUED", string(c.do("HGET", "a", "field").Bytes))
+		_, err := st.Get("a")
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound, "nothing runs before EXEC")
+
+		got := c.do("EXEC")
+		require.Len(t, got.Array, 3)
+		assert.Equal(t, "OK", string(got.Array[0].Bytes))
+		assert.Equal(t, "2", string(got.Array[1].Bytes))
+		assert.Equal(t, resp.TypeError, got.Array[2].Type, "errors at run time do not stop the others")
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		c.do("MULTI")
+		c.do("SET", "a", "discarded")
+		assert.Equal(t, "OK", string(c.do("DISCARD").Bytes))
+		assert.Equal(t, "2", string(c.do("GET", "a").Bytes))
+		assert.Equal(t, "ERR DISCARD without MULTI", string(c.do("DISCARD").Bytes))
+		assert.Equal(t, "ERR EXEC without MULTI", string(c.do("EXEC").Bytes))
+	})
+
+	t.Run("rejected command aborts exec", func(t *testing.T) {
+		c.do("MULTI")
+		assert.Equal(t, resp.TypeError, c.do("MULTI").Type)
+		assert.Equal(t, resp.TypeError, c.do("SUBSCRIBE", "ch").Type)
+		c.do("SET", "a", "aborted")
+		got := c.do("EXEC")
+		assert.Equal(t, "EXECABORT Transaction discarded because of previous errors.", string(got.Bytes))
+		assert.Equal(t, "2", string(c.do("GET", "a").Bytes))
+
+		// Unknown commands and wrong arities are caught before EXEC.
+		c.do("MULTI")
+		assert.Equal(t, "ERR unknown command 'NOSUCH'", string(c.do("NOSUCH").Bytes))
+		assert.Equal(t, "ERR wrong number of arguments for 'incr' command", string(c.do("INCR", "a", "b").Bytes))
+		c.do("SET", "a", "aborted")
+		got = c.do("EXEC")
+		assert.Equal(t, "EXECABORT Transaction discarded because of previous errors.", string(got.Bytes))
+		assert.Equal(t, "2", string(c.do("GET", "a").Bytes))
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		assert.Equal(t, "OK", string(c.do("WATCH", "a", "missing").Bytes))
+		other.do("SET", "a", "changed")
+		c.do("MULTI")
+		c.do("SET", "a", "mine")
+		got := c.do("EXEC")
+		assert.Equal(t, resp.TypeArray, got.Type)
+		assert.Nil(t, got.Array)
+		assert.Equal(t, "changed", string(c.do("GET", "a").Bytes))
+
+		// EXEC forgets watched keys, so this one goes through.
+		c.do("MULTI")
+		c.do("SET", "a", "mine")
+		assert.Len(t, c.do("EXEC").Array, 1)
+
+		c.do("WATCH", "missing")
+		other.do("SET", "missing", "now")
+		c.do("MULTI")
+		assert.Nil(t, c.do("EXEC").Array, "creating a watched key counts as a change")
+
+		c.do("WATCH", "fleeting")
+		other.do("SET", "fleeting", "v")
+		other.do("DEL", "fleeting")
+		c.do("MULTI")
+		assert.Nil(t, c.do("EXEC").Array, "so does creating and deleting it again")
+
+		c.do("WATCH", "a")
+		c.do("UNWATCH")
+		other.do("SET", "a", "again")
+		c.do("MULTI")
+		assert.Len(t, c.do("EXEC").Array, 0)
+	})
+}
+
+func TestTransactionsAreAtomic(t *testing.T) {
+	st := storage.NewInMemoryShardedStorage()
+	t.Cleanup(func() { st.Close() })
+	srv := server.New(st, executor.NewExecutor(st), server.Options{})
+	t.Cleanup(func() { srv.Close() })
+	addr := start(t, srv)
+
+	// Two counters on different shards must always be read as equal.
+	r := dial(t, addr)
+	r.do("SET", "counter:a", "0")
+	r.do("SET", "counter:b", "0")
+	const writers, rounds = 4, 50
+	done := make(chan struct{})
+	for range writers {
+		c := dial(t, addr)
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for range rounds {
+				c.send("MULTI")
+				c.send("INCR", "counter:a")
+				c.send("INCR", "counter:b")
+				c.send("EXEC")
+				for range 4 {
+					c.read()
+				}
+			}
+		}()
+	}
+
+	for finished := 0; finished < writers; {
+		select {
+		case <-done:
+			finished++
+		default:
+			r.do("MULTI")
+			r.do("GET", "counter:a")
+			r.do("GET", "counter:b")
+			got := r.do("EXEC")
+			require.Len(t, got.Array, 2)
+			require.Equal(t, string(got.Array[0].Bytes), string(got.Array[1].Bytes))
+		}
+	}
+	assert.Equal(t, "200", string(r.do("GET", "counter:a").Bytes))
+}
+
+func TestBlockingStreamReads(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	reader, writer := dial(t, addr), dial(t, addr)
+
+	t.Run("woken by a write", func(t *testing.T) {
+		reader.send("XREAD", "BLOCK", "0", "STREAMS", "events", "0")
+		assert.Equal(t, "1-1", string(writer.do("XADD", "events", "1-1", "k", "v").Bytes))
+		got := reader.read()
+		require.Len(t, got.Array, 1)
+		assert.Equal(t, "events", string(got.Array[0].Array[0].Bytes))
+		assert.Equal(t, "1-1", string(got.Array[0].Array[1].Array[0].Array[0].Bytes))
+	})
+
+	t.Run("group read", func(t *testing.T) {
+		require.Equal(t, "OK", string(writer.do("XGROUP", "CREATE", "events", "g", "$").Bytes))
+		reader.send("XREADGROUP", "GROUP", "g", "c", "BLOCK", "5000", "STREAMS", "events", ">")
+		writer.do("XADD", "events", "2-1", "k", "v")
+		got := reader.read()
+		require.Len(t, got.Array, 1)
+		assert.Equal(t, "2-1", string(got.Array[0].Array[1].Array[0].Array[0].Bytes))
+		assert.Equal(t, "1", string(writer.do("XPENDING", "events", "g").Array[0].Bytes))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+		got := reader.do("XREAD", "BLOCK", "50", "STREAMS", "events", "$")
+		assert.Equal(t, resp.TypeArray, got.Type)
+		assert.Nil(t, got.Array)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("not in a transaction", func(t *testing.T) {
+		reader.do("MULTI")
+		reader.do("XREAD", "BLOCK", "0", "STREAMS", "events", "$")
+		got := reader.do("EXEC")
+		require.Len(t, got.Array, 1)
+		assert.Nil(t, got.Array[0].Array)
+	})
+
+	t.Run("disconnect while blocked", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("XREAD", "BLOCK", "0", "STREAMS", "events", "$")
+		c.conn.Close()
+		for i := range 3 {
+			assert.NotEqual(t, resp.TypeError, writer.do("XADD", "events", "*", "n", strconv.Itoa(i)).Type)
+		}
+	})
+}
+
+func TestHello(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	c, pub := dial(t, addr), dial(t, addr)
+
+	// fields returns the keys and values of a map reply.
+	fields := func(v resp.Value) map[string]string {
+		out := make(map[string]string)
+		for i := 0; i+1 < len(v.Array); i += 2 {
+			out[string(v.Array[i].Bytes)] = string(v.Array[i+1].Bytes)
+		}
+		return out
+	}
+
+	got := c.do("HELLO")
+	assert.Equal(t, resp.TypeArray, got.Type, "RESP2 flattens maps")
+	assert.Equal(t, "2", fields(got)["proto"])
+
+	got = c.do("HELLO", "3", "AUTH", "default", "anything", "SETNAME", "conn-1")
+	require.Equal(t, resp.TypeMap, got.Type)
+	info := fields(got)
+	assert.Equal(t, "redis", info["server"])
+	assert.Equal(t, server.Version, info["version"])
+	assert.Equal(t, "3", info["proto"])
+	assert.Equal(t, "master", info["role"])
+	assert.NotEmpty(t, info["id"])
+
+	t.Run("typed replies", func(t *testing.T) {
+		assert.Equal(t, resp.TypeNull, c.do("HGET", "missing", "f").Type)
+		c.do("HSET", "h", "f", "v")
+		assert.Equal(t, resp.Value{Type: resp.TypeMap, Array: []resp.Value{
+			{Type: resp.TypeBulkString, Bytes: []byte("f")},
+			{Type: resp.TypeBulkString, Bytes: []byte("v")},
+		}}, c.do("HGETALL", "h"))
+		c.do("SADD", "s", "m")
+		assert.Equal(t, resp.TypeSet, c.do("SMEMBERS", "s").Type)
+		c.do("ZADD", "z", "1.5", "m")
+		assert.Equal(t, resp.Value{Type: resp.TypeDouble, Bytes: []byte("1.5")}, c.do("ZSCORE", "z", "m"))
+	})
+
+	t.Run("pairs and streams", func(t *testing.T) {
+		str := func(s string) resp.Value { return resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)} }
+		pair := func(a, b resp.Value) resp.Value { return resp.Value{Type: resp.TypeArray, Array: []resp.Value{a, b}} }
+		scored := pair(str("m"), resp.Value{Type: resp.TypeDouble, Bytes: []byte("1.5")})
+		for _, cmd := range [][]string{
+			{"ZRANGE", "z", "0", "-1", "WITHSCORES"},
+			{"ZRANGEBYSCORE", "z", "-inf", "+inf", "WITHSCORES"},
+			{"ZPOPMIN", "z", "1"},
+		} {
+			assert.Equal(t, resp.Value{Type: resp.TypeArray, Array: []resp.Value{scored}}, c.do(cmd...), cmd[0])
+		}
+		c.do("ZADD", "z", "1.5", "m")
+		assert.Equal(t, resp.Value{Type: resp.TypeArray, Array: scored.Array}, c.do("ZPOPMAX", "z"), "without a count, a pair is not nested")
+		assert.Equal(t, resp.Value{Type: resp.TypeArray, Array: []resp.Value{pair(str("f"), str("v"))}}, c.do("HRANDFIELD", "h", "1", "WITHVALUES"))
+
+		c.do("XADD", "x", "1-1", "a", "1")
+		entries := resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+			pair(str("1-1"), resp.Value{Type: resp.TypeArray, Array: []resp.Value{str("a"), str("1")}}),
+		}}
+		want := resp.Value{Type: resp.TypeMap, Array: []resp.Value{str("x"), entries}}
+		assert.Equal(t, want, c.do("XREAD", "STREAMS", "x", "0"))
+		c.do("XGROUP", "CREATE", "x", "g", "0")
+		assert.Equal(t, want, c.do("XREADGROUP", "GROUP", "g", "c", "STREAMS", "x", ">"))
+		assert.Equal(t, resp.TypeNull, c.do("XREAD", "STREAMS", "x", "1-1").Type)
+
+		c.do("HELLO", "2")
+		defer c.do("HELLO", "3")
+		c.do("ZADD", "z", "1.5", "m")
+		assert.Equal(t, []string{"m", "1.5"}, strs(c.do("ZRANGE", "z", "0", "-1", "WITHSCORES")), "RESP2 flattens pairs")
+		assert.Equal(t, []string{"f", "v"}, strs(c.do("HRANDFIELD", "h", "1", "WITHVALUES")))
+		assert.Equal(t, resp.Value{Type: resp.TypeArray, Array: []resp.Value{pair(str("x"), entries)}}, c.do("XREAD", "STREAMS", "x", "0"),
+			"RESP2 pairs stream names with their entries")
+	})
+
+	t.Run("pushes while subscribed", func(t *testing.T) {
+		got := c.do("SUBSCRIBE", "news")
+		assert.Equal(t, resp.TypePush, got.Type)
+		assert.Equal(t, "1.5", string(c.do("ZSCORE", "z", "m").Bytes), "RESP3 allows any command")
+		pub.do("PUBLISH", "news", "hi")
+		got = c.read()
+		assert.Equal(t, resp.TypePush, got.Type)
+		assert.Equal(t, []string{"message", "news", "hi"}, strs(got))
+		c.do("UNSUBSCRIBE")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert.Equal(t, "NOPROTO unsupported protocol version", string(c.do("HELLO", "4").Bytes))
+		assert.Equal(t, resp.TypeError, c.do("HELLO", "x").Type)
+		assert.Equal(t, resp.TypeError, c.do("HELLO", "3", "SETNAME", "has space").Type)
+		assert.Equal(t, resp.TypeError, c.do("HELLO", "3", "AUTH", "nobody", "pw").Type)
+		assert.Equal(t, resp.TypeError, c.do("HELLO", "3", "BOGUS").Type)
+		// A failed HELLO leaves the protocol unchanged.
+		assert.Equal(t, resp.TypeNull, c.do("HGET", "missing", "f").Type)
+	})
+
+	got = c.do("HELLO", "2")
+	assert.Equal(t, resp.TypeArray, got.Type)
+	missing := c.do("HGET", "missing", "f")
+	assert.Equal(t, resp.TypeBulkString, missing.Type)
+	assert.Nil(t, missing.Bytes)
+}
+
+func TestCommand(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	c := dial(t, start(t, srv))
+
+	all := c.do("COMMAND")
+	count, err := strconv.Atoi(string(c.do("COMMAND", "COUNT").Bytes))
+	require.NoError(t, err)
+	assert.Len(t, all.Array, count)
+
+	t.Run("info", func(t *testing.T) {
+		got := c.do("COMMAND", "INFO", "get", "XREADGROUP", "nosuch")
+		require.Len(t, got.Array, 3)
+		get := got.Array[0]
+		require.Len(t, get.Array, 10)
+		assert.Equal(t, "get", string(get.Array[0].Bytes))
+		assert.Equal(t, "2", string(get.Array[1].Bytes))
+		assert.Equal(t, []string{"readonly", "fast"}, strs(get.Array[2]))
+		assert.Equal(t, []string{"1", "1", "1"}, strs(resp.Value{Array: get.Array[3:6]}))
+		assert.Equal(t, []string{"@read", "@fast", "@string"}, strs(get.Array[6]))
+		assert.Contains(t, strs(got.Array[1].Array[2]), "movablekeys")
+		assert.Nil(t, got.Array[2].Array)
+
+		// The destination is at a fixed position, the other keys are not.
+		zunionstore := c.do("COMMAND", "INFO", "zunionstore").Array[0]
+		assert.Equal(t, []string{"1", "1", "1"}, strs(resp.Value{Array: zunionstore.Array[3:6]}))
+		assert.Contains(t, strs(zunionstore.Array[2]), "movablekeys")
+		assert.Equal(t, []string{"dst", "a", "b"}, strs(c.do("COMMAND", "GETKEYS", "ZUNIONSTORE", "dst", "2", "a", "b")))
+
+		xgroup := c.do("COMMAND", "INFO", "xgroup").Array[0]
+		subs := []string{}
+		for _, sub := range xgroup.Array[9].Array {
+			subs = append(subs, string(sub.Array[0].Bytes))
+		}
+		assert.Contains(t, subs, "xgroup|create")
+	})
+
+	t.Run("docs", func(t *testing.T) {
+		got := c.do("COMMAND", "DOCS", "set", "nosuch")
+		require.Len(t, got.Array, 2)
+		assert.Equal(t, "set", string(got.Array[0].Bytes))
+		docs := strs(got.Array[1])
+		assert.Equal(t, []string{"summary", "since", "group"}, []string{docs[0], docs[2], docs[4]})
+		assert.Equal(t, "string", docs[5])
+	})
+
+	t.Run("list", func(t *testing.T) {
+		list := strs(c.do("COMMAND", "LIST"))
+		assert.Contains(t, list, "xgroup")
+		assert.NotContains(t, list, "xgroup|create", "subcommands are not listed")
+		assert.Equal(t, []string{"hget", "hgetall"}, strs(c.do("COMMAND", "LIST", "FILTERBY", "PATTERN", "hget*")))
+		assert.NotContains(t, strs(c.do("COMMAND", "LIST", "FILTERBY", "ACLCAT", "read")), "set")
+	})
+
+	t.Run("getkeys", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b"}, strs(c.do("COMMAND", "GETKEYS", "DEL", "a", "b")))
+		assert.Equal(t, []string{"dst", "k1", "k2"}, strs(c.do("COMMAND", "GETKEYS", "ZUNIONSTORE", "dst", "2", "k1", "k2", "WEIGHTS", "1", "2")))
+		assert.Equal(t, []string{"s1", "s2"}, strs(c.do("COMMAND", "GETKEYS", "XREAD", "COUNT", "1", "STREAMS", "s1", "s2", "0", "0")))
+		assert.Equal(t, []string{"s"}, strs(c.do("COMMAND", "GETKEYS", "XGROUP", "CREATE", "s", "g", "$")))
+		assert.Equal(t, "ERR Invalid command specified", string(c.do("COMMAND", "GETKEYS", "nosuch", "a").Bytes))
+		assert.Equal(t, "ERR Invalid number of arguments specified for command", string(c.do("COMMAND", "GETKEYS", "GET", "a", "b").Bytes))
+		assert.Equal(t, "ERR The command has no key arguments", string(c.do("COMMAND", "GETKEYS", "ECHO", "a").Bytes))
+	})
+
+	t.Run("arity", func(t *testing.T) {
+		assert.Equal(t, "ERR wrong number of arguments for 'get' command", string(c.do("GET").Bytes))
+		assert.Equal(t, "ERR wrong number of arguments for 'xgroup|create' command", string(c.do("XGROUP", "CREATE", "s").Bytes))
+		assert.Equal(t, "ERR wrong number of arguments for 'publish' command", string(c.do("PUBLISH", "ch").Bytes))
+	})
+}
+
+func TestAuth(t *testing.T) {
+	srv, _ := newServer(t, server.Options{RequirePass: "secret"})
+	addr := start(t, srv)
+	c := dial(t, addr)
+
+	assert.Equal(t, "NOAUTH Authentication required.", string(c.do("GET", "k").Bytes))
+	assert.True(t, strings.HasPrefix(string(c.do("HELLO", "3").Bytes), "NOAUTH"))
+	assert.Equal(t, "WRONGPASS invalid username-password pair or user is disabled.", string(c.do("AUTH", "wrong").Bytes))
+	assert.Equal(t, "OK", string(c.do("AUTH", "secret").Bytes))
+	assert.Equal(t, "OK", string(c.do("SET", "k", "v").Bytes))
+	assert.Equal(t, "default", string(c.do("ACL", "WHOAMI").Bytes))
+
+	h := dial(t, addr)
+	assert.Equal(t, resp.TypeError, h.do("HELLO", "3", "AUTH", "default", "wrong").Type)
+	assert.Equal(t, resp.TypeMap, h.do("HELLO", "3", "AUTH", "default", "secret").Type)
+	assert.Equal(t, "v", string(h.do("GET", "k").Bytes))
+
+	t.Run("without a password", func(t *testing.T) {
+		srv, _ := newServer(t, server.Options{})
+		c := dial(t, start(t, srv))
+		assert.True(t, strings.HasPrefix(string(c.do("AUTH", "pw").Bytes), "ERR AUTH <password> called without any password configured"))
+	})
+}
+
+func TestACL(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "users.acl")
+	srv, _ := newServer(t, server.Options{ACLFile: aclFile})
+	addr := start(t, srv)
+	admin := dial(t, addr)
+
+	require.Equal(t, "OK", string(admin.do("ACL", "SETUSER", "app", "on", ">pw", "~app:*", "%R~shared:*", "&news", "+@read", "+@write", "+@transaction", "+publish", "+subscribe", "+acl|whoami", "-del").Bytes))
+	assert.Equal(t, []string{"app", "default"}, strs(admin.do("ACL", "USERS")))
+	assert.Contains(t, strs(admin.do("ACL", "LIST")), "user default on nopass ~* &* +@all")
+
+	c := dial(t, addr)
+	require.Equal(t, "OK", string(c.do("AUTH", "app", "pw").Bytes))
+	assert.Equal(t, "app", string(c.do("ACL", "WHOAMI").Bytes))
+
+	t.Run("commands", func(t *testing.T) {
+		assert.Equal(t, "OK", string(c.do("SET", "app:1", "v").Bytes))
+		assert.Equal(t, "NOPERM User app has no permissions to run the 'del' command", string(c.do("DEL", "app:1").Bytes))
+		assert.Equal(t, "NOPERM User app has no permissions to run the 'acl|users' command", string(c.do("ACL", "USERS").Bytes))
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		assert.Equal(t, "v", string(c.do("GET", "app:1").Bytes))
+		assert.Equal(t, resp.TypeBulkString, c.do("GET", "shared:1").Type)
+		assert.Equal(t, "NOPERM No permissions to access a key", string(c.do("SET", "shared:1", "v").Bytes))
+		assert.Equal(t, "NOPERM No permissions to access a key", string(c.do("SINTER", "app:1", "other").Bytes))
+
+		// Sources only need read access.
+		admin.do("SADD", "shared:s", "x")
+		assert.Equal(t, "1", string(c.do("SUNIONSTORE", "app:s", "shared:s").Bytes))
+		assert.Equal(t, "0", string(c.do("ZUNIONSTORE", "app:z", "1", "shared:z").Bytes))
+		assert.Equal(t, "NOPERM No permissions to access a key", string(c.do("SUNIONSTORE", "shared:t", "app:s").Bytes))
+	})
+
+	t.Run("channels", func(t *testing.T) {
+		assert.Equal(t, "0", string(c.do("PUBLISH", "news", "hi").Bytes))
+		assert.Equal(t, "NOPERM No permissions to access a channel", string(c.do("PUBLISH", "gossip", "hi").Bytes))
+		assert.Equal(t, "NOPERM No permissions to access a channel", string(c.do("SUBSCRIBE", "news", "gossip").Bytes))
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		c.do("MULTI")
+		assert.Equal(t, resp.TypeError, c.do("DEL", "app:1").Type)
+		assert.True(t, strings.HasPrefix(string(c.do("EXEC").Bytes), "EXECABORT"))
+	})
+
+	t.Run("log", func(t *testing.T) {
+		entries := admin.do("ACL", "LOG")
+		require.NotEmpty(t, entries.Array)
+		fields := strs(entries.Array[0])
+		assert.Equal(t, []string{"count", "1", "reason", "command", "context", "multi", "object", "del", "username", "app"}, fields[:10])
+
+		dial(t, addr).do("AUTH", "app", "wrong")
+		assert.Equal(t, "auth", strs(admin.do("ACL", "LOG", "1").Array[0])[3])
+		assert.Equal(t, "OK", string(admin.do("ACL", "LOG", "RESET").Bytes))
+		assert.Empty(t, admin.do("ACL", "LOG").Array)
+	})
+
+	t.Run("permissions changed before exec", func(t *testing.T) {
+		c.do("MULTI")
+		assert.Equal(t, "QUEUED", string(c.do("GET", "app:1").Bytes))
+		assert.Equal(t, "QUEUED", string(c.do("SET", "app:2", "v").Bytes))
+		require.Equal(t, "OK", string(admin.do("ACL", "SETUSER", "app", "-set").Bytes))
+		assert.Equal(t, "NOPERM User app has no permissions to run the 'set' command", string(c.do("EXEC").Bytes))
+		assert.Nil(t, c.do("GET", "app:2").Bytes, "nothing ran")
+		require.Equal(t, "OK", string(admin.do("ACL", "SETUSER", "app", "+set").Bytes))
+	})
+
+	t.Run("getuser", func(t *testing.T) {
+		got := strs(admin.do("ACL", "GETUSER", "app"))
+		require.Len(t, got, 12)
+		assert.Equal(t, "~app:* %R~shared:*", got[7])
+		assert.Equal(t, "&news", got[9])
+		assert.Equal(t, resp.TypeBulkString, admin.do("ACL", "GETUSER", "nobody").Type)
+	})
+
+	t.Run("cat", func(t *testing.T) {
+		assert.Contains(t, strs(admin.do("ACL", "CAT")), "sortedset")
+		assert.Contains(t, strs(admin.do("ACL", "CAT", "hash")), "hset")
+		assert.Equal(t, "ERR Unknown category 'nosuch'", string(admin.do("ACL", "CAT", "nosuch").Bytes))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert.Equal(t, "ERR Error in ACL SETUSER modifier 'bogus': Syntax error", string(admin.do("ACL", "SETUSER", "x", "bogus").Bytes))
+		assert.Equal(t, "ERR The 'default' user cannot be removed", string(admin.do("ACL", "DELUSER", "default").Bytes))
+		assert.Len(t, admin.do("ACL", "GENPASS").Bytes, 64)
+		assert.Len(t, admin.do("ACL", "GENPASS", "5").Bytes, 2)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		require.Equal(t, "OK", string(admin.do("ACL", "SAVE").Bytes))
+		assert.Equal(t, "1", string(admin.do("ACL", "DELUSER", "app").Bytes))
+		require.Equal(t, "OK", string(admin.do("ACL", "LOAD").Bytes))
+		assert.Equal(t, []string{"app", "default"}, strs(admin.do("ACL", "USERS")))
+	})
+
+	t.Run("deleted users are disconnected", func(t *testing.T) {
+		admin.do("ACL", "DELUSER", "app")
+		c.send("PING")
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := c.dec.Decode()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestTLS(t *testing.T) {
+	ca := certstest.NewCA(t)
+	srv, _ := newServer(t, server.Options{RequirePass: "secret", TLSAuthClientsUser: true})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go srv.ServeTLS(ln, &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, "server")},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+
+	dialTLS := func(cert ...tls.Certificate) *client {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: ca.Pool(), Certificates: cert})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return &client{t: t, conn: conn, enc: resp.NewEncoder(conn), dec: resp.NewDecoder(conn)}
+	}
+
+	admin := dialTLS()
+	assert.Equal(t, "NOAUTH Authentication required.", string(admin.do("PING").Bytes))
+	require.Equal(t, "OK", string(admin.do("AUTH", "secret").Bytes))
+	require.Equal(t, "OK", string(admin.do("ACL", "SETUSER", "alice", "on", "~*", "+@all").Bytes))
+
+	t.Run("certificate users", func(t *testing.T) {
+		c := dialTLS(ca.Issue(t, "alice"))
+		assert.Equal(t, "alice", string(c.do("ACL", "WHOAMI").Bytes))
+		assert.Equal(t, "OK", string(c.do("SET", "k", "v").Bytes))
+	})
+
+	t.Run("unknown certificate users", func(t *testing.T) {
+		c := dialTLS(ca.Issue(t, "bob"))
+		assert.Equal(t, "NOAUTH Authentication required.", string(c.do("PING").Bytes))
+	})
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte("# test\nappendfsync no\n"), 0o644))
+	cfg := config.New(server.Params())
+	require.NoError(t, cfg.Load(path))
+	srv, _ := newServer(t, server.Options{Config: cfg})
+	addr := start(t, srv)
+	c := dial(t, addr)
+
+	assert.Equal(t, []string{"appendfilename", "appendonly.aof", "appendfsync", "no", "appendonly", "no"}, strs(c.do("CONFIG", "GET", "append*")))
+	assert.Equal(t, []string{"port", "6379", "save", "3600 1 300 100 60 10000"}, strs(c.do("CONFIG", "GET", "save", "port")))
+
+	t.Run("set", func(t *testing.T) {
+		assert.Equal(t, "OK", string(c.do("CONFIG", "SET", "save", "60 1", "appendfsync", "always").Bytes))
+		assert.Equal(t, []string{"save", "60 1"}, strs(c.do("CONFIG", "GET", "save")))
+		assert.Equal(t, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config", string(c.do("CONFIG", "SET", "port", "1").Bytes))
+		assert.Equal(t, "ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'", string(c.do("CONFIG", "SET", "nosuch", "1").Bytes))
+		assert.Equal(t, resp.TypeError, c.do("CONFIG", "SET", "save", "60").Type)
+		assert.Equal(t, resp.TypeError, c.do("CONFIG", "SET", "save", "60 1", "appendfsync").Type)
+	})
+
+	t.Run("requirepass", func(t *testing.T) {
+		require.Equal(t, "OK", string(c.do("CONFIG", "SET", "requirepass", "secret").Bytes))
+		other := dial(t, addr)
+		assert.Equal(t, "NOAUTH Authentication required.", string(other.do("PING").Bytes))
+		assert.Equal(t, "OK", string(other.do("AUTH", "secret").Bytes))
+		require.Equal(t, "OK", string(other.do("CONFIG", "SET", "requirepass", "").Bytes))
+		assert.NotEqual(t, resp.TypeError, dial(t, addr).do("PING").Type)
+	})
+
+	t.Run("protocol limits", func(t *testing.T) {
+		require.Equal(t, "OK", string(c.do("CONFIG", "SET", "proto-max-multibulk-len", "4").Bytes))
+		other := dial(t, addr)
+		other.send("MSET", "a", "1", "b", "2")
+		assert.Equal(t, "ERR Protocol error: array size exceeds maximum", string(other.read().Bytes))
+		require.Equal(t, "OK", string(c.do("CONFIG", "SET", "proto-max-multibulk-len", "100000").Bytes))
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		require.Equal(t, "OK", string(c.do("CONFIG", "REWRITE").Bytes))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "# test\nappendfsync always\n# Generated by CONFIG REWRITE\nsave 60 1\n", string(data))
+
+		bare, _ := newServer(t, server.Options{})
+		assert.Equal(t, "ERR The server is running without a config file", string(dial(t, start(t, bare)).do("CONFIG", "REWRITE").Bytes))
+	})
+
+	assert.Equal(t, "OK", string(c.do("CONFIG", "RESETSTAT").Bytes))
+}
+
+// infoFields parses an INFO reply into its section headers and fields.
+func infoFields(t *testing.T, v resp.Value) ([]string, map[string]string) {
+	t.Helper()
+	require.Equal(t, resp.TypeBulkString, v.Type)
+	var sections []string
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(v.Bytes), "\r\n") {
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			sections = append(sections, name)
+		} else if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return sections, fields
+}
+
+func TestInfo(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "a", "1")
+	c.do("SET", "b", "2", "EX", "100")
+	c.do("GET", "a")
+	c.do("GET", "missing")
+	c.do("INCR", "a", "extra")
+	c.do("LPUSH", "a", "x")
+
+	sections, fields := infoFields(t, c.do("INFO"))
+	assert.Equal(t, []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "Keyspace"}, sections)
+	assert.Equal(t, server.Version, fields["redis_version"])
+	assert.Len(t, fields["run_id"], 40)
+	assert.Equal(t, "1", fields["connected_clients"])
+	assert.NotEmpty(t, fields["used_memory"])
+	assert.Equal(t, "2", fields["rdb_changes_since_last_save"])
+	assert.Equal(t, "1", fields["keyspace_hits"])
+	assert.Equal(t, "1", fields["keyspace_misses"])
+	assert.Equal(t, "master", fields["role"])
+	assert.Equal(t, "keys=2,expires=1,avg_ttl=0", fields["db0"])
+	assert.Contains(t, fields, "shard0")
+
+	sections, fields = infoFields(t, c.do("INFO", "commandstats", "CLIENTS"))
+	assert.Equal(t, []string{"Clients", "Commandstats"}, sections)
+	assert.Regexp(t, `^calls=2,usec=\d+,usec_per_call=[\d.]+,rejected_calls=0,failed_calls=0$`, fields["cmdstat_set"])
+	assert.Regexp(t, `^calls=0,.*,rejected_calls=1,failed_calls=0$`, fields["cmdstat_incr"])
+	assert.Regexp(t, `^calls=1,.*,rejected_calls=0,failed_calls=1$`, fields["cmdstat_lpush"])
+	assert.NotContains(t, fields, "cmdstat_ping")
+
+	sections, _ = infoFields(t, c.do("INFO", "everything"))
+	assert.Contains(t, sections, "Commandstats")
+	sections, _ = infoFields(t, c.do("INFO", "nosuch"))
+	assert.Empty(t, sections)
+
+	require.Equal(t, "OK", string(c.do("CONFIG", "RESETSTAT").Bytes))
+	_, fields = infoFields(t, c.do("INFO", "stats", "commandstats"))
+	assert.Equal(t, "0", fields["keyspace_hits"])
+	assert.Equal(t, "1", fields["total_commands_processed"], "only CONFIG RESETSTAT since the reset")
+	assert.Contains(t, fields, "cmdstat_config|resetstat")
+	assert.NotContains(t, fields, "cmdstat_set")
+}
+
+func TestMetrics(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	reg := metrics.NewRegistry()
+	srv.RegisterMetrics(reg)
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "a", "1")
+	c.do("SET", "b", "2", "EX", "100")
+	c.do("GET", "a")
+
+	var b strings.Builder
+	_, err := reg.WriteTo(&b)
+	require.NoError(t, err)
+	out := b.String()
+	assert.Contains(t, out, `kv_commands_total{command="set"} 2`+"\n")
+	assert.Contains(t, out, `kv_commands_total{command="get"} 1`+"\n")
+	assert.Contains(t, out, `kv_command_duration_seconds_count{command="set"} 2`+"\n")
+	assert.Contains(t, out, "kv_connected_clients 1\n")
+	assert.Contains(t, out, "kv_connections_received_total 1\n")
+	assert.Contains(t, out, `kv_keys{shard="0"} 2`+"\n")
+	assert.Contains(t, out, `kv_expiring_keys{shard="0"} 1`+"\n")
+	assert.Contains(t, out, "go_goroutines ")
+}
+
+func TestSlowlog(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "a", "1")
+	assert.Equal(t, "0", string(c.do("SLOWLOG", "LEN").Bytes), "nothing is that slow")
+
+	require.Equal(t, "OK", string(c.do("CONFIG", "SET", "slowlog-log-slower-than", "0").Bytes))
+	c.do("HELLO", "2", "SETNAME", "tester")
+	c.do("SET", "b", strings.Repeat("x", 200))
+	c.do("AUTH", "user", "secret")
+
+	got := c.do("SLOWLOG", "GET", "2")
+	require.Len(t, got.Array, 2)
+	auth, set := got.Array[0].Array, got.Array[1].Array
+	assert.Equal(t, []string{"AUTH", "(redacted)", "(redacted)"}, strs(auth[3]))
+	assert.Equal(t, []string{"SET", "b", strings.Repeat("x", 128) + "... (72 more bytes)"}, strs(set[3]))
+	assert.Greater(t, string(auth[0].Bytes), string(set[0].Bytes))
+	assert.InDelta(t, time.Now().Unix(), mustAtoi(t, set[1]), 5)
+	assert.Equal(t, c.conn.LocalAddr().String(), string(set[4].Bytes))
+	assert.Equal(t, "tester", string(set[5].Bytes))
+
+	require.Equal(t, "OK", string(c.do("CONFIG", "SET", "slowlog-max-len", "2").Bytes))
+	c.do("PING")
+	assert.Equal(t, "2", string(c.do("SLOWLOG", "LEN").Bytes))
+	assert.Equal(t, "ERR count should be greater than or equal to -1", string(c.do("SLOWLOG", "GET", "-2").Bytes))
+
+	require.Equal(t, "OK", string(c.do("CONFIG", "SET", "slowlog-log-slower-than", "-1").Bytes))
+	assert.Equal(t, "OK", string(c.do("SLOWLOG", "RESET").Bytes))
+	c.do("PING")
+	assert.Empty(t, c.do("SLOWLOG", "GET", "-1").Array)
+}
+
+func TestMonitor(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	m := dial(t, addr)
+	require.Equal(t, "OK", string(m.do("MONITOR").Bytes))
+
+	c := dial(t, addr)
+	c.do("SET", "k", "a \"b\"\n\x01")
+	c.do("CONFIG", "GET", "port")
+	c.do("AUTH", "secret")
+	c.do("MULTI")
+	assert.Equal(t, "ERR Command not allowed inside a transaction", string(c.do("MONITOR").Bytes))
+
+	prefix := `^\d+\.\d{6} \[0 ` + regexp.QuoteMeta(c.conn.LocalAddr().String()) + `\] `
+	got := m.read()
+	assert.Equal(t, resp.TypeSimpleString, got.Type)
+	assert.Regexp(t, prefix+`"SET" "k" "a \\"b\\"\\n\\x01"$`, string(got.Bytes))
+	assert.Regexp(t, prefix+`"AUTH" "\(redacted\)"$`, string(m.read().Bytes), "administrative commands are not shown")
+}
+
+func TestClientNoEvict(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	sub, pub := dial(t, addr), dial(t, addr)
+	// Keep what the server can hand to the kernel small, so that messages
+	// pile up in the subscriber's output queue.
+	require.NoError(t, sub.conn.(*net.TCPConn).SetReadBuffer(64<<10))
+
+	assert.Equal(t, "OK", string(sub.do("CLIENT", "NO-EVICT", "ON").Bytes))
+	sub.do("SUBSCRIBE", "news")
+
+	// More messages than fit in the output queue, published while the
+	// subscriber is not reading.
+	const n = 3000
+	msg := resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+		{Type: resp.TypeBulkString, Bytes: []byte("PUBLISH")},
+		{Type: resp.TypeBulkString, Bytes: []byte("news")},
+		{Type: resp.TypeBulkString, Bytes: bytes.Repeat([]byte("x"), 8<<10)},
+	}}
+	sent := make(chan error, 1)
+	go func() {
+		for range n {
+			if err := pub.enc.Encode(msg); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	for i := range n {
+		got := sub.read()
+		require.Len(t, got.Array, 3, "message %d", i)
+		assert.Equal(t, "message", string(got.Array[0].Bytes))
+	}
+	require.NoError(t, <-sent)
+	for range n {
+		assert.Equal(t, "1", string(pub.read().Bytes))
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	a, b := dial(t, addr), dial(t, addr)
+
+	id := mustAtoi(t, a.do("CLIENT", "ID"))
+	assert.Greater(t, mustAtoi(t, b.do("CLIENT", "ID")), id)
+
+	assert.Nil(t, a.do("CLIENT", "GETNAME").Bytes)
+	assert.Equal(t, "OK", string(a.do("CLIENT", "SETNAME", "app").Bytes))
+	assert.Equal(t, "app", string(a.do("CLIENT", "GETNAME").Bytes))
+	assert.Equal(t, "ERR Client names cannot contain spaces, newlines or special characters.", string(a.do("CLIENT", "SETNAME", "a b").Bytes))
+
+	info := string(a.do("CLIENT", "INFO").Bytes)
+	assert.Regexp(t, `^id=`+strconv.FormatInt(id, 10)+` addr=`+regexp.QuoteMeta(a.conn.LocalAddr().String())+` laddr=`+regexp.QuoteMeta(addr)+` name=app age=\d+ idle=0 flags=N db=0 sub=0 psub=0 multi=-1 .* oll=\d+ omem=\d+ tot-mem=\d+ cmd=client\|info user=default resp=2\n$`, info)
+	assert.Equal(t, "OK", string(a.do("CLIENT", "NO-EVICT", "ON").Bytes))
+	assert.Contains(t, string(a.do("CLIENT", "INFO").Bytes), " flags=e ")
+	assert.Equal(t, "ERR syntax error", string(a.do("CLIENT", "NO-EVICT", "maybe").Bytes))
+	assert.Equal(t, "OK", string(a.do("CLIENT", "NO-EVICT", "OFF").Bytes))
+	assert.Contains(t, string(a.do("CLIENT", "INFO").Bytes), " flags=N ")
+
+	b.do("SUBSCRIBE", "news")
+	list := strings.Split(strings.TrimSuffix(string(a.do("CLIENT", "LIST").Bytes), "\n"), "\n")
+	require.Len(t, list, 2)
+	assert.Contains(t, list[0], "name=app")
+	assert.Contains(t, list[1], "flags=P db=0 sub=1 psub=0")
+	assert.Contains(t, list[1], "cmd=subscribe")
+	pubsub := string(a.do("CLIENT", "LIST", "TYPE", "pubsub").Bytes)
+	assert.Equal(t, list[1]+"\n", pubsub)
+	assert.Contains(t, string(a.do("CLIENT", "LIST", "ID", strconv.FormatInt(id, 10)).Bytes), "name=app")
+	assert.Equal(t, "ERR Unknown client type 'nope'", string(a.do("CLIENT", "LIST", "TYPE", "nope").Bytes))
+
+	// b does not read its messages, so they pile up in its output queue.
+	msg := strings.Repeat("x", 1<<20)
+	for range 16 {
+		a.do("PUBLISH", "news", msg)
+	}
+	omem := regexp.MustCompile(` omem=(\d+) `).FindStringSubmatch(string(a.do("CLIENT", "LIST", "TYPE", "pubsub").Bytes))
+	require.Len(t, omem, 2)
+	n, err := strconv.Atoi(omem[1])
+	require.NoError(t, err)
+	assert.Greater(t, n, 1<<20)
+
+	assert.Equal(t, "ERR No such client", string(a.do("CLIENT", "KILL", "127.0.0.1:1").Bytes))
+	assert.Equal(t, "0", string(a.do("CLIENT", "KILL", "ID", strconv.FormatInt(id, 10)).Bytes), "SKIPME defaults to yes")
+	assert.Equal(t, "1", string(a.do("CLIENT", "KILL", "TYPE", "pubsub").Bytes))
+	b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for err == nil {
+		_, err = b.dec.Decode()
+	}
+	// The connection may be closed in the middle of a message.
+	assert.ErrorContains(t, err, "EOF")
+
+	a.send("CLIENT", "REPLY", "OFF")
+	a.send("SET", "k", "v")
+	assert.Equal(t, "OK", string(a.do("CLIENT", "REPLY", "ON").Bytes))
+	a.send("CLIENT", "REPLY", "SKIP")
+	a.send("GET", "k")
+	assert.Equal(t, "v", string(a.do("GET", "k").Bytes))
+
+	assert.Equal(t, "OK", string(a.do("CLIENT", "KILL", a.conn.LocalAddr().String()).Bytes), "killing itself still gets the reply")
+	_, err = a.dec.Decode()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestClientPause(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	admin, c := dial(t, addr), dial(t, addr)
+
+	require.Equal(t, "OK", string(admin.do("CLIENT", "PAUSE", "10000", "WRITE").Bytes))
+	assert.Nil(t, c.do("GET", "k").Bytes, "reads are not paused")
+	c.send("SET", "k", "v")
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c.dec.Decode()
+	var ne net.Error
+	require.ErrorAs(t, err, &ne)
+	require.True(t, ne.Timeout(), "writes are paused")
+
+	require.Equal(t, "OK", string(admin.do("CLIENT", "UNPAUSE").Bytes))
+	assert.Equal(t, "OK", string(c.read().Bytes))
+
+	start := time.Now()
+	require.Equal(t, "OK", string(admin.do("CLIENT", "PAUSE", "100").Bytes))
+	assert.Equal(t, "v", string(c.do("GET", "k").Bytes))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "ALL pauses reads too")
+	assert.Equal(t, "ERR timeout is not an integer or out of range", string(admin.do("CLIENT", "PAUSE", "-1").Bytes))
+}
+
+func TestMaxMemory(t *testing.T) {
+	srv, st := newServer(t, server.Options{})
+	c := dial(t, start(t, srv))
+
+	for i := range 10 {
+		require.Equal(t, "OK", string(c.do("SET", "k"+strconv.Itoa(i), strings.Repeat("x", 100)).Bytes))
+	}
+	used := st.UsedMemory()
+	require.Equal(t, "OK", string(c.do("CONFIG", "SET", "maxmemory", strconv.FormatInt(used/2, 10)).Bytes))
+
+	oom := "OOM command not allowed when used memory > 'maxmemory'."
+	assert.Equal(t, oom, string(c.do("SET", "new", "v").Bytes))
+	assert.Equal(t, strings.Repeat("x", 100), string(c.do("GET", "k0").Bytes), "reads are still served")
+	c.do("MULTI")
+	assert.Equal(t, oom, string(c.do("LPUSH", "l", "v").Bytes))
+	assert.True(t, strings.HasPrefix(string(c.do("EXEC").Bytes), "EXECABORT"))
+	assert.Equal(t, "1", string(c.do("DEL", "k0").Bytes), "deleting keys frees memory")
+
+	require.Equal(t, "OK", string(c.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lru").Bytes))
+	assert.Equal(t, "OK", string(c.do("SET", "new", "v").Bytes))
+	assert.LessOrEqual(t, st.UsedMemory(), used/2+int64(len("new")+len("v"))+256)
+	_, fields := infoFields(t, c.do("INFO", "memory", "stats"))
+	assert.Equal(t, strconv.FormatInt(used/2, 10), fields["maxmemory"])
+	assert.Equal(t, "allkeys-lru", fields["maxmemory_policy"])
+	assert.Equal(t, strconv.FormatInt(st.UsedMemory(), 10), fields["used_memory_dataset"])
+	evicted, err := strconv.Atoi(fields["evicted_keys"])
+	require.NoError(t, err)
+	assert.InDelta(t, 4, evicted, 1)
+	assert.Equal(t, 10-evicted, st.KeyCounts()[0].Keys, "9 keys were left, and one added")
+
+	assert.Contains(t, string(c.do("CONFIG", "SET", "maxmemory-policy", "lru").Bytes), "must be one of the following: noeviction, allkeys-lru")
+}
+
+func mustAtoi(t *testing.T, v resp.Value) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(string(v.Bytes), 10, 64)
+	require.NoError(t, err)
+	return n
+}
+
+func TestInlineCommands(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	addr := start(t, srv)
+	c := dial(t, addr)
+
+	_, err := c.conn.Write([]byte("SET greeting \"hello world\"\r\n\nHGET nokey f\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(c.read().Bytes))
+	assert.Nil(t, c.read().Bytes)
+
+	// Inline and RESP commands can be mixed on one connection.
+	assert.Equal(t, "hello world", string(c.do("GET", "greeting").Bytes))
+	_, err = c.conn.Write([]byte("ECHO 'it\\'s'\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "it's", string(c.read().Bytes))
+}
+
+// failingStorage fails every GET with an error clients are not expected to
+// see.
+type failingStorage struct {
+	storage.Storage
+}
+
+func (failingStorage) Get(string) ([]byte, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func (failingStorage) Version(string) uint64 {
+	return 1
+}
+
+func TestErrorReplies(t *testing.T) {
+	srv, _ := newServer(t, server.Options{})
+	c := dial(t, start(t, srv))
+
+	c.do("SET", "str", "abc")
+	c.do("SET", "max", "9223372036854775807")
+	c.do("RPUSH", "list", "a")
+	c.do("HSET", "hash", "f", "1e308")
+	for _, tt := range []struct {
+		cmd  []string
+		want resp.Value
+	}{
+		{[]string{"GET", "missing"}, resp.Value{Type: resp.TypeBulkString}},
+		{[]string{"INCR", "str"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not an integer or out of range")}},
+		{[]string{"INCR", "max"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR increment or decrement would overflow")}},
+		{[]string{"LPUSH", "str", "x"}, resp.Value{Type: resp.TypeError, Bytes: []byte("WRONGTYPE Operation against a key holding the wrong kind of value")}},
+		{[]string{"GET", "list"}, resp.Value{Type: resp.TypeError, Bytes: []byte("WRONGTYPE Operation against a key holding the wrong kind of value")}},
+		{[]string{"LSET", "missing", "0", "x"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR no such key")}},
+		{[]string{"LSET", "list", "5", "x"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR index out of range")}},
+		{[]string{"HINCRBYFLOAT", "hash", "f", "1e308"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR increment would produce NaN or Infinity")}},
+		{[]string{"ZINCRBY", "zset", "abc", "m"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR value is not a valid float")}},
+		{[]string{"NOSUCHCOMMAND"}, resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown command 'NOSUCHCOMMAND'")}},
+		// Writes are refused once over maxmemory under noeviction.
+		{[]string{"CONFIG", "SET", "maxmemory", "1"}, resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}},
+		{[]string{"SET", "k", "v"}, resp.Value{Type: resp.TypeError, Bytes: []byte("OOM command not allowed when used memory > 'maxmemory'.")}},
+	} {
+		assert.Equal(t, tt.want, c.do(tt.cmd...), "%v", tt.cmd)
+	}
+	// None of the errors ended the connection.
+	assert.Equal(t, "abc", string(c.do("GET", "str").Bytes))
+
+	t.Run("unexpected storage error", func(t *testing.T) {
+		st := failingStorage{storage.NewInMemoryStorage()}
+		srv := server.New(st, executor.NewExecutor(st), server.Options{})
+		t.Cleanup(func() { srv.Close() })
+		c := dial(t, start(t, srv))
+
+		assert.Equal(t, "ERR disk on fire", string(c.do("GET", "k").Bytes))
+		assert.Equal(t, "OK", string(c.do("SET", "k", "v").Bytes))
+		c.do("MULTI")
+		c.do("GET", "k")
+		c.do("SET", "k", "w")
+		got := c.do("EXEC")
+		require.Len(t, got.Array, 2)
+		assert.Equal(t, "ERR disk on fire", string(got.Array[0].Bytes))
+		assert.Equal(t, "OK", string(got.Array[1].Bytes))
+	})
+
+	t.Run("protocol errors close the connection", func(t *testing.T) {
+		for msg, want := range map[string]string{
+			"*1\r\n$x\r\n":     "ERR Protocol error: invalid bulk length",
+			"*z\r\n":           "ERR Protocol error: invalid multibulk length",
+			"ECHO \"open\r\n":  "ERR Protocol error: unbalanced quotes in request",
+			"*1\r\n$3\r\nabcd": "ERR Protocol error: expected CRLF after bulk string data",
+		} {
+			c := dial(t, start(t, srv))
+			_, err := c.conn.Write([]byte(msg))
+			require.NoError(t, err)
+			assert.Equal(t, want, string(c.read().Bytes))
+			_, err = c.dec.Decode()
+			assert.ErrorIs(t, err, io.EOF, msg)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const cmdSlowlog = "slowlog"
+
+// logIfSlow records a command in the slow log if it took at least the
+// configured threshold.
+func (s *Server) logIfSlow(c *client, spec *command.Spec, args []resp.Value, d time.Duration) {
+	threshold := s.slowlogThreshold.Load()
+	if threshold < 0 || d.Microseconds() < threshold || spec.Flags.Has(command.SkipSlowlog) {
+		return
+	}
+	s.slowlog.Add(d, redacted(spec, args), c.conn.RemoteAddr().String(), c.name)
+}
+
+// redacted returns args with the passwords given to AUTH and HELLO hidden,
+// for logs other clients can read.
+func redacted(spec *command.Spec, args []resp.Value) []resp.Value {
+	hidden := resp.Value{Type: resp.TypeBulkString, Bytes: []byte("(redacted)")}
+	switch spec.Name {
+	case cmdAuth:
+		out := []resp.Value{args[0]}
+		for range args[1:] {
+			out = append(out, hidden)
+		}
+		return out
+	case cmdHello:
+		out := append([]resp.Value(nil), args...)
+		for i := 2; i < len(out); i++ {
+			if strings.EqualFold(string(out[i].Bytes), "AUTH") && i+2 < len(out) {
+				out[i+1], out[i+2] = hidden, hidden
+				i += 2
+			}
+		}
+		return out
+	}
+	return args
+}
+
+// slowlogCommand serves SLOWLOG GET, LEN and RESET.
+func (s *Server) slowlogCommand(args []resp.Value) (resp.Value, error) {
+	sub := strings.ToLower(string(args[0].Bytes))
+	args = args[1:]
+	switch sub {
+	case "get":
+		n := 10
+		if len(args) > 0 {
+			v, err := strconv.Atoi(string(args[0].Bytes))
+			if err != nil || v < -1 {
+				return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR count should be greater than or equal to -1")}, nil
+			}
+			n = v
+		}
+		entries := s.slowlog.Get(n)
+		out := make([]resp.Value, len(entries))
+		for i, e := range entries {
+			cmd := make([]resp.Value, len(e.Args))
+			for j, arg := range e.Args {
+				cmd[j] = bulk(arg)
+			}
+			out[i] = resp.Value{Type: resp.TypeArray, Array: []resp.Value{
+				integer(int(e.ID)),
+				integer(int(e.Time.Unix())),
+				integer(int(e.Duration.Microseconds())),
+				{Type: resp.TypeArray, Array: cmd},
+				bulk(e.ClientAddr),
+				bulk(e.ClientName),
+			}}
+		}
+		return resp.Value{Type: resp.TypeArray, Array: out}, nil
+	case "len":
+		return integer(s.slowlog.Len()), nil
+	case "reset":
+		s.slowlog.Reset()
+		return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+	default:
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR unknown subcommand '" + sub + "'. Try SLOWLOG HELP.")}, nil
+	}
+}
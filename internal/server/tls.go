@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake of new connections.
+const handshakeTimeout = 10 * time.Second
+
+// ServeTLS accepts TLS connections on ln until it is closed.
+func (s *Server) ServeTLS(ln net.Listener, config *tls.Config) error {
+	return s.serve(tls.NewListener(ln, config))
+}
+
+// handshake completes the TLS handshake of conn and returns the common name
+// of the certificate the client presented, if any.
+func handshake(conn *tls.Conn) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+// authenticateCertificate logs the client in as the ACL user named after
+// the common name of its certificate, if there is such a user and it is
+// enabled.
+func (s *Server) authenticateCertificate(c *client, cn string) {
+	if u, ok := s.acl.User(cn); ok && u.Enabled {
+		c.user, c.authenticated = cn, true
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/elmq0022/kv-store/internal/acl"
+	"github.com/elmq0022/kv-store/internal/command"
+	"github.com/elmq0022/kv-store/internal/executor"
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+func (s *Server) multi(c *client, args []resp.Value) (resp.Value, error) {
+	if c.multi {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR MULTI calls can not be nested")}, nil
+	}
+	c.multi = true
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// queue adds a command to the open transaction. A command that is rejected
+// makes the whole transaction fail at EXEC. Commands that take over the
+// connection or wait for every other command to finish cannot be queued.
+func (s *Server) queue(c *client, spec *command.Spec, val resp.Value) (resp.Value, error) {
+	if spec.Flags.Has(command.NoMulti) {
+		c.abortTransaction()
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR Command not allowed inside a transaction")}, nil
+	}
+	c.queued = append(c.queued, val)
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("QUEUED")}, nil
+}
+
+// exec runs the queued commands atomically, unless a watched key changed
+// since it was watched, in which case it replies with a nil array. The
+// user's permissions are checked again first, in case they were changed
+// since the commands were queued: if any is no longer allowed, none runs.
+func (s *Server) exec(c *client, args []resp.Value) (resp.Value, error) {
+	if !c.multi {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR EXEC without MULTI")}, nil
+	}
+	var denied error
+	if !c.txAborted {
+		for _, val := range c.queued {
+			spec, _ := s.commands.Find(val.Array)
+			if denied = s.checkPermissions(c, spec, val.Array); denied != nil {
+				break
+			}
+		}
+	}
+	queued, aborted, watched := c.queued, c.txAborted, c.watched
+	c.resetTransaction()
+	defer s.unwatchKeys(watched)
+	if aborted {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("EXECABORT Transaction discarded because of previous errors.")}, nil
+	}
+	if denied != nil {
+		var d *acl.Denial
+		if !errors.As(denied, &d) {
+			// The user was deleted: log the client out for good.
+			return resp.Value{}, errDisconnected
+		}
+		return resp.Value{Type: resp.TypeError, Bytes: []byte(d.Error())}, nil
+	}
+
+	var out []resp.Value
+	s.exe.Atomic(func(tx *executor.Tx) {
+		for k, v := range watched {
+			if s.storage.Version(k) != v {
+				return
+			}
+		}
+		out = make([]resp.Value, 0, len(queued))
+		for _, val := range queued {
+			// Queued commands were found when they were queued.
+			spec, _ := s.commands.Find(val.Array)
+			v, err := s.call(c, spec, strings.ToLower(string(val.Array[0].Bytes)), val, tx)
+			if err != nil {
+				v = unexpectedError(err)
+			}
+			out = append(out, v)
+		}
+	})
+	// out is still nil, a nil array, if a watched key changed.
+	return resp.Value{Type: resp.TypeArray, Array: out}, nil
+}
+
+func (s *Server) discard(c *client, args []resp.Value) (resp.Value, error) {
+	if !c.multi {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR DISCARD without MULTI")}, nil
+	}
+	s.unwatchKeys(c.watched)
+	c.resetTransaction()
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// watch records the current version of each key. EXEC fails if any of them
+// is written, deleted or expires before it runs.
+func (s *Server) watch(c *client, args []resp.Value) (resp.Value, error) {
+	if c.multi {
+		return resp.Value{Type: resp.TypeError, Bytes: []byte("ERR WATCH inside MULTI is not allowed")}, nil
+	}
+	if c.watched == nil {
+		c.watched = make(map[string]uint64)
+	}
+	for _, arg := range args {
+		k := string(arg.Bytes)
+		if _, ok := c.watched[k]; !ok {
+			s.storage.Watch(k)
+			c.watched[k] = s.storage.Version(k)
+		}
+	}
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+func (s *Server) unwatch(c *client, args []resp.Value) (resp.Value, error) {
+	s.unwatchKeys(c.watched)
+	c.watched = nil
+	return resp.Value{Type: resp.TypeSimpleString, Bytes: []byte("OK")}, nil
+}
+
+// unwatchKeys lets the storage forget the versions of deleted keys that were
+// kept for WATCH.
+func (s *Server) unwatchKeys(watched map[string]uint64) {
+	for k := range watched {
+		s.storage.Unwatch(k)
+	}
+}
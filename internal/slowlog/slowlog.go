@@ -0,0 +1,133 @@
+// Package slowlog keeps the most recent commands that took longer than a
+// threshold to run, as reported by SLOWLOG.
+package slowlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+)
+
+const (
+	// MaxArgs is the number of arguments of a command kept in an entry. The
+	// last one kept is replaced by a count of those left out.
+	MaxArgs = 32
+	// MaxArgLen is the length of the arguments kept in an entry. Longer
+	// arguments are cut and end with a count of the bytes left out.
+	MaxArgLen = 128
+)
+
+// Entry is a slow command.
+type Entry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	// Args are the command's arguments, the command name included, cut to
+	// MaxArgs arguments of up to MaxArgLen bytes each.
+	Args       []string
+	ClientAddr string
+	ClientName string
+}
+
+// Log is a bounded log of slow commands. Once full, adding an entry drops
+// the oldest one.
+type Log struct {
+	mu sync.Mutex
+	// ring holds the entries from start on, wrapping around, oldest first.
+	ring   []Entry
+	start  int
+	n      int
+	nextID int64
+}
+
+// New returns a log keeping up to maxLen entries.
+func New(maxLen int) *Log {
+	return &Log{ring: make([]Entry, maxLen)}
+}
+
+// Add records a command that took d to run.
+func (l *Log) Add(d time.Duration, args []resp.Value, clientAddr, clientName string) {
+	e := Entry{
+		Time:       time.Now(),
+		Duration:   d,
+		Args:       truncate(args),
+		ClientAddr: clientAddr,
+		ClientName: clientName,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.ID = l.nextID
+	l.nextID++
+	if len(l.ring) == 0 {
+		return
+	}
+	if l.n < len(l.ring) {
+		l.ring[(l.start+l.n)%len(l.ring)] = e
+		l.n++
+		return
+	}
+	l.ring[l.start] = e
+	l.start = (l.start + 1) % len(l.ring)
+}
+
+// truncate keeps what an entry shows of a command's arguments.
+func truncate(args []resp.Value) []string {
+	out := make([]string, 0, min(len(args), MaxArgs))
+	for i, arg := range args {
+		if i == MaxArgs-1 && len(args) > MaxArgs {
+			out = append(out, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if len(arg.Bytes) > MaxArgLen {
+			out = append(out, fmt.Sprintf("%s... (%d more bytes)", arg.Bytes[:MaxArgLen], len(arg.Bytes)-MaxArgLen))
+			continue
+		}
+		out = append(out, string(arg.Bytes))
+	}
+	return out
+}
+
+// Get returns up to n entries, newest first, or all of them if n is
+// negative.
+func (l *Log) Get(n int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 || n > l.n {
+		n = l.n
+	}
+	out := make([]Entry, n)
+	for i := range out {
+		out[i] = l.ring[(l.start+l.n-1-i)%len(l.ring)]
+	}
+	return out
+}
+
+// Len returns the number of entries.
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// Reset removes every entry. IDs keep increasing.
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.ring)
+	l.start, l.n = 0, 0
+}
+
+// SetMaxLen changes the number of entries kept, dropping the oldest ones if
+// there are more.
+func (l *Log) SetMaxLen(maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keep := min(l.n, maxLen)
+	ring := make([]Entry, maxLen)
+	for i := range keep {
+		ring[i] = l.ring[(l.start+l.n-keep+i)%len(l.ring)]
+	}
+	l.ring, l.start, l.n = ring, 0, keep
+}
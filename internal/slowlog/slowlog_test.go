@@ -0,0 +1,82 @@
+package slowlog_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elmq0022/kv-store/internal/resp"
+	"github.com/elmq0022/kv-store/internal/slowlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func args(ss ...string) []resp.Value {
+	out := make([]resp.Value, len(ss))
+	for i, s := range ss {
+		out[i] = resp.Value{Type: resp.TypeBulkString, Bytes: []byte(s)}
+	}
+	return out
+}
+
+// ids returns the IDs of the entries.
+func ids(entries []slowlog.Entry) []int64 {
+	out := make([]int64, len(entries))
+	for i, e := range entries {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func TestLog(t *testing.T) {
+	l := slowlog.New(3)
+	assert.Empty(t, l.Get(-1))
+
+	l.Add(time.Millisecond, args("GET", "k"), "127.0.0.1:1234", "app")
+	e := l.Get(1)[0]
+	assert.Equal(t, int64(0), e.ID)
+	assert.Equal(t, time.Millisecond, e.Duration)
+	assert.Equal(t, []string{"GET", "k"}, e.Args)
+	assert.Equal(t, "127.0.0.1:1234", e.ClientAddr)
+	assert.Equal(t, "app", e.ClientName)
+	assert.WithinDuration(t, time.Now(), e.Time, time.Second)
+
+	for i := range 4 {
+		l.Add(time.Millisecond, args("SET", strconv.Itoa(i), "v"), "", "")
+	}
+	assert.Equal(t, 3, l.Len())
+	assert.Equal(t, []int64{4, 3, 2}, ids(l.Get(-1)), "newest first, oldest dropped")
+	assert.Equal(t, []int64{4, 3}, ids(l.Get(2)))
+
+	l.SetMaxLen(2)
+	assert.Equal(t, []int64{4, 3}, ids(l.Get(-1)))
+	l.SetMaxLen(4)
+	l.Add(time.Millisecond, args("PING"), "", "")
+	assert.Equal(t, []int64{5, 4, 3}, ids(l.Get(-1)))
+
+	l.Reset()
+	assert.Zero(t, l.Len())
+	l.Add(time.Millisecond, args("PING"), "", "")
+	assert.Equal(t, []int64{6}, ids(l.Get(10)), "IDs survive a reset")
+
+	l.SetMaxLen(0)
+	l.Add(time.Millisecond, args("PING"), "", "")
+	assert.Zero(t, l.Len())
+}
+
+func TestTruncation(t *testing.T) {
+	l := slowlog.New(1)
+	long := strings.Repeat("x", slowlog.MaxArgLen+10)
+	many := make([]string, 40)
+	for i := range many {
+		many[i] = strconv.Itoa(i)
+	}
+	l.Add(0, args(append([]string{"SADD", long}, many...)...), "", "")
+
+	got := l.Get(1)[0].Args
+	require.Len(t, got, slowlog.MaxArgs)
+	assert.Equal(t, strings.Repeat("x", slowlog.MaxArgLen)+"... (10 more bytes)", got[1])
+	assert.Equal(t, "28", got[30])
+	assert.Equal(t, "... (11 more arguments)", got[31])
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashCommands(t *testing.T) {
+	s := NewInMemoryStorage()
+	pairs := func(kv ...string) [][]byte {
+		out := make([][]byte, len(kv))
+		for i, v := range kv {
+			out[i] = []byte(v)
+		}
+		return out
+	}
+
+	n, err := s.HashSet("h", pairs("a", "1", "b", "2"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = s.HashSet("h", pairs("a", "10", "c", "3"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	v := s.Version("h")
+	n, err = s.HashSet("h", pairs("a", "x"), true)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, v, s.Version("h"), "a no-op HSETNX is not a write")
+
+	got, err := s.HashGet("h", []byte("a"), []byte("nope"), []byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("10"), nil, []byte("3")}, got)
+
+	kind, err := s.Type("h")
+	require.NoError(t, err)
+	assert.Equal(t, KindHash, kind)
+
+	_, err = s.Get("h")
+	assert.ErrorIs(t, err, ErrWrongType)
+	require.NoError(t, s.Set("str", []byte("v")))
+	_, err = s.HashSet("str", pairs("a", "1"), false)
+	assert.ErrorIs(t, err, ErrWrongType)
+
+	t.Run("incr", func(t *testing.T) {
+		n, err := s.HashIncrBy("h", []byte("a"), 5)
+		require.NoError(t, err)
+		assert.Equal(t, int64(15), n)
+		_, err = s.HashIncrBy("h", []byte("a"), math.MaxInt64)
+		assert.ErrorIs(t, err, ErrIntegerOverflow)
+
+		f, err := s.HashIncrByFloat("h", []byte("f"), 10.5)
+		require.NoError(t, err)
+		assert.Equal(t, "10.5", string(f))
+		f, err = s.HashIncrByFloat("h", []byte("f"), 0.1)
+		require.NoError(t, err)
+		assert.Equal(t, "10.6", string(f))
+
+		_, err = s.HashSet("h", pairs("word", "abc"), false)
+		require.NoError(t, err)
+		_, err = s.HashIncrBy("h", []byte("word"), 1)
+		assert.ErrorIs(t, err, ErrNotInteger)
+		_, err = s.HashIncrByFloat("h", []byte("word"), 1)
+		assert.ErrorIs(t, err, ErrNotFloat)
+		_, err = s.HashIncrByFloat("h", []byte("f"), math.MaxFloat64)
+		require.NoError(t, err)
+		_, err = s.HashIncrByFloat("h", []byte("f"), math.MaxFloat64)
+		assert.ErrorIs(t, err, ErrNotFinite)
+
+		_, err = s.HashIncrBy("fresh", []byte("a"), 1)
+		require.NoError(t, err)
+		_, err = s.HashIncrBy("badfresh", []byte("a"), math.MinInt64)
+		require.NoError(t, err)
+		_, err = s.HashIncrBy("badfresh", []byte("a"), -1)
+		assert.ErrorIs(t, err, ErrIntegerOverflow)
+	})
+
+	t.Run("deleting the last field deletes the key", func(t *testing.T) {
+		n, err := s.HashDel("fresh", []byte("a"), []byte("b"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = s.Type("fresh")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+}
+
+func TestHashScan(t *testing.T) {
+	s := NewInMemoryStorage()
+	for i := range 1000 {
+		_, err := s.HashSet("h", [][]byte{[]byte(strconv.Itoa(i)), []byte("v")}, false)
+		require.NoError(t, err)
+	}
+
+	// Fields that are there for the whole scan must be returned even though
+	// the hash changes between calls.
+	seen := map[string]bool{}
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		require.Less(t, calls, 1000, "scan does not terminate")
+		next, pairs, err := s.HashScan("h", cursor, 10)
+		require.NoError(t, err)
+		for i := 0; i < len(pairs); i += 2 {
+			assert.Equal(t, "v", string(pairs[i+1]))
+			seen[string(pairs[i])] = true
+		}
+		_, err = s.HashDel("h", []byte(strconv.Itoa(1000+calls)), []byte(strconv.Itoa(500+calls)))
+		require.NoError(t, err)
+		_, err = s.HashSet("h", [][]byte{[]byte(strconv.Itoa(2000 + calls)), []byte("v")}, false)
+		require.NoError(t, err)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	for i := range 500 {
+		assert.True(t, seen[strconv.Itoa(i)], i)
+	}
+}
+
+func TestHashRandFields(t *testing.T) {
+	s := NewInMemoryStorage()
+	_, err := s.HashSet("h", [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("2"), []byte("c"), []byte("3")}, false)
+	require.NoError(t, err)
+
+	pairs, err := s.HashRandFields("h", 2)
+	require.NoError(t, err)
+	require.Len(t, pairs, 4)
+	assert.NotEqual(t, pairs[0], pairs[2], "fields are distinct")
+
+	pairs, err = s.HashRandFields("h", 10)
+	require.NoError(t, err)
+	assert.Len(t, pairs, 6)
+
+	pairs, err = s.HashRandFields("h", -10)
+	require.NoError(t, err)
+	assert.Len(t, pairs, 20)
+
+	pairs, err = s.HashRandFields("missing", -10)
+	require.NoError(t, err)
+	assert.Empty(t, pairs)
+}
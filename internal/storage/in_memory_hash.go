@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"math"
+	"strconv"
+)
+
+// hash is the value of a hash key. Values are replaced, never modified in
+// place, so they may be shared with snapshots.
+type hash map[string][]byte
+
+// getHash returns the hash at k, creating an empty one if create is set and
+// k does not exist. It returns nil if k does not exist. Callers must hold the
+// write lock, and must call hashChanged after modifying the hash.
+func (s *InMemoryStorage) getHash(k string, create bool) (hash, error) {
+	s.expireIfNeeded(k)
+	v, ok := s.m[k]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		h := hash{}
+		s.m[k] = h
+		return h, nil
+	}
+	h, ok := v.(hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return h, nil
+}
+
+// readHash is getHash for callers holding only the read lock.
+func (s *InMemoryStorage) readHash(k string) (hash, error) {
+	ms := now().UnixMilli()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, ms) {
+		return nil, nil
+	}
+	h, ok := v.(hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	s.accessed(k, ms)
+	return h, nil
+}
+
+// hashChanged records a write to the hash at k by event, deleting it if it
+// became empty. Callers must hold the write lock.
+func (s *InMemoryStorage) hashChanged(k string, h hash, event string) {
+	s.changed(k, len(h) == 0, EventHash, event)
+}
+
+// pairs returns the given fields of h and their values, alternating.
+func (h hash) pairs(fields []string) [][]byte {
+	out := make([][]byte, 0, 2*len(fields))
+	for _, f := range fields {
+		out = append(out, []byte(f), clone(h[f]))
+	}
+	return out
+}
+
+func (s *InMemoryStorage) HashSet(k string, pairs [][]byte, nx bool) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	h, err := s.getHash(k, true)
+	if err != nil {
+		return 0, err
+	}
+	added, changed := 0, false
+	for i := 0; i+1 < len(pairs); i += 2 {
+		f := string(pairs[i])
+		_, exists := h[f]
+		if exists && nx {
+			continue
+		}
+		if !exists {
+			added++
+		}
+		h[f] = clone(pairs[i+1])
+		changed = true
+	}
+	switch {
+	case changed:
+		s.hashChanged(k, h, "hset")
+	case len(h) == 0:
+		s.remove(k)
+	}
+	return added, nil
+}
+
+func (s *InMemoryStorage) HashGet(k string, fields ...[]byte) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	h, err := s.readHash(k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(fields))
+	for i, f := range fields {
+		if v, ok := h[string(f)]; ok {
+			out[i] = clone(v)
+		}
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) HashDel(k string, fields ...[]byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	h, err := s.getHash(k, false)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range fields {
+		if _, ok := h[string(f)]; ok {
+			delete(h, string(f))
+			n++
+		}
+	}
+	if n > 0 {
+		s.hashChanged(k, h, "hdel")
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) HashLen(k string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	h, err := s.readHash(k)
+	return len(h), err
+}
+
+func (s *InMemoryStorage) HashGetAll(k string) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	h, err := s.readHash(k)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	return h.pairs(fields), nil
+}
+
+func (s *InMemoryStorage) HashIncrBy(k string, field []byte, delta int64) (int64, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	h, err := s.getHash(k, false)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if v, ok := h[string(field)]; ok {
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrIntegerOverflow
+	}
+	n += delta
+	if h == nil {
+		h, _ = s.getHash(k, true)
+	}
+	h[string(field)] = []byte(strconv.FormatInt(n, 10))
+	s.hashChanged(k, h, "hincrby")
+	return n, nil
+}
+
+func (s *InMemoryStorage) HashIncrByFloat(k string, field []byte, delta float64) ([]byte, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	h, err := s.getHash(k, false)
+	if err != nil {
+		return nil, err
+	}
+	var f float64
+	if v, ok := h[string(field)]; ok {
+		if f, err = strconv.ParseFloat(string(v), 64); err != nil {
+			return nil, ErrNotFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, ErrNotFinite
+	}
+	if h == nil {
+		h, _ = s.getHash(k, true)
+	}
+	v := []byte(strconv.FormatFloat(f, 'f', -1, 64))
+	h[string(field)] = v
+	s.hashChanged(k, h, "hincrbyfloat")
+	return clone(v), nil
+}
+
+func (s *InMemoryStorage) HashScan(k string, cursor uint64, count int) (uint64, [][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	h, err := s.readHash(k)
+	if err != nil {
+		return 0, nil, err
+	}
+	next, fields := scan(h, cursor, count)
+	return next, h.pairs(fields), nil
+}
+
+func (s *InMemoryStorage) HashRandFields(k string, count int) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	h, err := s.readHash(k)
+	if err != nil || len(h) == 0 {
+		return nil, err
+	}
+	return h.pairs(randomMembers(h, count)), nil
+}
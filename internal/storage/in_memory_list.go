@@ -0,0 +1,252 @@
+package storage
+
+// getList returns the list at k, creating an empty one if create is set and
+// k does not exist. It returns nil if k does not exist. Callers must hold the
+// write lock, and must call listChanged after modifying the list.
+func (s *InMemoryStorage) getList(k string, create bool) (*list, error) {
+	s.expireIfNeeded(k)
+	v, ok := s.m[k]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		l := newList()
+		s.m[k] = l
+		return l, nil
+	}
+	l, ok := v.(*list)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return l, nil
+}
+
+// readList is getList for callers holding only the read lock.
+func (s *InMemoryStorage) readList(k string) (*list, error) {
+	ms := now().UnixMilli()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, ms) {
+		return nil, nil
+	}
+	l, ok := v.(*list)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	s.accessed(k, ms)
+	return l, nil
+}
+
+// listChanged records a write to the list at k by event, deleting it if it
+// became empty. Callers must hold the write lock.
+func (s *InMemoryStorage) listChanged(k string, l *list, event string) {
+	s.changed(k, l.count == 0, EventList, event)
+}
+
+// endEvent returns the event of pushing or popping at end: one of lpush,
+// rpush, lpop and rpop.
+func endEvent(end ListEnd, op string) string {
+	if end == ListHead {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// normalizeIndex resolves a possibly negative index into a list of n
+// elements and reports whether it is in range.
+func normalizeIndex(i, n int) (int, bool) {
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+// normalizeRange resolves an inclusive, possibly negative range into a list
+// of n elements, clamping it, and reports whether it is non-empty.
+func normalizeRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	return start, stop, start <= stop
+}
+
+func (s *InMemoryStorage) ListPush(k string, end ListEnd, vals [][]byte, existing bool) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, !existing)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	for _, v := range vals {
+		if end == ListHead {
+			l.pushHead(v)
+		} else {
+			l.pushTail(v)
+		}
+	}
+	s.listChanged(k, l, endEvent(end, "push"))
+	return l.count, nil
+}
+
+func (s *InMemoryStorage) ListPop(k string, end ListEnd, count int) ([][]byte, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, false)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, min(count, l.count))
+	for len(out) < count && l.count > 0 {
+		if end == ListHead {
+			out = append(out, l.popHead())
+		} else {
+			out = append(out, l.popTail())
+		}
+	}
+	s.listChanged(k, l, endEvent(end, "pop"))
+	return out, nil
+}
+
+func (s *InMemoryStorage) ListLen(k string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	l, err := s.readList(k)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	return l.count, nil
+}
+
+func (s *InMemoryStorage) ListIndex(k string, i int) ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	l, err := s.readList(k)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	i, ok := normalizeIndex(i, l.count)
+	if !ok {
+		return nil, nil
+	}
+	return l.index(i), nil
+}
+
+func (s *InMemoryStorage) ListSet(k string, i int, v []byte) error {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, false)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return ErrKeyNotFound
+	}
+	i, ok := normalizeIndex(i, l.count)
+	if !ok {
+		return ErrIndexOutOfRange
+	}
+	l.set(i, v)
+	s.listChanged(k, l, "lset")
+	return nil
+}
+
+func (s *InMemoryStorage) ListRange(k string, start, stop int) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	l, err := s.readList(k)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	start, stop, ok := normalizeRange(start, stop, l.count)
+	if !ok {
+		return nil, nil
+	}
+	return l.rangeOf(start, stop), nil
+}
+
+func (s *InMemoryStorage) ListRem(k string, count int, v []byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, false)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	n := l.remove(count, v)
+	if n > 0 {
+		s.listChanged(k, l, "lrem")
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) ListTrim(k string, start, stop int) error {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, false)
+	if err != nil || l == nil {
+		return err
+	}
+	start, stop, ok := normalizeRange(start, stop, l.count)
+	if !ok {
+		start, stop = 1, 0
+	}
+	l.trim(start, stop)
+	s.listChanged(k, l, "ltrim")
+	return nil
+}
+
+func (s *InMemoryStorage) ListInsert(k string, before bool, pivot, v []byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	l, err := s.getList(k, false)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	if !l.insert(pivot, v, before) {
+		return -1, nil
+	}
+	s.listChanged(k, l, "linsert")
+	return l.count, nil
+}
+
+func (s *InMemoryStorage) ListMove(src, dst string, from, to ListEnd) ([]byte, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	return listMove(s, s, src, dst, from, to)
+}
+
+// listMove implements ListMove between the shards holding src and dst,
+// which may be the same. Callers must hold both write locks.
+func listMove(a, b *InMemoryStorage, src, dst string, from, to ListEnd) ([]byte, error) {
+	sl, err := a.getList(src, false)
+	if err != nil || sl == nil {
+		return nil, err
+	}
+	// Check the destination before popping, so a type error changes nothing.
+	b.expireIfNeeded(dst)
+	if v, ok := b.m[dst]; ok {
+		if _, ok := v.(*list); !ok {
+			return nil, ErrWrongType
+		}
+	}
+
+	var v []byte
+	if from == ListHead {
+		v = sl.popHead()
+	} else {
+		v = sl.popTail()
+	}
+	a.listChanged(src, sl, endEvent(from, "pop"))
+
+	dl, _ := b.getList(dst, true)
+	if to == ListHead {
+		dl.pushHead(v)
+	} else {
+		dl.pushTail(v)
+	}
+	b.listChanged(dst, dl, endEvent(to, "push"))
+	return v, nil
+}
@@ -0,0 +1,328 @@
+package storage
+
+import "slices"
+
+// set is the value of a set key.
+type set map[string]struct{}
+
+// getSet returns the set at k, creating an empty one if create is set and k
+// does not exist. It returns nil if k does not exist. Callers must hold the
+// write lock, and must call setChanged after modifying the set.
+func (s *InMemoryStorage) getSet(k string, create bool) (set, error) {
+	s.expireIfNeeded(k)
+	v, ok := s.m[k]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		st := set{}
+		s.m[k] = st
+		return st, nil
+	}
+	st, ok := v.(set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return st, nil
+}
+
+// readSet is getSet for callers holding only the read lock.
+func (s *InMemoryStorage) readSet(k string) (set, error) {
+	ms := now().UnixMilli()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, ms) {
+		return nil, nil
+	}
+	st, ok := v.(set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	s.accessed(k, ms)
+	return st, nil
+}
+
+// setChanged records a write to the set at k by event, deleting it if it
+// became empty. Callers must hold the write lock.
+func (s *InMemoryStorage) setChanged(k string, st set, event string) {
+	s.changed(k, len(st) == 0, EventSet, event)
+}
+
+func (st set) members() [][]byte {
+	out := make([][]byte, 0, len(st))
+	for m := range st {
+		out = append(out, []byte(m))
+	}
+	return out
+}
+
+func (s *InMemoryStorage) SetAdd(k string, members ...[]byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getSet(k, true)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		if _, ok := st[string(m)]; !ok {
+			st[string(m)] = struct{}{}
+			n++
+		}
+	}
+	switch {
+	case n > 0:
+		s.setChanged(k, st, "sadd")
+	case len(st) == 0:
+		s.remove(k)
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) SetRem(k string, members ...[]byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getSet(k, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		if _, ok := st[string(m)]; ok {
+			delete(st, string(m))
+			n++
+		}
+	}
+	if n > 0 {
+		s.setChanged(k, st, "srem")
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) SetMembers(k string) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readSet(k)
+	if err != nil {
+		return nil, err
+	}
+	return st.members(), nil
+}
+
+func (s *InMemoryStorage) SetIsMember(k string, members ...[]byte) ([]bool, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readSet(k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]bool, len(members))
+	for i, m := range members {
+		_, out[i] = st[string(m)]
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) SetCard(k string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readSet(k)
+	return len(st), err
+}
+
+func (s *InMemoryStorage) SetPop(k string, count int) ([][]byte, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getSet(k, false)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	popped := randomMembers(st, count)
+	out := make([][]byte, len(popped))
+	for i, m := range popped {
+		delete(st, m)
+		out[i] = []byte(m)
+	}
+	s.setChanged(k, st, "spop")
+	return out, nil
+}
+
+func (s *InMemoryStorage) SetRandMembers(k string, count int) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readSet(k)
+	if err != nil || len(st) == 0 {
+		return nil, err
+	}
+	members := randomMembers(st, count)
+	out := make([][]byte, len(members))
+	for i, m := range members {
+		out[i] = []byte(m)
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) SetScan(k string, cursor uint64, count int) (uint64, [][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readSet(k)
+	if err != nil {
+		return 0, nil, err
+	}
+	next, members := scan(st, cursor, count)
+	out := make([][]byte, len(members))
+	for i, m := range members {
+		out[i] = []byte(m)
+	}
+	return next, out, nil
+}
+
+func (s *InMemoryStorage) SetMove(src, dst string, member []byte) (bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	return setMove(s, s, src, dst, member)
+}
+
+// setMove implements SetMove between the shards holding src and dst, which
+// may be the same. Callers must hold both write locks.
+func setMove(a, b *InMemoryStorage, src, dst string, member []byte) (bool, error) {
+	from, err := a.getSet(src, false)
+	if err != nil {
+		return false, err
+	}
+	b.expireIfNeeded(dst)
+	if v, ok := b.m[dst]; ok {
+		if _, ok := v.(set); !ok {
+			return false, ErrWrongType
+		}
+	}
+	if _, ok := from[string(member)]; !ok {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+
+	delete(from, string(member))
+	a.setChanged(src, from, "srem")
+	to, _ := b.getSet(dst, true)
+	to[string(member)] = struct{}{}
+	b.setChanged(dst, to, "sadd")
+	return true, nil
+}
+
+func (s *InMemoryStorage) SetCombine(op SetOp, keys ...string) ([][]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res, err := combineSets(func(string) *InMemoryStorage { return s }, op, keys)
+	if err != nil {
+		return nil, err
+	}
+	return res.members(), nil
+}
+
+func (s *InMemoryStorage) SetCombineStore(op SetOp, dst string, keys ...string) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	res, err := combineSets(func(string) *InMemoryStorage { return s }, op, keys)
+	if err != nil {
+		return 0, err
+	}
+	s.storeSet(dst, res, "s"+op.name()+"store")
+	return len(res), nil
+}
+
+func (s *InMemoryStorage) SetInterCard(limit int, keys ...string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return interCard(func(string) *InMemoryStorage { return s }, limit, keys)
+}
+
+// storeSet replaces whatever is at k with st, the result of event, or
+// deletes k if st is empty. Callers must hold the write lock.
+func (s *InMemoryStorage) storeSet(k string, st set, event string) {
+	s.store(k, st, len(st) == 0, EventSet, event)
+}
+
+// readSets returns the sets at keys, nil for missing ones, from the shards
+// returned by shard. Callers must hold at least the read locks of those
+// shards.
+func readSets(shard func(string) *InMemoryStorage, keys []string) ([]set, error) {
+	sets := make([]set, len(keys))
+	for i, k := range keys {
+		st, err := shard(k).readSet(k)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = st
+	}
+	return sets, nil
+}
+
+// combineSets computes the union, intersection or difference of the sets at
+// keys into a new set. Callers must hold at least the read locks of the
+// shards holding keys.
+func combineSets(shard func(string) *InMemoryStorage, op SetOp, keys []string) (set, error) {
+	sets, err := readSets(shard, keys)
+	if err != nil {
+		return nil, err
+	}
+	out := set{}
+	switch op {
+	case SetUnion:
+		for _, st := range sets {
+			for m := range st {
+				out[m] = struct{}{}
+			}
+		}
+	case SetInter:
+		// Probe the others with the members of the smallest set.
+		slices.SortFunc(sets, func(a, b set) int { return len(a) - len(b) })
+		if len(sets[0]) == 0 {
+			return out, nil
+		}
+	members:
+		for m := range sets[0] {
+			for _, st := range sets[1:] {
+				if _, ok := st[m]; !ok {
+					continue members
+				}
+			}
+			out[m] = struct{}{}
+		}
+	case SetDiff:
+	diff:
+		for m := range sets[0] {
+			for _, st := range sets[1:] {
+				if _, ok := st[m]; ok {
+					continue diff
+				}
+			}
+			out[m] = struct{}{}
+		}
+	}
+	return out, nil
+}
+
+// interCard counts the members of the intersection of the sets at keys, up
+// to limit if it is positive, without building the intersection.
+func interCard(shard func(string) *InMemoryStorage, limit int, keys []string) (int, error) {
+	sets, err := readSets(shard, keys)
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(sets, func(a, b set) int { return len(a) - len(b) })
+	n := 0
+members:
+	for m := range sets[0] {
+		for _, st := range sets[1:] {
+			if _, ok := st[m]; !ok {
+				continue members
+			}
+		}
+		n++
+		if n == limit {
+			break
+		}
+	}
+	return n, nil
+}
@@ -1,28 +1,119 @@
 package storage
 
-import "hash/fnv"
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-const size int64 = 64
+// DefaultShards is the number of shards of NewInMemoryShardedStorage.
+const DefaultShards = 64
+
+// activeExpireInterval is how often every shard runs an active expire cycle.
+const activeExpireInterval = 100 * time.Millisecond
 
 type InMemoryShardedStorage struct {
 	m []*InMemoryStorage
+
+	// used is the estimated memory used by every shard, which they keep up
+	// to date.
+	used  atomic.Int64
+	limit atomic.Pointer[MemoryLimit]
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 func NewInMemoryShardedStorage() *InMemoryShardedStorage {
-	storages := make([]*InMemoryStorage, size)
-	for i := range size {
-		storages[i] = NewInMemoryStorage()
+	return NewInMemoryShardedStorageShards(DefaultShards)
+}
+
+// NewInMemoryShardedStorageShards returns a storage spreading keys over n
+// shards, each with its own lock. More shards mean less contention between
+// commands on different keys.
+func NewInMemoryShardedStorageShards(n int) *InMemoryShardedStorage {
+	s := &InMemoryShardedStorage{
+		m:    make([]*InMemoryStorage, n),
+		done: make(chan struct{}),
+	}
+	for i := range n {
+		s.m[i] = NewInMemoryStorage()
+		s.m[i].used = &s.used
 	}
+	go s.activeExpire()
+	return s
+}
 
-	return &InMemoryShardedStorage{
-		m: storages,
+// Close stops the background active expire sweep.
+func (s *InMemoryShardedStorage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+func (s *InMemoryShardedStorage) activeExpire() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			for _, shard := range s.m {
+				shard.activeExpireCycle()
+			}
+		}
 	}
 }
 
 func (s *InMemoryShardedStorage) shard(k string) *InMemoryStorage {
+	return s.m[s.shardIndex(k)]
+}
+
+func (s *InMemoryShardedStorage) shardIndex(k string) int {
 	h := fnv.New64a()
 	h.Write([]byte(k))
-	return s.m[h.Sum64()%uint64(size)]
+	return int(h.Sum64() % uint64(len(s.m)))
+}
+
+// shardsOf returns the indexes of the shards holding keys, sorted and
+// without duplicates. Shards are always locked in this order so that
+// concurrent callers locking several of them cannot deadlock.
+func (s *InMemoryShardedStorage) shardsOf(keys []string) []int {
+	idx := make([]int, 0, len(keys))
+	for _, k := range keys {
+		idx = append(idx, s.shardIndex(k))
+	}
+	slices.Sort(idx)
+	return slices.Compact(idx)
+}
+
+// lockShards takes the write locks of the shards holding keys and returns a
+// function releasing them.
+func (s *InMemoryShardedStorage) lockShards(keys ...string) func() {
+	idx := s.shardsOf(keys)
+	for _, i := range idx {
+		s.m[i].lock()
+	}
+	return func() {
+		for _, i := range idx {
+			s.m[i].mux.Unlock()
+		}
+	}
+}
+
+// rlockShards is lockShards for read locks.
+func (s *InMemoryShardedStorage) rlockShards(keys ...string) func() {
+	idx := s.shardsOf(keys)
+	for _, i := range idx {
+		s.m[i].mux.RLock()
+	}
+	return func() {
+		for _, i := range idx {
+			s.m[i].mux.RUnlock()
+		}
+	}
 }
 
 func (s *InMemoryShardedStorage) Get(k string) ([]byte, error) {
@@ -33,14 +124,20 @@ func (s *InMemoryShardedStorage) Set(k string, v []byte) error {
 	return s.shard(k).Set(k, v)
 }
 
+func (s *InMemoryShardedStorage) SetWithOptions(k string, v []byte, opts SetOptions) ([]byte, bool, error) {
+	return s.shard(k).SetWithOptions(k, v, opts)
+}
+
+// Del deletes keys from all their shards at once, so no other caller sees
+// some of them deleted and others not.
 func (s *InMemoryShardedStorage) Del(k ...string) (int, error) {
+	unlock := s.lockShards(k...)
+	defer unlock()
 	count := 0
 	for _, key := range k {
-		n, err := s.shard(key).Del(key)
-		if err != nil {
-			return count, err
+		if s.shard(key).del(key) {
+			count++
 		}
-		count += n
 	}
 	return count, nil
 }
@@ -48,3 +145,374 @@ func (s *InMemoryShardedStorage) Del(k ...string) (int, error) {
 func (s *InMemoryShardedStorage) Incr(k string) (int64, error) {
 	return s.shard(k).Incr(k)
 }
+
+func (s *InMemoryShardedStorage) Expire(k string, at time.Time, cond ExpireCond) (bool, error) {
+	return s.shard(k).Expire(k, at, cond)
+}
+
+func (s *InMemoryShardedStorage) Persist(k string) (bool, error) {
+	return s.shard(k).Persist(k)
+}
+
+func (s *InMemoryShardedStorage) ExpireTime(k string) (time.Time, error) {
+	return s.shard(k).ExpireTime(k)
+}
+
+func (s *InMemoryShardedStorage) Watch(k string) {
+	s.shard(k).Watch(k)
+}
+
+func (s *InMemoryShardedStorage) Unwatch(k string) {
+	s.shard(k).Unwatch(k)
+}
+
+func (s *InMemoryShardedStorage) Version(k string) uint64 {
+	return s.shard(k).Version(k)
+}
+
+func (s *InMemoryShardedStorage) KeyCounts() []KeyCount {
+	out := make([]KeyCount, 0, len(s.m))
+	for _, shard := range s.m {
+		out = append(out, shard.KeyCounts()...)
+	}
+	return out
+}
+
+func (s *InMemoryShardedStorage) UsedMemory() int64 {
+	return s.used.Load()
+}
+
+func (s *InMemoryShardedStorage) SetMemoryLimit(l MemoryLimit) {
+	s.limit.Store(&l)
+}
+
+// Evict samples keys from a few shards at a time, so that no shard is
+// locked for long.
+func (s *InMemoryShardedStorage) Evict() ([]string, error) {
+	return evict(s.m, &s.used, s.limit.Load())
+}
+
+func (s *InMemoryShardedStorage) SetNotifier(n Notifier) {
+	for _, shard := range s.m {
+		shard.SetNotifier(n)
+	}
+}
+
+func (s *InMemoryShardedStorage) Type(k string) (Kind, error) {
+	return s.shard(k).Type(k)
+}
+
+func (s *InMemoryShardedStorage) Restore(e Entry) error {
+	return s.shard(e.Key).Restore(e)
+}
+
+func (s *InMemoryShardedStorage) ListPush(k string, end ListEnd, vals [][]byte, existing bool) (int, error) {
+	return s.shard(k).ListPush(k, end, vals, existing)
+}
+
+func (s *InMemoryShardedStorage) ListPop(k string, end ListEnd, count int) ([][]byte, error) {
+	return s.shard(k).ListPop(k, end, count)
+}
+
+func (s *InMemoryShardedStorage) ListLen(k string) (int, error) {
+	return s.shard(k).ListLen(k)
+}
+
+func (s *InMemoryShardedStorage) ListIndex(k string, i int) ([]byte, error) {
+	return s.shard(k).ListIndex(k, i)
+}
+
+func (s *InMemoryShardedStorage) ListSet(k string, i int, v []byte) error {
+	return s.shard(k).ListSet(k, i, v)
+}
+
+func (s *InMemoryShardedStorage) ListRange(k string, start, stop int) ([][]byte, error) {
+	return s.shard(k).ListRange(k, start, stop)
+}
+
+func (s *InMemoryShardedStorage) ListRem(k string, count int, v []byte) (int, error) {
+	return s.shard(k).ListRem(k, count, v)
+}
+
+func (s *InMemoryShardedStorage) ListTrim(k string, start, stop int) error {
+	return s.shard(k).ListTrim(k, start, stop)
+}
+
+func (s *InMemoryShardedStorage) ListInsert(k string, before bool, pivot, v []byte) (int, error) {
+	return s.shard(k).ListInsert(k, before, pivot, v)
+}
+
+func (s *InMemoryShardedStorage) ListMove(src, dst string, from, to ListEnd) ([]byte, error) {
+	unlock := s.lockShards(src, dst)
+	defer unlock()
+	return listMove(s.shard(src), s.shard(dst), src, dst, from, to)
+}
+
+func (s *InMemoryShardedStorage) HashSet(k string, pairs [][]byte, nx bool) (int, error) {
+	return s.shard(k).HashSet(k, pairs, nx)
+}
+
+func (s *InMemoryShardedStorage) HashGet(k string, fields ...[]byte) ([][]byte, error) {
+	return s.shard(k).HashGet(k, fields...)
+}
+
+func (s *InMemoryShardedStorage) HashDel(k string, fields ...[]byte) (int, error) {
+	return s.shard(k).HashDel(k, fields...)
+}
+
+func (s *InMemoryShardedStorage) HashLen(k string) (int, error) {
+	return s.shard(k).HashLen(k)
+}
+
+func (s *InMemoryShardedStorage) HashGetAll(k string) ([][]byte, error) {
+	return s.shard(k).HashGetAll(k)
+}
+
+func (s *InMemoryShardedStorage) HashIncrBy(k string, field []byte, delta int64) (int64, error) {
+	return s.shard(k).HashIncrBy(k, field, delta)
+}
+
+func (s *InMemoryShardedStorage) HashIncrByFloat(k string, field []byte, delta float64) ([]byte, error) {
+	return s.shard(k).HashIncrByFloat(k, field, delta)
+}
+
+func (s *InMemoryShardedStorage) HashScan(k string, cursor uint64, count int) (uint64, [][]byte, error) {
+	return s.shard(k).HashScan(k, cursor, count)
+}
+
+func (s *InMemoryShardedStorage) HashRandFields(k string, count int) ([][]byte, error) {
+	return s.shard(k).HashRandFields(k, count)
+}
+
+func (s *InMemoryShardedStorage) SetAdd(k string, members ...[]byte) (int, error) {
+	return s.shard(k).SetAdd(k, members...)
+}
+
+func (s *InMemoryShardedStorage) SetRem(k string, members ...[]byte) (int, error) {
+	return s.shard(k).SetRem(k, members...)
+}
+
+func (s *InMemoryShardedStorage) SetMembers(k string) ([][]byte, error) {
+	return s.shard(k).SetMembers(k)
+}
+
+func (s *InMemoryShardedStorage) SetIsMember(k string, members ...[]byte) ([]bool, error) {
+	return s.shard(k).SetIsMember(k, members...)
+}
+
+func (s *InMemoryShardedStorage) SetCard(k string) (int, error) {
+	return s.shard(k).SetCard(k)
+}
+
+func (s *InMemoryShardedStorage) SetPop(k string, count int) ([][]byte, error) {
+	return s.shard(k).SetPop(k, count)
+}
+
+func (s *InMemoryShardedStorage) SetRandMembers(k string, count int) ([][]byte, error) {
+	return s.shard(k).SetRandMembers(k, count)
+}
+
+func (s *InMemoryShardedStorage) SetScan(k string, cursor uint64, count int) (uint64, [][]byte, error) {
+	return s.shard(k).SetScan(k, cursor, count)
+}
+
+func (s *InMemoryShardedStorage) SetMove(src, dst string, member []byte) (bool, error) {
+	unlock := s.lockShards(src, dst)
+	defer unlock()
+	return setMove(s.shard(src), s.shard(dst), src, dst, member)
+}
+
+// The multi-key set operations hold the locks of every shard involved for
+// their whole duration, so they observe and produce a consistent state.
+
+func (s *InMemoryShardedStorage) SetCombine(op SetOp, keys ...string) ([][]byte, error) {
+	unlock := s.rlockShards(keys...)
+	defer unlock()
+	res, err := combineSets(s.shard, op, keys)
+	if err != nil {
+		return nil, err
+	}
+	return res.members(), nil
+}
+
+func (s *InMemoryShardedStorage) SetCombineStore(op SetOp, dst string, keys ...string) (int, error) {
+	unlock := s.lockShards(append([]string{dst}, keys...)...)
+	defer unlock()
+	res, err := combineSets(s.shard, op, keys)
+	if err != nil {
+		return 0, err
+	}
+	s.shard(dst).storeSet(dst, res, "s"+op.name()+"store")
+	return len(res), nil
+}
+
+func (s *InMemoryShardedStorage) SetInterCard(limit int, keys ...string) (int, error) {
+	unlock := s.rlockShards(keys...)
+	defer unlock()
+	return interCard(s.shard, limit, keys)
+}
+
+func (s *InMemoryShardedStorage) ZAdd(k string, opts ZAddOptions, members []ScoredMember) (int, int, error) {
+	return s.shard(k).ZAdd(k, opts, members)
+}
+
+func (s *InMemoryShardedStorage) ZIncrBy(k string, opts ZAddOptions, member []byte, delta float64) (float64, bool, error) {
+	return s.shard(k).ZIncrBy(k, opts, member, delta)
+}
+
+func (s *InMemoryShardedStorage) ZRem(k string, members ...[]byte) (int, error) {
+	return s.shard(k).ZRem(k, members...)
+}
+
+func (s *InMemoryShardedStorage) ZCard(k string) (int, error) {
+	return s.shard(k).ZCard(k)
+}
+
+func (s *InMemoryShardedStorage) ZScores(k string, members ...[]byte) ([]*float64, error) {
+	return s.shard(k).ZScores(k, members...)
+}
+
+func (s *InMemoryShardedStorage) ZRank(k string, member []byte, rev bool) (int, float64, error) {
+	return s.shard(k).ZRank(k, member, rev)
+}
+
+func (s *InMemoryShardedStorage) ZRange(k string, r ZRange) ([]ScoredMember, error) {
+	return s.shard(k).ZRange(k, r)
+}
+
+func (s *InMemoryShardedStorage) ZCount(k string, r ZRange) (int, error) {
+	return s.shard(k).ZCount(k, r)
+}
+
+func (s *InMemoryShardedStorage) ZRangeStore(dst, src string, r ZRange) (int, error) {
+	unlock := s.lockShards(dst, src)
+	defer unlock()
+	return zrangeStore(s.shard(src), s.shard(dst), dst, src, r)
+}
+
+func (s *InMemoryShardedStorage) ZRemRange(k string, r ZRange) (int, error) {
+	return s.shard(k).ZRemRange(k, r)
+}
+
+func (s *InMemoryShardedStorage) ZPop(k string, count int, max bool) ([]ScoredMember, error) {
+	return s.shard(k).ZPop(k, count, max)
+}
+
+func (s *InMemoryShardedStorage) ZCombine(op SetOp, keys []string, weights []float64, agg ZAggregate) ([]ScoredMember, error) {
+	unlock := s.rlockShards(keys...)
+	defer unlock()
+	z, err := combineZSets(s.shard, op, keys, weights, agg)
+	if err != nil {
+		return nil, err
+	}
+	return z.members(), nil
+}
+
+func (s *InMemoryShardedStorage) ZCombineStore(op SetOp, dst string, keys []string, weights []float64, agg ZAggregate) (int, error) {
+	unlock := s.lockShards(append([]string{dst}, keys...)...)
+	defer unlock()
+	z, err := combineZSets(s.shard, op, keys, weights, agg)
+	if err != nil {
+		return 0, err
+	}
+	s.shard(dst).storeZSet(dst, z, "z"+op.name()+"store")
+	return z.len(), nil
+}
+
+func (s *InMemoryShardedStorage) XAdd(k string, fields [][]byte, opts XAddOptions) (StreamID, bool, error) {
+	return s.shard(k).XAdd(k, fields, opts)
+}
+
+func (s *InMemoryShardedStorage) XLen(k string) (int, error) {
+	return s.shard(k).XLen(k)
+}
+
+func (s *InMemoryShardedStorage) XRange(k string, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	return s.shard(k).XRange(k, start, end, count, rev)
+}
+
+func (s *InMemoryShardedStorage) XDel(k string, ids ...StreamID) (int, error) {
+	return s.shard(k).XDel(k, ids...)
+}
+
+func (s *InMemoryShardedStorage) XTrim(k string, trim StreamTrim) (int, error) {
+	return s.shard(k).XTrim(k, trim)
+}
+
+func (s *InMemoryShardedStorage) XLastID(k string) (StreamID, error) {
+	return s.shard(k).XLastID(k)
+}
+
+func (s *InMemoryShardedStorage) XSetID(k string, id StreamID) error {
+	return s.shard(k).XSetID(k, id)
+}
+
+func (s *InMemoryShardedStorage) XGroupCreate(k, group string, id StreamID, latest, mkStream bool) error {
+	return s.shard(k).XGroupCreate(k, group, id, latest, mkStream)
+}
+
+func (s *InMemoryShardedStorage) XGroupSetID(k, group string, id StreamID, latest bool) error {
+	return s.shard(k).XGroupSetID(k, group, id, latest)
+}
+
+func (s *InMemoryShardedStorage) XGroupDestroy(k, group string) (bool, error) {
+	return s.shard(k).XGroupDestroy(k, group)
+}
+
+func (s *InMemoryShardedStorage) XGroupCreateConsumer(k, group, consumer string) (bool, error) {
+	return s.shard(k).XGroupCreateConsumer(k, group, consumer)
+}
+
+func (s *InMemoryShardedStorage) XGroupDelConsumer(k, group, consumer string) (int, error) {
+	return s.shard(k).XGroupDelConsumer(k, group, consumer)
+}
+
+func (s *InMemoryShardedStorage) XReadGroup(k, group, consumer string, after StreamID, history bool, count int, noAck bool) ([]StreamEntry, error) {
+	return s.shard(k).XReadGroup(k, group, consumer, after, history, count, noAck)
+}
+
+func (s *InMemoryShardedStorage) XAck(k, group string, ids ...StreamID) (int, error) {
+	return s.shard(k).XAck(k, group, ids...)
+}
+
+func (s *InMemoryShardedStorage) XPendingSummary(k, group string) (PendingSummary, error) {
+	return s.shard(k).XPendingSummary(k, group)
+}
+
+func (s *InMemoryShardedStorage) XPending(k, group string, r PendingRange) ([]PendingEntry, error) {
+	return s.shard(k).XPending(k, group, r)
+}
+
+func (s *InMemoryShardedStorage) XClaim(k, group, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) ([]StreamEntry, error) {
+	return s.shard(k).XClaim(k, group, consumer, minIdle, ids, opts)
+}
+
+func (s *InMemoryShardedStorage) XAutoClaim(k, group, consumer string, minIdle time.Duration, start StreamID, count int, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	return s.shard(k).XAutoClaim(k, group, consumer, minIdle, start, count, justID)
+}
+
+func (s *InMemoryShardedStorage) FlushAll() error {
+	for _, shard := range s.m {
+		if err := shard.FlushAll(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot returns a point-in-time view of every shard. All shards are locked
+// together only long enough to register the snapshot; the copying happens
+// lazily, shard by shard.
+func (s *InMemoryShardedStorage) Snapshot() *Snapshot {
+	for _, shard := range s.m {
+		shard.mux.Lock()
+	}
+	snap := &Snapshot{shards: make([]*shardSnapshot, len(s.m))}
+	for i, shard := range s.m {
+		snap.shards[i] = shard.snapshot()
+	}
+	for _, shard := range s.m {
+		shard.mux.Unlock()
+	}
+	return snap
+}
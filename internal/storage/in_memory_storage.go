@@ -1,73 +1,479 @@
 package storage
 
 import (
+	"fmt"
 	"math"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// Keys with an expiry sampled per active expire round.
+	activeExpireSample = 20
+	// Maximum rounds per active expire cycle. A round is repeated while more
+	// than a quarter of the sampled keys were expired.
+	activeExpireMaxRounds = 16
+)
+
+// now is swapped out by tests that need to control the clock.
+var now = time.Now
+
+// InMemoryStorage keeps every key in a single map. A value is a []byte for
+// strings, a *list for lists, a hash for hashes, a set for sets or a *zset
+// for sorted sets or a *stream for streams. Values are never shared with callers.
 type InMemoryStorage struct {
-	mux sync.RWMutex
-	m   map[string][]byte
+	mux     sync.RWMutex
+	m       map[string]any
+	expires map[string]int64 // unix milliseconds
+	pending []*shardSnapshot
+
+	// versions holds the clock value of the last write to each key, for
+	// Version. A deleted key keeps the version of its deletion while it is
+	// watched, watched counting the Watch calls not yet undone by Unwatch.
+	// The clock only moves forward, even across FlushAll.
+	versions map[string]uint64
+	watched  map[string]int
+	clock    uint64
+
+	// meta holds the estimated size and access history of each key, and
+	// bytes their total. used is the total of every shard of the keyspace,
+	// shared between them, and limit the memory limit of a storage that is
+	// not sharded.
+	meta  map[string]*keyMeta
+	bytes int64
+	used  *atomic.Int64
+	limit atomic.Pointer[MemoryLimit]
+
+	notifier atomic.Pointer[Notifier]
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		m: make(map[string][]byte),
+		m:        make(map[string]any),
+		expires:  make(map[string]int64),
+		versions: make(map[string]uint64),
+		watched:  make(map[string]int),
+		meta:     make(map[string]*keyMeta),
+		used:     new(atomic.Int64),
+	}
+}
+
+// expired reports whether k has an expiry at or before ms. Callers must hold
+// at least a read lock.
+func (s *InMemoryStorage) expired(k string, ms int64) bool {
+	at, ok := s.expires[k]
+	return ok && at <= ms
+}
+
+// expireIfNeeded deletes k if it has expired. Callers must hold the write lock.
+func (s *InMemoryStorage) expireIfNeeded(k string) {
+	if s.expired(k, now().UnixMilli()) {
+		s.remove(k)
+		s.notify(EventExpired, "expired", k)
+	}
+}
+
+// remove deletes k and its expiry. Callers must hold the write lock.
+func (s *InMemoryStorage) remove(k string) {
+	if _, ok := s.m[k]; ok {
+		s.deleted(k)
+	}
+	delete(s.m, k)
+	delete(s.expires, k)
+	s.unaccount(k)
+}
+
+// deleted records the deletion of k: its version is kept only while it is
+// watched. Callers must hold the write lock.
+func (s *InMemoryStorage) deleted(k string) {
+	if s.watched[k] == 0 {
+		delete(s.versions, k)
+		return
 	}
+	s.clock++
+	s.versions[k] = s.clock
+}
+
+// touch records a write to k. Callers must hold the write lock.
+func (s *InMemoryStorage) touch(k string) {
+	s.clock++
+	// Keys get their metadata on their first write.
+	if s.notifier.Load() != nil && s.meta[k] == nil {
+		s.notify(EventNew, "new", k)
+	}
+	s.versions[k] = s.clock
+	s.account(k)
 }
 
 func (s *InMemoryStorage) Get(k string) ([]byte, error) {
 	s.mux.RLock()
-	defer s.mux.RUnlock()
+	ms := now().UnixMilli()
 	v, ok := s.m[k]
-	if !ok {
-		return nil, ErrKeyNotFound
+	if ok && !s.expired(k, ms) {
+		defer s.mux.RUnlock()
+		str, ok := v.([]byte)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		s.accessed(k, ms)
+		return clone(str), nil
+	}
+	s.mux.RUnlock()
+
+	if ok {
+		s.lock()
+		s.expireIfNeeded(k)
+		s.mux.Unlock()
 	}
-	cp := make([]byte, len(v))
-	copy(cp, v)
-	return cp, nil
+	return nil, ErrKeyNotFound
+}
+
+func (s *InMemoryStorage) KeyCounts() []KeyCount {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return []KeyCount{{Keys: len(s.m), Expires: len(s.expires)}}
+}
+
+func (s *InMemoryStorage) UsedMemory() int64 {
+	return s.used.Load()
+}
+
+func (s *InMemoryStorage) SetMemoryLimit(l MemoryLimit) {
+	s.limit.Store(&l)
+}
+
+func (s *InMemoryStorage) Evict() ([]string, error) {
+	return evict([]*InMemoryStorage{s}, s.used, s.limit.Load())
 }
 
 func (s *InMemoryStorage) Set(k string, v []byte) error {
-	s.mux.Lock()
+	s.lock()
 	defer s.mux.Unlock()
-	cp := make([]byte, len(v))
-	copy(cp, v)
-	s.m[k] = cp
+	s.m[k] = clone(v)
+	delete(s.expires, k)
+	s.touch(k)
+	s.notify(EventString, "set", k)
 	return nil
 }
 
+func (s *InMemoryStorage) SetWithOptions(k string, v []byte, opts SetOptions) ([]byte, bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	s.expireIfNeeded(k)
+
+	cur, exists := s.m[k]
+	old, isString := cur.([]byte)
+	if opts.Get && exists && !isString {
+		return nil, false, ErrWrongType
+	}
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return old, false, nil
+	}
+
+	s.m[k] = clone(v)
+	switch {
+	case opts.KeepTTL:
+	case opts.ExpireAt.IsZero():
+		delete(s.expires, k)
+	default:
+		s.expires[k] = opts.ExpireAt.UnixMilli()
+	}
+	s.touch(k)
+	s.notify(EventString, "set", k)
+	if !opts.KeepTTL && !opts.ExpireAt.IsZero() {
+		s.notify(EventGeneric, "expire", k)
+	}
+	return old, true, nil
+}
+
 func (s *InMemoryStorage) Del(k ...string) (int, error) {
-	s.mux.Lock()
+	s.lock()
 	defer s.mux.Unlock()
 	count := 0
 	for _, item := range k {
-		if _, ok := s.m[item]; ok {
+		if s.del(item) {
 			count++
 		}
-		delete(s.m, item)
 	}
 	return count, nil
 }
 
+// del deletes k and reports whether it existed. Callers must hold the write
+// lock.
+func (s *InMemoryStorage) del(k string) bool {
+	s.expireIfNeeded(k)
+	_, ok := s.m[k]
+	s.remove(k)
+	if ok {
+		s.notify(EventGeneric, "del", k)
+	}
+	return ok
+}
+
 func (s *InMemoryStorage) Incr(k string) (int64, error) {
-	s.mux.Lock()
+	s.lock()
 	defer s.mux.Unlock()
-	v, ok := s.m[k]
+	s.expireIfNeeded(k)
+	cur, ok := s.m[k]
 	if !ok {
 		s.m[k] = []byte(strconv.FormatInt(1, 10))
+		s.touch(k)
+		s.notify(EventString, "incrby", k)
 		return 1, nil
 	}
+	v, ok := cur.([]byte)
+	if !ok {
+		return 0, ErrWrongType
+	}
 	n, err := strconv.ParseInt(string(v), 10, 64)
-
 	if err != nil {
-		return 0, err
+		return 0, ErrNotInteger
 	}
 	if n == math.MaxInt64 {
 		return 0, ErrIntegerOverflow
 	}
 	n++
 	s.m[k] = []byte(strconv.FormatInt(n, 10))
+	s.touch(k)
+	s.notify(EventString, "incrby", k)
 	return n, nil
 }
+
+func (s *InMemoryStorage) Expire(k string, at time.Time, cond ExpireCond) (bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	s.expireIfNeeded(k)
+	if _, ok := s.m[k]; !ok {
+		return false, nil
+	}
+
+	ms := at.UnixMilli()
+	cur, has := s.expires[k]
+	switch cond {
+	case ExpireNX:
+		if has {
+			return false, nil
+		}
+	case ExpireXX:
+		if !has {
+			return false, nil
+		}
+	case ExpireGT:
+		// A key without an expiry has an infinite TTL, so nothing is greater.
+		if !has || ms <= cur {
+			return false, nil
+		}
+	case ExpireLT:
+		if has && ms >= cur {
+			return false, nil
+		}
+	}
+
+	if ms <= now().UnixMilli() {
+		s.remove(k)
+		s.notify(EventGeneric, "del", k)
+		return true, nil
+	}
+	s.expires[k] = ms
+	s.touch(k)
+	s.notify(EventGeneric, "expire", k)
+	return true, nil
+}
+
+func (s *InMemoryStorage) Persist(k string) (bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	s.expireIfNeeded(k)
+	if _, ok := s.expires[k]; !ok {
+		return false, nil
+	}
+	delete(s.expires, k)
+	s.touch(k)
+	s.notify(EventGeneric, "persist", k)
+	return true, nil
+}
+
+func (s *InMemoryStorage) ExpireTime(k string) (time.Time, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	ms := now().UnixMilli()
+	if _, ok := s.m[k]; !ok || s.expired(k, ms) {
+		return time.Time{}, ErrKeyNotFound
+	}
+	at, ok := s.expires[k]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(at), nil
+}
+
+func (s *InMemoryStorage) FlushAll() error {
+	s.lock()
+	defer s.mux.Unlock()
+	for k := range s.m {
+		s.deleted(k)
+	}
+	s.m = make(map[string]any)
+	s.expires = make(map[string]int64)
+	s.meta = make(map[string]*keyMeta)
+	s.used.Add(-s.bytes)
+	s.bytes = 0
+	return nil
+}
+
+func (s *InMemoryStorage) Type(k string) (Kind, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, now().UnixMilli()) {
+		return 0, ErrKeyNotFound
+	}
+	return kindOf(v), nil
+}
+
+func kindOf(v any) Kind {
+	switch v.(type) {
+	case *list:
+		return KindList
+	case hash:
+		return KindHash
+	case set:
+		return KindSet
+	case *zset:
+		return KindZSet
+	case *stream:
+		return KindStream
+	default:
+		return KindString
+	}
+}
+
+func (s *InMemoryStorage) Restore(e Entry) error {
+	s.lock()
+	defer s.mux.Unlock()
+	s.remove(e.Key)
+
+	switch e.Kind {
+	case KindString:
+		s.m[e.Key] = clone(e.Value)
+	case KindList:
+		if len(e.Elems) == 0 {
+			return nil
+		}
+		l := newList()
+		for _, v := range e.Elems {
+			l.pushTail(v)
+		}
+		s.m[e.Key] = l
+	case KindHash:
+		if len(e.Elems) == 0 {
+			return nil
+		}
+		h := make(hash, len(e.Elems)/2)
+		for i := 0; i+1 < len(e.Elems); i += 2 {
+			h[string(e.Elems[i])] = clone(e.Elems[i+1])
+		}
+		s.m[e.Key] = h
+	case KindSet:
+		if len(e.Elems) == 0 {
+			return nil
+		}
+		st := make(set, len(e.Elems))
+		for _, m := range e.Elems {
+			st[string(m)] = struct{}{}
+		}
+		s.m[e.Key] = st
+	case KindZSet:
+		if len(e.Elems) == 0 {
+			return nil
+		}
+		z := newZSet()
+		for i := 0; i+1 < len(e.Elems); i += 2 {
+			score, err := strconv.ParseFloat(string(e.Elems[i]), 64)
+			if err != nil || math.IsNaN(score) {
+				return fmt.Errorf("restoring %s: invalid score %q", e.Key, e.Elems[i])
+			}
+			z.set(string(e.Elems[i+1]), score)
+		}
+		s.m[e.Key] = z
+	case KindStream:
+		if e.Stream == nil {
+			return fmt.Errorf("restoring %s: missing stream", e.Key)
+		}
+		s.m[e.Key] = streamFrom(e.Stream)
+	default:
+		return fmt.Errorf("restoring %s: unsupported kind %d", e.Key, e.Kind)
+	}
+	if !e.ExpireAt.IsZero() {
+		s.expires[e.Key] = e.ExpireAt.UnixMilli()
+	}
+	s.touch(e.Key)
+	s.notify(EventGeneric, "restore", e.Key)
+	return nil
+}
+
+func (s *InMemoryStorage) Watch(k string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.watched[k]++
+}
+
+func (s *InMemoryStorage) Unwatch(k string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.watched[k]--; s.watched[k] > 0 {
+		return
+	}
+	delete(s.watched, k)
+	if _, ok := s.m[k]; !ok {
+		delete(s.versions, k)
+	}
+}
+
+// Version deletes k first if it expired, so that it reads as the version of
+// the deletion rather than changing again once k is deleted.
+func (s *InMemoryStorage) Version(k string) uint64 {
+	s.mux.RLock()
+	if !s.expired(k, now().UnixMilli()) {
+		defer s.mux.RUnlock()
+		return s.versions[k]
+	}
+	s.mux.RUnlock()
+
+	s.lock()
+	defer s.mux.Unlock()
+	s.expireIfNeeded(k)
+	return s.versions[k]
+}
+
+// activeExpireCycle samples keys with an expiry and deletes the expired
+// ones, so keys that are never accessed again still release their memory.
+// It returns the number of keys deleted.
+func (s *InMemoryStorage) activeExpireCycle() int {
+	total := 0
+	for range activeExpireMaxRounds {
+		s.lock()
+		ms := now().UnixMilli()
+		sampled, expired := 0, 0
+		for k, at := range s.expires {
+			if sampled == activeExpireSample {
+				break
+			}
+			sampled++
+			if at <= ms {
+				s.remove(k)
+				s.notify(EventExpired, "expired", k)
+				expired++
+			}
+		}
+		s.mux.Unlock()
+
+		total += expired
+		if expired*4 <= sampled {
+			break
+		}
+	}
+	return total
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setClock pins the package clock to t for the duration of the test and
+// returns a function that moves it forward.
+func setClock(tb testing.TB, t time.Time) func(time.Duration) {
+	tb.Helper()
+	orig := now
+	now = func() time.Time { return t }
+	tb.Cleanup(func() { now = orig })
+	return func(d time.Duration) {
+		t = t.Add(d)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+
+	t.Run("lazy expiry on read", func(t *testing.T) {
+		advance := setClock(t, base)
+		s := NewInMemoryStorage()
+
+		_, ok, err := s.SetWithOptions("k", []byte("v"), SetOptions{ExpireAt: base.Add(time.Second)})
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		v, err := s.Get("k")
+		require.NoError(t, err)
+		assert.Equal(t, "v", string(v))
+
+		advance(time.Second)
+		_, err = s.Get("k")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		assert.NotContains(t, s.m, "k")
+		assert.NotContains(t, s.expires, "k")
+	})
+
+	t.Run("set clears expiry unless keepttl", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+		at := base.Add(time.Minute)
+
+		s.SetWithOptions("k", []byte("v"), SetOptions{ExpireAt: at})
+		s.SetWithOptions("k", []byte("v2"), SetOptions{KeepTTL: true})
+		got, err := s.ExpireTime("k")
+		require.NoError(t, err)
+		assert.Equal(t, at.UnixMilli(), got.UnixMilli())
+
+		require.NoError(t, s.Set("k", []byte("v3")))
+		got, err = s.ExpireTime("k")
+		require.NoError(t, err)
+		assert.True(t, got.IsZero())
+	})
+
+	t.Run("nx and xx", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+
+		_, ok, _ := s.SetWithOptions("k", []byte("v"), SetOptions{XX: true})
+		assert.False(t, ok)
+		_, ok, _ = s.SetWithOptions("k", []byte("v"), SetOptions{NX: true})
+		assert.True(t, ok)
+		old, ok, _ := s.SetWithOptions("k", []byte("v2"), SetOptions{NX: true})
+		assert.False(t, ok)
+		assert.Equal(t, "v", string(old))
+		old, ok, _ = s.SetWithOptions("k", []byte("v2"), SetOptions{XX: true})
+		assert.True(t, ok)
+		assert.Equal(t, "v", string(old))
+	})
+
+	t.Run("expired key counts as missing for nx", func(t *testing.T) {
+		advance := setClock(t, base)
+		s := NewInMemoryStorage()
+
+		s.SetWithOptions("k", []byte("v"), SetOptions{ExpireAt: base.Add(time.Second)})
+		advance(2 * time.Second)
+		old, ok, _ := s.SetWithOptions("k", []byte("new"), SetOptions{NX: true})
+		assert.True(t, ok)
+		assert.Nil(t, old)
+	})
+
+	t.Run("expire conditions", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+		s.Set("k", []byte("v"))
+		later, sooner := base.Add(time.Hour), base.Add(time.Minute)
+
+		ok, _ := s.Expire("k", later, ExpireXX)
+		assert.False(t, ok, "XX without expiry")
+		ok, _ = s.Expire("k", later, ExpireGT)
+		assert.False(t, ok, "GT without expiry")
+		ok, _ = s.Expire("k", later, ExpireNX)
+		assert.True(t, ok, "NX without expiry")
+		ok, _ = s.Expire("k", sooner, ExpireNX)
+		assert.False(t, ok, "NX with expiry")
+		ok, _ = s.Expire("k", sooner, ExpireGT)
+		assert.False(t, ok, "GT with sooner expiry")
+		ok, _ = s.Expire("k", sooner, ExpireLT)
+		assert.True(t, ok, "LT with sooner expiry")
+
+		got, _ := s.ExpireTime("k")
+		assert.Equal(t, sooner.UnixMilli(), got.UnixMilli())
+
+		ok, _ = s.Expire("missing", later, ExpireAlways)
+		assert.False(t, ok)
+	})
+
+	t.Run("expire in the past deletes", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+		s.Set("k", []byte("v"))
+
+		ok, err := s.Expire("k", base.Add(-time.Second), ExpireAlways)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		_, err = s.Get("k")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+		s.SetWithOptions("k", []byte("v"), SetOptions{ExpireAt: base.Add(time.Minute)})
+
+		ok, _ := s.Persist("k")
+		assert.True(t, ok)
+		ok, _ = s.Persist("k")
+		assert.False(t, ok)
+		got, err := s.ExpireTime("k")
+		require.NoError(t, err)
+		assert.True(t, got.IsZero())
+	})
+
+	t.Run("incr keeps expiry", func(t *testing.T) {
+		setClock(t, base)
+		s := NewInMemoryStorage()
+		at := base.Add(time.Minute)
+		s.SetWithOptions("n", []byte("1"), SetOptions{ExpireAt: at})
+
+		n, err := s.Incr("n")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		got, _ := s.ExpireTime("n")
+		assert.Equal(t, at.UnixMilli(), got.UnixMilli())
+	})
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+	advance := setClock(t, base)
+	s := NewInMemoryStorage()
+
+	for i := range 100 {
+		s.SetWithOptions("k"+strconv.Itoa(i), []byte("v"), SetOptions{ExpireAt: base.Add(time.Second)})
+	}
+	s.Set("persistent", []byte("v"))
+
+	advance(time.Minute)
+	for len(s.expires) > 0 {
+		require.Positive(t, s.activeExpireCycle())
+	}
+	assert.Len(t, s.m, 1)
+	assert.Contains(t, s.m, "persistent")
+}
+
+func TestVersion(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+	advance := setClock(t, base)
+	s := NewInMemoryStorage()
+	s.Watch("k")
+	s.Watch("m")
+
+	assert.Zero(t, s.Version("k"))
+	require.NoError(t, s.Set("k", []byte("1")))
+	v1 := s.Version("k")
+	assert.NotZero(t, v1)
+	assert.Equal(t, v1, s.Version("k"), "reads do not change the version")
+	s.Get("k")
+	assert.Equal(t, v1, s.Version("k"))
+
+	s.Incr("k")
+	v2 := s.Version("k")
+	assert.Greater(t, v2, v1)
+
+	s.Expire("k", base.Add(time.Second), ExpireAlways)
+	v3 := s.Version("k")
+	assert.Greater(t, v3, v2)
+
+	advance(time.Second)
+	v4 := s.Version("k")
+	assert.Greater(t, v4, v3, "expiring is a write")
+	assert.Equal(t, v4, s.Version("k"))
+
+	s.Set("k", []byte("again"))
+	v5 := s.Version("k")
+	assert.Greater(t, v5, v4)
+	s.Del("k")
+	assert.Greater(t, s.Version("k"), v5, "deleted watched keys keep the version of their deletion")
+
+	// A key created and deleted in between reads differently.
+	missing := s.Version("m")
+	assert.Zero(t, missing)
+	s.Set("m", []byte("x"))
+	s.Del("m")
+	assert.NotEqual(t, missing, s.Version("m"))
+	before := s.Version("m")
+	s.Del("m")
+	assert.Equal(t, before, s.Version("m"), "deleting a missing key is not a write")
+
+	s.Set("k", []byte("x"))
+	before = s.Version("k")
+	require.NoError(t, s.FlushAll())
+	s.Set("k", []byte("x"))
+	assert.Greater(t, s.Version("k"), before, "versions keep increasing across flushes")
+
+	s.Del("k")
+	s.Watch("m")
+	s.Unwatch("m")
+	assert.NotZero(t, s.Version("m"), "m is still watched once")
+	s.Unwatch("k")
+	s.Unwatch("m")
+	assert.Zero(t, s.Version("k"))
+	assert.Zero(t, s.Version("m"))
+
+	// Keys nobody watches leave no version behind.
+	for i := range 100 {
+		k := "churn" + strconv.Itoa(i)
+		s.Set(k, []byte("x"))
+		s.Del(k)
+		s.Set(k, []byte("x"))
+		s.Expire(k, base, ExpireAlways)
+	}
+	assert.Empty(t, s.versions)
+	assert.Empty(t, s.watched)
+}
+
+func TestKeyCounts(t *testing.T) {
+	s := NewInMemoryShardedStorageShards(4)
+	t.Cleanup(func() { s.Close() })
+
+	for i := range 10 {
+		k := "k" + strconv.Itoa(i)
+		s.Set(k, []byte("v"))
+		if i%2 == 0 {
+			s.Expire(k, time.Now().Add(time.Hour), ExpireAlways)
+		}
+	}
+	counts := s.KeyCounts()
+	require.Len(t, counts, 4)
+	var total KeyCount
+	for i, c := range counts {
+		assert.Equal(t, c, s.m[i].KeyCounts()[0])
+		total.Keys += c.Keys
+		total.Expires += c.Expires
+	}
+	assert.Equal(t, KeyCount{Keys: 10, Expires: 5}, total)
+}
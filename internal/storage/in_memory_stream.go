@@ -0,0 +1,501 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// getStream returns the stream at k, creating an empty one if create is set
+// and k does not exist. It returns nil if k does not exist. Callers must hold
+// the write lock, and must touch k after modifying the stream.
+func (s *InMemoryStorage) getStream(k string, create bool) (*stream, error) {
+	s.expireIfNeeded(k)
+	v, ok := s.m[k]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		st := newStream()
+		s.m[k] = st
+		return st, nil
+	}
+	st, ok := v.(*stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return st, nil
+}
+
+// readStream is getStream for callers holding only the read lock.
+func (s *InMemoryStorage) readStream(k string) (*stream, error) {
+	ms := now().UnixMilli()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, ms) {
+		return nil, nil
+	}
+	st, ok := v.(*stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	s.accessed(k, ms)
+	return st, nil
+}
+
+// getGroup returns a group of the stream at k. It fails with ErrNoGroup if
+// either does not exist. Callers must hold the write lock.
+func (s *InMemoryStorage) getGroup(k, name string) (*stream, *group, error) {
+	st, err := s.getStream(k, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil || st.groups[name] == nil {
+		return nil, nil, ErrNoGroup
+	}
+	return st, st.groups[name], nil
+}
+
+// groupConsumer marks the consumer of g at k as seen at ms, creating it
+// if needed, and reports whether it already existed. Callers must hold the
+// write lock.
+func (s *InMemoryStorage) groupConsumer(k string, g *group, consumer string, ms int64) bool {
+	_, known := g.consumers[consumer]
+	g.consumer(consumer, ms)
+	if !known {
+		s.notify(EventStream, "xgroup-createconsumer", k)
+	}
+	return known
+}
+
+func (s *InMemoryStorage) XAdd(k string, fields [][]byte, opts XAddOptions) (StreamID, bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, false)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if st == nil {
+		if opts.NoMkStream {
+			return StreamID{}, false, nil
+		}
+		st = newStream()
+	}
+	id, err := st.nextID(opts, uint64(now().UnixMilli()))
+	if err != nil {
+		return StreamID{}, false, err
+	}
+
+	e := StreamEntry{ID: id, Fields: make([][]byte, len(fields))}
+	for i, f := range fields {
+		e.Fields[i] = clone(f)
+	}
+	st.entries = append(st.entries, e)
+	st.lastID = id
+	trimmed := st.trim(opts.Trim)
+	s.m[k] = st
+	s.touch(k)
+	s.notify(EventStream, "xadd", k)
+	if trimmed > 0 {
+		s.notify(EventStream, "xtrim", k)
+	}
+	return id, true, nil
+}
+
+func (s *InMemoryStorage) XLen(k string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readStream(k)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	return len(st.entries), nil
+}
+
+func (s *InMemoryStorage) XRange(k string, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readStream(k)
+	if err != nil || st == nil {
+		return []StreamEntry{}, err
+	}
+	return st.rangeOf(start, end, count, rev), nil
+}
+
+func (s *InMemoryStorage) XDel(k string, ids ...StreamID) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		i := st.search(id)
+		if i < len(st.entries) && st.entries[i].ID == id {
+			st.entries = append(st.entries[:i], st.entries[i+1:]...)
+			n++
+		}
+	}
+	if n > 0 {
+		s.touch(k)
+		s.notify(EventStream, "xdel", k)
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) XTrim(k string, trim StreamTrim) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	n := st.trim(trim)
+	if n > 0 {
+		s.touch(k)
+		s.notify(EventStream, "xtrim", k)
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) XLastID(k string) (StreamID, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, err := s.readStream(k)
+	if err != nil || st == nil {
+		return StreamID{}, err
+	}
+	return st.lastID, nil
+}
+
+func (s *InMemoryStorage) XSetID(k string, id StreamID) error {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, false)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return ErrKeyNotFound
+	}
+	if n := len(st.entries); n > 0 && id.Compare(st.entries[n-1].ID) < 0 {
+		return ErrStreamIDTooSmall
+	}
+	st.lastID = id
+	s.touch(k)
+	s.notify(EventStream, "xsetid", k)
+	return nil
+}
+
+func (s *InMemoryStorage) XGroupCreate(k, name string, id StreamID, latest, mkStream bool) error {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, mkStream)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return ErrKeyNotFound
+	}
+	if st.groups[name] != nil {
+		return ErrGroupExists
+	}
+	st.groups[name] = newGroup(st.latest(id, latest))
+	s.touch(k)
+	s.notify(EventStream, "xgroup-create", k)
+	return nil
+}
+
+func (s *InMemoryStorage) XGroupSetID(k, name string, id StreamID, latest bool) error {
+	s.lock()
+	defer s.mux.Unlock()
+	st, g, err := s.getGroup(k, name)
+	if err != nil {
+		return err
+	}
+	g.lastID = st.latest(id, latest)
+	s.touch(k)
+	s.notify(EventStream, "xgroup-setid", k)
+	return nil
+}
+
+func (s *InMemoryStorage) XGroupDestroy(k, name string) (bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, err := s.getStream(k, false)
+	if err != nil {
+		return false, err
+	}
+	if st == nil {
+		return false, ErrKeyNotFound
+	}
+	if st.groups[name] == nil {
+		return false, nil
+	}
+	delete(st.groups, name)
+	s.touch(k)
+	s.notify(EventStream, "xgroup-destroy", k)
+	return true, nil
+}
+
+func (s *InMemoryStorage) XGroupCreateConsumer(k, name, consumer string) (bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	_, g, err := s.getGroup(k, name)
+	if err != nil {
+		return false, err
+	}
+	if g.consumers[consumer] != nil {
+		return false, nil
+	}
+	g.consumer(consumer, now().UnixMilli())
+	s.touch(k)
+	s.notify(EventStream, "xgroup-createconsumer", k)
+	return true, nil
+}
+
+func (s *InMemoryStorage) XGroupDelConsumer(k, name, consumer string) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	_, g, err := s.getGroup(k, name)
+	if err != nil {
+		return 0, err
+	}
+	if g.consumers[consumer] == nil {
+		return 0, nil
+	}
+	n := 0
+	for id, p := range g.pending {
+		if p.consumer == consumer {
+			delete(g.pending, id)
+			n++
+		}
+	}
+	delete(g.consumers, consumer)
+	s.touch(k)
+	s.notify(EventStream, "xgroup-delconsumer", k)
+	return n, nil
+}
+
+func (s *InMemoryStorage) XReadGroup(k, name, consumer string, after StreamID, history bool, count int, noAck bool) ([]StreamEntry, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, g, err := s.getGroup(k, name)
+	if err != nil {
+		return nil, err
+	}
+	ms := now().UnixMilli()
+	known := s.groupConsumer(k, g, consumer, ms)
+	out := []StreamEntry{}
+	defer func() {
+		if !known || len(out) > 0 {
+			s.touch(k)
+		}
+	}()
+
+	if history {
+		for _, id := range g.pendingIDs() {
+			if count > 0 && len(out) == count {
+				break
+			}
+			p := g.pending[id]
+			if p.consumer != consumer || id.Compare(after) <= 0 {
+				continue
+			}
+			e, ok := st.get(id)
+			if !ok {
+				e = StreamEntry{ID: id}
+			}
+			out = append(out, cloneEntry(e))
+			p.deliveredAt = ms
+			p.deliveries++
+		}
+		return out, nil
+	}
+
+	start, ok := g.lastID.Next()
+	if !ok {
+		return out, nil
+	}
+	for _, e := range st.entries[st.search(start):] {
+		if count > 0 && len(out) == count {
+			break
+		}
+		out = append(out, cloneEntry(e))
+		g.lastID = e.ID
+		if !noAck {
+			g.pending[e.ID] = &pending{consumer: consumer, deliveredAt: ms, deliveries: 1}
+		}
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) XAck(k, name string, ids ...StreamID) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	_, g, err := s.getGroup(k, name)
+	if errors.Is(err, ErrNoGroup) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		if g.pending[id] != nil {
+			delete(g.pending, id)
+			n++
+		}
+	}
+	if n > 0 {
+		s.touch(k)
+	}
+	return n, nil
+}
+
+// readGroup is getGroup for callers holding only the read lock.
+func (s *InMemoryStorage) readGroup(k, name string) (*group, error) {
+	st, err := s.readStream(k)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil || st.groups[name] == nil {
+		return nil, ErrNoGroup
+	}
+	return st.groups[name], nil
+}
+
+func (s *InMemoryStorage) XPendingSummary(k, name string) (PendingSummary, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	g, err := s.readGroup(k, name)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+	sum := PendingSummary{Count: len(g.pending), Consumers: make(map[string]int)}
+	for id, p := range g.pending {
+		if len(sum.Consumers) == 0 || id.Compare(sum.Min) < 0 {
+			sum.Min = id
+		}
+		if id.Compare(sum.Max) > 0 {
+			sum.Max = id
+		}
+		sum.Consumers[p.consumer]++
+	}
+	return sum, nil
+}
+
+func (s *InMemoryStorage) XPending(k, name string, r PendingRange) ([]PendingEntry, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	g, err := s.readGroup(k, name)
+	if err != nil {
+		return nil, err
+	}
+	ms := now().UnixMilli()
+	out := []PendingEntry{}
+	for _, id := range g.pendingIDs() {
+		if len(out) >= r.Count || id.Compare(r.End) > 0 {
+			break
+		}
+		p := g.pending[id]
+		if id.Compare(r.Start) < 0 || (r.Consumer != "" && p.consumer != r.Consumer) {
+			continue
+		}
+		if time.Duration(ms-p.deliveredAt)*time.Millisecond < r.MinIdle {
+			continue
+		}
+		out = append(out, PendingEntry{
+			ID:          id,
+			Consumer:    p.consumer,
+			DeliveredAt: time.UnixMilli(p.deliveredAt),
+			Deliveries:  p.deliveries,
+		})
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) XClaim(k, name, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) ([]StreamEntry, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, g, err := s.getGroup(k, name)
+	if err != nil {
+		return nil, err
+	}
+	ms := now().UnixMilli()
+	s.groupConsumer(k, g, consumer, ms)
+	defer s.touch(k)
+	if opts.LastID.Compare(g.lastID) > 0 {
+		g.lastID = opts.LastID
+	}
+	deliveredAt := ms
+	if !opts.DeliveredAt.IsZero() {
+		deliveredAt = opts.DeliveredAt.UnixMilli()
+	}
+
+	out := []StreamEntry{}
+	for _, id := range ids {
+		p := g.pending[id]
+		e, ok := st.get(id)
+		switch {
+		case !ok:
+			delete(g.pending, id)
+			continue
+		case p == nil && !opts.Force:
+			continue
+		case p != nil && time.Duration(ms-p.deliveredAt)*time.Millisecond < minIdle:
+			continue
+		}
+		g.claim(id, consumer, deliveredAt, opts)
+		if opts.JustID {
+			e = StreamEntry{ID: id}
+		}
+		out = append(out, cloneEntry(e))
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) XAutoClaim(k, name, consumer string, minIdle time.Duration, start StreamID, count int, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	st, g, err := s.getGroup(k, name)
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+	ms := now().UnixMilli()
+	s.groupConsumer(k, g, consumer, ms)
+	defer s.touch(k)
+
+	claimed, deleted := []StreamEntry{}, []StreamID{}
+	ids := g.pendingIDs()
+	i := 0
+	for i < len(ids) && ids[i].Compare(start) < 0 {
+		i++
+	}
+	// Like Redis, look at no more than ten times count entries, so a call
+	// never scans a huge list of entries that are not idle enough.
+	for attempts := 10 * count; i < len(ids) && count > 0 && attempts > 0; i++ {
+		attempts--
+		id := ids[i]
+		e, ok := st.get(id)
+		if !ok {
+			delete(g.pending, id)
+			deleted = append(deleted, id)
+			continue
+		}
+		if time.Duration(ms-g.pending[id].deliveredAt)*time.Millisecond < minIdle {
+			continue
+		}
+		g.claim(id, consumer, ms, XClaimOptions{RetryCount: -1, JustID: justID})
+		if justID {
+			e = StreamEntry{ID: id}
+		}
+		claimed = append(claimed, cloneEntry(e))
+		count--
+	}
+
+	var next StreamID
+	if i < len(ids) {
+		next = ids[i]
+	}
+	return next, claimed, deleted, nil
+}
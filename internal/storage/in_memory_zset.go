@@ -0,0 +1,524 @@
+package storage
+
+import (
+	"bytes"
+	"math"
+)
+
+// getZSet returns the sorted set at k, creating an empty one if create is
+// set and k does not exist. It returns nil if k does not exist. Callers must
+// hold the write lock, and must call zsetChanged after modifying the set.
+func (s *InMemoryStorage) getZSet(k string, create bool) (*zset, error) {
+	s.expireIfNeeded(k)
+	v, ok := s.m[k]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		z := newZSet()
+		s.m[k] = z
+		return z, nil
+	}
+	z, ok := v.(*zset)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return z, nil
+}
+
+// readZSet is getZSet for callers holding only the read lock.
+func (s *InMemoryStorage) readZSet(k string) (*zset, error) {
+	ms := now().UnixMilli()
+	v, ok := s.m[k]
+	if !ok || s.expired(k, ms) {
+		return nil, nil
+	}
+	z, ok := v.(*zset)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	s.accessed(k, ms)
+	return z, nil
+}
+
+// zsetChanged records a write to the sorted set at k by event, deleting it
+// if it became empty. Callers must hold the write lock.
+func (s *InMemoryStorage) zsetChanged(k string, z *zset, event string) {
+	s.changed(k, z.len() == 0, EventZSet, event)
+}
+
+// storeZSet replaces whatever is at k with z, the result of event, or
+// deletes k if z is empty. Callers must hold the write lock.
+func (s *InMemoryStorage) storeZSet(k string, z *zset, event string) {
+	s.store(k, z, z.len() == 0, EventZSet, event)
+}
+
+// aboveMin reports whether n is at or above the lower end of r.
+func (r *ZRange) aboveMin(n *skipNode) bool {
+	if r.By == ZByLex {
+		switch r.LexMin.Inf {
+		case -1:
+			return true
+		case 1:
+			return false
+		}
+		c := bytes.Compare([]byte(n.member), r.LexMin.Value)
+		return c > 0 || (c == 0 && !r.LexMin.Exclusive)
+	}
+	return n.score > r.Min.Value || (n.score == r.Min.Value && !r.Min.Exclusive)
+}
+
+// belowMax reports whether n is at or below the upper end of r.
+func (r *ZRange) belowMax(n *skipNode) bool {
+	if r.By == ZByLex {
+		switch r.LexMax.Inf {
+		case -1:
+			return false
+		case 1:
+			return true
+		}
+		c := bytes.Compare([]byte(n.member), r.LexMax.Value)
+		return c < 0 || (c == 0 && !r.LexMax.Exclusive)
+	}
+	return n.score < r.Max.Value || (n.score == r.Max.Value && !r.Max.Exclusive)
+}
+
+// bounds returns the first and last nodes of a score or lex range, in list
+// order, or nils if the range is empty.
+func (z *zset) bounds(r *ZRange) (*skipNode, *skipNode) {
+	first := z.sl.first(func(n *skipNode) bool { return !r.aboveMin(n) })
+	if first == nil || !r.belowMax(first) {
+		return nil, nil
+	}
+	return first, z.sl.last(r.belowMax)
+}
+
+// nodes returns the nodes selected by r, in the requested order.
+func (z *zset) nodes(r *ZRange) []*skipNode {
+	var start *skipNode
+	n := 0
+	if r.By == ZByRank {
+		// Reversed ranks count from the highest member.
+		first, last, ok := normalizeRange(r.Start, r.Stop, z.len())
+		if !ok {
+			return nil
+		}
+		n = last - first + 1
+		if r.Rev {
+			first = z.len() - 1 - first
+		}
+		start = z.sl.byRank(first)
+	} else {
+		first, last := z.bounds(r)
+		if first == nil {
+			return nil
+		}
+		start, n = first, z.sl.rank(last.score, last.member)-z.sl.rank(first.score, first.member)+1
+		if r.Rev {
+			start = last
+		}
+		if r.Limit {
+			if r.Offset < 0 {
+				return nil
+			}
+			n -= r.Offset
+			for i := 0; i < r.Offset && start != nil; i++ {
+				start = step(start, r.Rev)
+			}
+			if r.Count >= 0 {
+				n = min(n, r.Count)
+			}
+		}
+	}
+
+	out := make([]*skipNode, 0, max(n, 0))
+	for x := start; x != nil && len(out) < n; x = step(x, r.Rev) {
+		out = append(out, x)
+	}
+	return out
+}
+
+// step moves to the next node in the direction of a range.
+func step(n *skipNode, rev bool) *skipNode {
+	if rev {
+		return n.backward
+	}
+	return n.next()
+}
+
+func scored(nodes []*skipNode) []ScoredMember {
+	out := make([]ScoredMember, len(nodes))
+	for i, n := range nodes {
+		out[i] = ScoredMember{Member: []byte(n.member), Score: n.score}
+	}
+	return out
+}
+
+func (s *InMemoryStorage) ZAdd(k string, opts ZAddOptions, members []ScoredMember) (int, int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := s.getZSet(k, !opts.XX)
+	if err != nil || z == nil {
+		return 0, 0, err
+	}
+	added, updated := 0, 0
+	for _, m := range members {
+		switch z.add(string(m.Member), m.Score, opts) {
+		case zsetAdded:
+			added++
+		case zsetUpdated:
+			updated++
+		}
+	}
+	switch {
+	case added+updated > 0:
+		s.zsetChanged(k, z, "zadd")
+	case z.len() == 0:
+		s.remove(k)
+	}
+	return added, updated, nil
+}
+
+// zsetResult is the outcome of adding a single member.
+type zsetResult int
+
+const (
+	zsetNone zsetResult = iota
+	zsetAdded
+	zsetUpdated
+)
+
+// add adds member with score, or updates its score, subject to opts.
+func (z *zset) add(member string, score float64, opts ZAddOptions) zsetResult {
+	cur, exists := z.scores[member]
+	switch {
+	case exists && opts.NX, !exists && opts.XX:
+		return zsetNone
+	case !exists:
+		z.set(member, score)
+		return zsetAdded
+	case score == cur, opts.GT && score < cur, opts.LT && score > cur:
+		return zsetNone
+	}
+	z.set(member, score)
+	return zsetUpdated
+}
+
+func (s *InMemoryStorage) ZIncrBy(k string, opts ZAddOptions, member []byte, delta float64) (float64, bool, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := s.getZSet(k, false)
+	if err != nil {
+		return 0, false, err
+	}
+	var cur float64
+	exists := false
+	if z != nil {
+		cur, exists = z.scores[string(member)]
+	}
+	if (exists && opts.NX) || (!exists && opts.XX) {
+		return 0, false, nil
+	}
+	score := cur + delta
+	if math.IsNaN(score) {
+		return 0, false, ErrNotFinite
+	}
+	if exists && ((opts.GT && score <= cur) || (opts.LT && score >= cur)) {
+		return 0, false, nil
+	}
+
+	if z == nil {
+		z, _ = s.getZSet(k, true)
+	}
+	if !exists || score != cur {
+		z.set(string(member), score)
+		s.zsetChanged(k, z, "zincr")
+	}
+	return score, true, nil
+}
+
+func (s *InMemoryStorage) ZRem(k string, members ...[]byte) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := s.getZSet(k, false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		if z.remove(string(m)) {
+			n++
+		}
+	}
+	if n > 0 {
+		s.zsetChanged(k, z, "zrem")
+	}
+	return n, nil
+}
+
+func (s *InMemoryStorage) ZCard(k string) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := s.readZSet(k)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	return z.len(), nil
+}
+
+func (s *InMemoryStorage) ZScores(k string, members ...[]byte) ([]*float64, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := s.readZSet(k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*float64, len(members))
+	if z == nil {
+		return out, nil
+	}
+	for i, m := range members {
+		if score, ok := z.scores[string(m)]; ok {
+			out[i] = &score
+		}
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) ZRank(k string, member []byte, rev bool) (int, float64, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := s.readZSet(k)
+	if err != nil || z == nil {
+		return -1, 0, err
+	}
+	score, ok := z.scores[string(member)]
+	if !ok {
+		return -1, 0, nil
+	}
+	rank := z.sl.rank(score, string(member))
+	if rev {
+		rank = z.len() - 1 - rank
+	}
+	return rank, score, nil
+}
+
+func (s *InMemoryStorage) ZRange(k string, r ZRange) ([]ScoredMember, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := s.readZSet(k)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	return scored(z.nodes(&r)), nil
+}
+
+func (s *InMemoryStorage) ZCount(k string, r ZRange) (int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := s.readZSet(k)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	return z.count(&r), nil
+}
+
+// count returns the number of members in a score or lex range.
+func (z *zset) count(r *ZRange) int {
+	first, last := z.bounds(r)
+	if first == nil {
+		return 0
+	}
+	return z.sl.rank(last.score, last.member) - z.sl.rank(first.score, first.member) + 1
+}
+
+func (s *InMemoryStorage) ZRangeStore(dst, src string, r ZRange) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	return zrangeStore(s, s, dst, src, r)
+}
+
+// zrangeStore implements ZRangeStore between the shards holding src and
+// dst, which may be the same. Callers must hold both write locks.
+func zrangeStore(a, b *InMemoryStorage, dst, src string, r ZRange) (int, error) {
+	z, err := a.readZSet(src)
+	if err != nil {
+		return 0, err
+	}
+	out := newZSet()
+	if z != nil {
+		for _, n := range z.nodes(&r) {
+			out.set(n.member, n.score)
+		}
+	}
+	b.storeZSet(dst, out, "zrangestore")
+	return out.len(), nil
+}
+
+func (s *InMemoryStorage) ZRemRange(k string, r ZRange) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := s.getZSet(k, false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	r.Rev, r.Limit = false, false
+	nodes := z.nodes(&r)
+	for _, n := range nodes {
+		z.remove(n.member)
+	}
+	if len(nodes) > 0 {
+		s.zsetChanged(k, z, "zremrangeby"+r.By.name())
+	}
+	return len(nodes), nil
+}
+
+func (s *InMemoryStorage) ZPop(k string, count int, max bool) ([]ScoredMember, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := s.getZSet(k, false)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	nodes := z.nodes(&ZRange{By: ZByRank, Start: 0, Stop: count - 1, Rev: max})
+	out := scored(nodes)
+	for _, n := range nodes {
+		z.remove(n.member)
+	}
+	event := "zpopmin"
+	if max {
+		event = "zpopmax"
+	}
+	s.zsetChanged(k, z, event)
+	return out, nil
+}
+
+func (s *InMemoryStorage) ZCombine(op SetOp, keys []string, weights []float64, agg ZAggregate) ([]ScoredMember, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	z, err := combineZSets(func(string) *InMemoryStorage { return s }, op, keys, weights, agg)
+	if err != nil {
+		return nil, err
+	}
+	return z.members(), nil
+}
+
+func (s *InMemoryStorage) ZCombineStore(op SetOp, dst string, keys []string, weights []float64, agg ZAggregate) (int, error) {
+	s.lock()
+	defer s.mux.Unlock()
+	z, err := combineZSets(func(string) *InMemoryStorage { return s }, op, keys, weights, agg)
+	if err != nil {
+		return 0, err
+	}
+	s.storeZSet(dst, z, "z"+op.name()+"store")
+	return z.len(), nil
+}
+
+// members returns every member of z, in order.
+func (z *zset) members() []ScoredMember {
+	out := make([]ScoredMember, 0, z.len())
+	for n := z.sl.header.next(); n != nil; n = n.next() {
+		out = append(out, ScoredMember{Member: []byte(n.member), Score: n.score})
+	}
+	return out
+}
+
+// readScores returns the scores of the members of the sorted set or set at
+// k, or nil if k does not exist. Callers must hold at least the read lock.
+func (s *InMemoryStorage) readScores(k string) (map[string]float64, error) {
+	v, ok := s.m[k]
+	if !ok || s.expired(k, now().UnixMilli()) {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case *zset:
+		return v.scores, nil
+	case set:
+		scores := make(map[string]float64, len(v))
+		for m := range v {
+			scores[m] = 1
+		}
+		return scores, nil
+	}
+	return nil, ErrWrongType
+}
+
+// combineZSets computes the union, intersection or difference of the sorted
+// sets at keys into a new sorted set. Callers must hold at least the read
+// locks of the shards holding keys.
+func combineZSets(shard func(string) *InMemoryStorage, op SetOp, keys []string, weights []float64, agg ZAggregate) (*zset, error) {
+	inputs := make([]map[string]float64, len(keys))
+	for i, k := range keys {
+		scores, err := shard(k).readScores(k)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = scores
+	}
+	weight := func(i int, score float64) float64 {
+		if weights == nil {
+			return score
+		}
+		// As in Redis, 0 * inf counts as 0 rather than NaN.
+		if v := score * weights[i]; !math.IsNaN(v) {
+			return v
+		}
+		return 0
+	}
+
+	out := newZSet()
+	switch op {
+	case SetUnion:
+		acc := map[string]float64{}
+		for i, in := range inputs {
+			for m, score := range in {
+				if cur, ok := acc[m]; ok {
+					acc[m] = aggregate(agg, cur, weight(i, score))
+				} else {
+					acc[m] = weight(i, score)
+				}
+			}
+		}
+		for m, score := range acc {
+			out.set(m, score)
+		}
+	case SetInter:
+	members:
+		for m, score := range inputs[0] {
+			acc := weight(0, score)
+			for i, in := range inputs[1:] {
+				score, ok := in[m]
+				if !ok {
+					continue members
+				}
+				acc = aggregate(agg, acc, weight(i+1, score))
+			}
+			out.set(m, acc)
+		}
+	case SetDiff:
+	diff:
+		for m, score := range inputs[0] {
+			for _, in := range inputs[1:] {
+				if _, ok := in[m]; ok {
+					continue diff
+				}
+			}
+			out.set(m, score)
+		}
+	}
+	return out, nil
+}
+
+func aggregate(agg ZAggregate, a, b float64) float64 {
+	switch agg {
+	case ZAggMin:
+		return min(a, b)
+	case ZAggMax:
+		return max(a, b)
+	}
+	// inf + -inf counts as 0 rather than NaN.
+	if v := a + b; !math.IsNaN(v) {
+		return v
+	}
+	return 0
+}
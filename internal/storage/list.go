@@ -0,0 +1,338 @@
+package storage
+
+import "encoding/binary"
+
+const (
+	// A list node holds at most this many elements or this many bytes,
+	// whichever limit is hit first. An element larger than the byte limit
+	// gets a node of its own.
+	listNodeMaxEntries = 128
+	listNodeMaxBytes   = 8 * 1024
+)
+
+// list is a doubly linked list of nodes, each packing a run of elements into
+// a single byte slice as uvarint length-prefixed entries. A short list is a
+// single node, costing barely more than its elements' bytes; a long one costs
+// one node per run, and pushes and pops at either end stay cheap.
+type list struct {
+	head, tail *listNode
+	count      int
+}
+
+type listNode struct {
+	prev, next *listNode
+	buf        []byte
+	count      int
+}
+
+func newList() *list {
+	return &list{}
+}
+
+// entrySize returns the encoded size of an element of n bytes.
+func entrySize(n int) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], uint64(n)) + n
+}
+
+func appendEntry(buf, v []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+// nextEntry decodes the entry at the start of buf and returns it with its size.
+func nextEntry(buf []byte) ([]byte, int) {
+	n, w := binary.Uvarint(buf)
+	return buf[w : w+int(n)], w + int(n)
+}
+
+// fits reports whether an element of n bytes can be added to the node.
+func (n *listNode) fits(size int) bool {
+	return n.count < listNodeMaxEntries && len(n.buf)+entrySize(size) <= listNodeMaxBytes
+}
+
+// entries returns the node's elements. They alias the node's buffer.
+func (n *listNode) entries() [][]byte {
+	out := make([][]byte, 0, n.count)
+	for buf := n.buf; len(buf) > 0; {
+		v, size := nextEntry(buf)
+		out = append(out, v)
+		buf = buf[size:]
+	}
+	return out
+}
+
+// insertAfter links n after at, or at the head if at is nil.
+func (l *list) insertAfter(at, n *listNode) {
+	n.prev = at
+	if at == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = at.next
+		at.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+func (l *list) unlink(n *listNode) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+}
+
+// replace swaps node n for nodes holding entries, packed as tightly as the
+// node limits allow. The entries may alias n's buffer.
+func (l *list) replace(n *listNode, entries [][]byte) {
+	l.count += len(entries) - n.count
+	at := n.prev
+	l.unlink(n)
+
+	var cur *listNode
+	for _, v := range entries {
+		if cur == nil || !cur.fits(len(v)) {
+			cur = &listNode{}
+			l.insertAfter(at, cur)
+			at = cur
+		}
+		cur.buf = appendEntry(cur.buf, v)
+		cur.count++
+	}
+}
+
+func (l *list) pushHead(v []byte) {
+	if l.head == nil || !l.head.fits(len(v)) {
+		l.insertAfter(nil, &listNode{})
+	}
+	h := l.head
+	buf := make([]byte, 0, entrySize(len(v))+len(h.buf))
+	h.buf = append(appendEntry(buf, v), h.buf...)
+	h.count++
+	l.count++
+}
+
+func (l *list) pushTail(v []byte) {
+	if l.tail == nil || !l.tail.fits(len(v)) {
+		l.insertAfter(l.tail, &listNode{})
+	}
+	t := l.tail
+	t.buf = appendEntry(t.buf, v)
+	t.count++
+	l.count++
+}
+
+func (l *list) popHead() []byte {
+	h := l.head
+	v, size := nextEntry(h.buf)
+	out := clone(v)
+	h.buf = h.buf[size:]
+	h.count--
+	l.count--
+	if h.count == 0 {
+		l.unlink(h)
+	}
+	return out
+}
+
+func (l *list) popTail() []byte {
+	t := l.tail
+	off := 0
+	for i := 0; i < t.count-1; i++ {
+		_, size := nextEntry(t.buf[off:])
+		off += size
+	}
+	v, _ := nextEntry(t.buf[off:])
+	out := clone(v)
+	t.buf = t.buf[:off]
+	t.count--
+	l.count--
+	if t.count == 0 {
+		l.unlink(t)
+	}
+	return out
+}
+
+// locate returns the node holding element i and the element's index within
+// it, walking from whichever end is closer.
+func (l *list) locate(i int) (*listNode, int) {
+	if i < l.count/2 {
+		n := l.head
+		for i >= n.count {
+			i -= n.count
+			n = n.next
+		}
+		return n, i
+	}
+	i = l.count - 1 - i
+	n := l.tail
+	for i >= n.count {
+		i -= n.count
+		n = n.prev
+	}
+	return n, n.count - 1 - i
+}
+
+// index returns a copy of element i, which must be in range.
+func (l *list) index(i int) []byte {
+	n, j := l.locate(i)
+	return clone(n.entries()[j])
+}
+
+// set replaces element i, which must be in range.
+func (l *list) set(i int, v []byte) {
+	n, j := l.locate(i)
+	entries := n.entries()
+	entries[j] = v
+	l.replace(n, entries)
+}
+
+// rangeOf returns copies of the elements from start to stop inclusive, which
+// must be in range.
+func (l *list) rangeOf(start, stop int) [][]byte {
+	out := make([][]byte, 0, stop-start+1)
+	n, j := l.locate(start)
+	for ; n != nil && len(out) < cap(out); n = n.next {
+		for _, v := range n.entries()[j:] {
+			if len(out) == cap(out) {
+				break
+			}
+			out = append(out, clone(v))
+		}
+		j = 0
+	}
+	return out
+}
+
+// elements returns copies of every element.
+func (l *list) elements() [][]byte {
+	if l.count == 0 {
+		return nil
+	}
+	return l.rangeOf(0, l.count-1)
+}
+
+// remove deletes up to count occurrences of v, all of them if count is 0,
+// scanning from the tail if count is negative. It returns the number deleted.
+func (l *list) remove(count int, v []byte) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	fromTail := count < 0
+	n := l.head
+	if fromTail {
+		n = l.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if fromTail {
+			next = n.prev
+		}
+		entries := n.entries()
+		kept := make([][]byte, 0, len(entries))
+		changed := false
+		for i := range entries {
+			j := i
+			if fromTail {
+				j = len(entries) - 1 - i
+			}
+			if (limit == 0 || removed < limit) && string(entries[j]) == string(v) {
+				removed++
+				changed = true
+				continue
+			}
+			kept = append(kept, entries[j])
+		}
+		if changed {
+			if fromTail {
+				for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+					kept[i], kept[j] = kept[j], kept[i]
+				}
+			}
+			l.replace(n, kept)
+		}
+		n = next
+	}
+	return removed
+}
+
+// trim keeps only the elements from start to stop inclusive. An empty range
+// (start > stop) empties the list.
+func (l *list) trim(start, stop int) {
+	if start > stop {
+		l.head, l.tail, l.count = nil, nil, 0
+		return
+	}
+	// Drop whole nodes from both ends, then cut the edge nodes.
+	for l.head.count <= start {
+		start -= l.head.count
+		stop -= l.head.count
+		l.count -= l.head.count
+		l.unlink(l.head)
+	}
+	for l.count-1-stop >= l.tail.count {
+		l.count -= l.tail.count
+		l.unlink(l.tail)
+	}
+	// A subset of a node's entries always fits in one node, so each edge
+	// stays a single node.
+	if start > 0 {
+		l.replace(l.head, l.head.entries()[start:])
+		stop -= start
+	}
+	if stop < l.count-1 {
+		t := l.tail
+		keep := t.count - (l.count - 1 - stop)
+		l.replace(t, t.entries()[:keep])
+	}
+}
+
+// insert adds v before or after the first occurrence of pivot and reports
+// whether pivot was found.
+func (l *list) insert(pivot, v []byte, before bool) bool {
+	for n := l.head; n != nil; n = n.next {
+		entries := n.entries()
+		for i, e := range entries {
+			if string(e) != string(pivot) {
+				continue
+			}
+			if !before {
+				i++
+			}
+			grown := make([][]byte, 0, len(entries)+1)
+			grown = append(grown, entries[:i]...)
+			grown = append(grown, v)
+			grown = append(grown, entries[i:]...)
+			l.replace(n, grown)
+			return true
+		}
+	}
+	return false
+}
+
+// encoding names the representation in use, after Redis: a single compact
+// node or a chain of them.
+func (l *list) encoding() string {
+	if l.head == l.tail {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+func clone(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strs(vals [][]byte) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = string(v)
+	}
+	return out
+}
+
+// checkList verifies the list's structure and that it holds want.
+func checkList(t *testing.T, l *list, want []string) {
+	t.Helper()
+	require.Equal(t, len(want), l.count)
+	n, prev := 0, (*listNode)(nil)
+	for node := l.head; node != nil; node = node.next {
+		require.Equal(t, prev, node.prev)
+		require.Positive(t, node.count)
+		require.Len(t, node.entries(), node.count)
+		n += node.count
+		prev = node
+	}
+	require.Equal(t, prev, l.tail)
+	require.Equal(t, l.count, n)
+	assert.Equal(t, append([]string{}, want...), strs(l.elements()))
+}
+
+func TestListAgainstSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := newList()
+	var want []string
+
+	value := func() string {
+		// Mostly short values, with the odd one larger than a node.
+		if rng.Intn(50) == 0 {
+			return strings.Repeat("x", listNodeMaxBytes+rng.Intn(100))
+		}
+		return fmt.Sprint(rng.Intn(20))
+	}
+
+	for range 2000 {
+		switch op := rng.Intn(10); {
+		case op < 3:
+			v := value()
+			l.pushHead([]byte(v))
+			want = append([]string{v}, want...)
+		case op < 6:
+			v := value()
+			l.pushTail([]byte(v))
+			want = append(want, v)
+		case op == 6 && len(want) > 0:
+			if rng.Intn(2) == 0 {
+				assert.Equal(t, want[0], string(l.popHead()))
+				want = want[1:]
+			} else {
+				assert.Equal(t, want[len(want)-1], string(l.popTail()))
+				want = want[:len(want)-1]
+			}
+		case op == 7 && len(want) > 0:
+			i := rng.Intn(len(want))
+			assert.Equal(t, want[i], string(l.index(i)))
+			v := value()
+			l.set(i, []byte(v))
+			want[i] = v
+		case op == 8 && len(want) > 0:
+			pivot := want[rng.Intn(len(want))]
+			v := value()
+			before := rng.Intn(2) == 0
+			require.True(t, l.insert([]byte(pivot), []byte(v), before))
+			i := slices.Index(want, pivot)
+			if !before {
+				i++
+			}
+			want = slices.Insert(want, i, v)
+		case op == 9 && len(want) > 0:
+			v := want[rng.Intn(len(want))]
+			count := rng.Intn(5) - 2
+			want = removeRef(want, count, v)
+			l.remove(count, []byte(v))
+		}
+		checkList(t, l, want)
+	}
+}
+
+// removeRef is the reference implementation of list.remove.
+func removeRef(vals []string, count int, v string) []string {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	idx := make([]int, 0)
+	for i := range vals {
+		j := i
+		if count < 0 {
+			j = len(vals) - 1 - i
+		}
+		if vals[j] == v && (limit == 0 || len(idx) < limit) {
+			idx = append(idx, j)
+		}
+	}
+	var out []string
+	for i, e := range vals {
+		if !slices.Contains(idx, i) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestListTrim(t *testing.T) {
+	for _, n := range []int{5, 300, 1000} {
+		for _, r := range [][2]int{{0, n - 1}, {1, n - 2}, {n / 2, n / 2}, {130, 260}, {0, 0}, {n - 1, n - 1}} {
+			if r[1] >= n {
+				continue
+			}
+			l := newList()
+			var want []string
+			for i := range n {
+				l.pushTail([]byte(fmt.Sprint(i)))
+				want = append(want, fmt.Sprint(i))
+			}
+			l.trim(r[0], r[1])
+			checkList(t, l, want[r[0]:r[1]+1])
+		}
+	}
+}
+
+func TestListEncoding(t *testing.T) {
+	l := newList()
+	for i := range listNodeMaxEntries {
+		l.pushTail([]byte(fmt.Sprint(i)))
+	}
+	assert.Equal(t, "listpack", l.encoding())
+	l.pushTail([]byte("one more"))
+	assert.Equal(t, "quicklist", l.encoding())
+}
+
+func TestListCommands(t *testing.T) {
+	s := NewInMemoryStorage()
+	b := func(vals ...string) [][]byte {
+		out := make([][]byte, len(vals))
+		for i, v := range vals {
+			out[i] = []byte(v)
+		}
+		return out
+	}
+
+	n, err := s.ListPush("l", ListTail, b("a", "b", "c"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, _ = s.ListPush("l", ListHead, b("x", "y"), false)
+	assert.Equal(t, 5, n)
+	got, _ := s.ListRange("l", 0, -1)
+	assert.Equal(t, []string{"y", "x", "a", "b", "c"}, strs(got))
+
+	n, _ = s.ListPush("missing", ListHead, b("v"), true)
+	assert.Zero(t, n)
+	_, err = s.Type("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	v, _ := s.ListIndex("l", -1)
+	assert.Equal(t, "c", string(v))
+	v, _ = s.ListIndex("l", 10)
+	assert.Nil(t, v)
+
+	assert.ErrorIs(t, s.ListSet("l", 10, []byte("v")), ErrIndexOutOfRange)
+	assert.ErrorIs(t, s.ListSet("missing", 0, []byte("v")), ErrKeyNotFound)
+
+	n, _ = s.ListInsert("l", true, []byte("a"), []byte("before-a"))
+	assert.Equal(t, 6, n)
+	n, _ = s.ListInsert("l", true, []byte("nope"), []byte("v"))
+	assert.Equal(t, -1, n)
+
+	require.NoError(t, s.ListTrim("l", 1, -2))
+	got, _ = s.ListRange("l", 0, -1)
+	assert.Equal(t, []string{"x", "before-a", "a", "b"}, strs(got))
+
+	popped, _ := s.ListPop("l", ListTail, 10)
+	assert.Equal(t, []string{"b", "a", "before-a", "x"}, strs(popped))
+	_, err = s.Type("l")
+	assert.ErrorIs(t, err, ErrKeyNotFound, "empty lists are deleted")
+
+	require.NoError(t, s.Set("str", []byte("v")))
+	_, err = s.ListPush("str", ListTail, b("v"), false)
+	assert.ErrorIs(t, err, ErrWrongType)
+	s.ListPush("l", ListTail, b("v"), false)
+	_, err = s.Get("l")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = s.Incr("l")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = s.SetWithOptions("l", []byte("v"), SetOptions{Get: true})
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, ok, err := s.SetWithOptions("l", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	assert.True(t, ok, "SET overwrites any kind")
+}
+
+func TestListMoveAcrossShards(t *testing.T) {
+	s := NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+
+	// Find two keys on different shards.
+	src, dst := "src", "dst"
+	for i := 0; s.shardIndex(src) == s.shardIndex(dst); i++ {
+		dst = fmt.Sprint("dst", i)
+	}
+	s.ListPush(src, ListTail, [][]byte{[]byte("a"), []byte("b")}, false)
+
+	v, err := s.ListMove(src, dst, ListHead, ListTail)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(v))
+	got, _ := s.ListRange(dst, 0, -1)
+	assert.Equal(t, []string{"a"}, strs(got))
+
+	require.NoError(t, s.Set("str", []byte("v")))
+	_, err = s.ListMove(src, "str", ListHead, ListTail)
+	assert.ErrorIs(t, err, ErrWrongType)
+	n, _ := s.ListLen(src)
+	assert.Equal(t, 1, n, "a failed move leaves the source alone")
+
+	// Rotating a list onto itself.
+	s.ListPush("r", ListTail, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, false)
+	s.ListMove("r", "r", ListTail, ListHead)
+	got, _ = s.ListRange("r", 0, -1)
+	assert.Equal(t, []string{"3", "1", "2"}, strs(got))
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// EvictionPolicy selects the keys deleted to keep memory usage within the
+// limit.
+type EvictionPolicy int
+
+const (
+	// NoEviction deletes nothing: writes fail with ErrOutOfMemory instead.
+	NoEviction     EvictionPolicy = iota
+	AllKeysLRU                    // least recently used keys
+	AllKeysLFU                    // least frequently used keys
+	AllKeysRandom                 // random keys
+	VolatileLRU                   // least recently used keys with an expiry
+	VolatileLFU                   // least frequently used keys with an expiry
+	VolatileRandom                // random keys with an expiry
+	VolatileTTL                   // keys with an expiry, soonest to expire first
+)
+
+var evictionPolicyNames = []string{
+	NoEviction:     "noeviction",
+	AllKeysLRU:     "allkeys-lru",
+	AllKeysLFU:     "allkeys-lfu",
+	AllKeysRandom:  "allkeys-random",
+	VolatileLRU:    "volatile-lru",
+	VolatileLFU:    "volatile-lfu",
+	VolatileRandom: "volatile-random",
+	VolatileTTL:    "volatile-ttl",
+}
+
+func (p EvictionPolicy) String() string {
+	if p < 0 || int(p) >= len(evictionPolicyNames) {
+		return "unknown"
+	}
+	return evictionPolicyNames[p]
+}
+
+// EvictionPolicies returns the names of the eviction policies, as accepted
+// by ParseEvictionPolicy.
+func EvictionPolicies() []string {
+	return slices.Clone(evictionPolicyNames)
+}
+
+// ParseEvictionPolicy parses a maxmemory-policy setting.
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	i := slices.Index(evictionPolicyNames, strings.ToLower(s))
+	if i < 0 {
+		return 0, fmt.Errorf("invalid eviction policy %q", s)
+	}
+	return EvictionPolicy(i), nil
+}
+
+// volatile reports whether the policy only evicts keys with an expiry.
+func (p EvictionPolicy) volatile() bool {
+	return p == VolatileLRU || p == VolatileLFU || p == VolatileRandom || p == VolatileTTL
+}
+
+// DefaultEvictionSamples is the number of keys sampled per shard to pick
+// one to evict.
+const DefaultEvictionSamples = 5
+
+// MemoryLimit bounds the memory used by a keyspace.
+type MemoryLimit struct {
+	// MaxBytes is the most memory the keys may use, as estimated by
+	// UsedMemory. Zero means no limit.
+	MaxBytes int64
+	Policy   EvictionPolicy
+	// Samples is the number of keys sampled in each shard visited to find
+	// the best one to evict. More samples approximate the policy better at
+	// a higher cost. Zero means DefaultEvictionSamples.
+	Samples int
+}
+
+const (
+	// evictionPoolSize is the number of eviction candidates remembered
+	// from one eviction to the next within a call to Evict.
+	evictionPoolSize = 16
+	// evictionShards is the number of non-empty shards sampled per key
+	// evicted.
+	evictionShards = 4
+)
+
+// candidate is a key that may be evicted. Keys with a higher score are
+// evicted first.
+type candidate struct {
+	shard *InMemoryStorage
+	key   string
+	score int64
+}
+
+// evict deletes keys from shards by l's policy until used is within l, and
+// returns them. The candidates are the best keys of a sample taken from a
+// few shards at a time, so the policy is only approximated.
+func evict(shards []*InMemoryStorage, used *atomic.Int64, l *MemoryLimit) ([]string, error) {
+	if l == nil || l.MaxBytes <= 0 || used.Load() <= l.MaxBytes {
+		return nil, nil
+	}
+	if l.Policy == NoEviction {
+		return nil, ErrOutOfMemory
+	}
+	samples := l.Samples
+	if samples <= 0 {
+		samples = DefaultEvictionSamples
+	}
+
+	var evicted []string
+	var pool []candidate
+	for used.Load() > l.MaxBytes {
+		pool = fillPool(pool, shards, l.Policy, samples)
+		if len(pool) == 0 {
+			return evicted, ErrOutOfMemory
+		}
+		// The best candidate is last. It may have been deleted or lost its
+		// expiry since it was sampled.
+		c := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+		if c.shard.evictKey(c.key, l.Policy.volatile()) {
+			evicted = append(evicted, c.key)
+		}
+	}
+	return evicted, nil
+}
+
+// fillPool adds keys sampled from shards to pool, keeping it sorted by
+// score with the best candidates last.
+func fillPool(pool []candidate, shards []*InMemoryStorage, policy EvictionPolicy, samples int) []candidate {
+	visited := 0
+	start := rand.IntN(len(shards))
+	for i := range shards {
+		shard := shards[(start+i)%len(shards)]
+		cs := shard.sample(policy, samples)
+		if len(cs) == 0 {
+			continue
+		}
+		for _, c := range cs {
+			if !slices.ContainsFunc(pool, func(p candidate) bool { return p.shard == c.shard && p.key == c.key }) {
+				pool = append(pool, c)
+			}
+		}
+		if visited++; visited == evictionShards {
+			break
+		}
+	}
+	slices.SortFunc(pool, func(a, b candidate) int { return cmp.Compare(a.score, b.score) })
+	if len(pool) > evictionPoolSize {
+		pool = slices.Delete(pool, 0, len(pool)-evictionPoolSize)
+	}
+	return pool
+}
+
+// sample returns up to n keys that policy may evict, with their scores.
+// Map iteration order is random, so the keys are too.
+func (s *InMemoryStorage) sample(policy EvictionPolicy, n int) []candidate {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	ms := now().UnixMilli()
+	out := make([]candidate, 0, n)
+	add := func(k string) bool {
+		c := candidate{shard: s, key: k}
+		switch policy {
+		case AllKeysLRU, VolatileLRU:
+			if m := s.meta[k]; m != nil {
+				c.score = ms - m.accessed.Load()
+			}
+		case AllKeysLFU, VolatileLFU:
+			if m := s.meta[k]; m != nil {
+				c.score = 255 - int64(lfuDecayed(m.freq.Load(), ms))
+			}
+		case VolatileTTL:
+			c.score = math.MaxInt64 - s.expires[k]
+		case AllKeysRandom, VolatileRandom:
+			c.score = rand.Int64()
+		}
+		out = append(out, c)
+		return len(out) < n
+	}
+	if policy.volatile() {
+		for k := range s.expires {
+			if !add(k) {
+				break
+			}
+		}
+	} else {
+		for k := range s.m {
+			if !add(k) {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// evictKey deletes k, if it still exists and, with volatile set, still has
+// an expiry. It reports whether it deleted k.
+func (s *InMemoryStorage) evictKey(k string, volatile bool) bool {
+	s.lock()
+	defer s.mux.Unlock()
+	if _, ok := s.m[k]; !ok {
+		return false
+	}
+	if _, ok := s.expires[k]; volatile && !ok {
+		return false
+	}
+	s.remove(k)
+	s.notify(EventEvicted, "evicted", k)
+	return true
+}
+
+// keyMeta is what the memory limit needs to know about a key: its size and
+// how it was accessed. The access fields change under the read lock.
+type keyMeta struct {
+	size int64
+	// accessed is the time of the last access in unix milliseconds.
+	accessed atomic.Int64
+	// freq packs the LFU counter in its low 8 bits with, above them, the
+	// time in minutes it was last decayed at.
+	freq atomic.Uint64
+}
+
+const (
+	// lfuInitial is the LFU counter of new keys, so that they are not the
+	// first evicted before they get a chance to be accessed.
+	lfuInitial = 5
+	// lfuLogFactor slows the counter down as it grows: it takes about a
+	// million accesses to reach 255.
+	lfuLogFactor = 10
+	// lfuDecayMinutes is the time it takes an idle key's counter to
+	// decrease by one.
+	lfuDecayMinutes = 1
+)
+
+func newKeyMeta(ms int64) *keyMeta {
+	m := &keyMeta{}
+	m.accessed.Store(ms)
+	m.freq.Store(uint64(ms/60000)<<8 | lfuInitial)
+	return m
+}
+
+// access records an access at ms. The fields are only stored when they
+// change, so that readers of a hot key do not contend on them.
+func (m *keyMeta) access(ms int64) {
+	if m.accessed.Load() != ms {
+		m.accessed.Store(ms)
+	}
+	freq := m.freq.Load()
+	counter := lfuDecayed(freq, ms)
+	if counter < 255 {
+		base := max(int(counter)-lfuInitial, 0)
+		if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	if next := uint64(ms/60000)<<8 | uint64(counter); next != freq {
+		m.freq.Store(next)
+	}
+}
+
+// lfuDecayed returns the LFU counter packed in freq, decayed for the time
+// elapsed until ms.
+func lfuDecayed(freq uint64, ms int64) uint8 {
+	counter := int64(freq & 0xff)
+	idle := ms/60000 - int64(freq>>8)
+	return uint8(max(counter-idle/lfuDecayMinutes, 0))
+}
+
+// Rough sizes of the Go structures holding keys and values, in bytes.
+const (
+	keyOverhead   = 64 // map entries for the value, version and metadata
+	bytesOverhead = 24 // slice header
+	entryOverhead = 32 // an entry of a hash, set or sorted set map
+	nodeOverhead  = 64 // a list node or skiplist node
+	// sizeSamples is the number of elements of a collection measured to
+	// estimate the size of the rest.
+	sizeSamples = 16
+)
+
+// sizeOf estimates the memory used by the key k holding v. Collections are
+// measured from a sample of their elements, so that writes to large ones
+// stay cheap.
+func sizeOf(k string, v any) int64 {
+	return keyOverhead + int64(len(k)) + valueSize(v)
+}
+
+func valueSize(v any) int64 {
+	switch v := v.(type) {
+	case []byte:
+		return bytesOverhead + int64(len(v))
+	case *list:
+		var size, sampled int64
+		n := v.head
+		for i := 0; n != nil && i < sizeSamples; i++ {
+			size += nodeOverhead + int64(cap(n.buf))
+			sampled += int64(n.count)
+			n = n.next
+		}
+		return scaled(size, sampled, int64(v.count))
+	case hash:
+		var size, sampled int64
+		for f, val := range v {
+			size += entryOverhead + int64(len(f)) + bytesOverhead + int64(len(val))
+			if sampled++; sampled == sizeSamples {
+				break
+			}
+		}
+		return scaled(size, sampled, int64(len(v)))
+	case set:
+		var size, sampled int64
+		for m := range v {
+			size += entryOverhead + int64(len(m))
+			if sampled++; sampled == sizeSamples {
+				break
+			}
+		}
+		return scaled(size, sampled, int64(len(v)))
+	case *zset:
+		var size, sampled int64
+		for m := range v.scores {
+			// The member is shared by the map and the skiplist node.
+			size += entryOverhead + nodeOverhead + int64(len(m))
+			if sampled++; sampled == sizeSamples {
+				break
+			}
+		}
+		return scaled(size, sampled, int64(v.len()))
+	case *stream:
+		var size, sampled int64
+		for _, e := range v.entries[:min(len(v.entries), sizeSamples)] {
+			size += 16 + bytesOverhead
+			for _, f := range e.Fields {
+				size += bytesOverhead + int64(len(f))
+			}
+			sampled++
+		}
+		size = scaled(size, sampled, int64(len(v.entries)))
+		for name, g := range v.groups {
+			size += entryOverhead + int64(len(name)) + int64(len(g.pending)+len(g.consumers))*entryOverhead
+		}
+		return size
+	}
+	return 0
+}
+
+// scaled extrapolates the size of sampled elements out of n.
+func scaled(size, sampled, n int64) int64 {
+	if sampled == 0 || sampled >= n {
+		return size
+	}
+	return size * n / sampled
+}
+
+// account updates the estimated size of k after a write. Callers must hold
+// the write lock.
+func (s *InMemoryStorage) account(k string) {
+	v, ok := s.m[k]
+	if !ok {
+		return
+	}
+	ms := now().UnixMilli()
+	m := s.meta[k]
+	if m == nil {
+		m = newKeyMeta(ms)
+		s.meta[k] = m
+	} else {
+		m.access(ms)
+	}
+	size := sizeOf(k, v)
+	s.bytes += size - m.size
+	s.used.Add(size - m.size)
+	m.size = size
+}
+
+// unaccount forgets the size of k once deleted. Callers must hold the write
+// lock.
+func (s *InMemoryStorage) unaccount(k string) {
+	if m := s.meta[k]; m != nil {
+		s.bytes -= m.size
+		s.used.Add(-m.size)
+		delete(s.meta, k)
+	}
+}
+
+// accessed records a read of k at ms. Callers must hold at least the read
+// lock.
+func (s *InMemoryStorage) accessed(k string, ms int64) {
+	if m := s.meta[k]; m != nil {
+		m.access(ms)
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsedMemory(t *testing.T) {
+	sharded := NewInMemoryShardedStorageShards(4)
+	defer sharded.Close()
+	for name, s := range map[string]Storage{
+		"single":  NewInMemoryStorage(),
+		"sharded": sharded,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Zero(t, s.UsedMemory())
+
+			require.NoError(t, s.Set("k", make([]byte, 1000)))
+			str := s.UsedMemory()
+			assert.Greater(t, str, int64(1000))
+			require.NoError(t, s.Set("k", make([]byte, 10)))
+			assert.Less(t, s.UsedMemory(), str-900)
+
+			vals := make([][]byte, 1000)
+			for i := range vals {
+				vals[i] = make([]byte, 100)
+			}
+			before := s.UsedMemory()
+			_, err := s.ListPush("l", ListTail, vals, false)
+			require.NoError(t, err)
+			assert.InDelta(t, before+1000*100, s.UsedMemory(), 1000*100/2, "large collections are estimated")
+
+			before = s.UsedMemory()
+			for i := range 100 {
+				_, err := s.HashSet("h", [][]byte{[]byte(strconv.Itoa(i)), make([]byte, 100)}, false)
+				require.NoError(t, err)
+			}
+			assert.InDelta(t, before+100*100, s.UsedMemory(), 100*100)
+
+			_, err = s.Del("k", "l", "h")
+			require.NoError(t, err)
+			assert.Zero(t, s.UsedMemory())
+
+			_, err = s.SetAdd("s", []byte("a"), []byte("b"))
+			require.NoError(t, err)
+			require.NoError(t, s.FlushAll())
+			assert.Zero(t, s.UsedMemory())
+		})
+	}
+}
+
+// fill sets n keys k0 to k(n-1), one millisecond apart.
+func fill(t *testing.T, s Storage, n int, tick func(time.Duration)) {
+	t.Helper()
+	for i := range n {
+		require.NoError(t, s.Set("k"+strconv.Itoa(i), []byte("v")))
+		tick(time.Millisecond)
+	}
+}
+
+// exists reports which of the keys k0 to k(n-1) exist.
+func exists(s Storage, n int) []bool {
+	out := make([]bool, n)
+	for i := range out {
+		_, err := s.Type("k" + strconv.Itoa(i))
+		out[i] = err == nil
+	}
+	return out
+}
+
+func TestEvict(t *testing.T) {
+	tick := setClock(t, time.Unix(1_700_000_000, 0))
+	// The limit leaves room for 8 of 10 keys of the same size, and the
+	// samples cover every key, so that the policies are applied exactly.
+	limit := func(s Storage, policy EvictionPolicy) {
+		s.SetMemoryLimit(MemoryLimit{MaxBytes: s.UsedMemory() * 8 / 10, Policy: policy, Samples: 100})
+	}
+
+	t.Run("noeviction", func(t *testing.T) {
+		s := NewInMemoryStorage()
+		fill(t, s, 10, tick)
+		evicted, err := s.Evict()
+		require.NoError(t, err, "no limit")
+		assert.Empty(t, evicted)
+
+		limit(s, NoEviction)
+		_, err = s.Evict()
+		assert.ErrorIs(t, err, ErrOutOfMemory)
+		assert.Equal(t, 10, s.KeyCounts()[0].Keys)
+	})
+
+	t.Run("allkeys-lru", func(t *testing.T) {
+		s := NewInMemoryStorage()
+		fill(t, s, 10, tick)
+		_, err := s.Get("k0")
+		require.NoError(t, err)
+		limit(s, AllKeysLRU)
+		evicted, err := s.Evict()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"k1", "k2"}, evicted)
+		assert.LessOrEqual(t, s.UsedMemory(), s.limit.Load().MaxBytes)
+	})
+
+	t.Run("allkeys-lfu", func(t *testing.T) {
+		s := NewInMemoryStorage()
+		fill(t, s, 10, tick)
+		for i := range 10 {
+			if i == 3 || i == 7 {
+				continue
+			}
+			for range 100 {
+				_, err := s.Get("k" + strconv.Itoa(i))
+				require.NoError(t, err)
+			}
+		}
+		limit(s, AllKeysLFU)
+		evicted, err := s.Evict()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"k3", "k7"}, evicted)
+	})
+
+	t.Run("volatile-ttl", func(t *testing.T) {
+		s := NewInMemoryStorage()
+		fill(t, s, 10, tick)
+		for i, ttl := range map[int]time.Duration{2: time.Hour, 5: time.Minute, 8: 2 * time.Minute} {
+			_, err := s.Expire("k"+strconv.Itoa(i), now().Add(ttl), ExpireAlways)
+			require.NoError(t, err)
+		}
+		limit(s, VolatileTTL)
+		evicted, err := s.Evict()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"k5", "k8"}, evicted)
+	})
+
+	t.Run("volatile without expiring keys", func(t *testing.T) {
+		s := NewInMemoryStorage()
+		fill(t, s, 10, tick)
+		_, err := s.Expire("k4", now().Add(time.Hour), ExpireAlways)
+		require.NoError(t, err)
+		limit(s, VolatileLRU)
+		evicted, err := s.Evict()
+		assert.ErrorIs(t, err, ErrOutOfMemory)
+		assert.Equal(t, []string{"k4"}, evicted, "what could be evicted was")
+	})
+
+	t.Run("sharded", func(t *testing.T) {
+		s := NewInMemoryShardedStorageShards(8)
+		// Stop the active expire sweep before it reads the test clock.
+		s.Close()
+		fill(t, s, 1000, tick)
+		s.SetMemoryLimit(MemoryLimit{MaxBytes: s.UsedMemory() / 2, Policy: AllKeysLRU})
+		evicted, err := s.Evict()
+		require.NoError(t, err)
+		assert.InDelta(t, 500, len(evicted), 10)
+		assert.LessOrEqual(t, s.UsedMemory(), s.limit.Load().MaxBytes)
+
+		// Sampling only approximates LRU, but the oldest keys still go
+		// first overall.
+		kept := exists(s, 1000)
+		var oldest, newest int
+		for i := range 250 {
+			if kept[i] {
+				oldest++
+			}
+			if kept[999-i] {
+				newest++
+			}
+		}
+		assert.Less(t, oldest, newest)
+
+		s.SetMemoryLimit(MemoryLimit{MaxBytes: 1, Policy: AllKeysRandom})
+		_, err = s.Evict()
+		require.NoError(t, err)
+		assert.Zero(t, s.UsedMemory())
+	})
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, name := range EvictionPolicies() {
+		p, err := ParseEvictionPolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, p.String())
+	}
+	p, err := ParseEvictionPolicy("AllKeys-LRU")
+	require.NoError(t, err)
+	assert.Equal(t, AllKeysLRU, p)
+	_, err = ParseEvictionPolicy("lru")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package storage
+
+// EventClass is a class of keyspace events. Notifications are enabled by
+// class.
+type EventClass int
+
+const (
+	// EventGeneric events are those of writes to keys of any kind: del,
+	// expire, persist and restore.
+	EventGeneric EventClass = 1 << iota
+	EventString             // writes to strings
+	EventList               // writes to lists
+	EventSet                // writes to sets
+	EventHash               // writes to hashes
+	EventZSet               // writes to sorted sets
+	EventStream             // writes to streams
+	EventExpired            // keys deleted because they expired
+	EventEvicted            // keys deleted to keep within the memory limit
+	EventNew                // keys created
+)
+
+// Notifier receives the events of a keyspace: the name of the event, the
+// same as Redis's, such as "set", "lpush" or "expired", and the key it
+// happened to. A write emits its events once it is done, and a collection
+// it emptied is deleted with a "del" event after its own.
+//
+// Notifiers are called with the shard holding the key locked, so they must
+// be quick and must not call back into the storage.
+type Notifier func(class EventClass, event, key string)
+
+// notify calls the notifier, if any, with an event. Callers must hold the
+// write lock.
+func (s *InMemoryStorage) notify(class EventClass, event, k string) {
+	if n := s.notifier.Load(); n != nil {
+		(*n)(class, event, k)
+	}
+}
+
+func (s *InMemoryStorage) SetNotifier(n Notifier) {
+	if n == nil {
+		s.notifier.Store(nil)
+		return
+	}
+	s.notifier.Store(&n)
+}
+
+// changed records a write to the collection at k, of the kind class, and
+// emits its event. If the collection became empty, it deletes k instead.
+// Callers must hold the write lock.
+func (s *InMemoryStorage) changed(k string, empty bool, class EventClass, event string) {
+	if empty {
+		s.notify(class, event, k)
+		s.remove(k)
+		s.notify(EventGeneric, "del", k)
+		return
+	}
+	s.touch(k)
+	s.notify(class, event, k)
+}
+
+// store replaces whatever is at k with v, the collection of the kind class
+// resulting from the event, or deletes k if v is empty. Callers must hold
+// the write lock.
+func (s *InMemoryStorage) store(k string, v any, empty bool, class EventClass, event string) {
+	s.expireIfNeeded(k)
+	_, existed := s.m[k]
+	s.remove(k)
+	if empty {
+		if existed {
+			s.notify(EventGeneric, "del", k)
+		}
+		return
+	}
+	s.m[k] = v
+	s.touch(k)
+	s.notify(class, event, k)
+}
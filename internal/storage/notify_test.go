@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects events as "event key".
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) notify(_ EventClass, event, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event+" "+key)
+}
+
+// take returns the events recorded since the last call.
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.events
+	r.events = nil
+	return out
+}
+
+func TestNotifier(t *testing.T) {
+	tick := setClock(t, time.Unix(1_700_000_000, 0))
+	sharded := NewInMemoryShardedStorageShards(4)
+	// Stop the active expire sweep before it reads the test clock.
+	sharded.Close()
+	for name, s := range map[string]Storage{
+		"single":  NewInMemoryStorage(),
+		"sharded": sharded,
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := &recorder{}
+			s.SetNotifier(r.notify)
+
+			require.NoError(t, s.Set("k", []byte("v")))
+			assert.Equal(t, []string{"new k", "set k"}, r.take())
+			_, _, err := s.SetWithOptions("k", []byte("v"), SetOptions{ExpireAt: now().Add(time.Second)})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"set k", "expire k"}, r.take())
+			tick(time.Second)
+			_, err = s.Get("k")
+			require.ErrorIs(t, err, ErrKeyNotFound)
+			assert.Equal(t, []string{"expired k"}, r.take())
+
+			_, err = s.ListPush("l", ListTail, [][]byte{[]byte("a")}, false)
+			require.NoError(t, err)
+			_, err = s.ListMove("l", "m", ListHead, ListTail)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"new l", "rpush l", "lpop l", "del l", "new m", "rpush m"}, r.take())
+
+			_, err = s.SetAdd("a", []byte("x"))
+			require.NoError(t, err)
+			_, err = s.SetCombineStore(SetInter, "m", "a", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"new a", "sadd a", "del m"}, r.take(), "an empty result deletes the destination")
+			_, err = s.ZCombineStore(SetUnion, "z", []string{"a"}, nil, ZAggSum)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"new z", "zunionstore z"}, r.take())
+
+			// Writes that change nothing emit nothing.
+			_, err = s.SetAdd("a", []byte("x"))
+			require.NoError(t, err)
+			_, err = s.Del("missing")
+			require.NoError(t, err)
+			assert.Empty(t, r.take())
+
+			s.SetNotifier(nil)
+			_, err = s.Del("a", "z")
+			require.NoError(t, err)
+			assert.Empty(t, r.take())
+		})
+	}
+}
+
+func TestNotifierEviction(t *testing.T) {
+	s := NewInMemoryStorage()
+	require.NoError(t, s.Set("k", []byte("v")))
+	r := &recorder{}
+	s.SetNotifier(r.notify)
+	s.SetMemoryLimit(MemoryLimit{MaxBytes: 1, Policy: AllKeysRandom})
+	_, err := s.Evict()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evicted k"}, r.take())
+}
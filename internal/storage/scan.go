@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+)
+
+// Collections are scanned in the order of a fixed 32-bit hash of their
+// members. A cursor is one more than the hash of the last member returned,
+// or 0 once the scan is complete. As the order does not depend on the
+// contents of the collection, every member present for the whole scan is
+// returned, whatever is added or removed in between. Members with equal
+// hashes are always returned together, so none is skipped.
+
+func scanHash(member string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(member))
+	return uint64(h.Sum32())
+}
+
+// scan returns at least count members of m from cursor onwards, unless the
+// scan ends first, and the cursor to continue from.
+func scan[V any](m map[string]V, cursor uint64, count int) (uint64, []string) {
+	type member struct {
+		h uint64
+		k string
+	}
+	var rest []member
+	for k := range m {
+		if h := scanHash(k); h >= cursor {
+			rest = append(rest, member{h, k})
+		}
+	}
+	slices.SortFunc(rest, func(a, b member) int { return cmp.Compare(a.h, b.h) })
+
+	var out []string
+	for i, mem := range rest {
+		if len(out) >= count && mem.h != rest[i-1].h {
+			return rest[i-1].h + 1, out
+		}
+		out = append(out, mem.k)
+	}
+	return 0, out
+}
+
+// randomMembers returns count distinct random members of m, or all of them
+// if m is smaller. A negative count asks for -count members that may repeat.
+func randomMembers[V any](m map[string]V, count int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if count < 0 {
+		out := make([]string, -count)
+		for i := range out {
+			out[i] = keys[rand.IntN(len(keys))]
+		}
+		return out
+	}
+	count = min(count, len(keys))
+	for i := range count {
+		j := i + rand.IntN(len(keys)-i)
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return keys[:count]
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func members(vals ...string) [][]byte {
+	out := make([][]byte, len(vals))
+	for i, v := range vals {
+		out[i] = []byte(v)
+	}
+	return out
+}
+
+func sorted(vals [][]byte) []string {
+	out := strs(vals)
+	slices.Sort(out)
+	return out
+}
+
+func TestSetCommands(t *testing.T) {
+	sharded := NewInMemoryShardedStorage()
+	t.Cleanup(func() { sharded.Close() })
+	for name, s := range map[string]Storage{
+		"single":  NewInMemoryStorage(),
+		"sharded": sharded,
+	} {
+		t.Run(name, func(t *testing.T) {
+			n, err := s.SetAdd("a", members("1", "2", "3", "2")...)
+			require.NoError(t, err)
+			assert.Equal(t, 3, n)
+			_, err = s.SetAdd("b", members("2", "3", "4")...)
+			require.NoError(t, err)
+			_, err = s.SetAdd("c", members("3", "5")...)
+			require.NoError(t, err)
+
+			kind, err := s.Type("a")
+			require.NoError(t, err)
+			assert.Equal(t, KindSet, kind)
+			found, err := s.SetIsMember("a", members("1", "4")...)
+			require.NoError(t, err)
+			assert.Equal(t, []bool{true, false}, found)
+
+			got, err := s.SetCombine(SetInter, "a", "b", "c")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"3"}, sorted(got))
+			got, err = s.SetCombine(SetUnion, "a", "b", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1", "2", "3", "4"}, sorted(got))
+			got, err = s.SetCombine(SetDiff, "a", "b")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1"}, sorted(got))
+			got, err = s.SetCombine(SetInter, "a", "missing")
+			require.NoError(t, err)
+			assert.Empty(t, got)
+
+			n, err = s.SetInterCard(0, "a", "b")
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			n, err = s.SetInterCard(1, "a", "b")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			require.NoError(t, s.Set("str", []byte("v")))
+			n, err = s.SetCombineStore(SetUnion, "str", "a", "c")
+			require.NoError(t, err)
+			assert.Equal(t, 4, n)
+			got, err = s.SetMembers("str")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1", "2", "3", "5"}, sorted(got), "stores overwrite any type")
+			n, err = s.SetCombineStore(SetInter, "str", "a", "missing")
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			_, err = s.Type("str")
+			assert.ErrorIs(t, err, ErrKeyNotFound, "an empty result deletes the destination")
+
+			require.NoError(t, s.Set("str", []byte("v")))
+			_, err = s.SetCombine(SetUnion, "a", "str")
+			assert.ErrorIs(t, err, ErrWrongType)
+			_, err = s.SetMove("a", "str", []byte("1"))
+			assert.ErrorIs(t, err, ErrWrongType)
+			ok, err := s.SetMove("a", "d", []byte("1"))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = s.SetMove("a", "d", []byte("1"))
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			popped, err := s.SetPop("a", 5)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"2", "3"}, sorted(popped))
+			_, err = s.Type("a")
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		})
+	}
+}
+
+func TestSetCombineAcrossShardsIsAtomic(t *testing.T) {
+	s := NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+
+	// Members move back and forth between two sets on different shards, so
+	// their union always holds every member.
+	a, b := "a", "b"
+	for i := 0; s.shardIndex(a) == s.shardIndex(b); i++ {
+		b = fmt.Sprint("b", i)
+	}
+	const n = 20
+	for i := range n {
+		_, err := s.SetAdd(a, []byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m := []byte(fmt.Sprint(i % n))
+			if ok, _ := s.SetMove(a, b, m); !ok {
+				s.SetMove(b, a, m)
+			}
+		}
+	}()
+
+	for range 2000 {
+		got, err := s.SetCombine(SetUnion, a, b)
+		require.NoError(t, err)
+		require.Len(t, got, n)
+		n, err := s.SetInterCard(0, a, b)
+		require.NoError(t, err)
+		require.Zero(t, n)
+	}
+	close(done)
+	wg.Wait()
+}
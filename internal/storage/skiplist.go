@@ -0,0 +1,240 @@
+package storage
+
+import "math/rand/v2"
+
+const (
+	skiplistMaxLevel = 32
+	// Each node is promoted to the next level with probability 1/4.
+	skiplistP = 4
+)
+
+// skiplist orders the members of a sorted set by score, then by member.
+// Every link records how many nodes it skips, so that the rank of a node and
+// the node at a rank are found in O(log n), as in Redis.
+type skiplist struct {
+	header *skipNode
+	tail   *skipNode
+	length int
+	level  int
+}
+
+type skipNode struct {
+	member   string
+	score    float64
+	backward *skipNode
+	level    []skipLevel
+}
+
+type skipLevel struct {
+	forward *skipNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skipNode{level: make([]skipLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.IntN(skiplistP) == 0 {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before the given score and member.
+func (n *skipNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// next returns the node following n, or nil.
+func (n *skipNode) next() *skipNode {
+	return n.level[0].forward
+}
+
+// insert adds a member, which must not already be in the list.
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skipNode
+	var rank [skiplistMaxLevel]int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipNode{member: member, score: score, level: make([]skipLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if next := x.next(); next != nil {
+		next.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes a member and reports whether it was found.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skipNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.next()
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := range sl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if next := x.next(); next != nil {
+		next.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank returns the 0-based rank of a member, or -1 if it is not in the list.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && (f.before(score, member) || (f.score == score && f.member == member)); f = x.level[i].forward {
+			rank += x.level[i].span
+			x = f
+		}
+		if x != sl.header && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at a 0-based rank, or nil if it is out of range.
+func (sl *skiplist) byRank(rank int) *skipNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	rank++
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// first returns the first node for which below is false, or nil. below must
+// hold for a prefix of the list only.
+func (sl *skiplist) first(below func(*skipNode) bool) *skipNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && below(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.next()
+}
+
+// last returns the last node for which within is true, or nil. within must
+// hold for a prefix of the list only.
+func (sl *skiplist) last(within func(*skipNode) bool) *skipNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && within(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header {
+		return nil
+	}
+	return x
+}
+
+// zset is the value of a sorted set key: a skiplist for ordered access and
+// a map for looking up the score of a member.
+type zset struct {
+	scores map[string]float64
+	sl     *skiplist
+}
+
+func newZSet() *zset {
+	return &zset{scores: make(map[string]float64), sl: newSkiplist()}
+}
+
+func (z *zset) len() int {
+	return len(z.scores)
+}
+
+// set adds member or changes its score.
+func (z *zset) set(member string, score float64) {
+	if cur, ok := z.scores[member]; ok {
+		if cur == score {
+			return
+		}
+		z.sl.delete(cur, member)
+	}
+	z.scores[member] = score
+	z.sl.insert(score, member)
+}
+
+// remove deletes member and reports whether it was in the set.
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.sl.delete(score, member)
+	return true
+}
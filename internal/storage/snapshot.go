@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"strconv"
+	"time"
+)
+
+// Entry is a single key captured by a Snapshot.
+type Entry struct {
+	Key  string
+	Kind Kind
+	// Value holds a string.
+	Value []byte
+	// Elems holds the elements of a list, from head to tail, the fields and
+	// values of a hash as pairs, the members of a set, or the scores and
+	// members of a sorted set as pairs, in order.
+	Elems [][]byte
+	// Stream holds a stream.
+	Stream *StreamValue
+	// ExpireAt is the absolute expiry of the key, or the zero time if the
+	// key does not expire.
+	ExpireAt time.Time
+}
+
+// Snapshot is a point-in-time view of the keyspace. Taking one is cheap: each
+// shard is copied either when Walk reaches it or just before the first write
+// to that shard after the snapshot was taken, whichever happens first. The
+// keyspace is never locked as a whole.
+type Snapshot struct {
+	shards []*shardSnapshot
+}
+
+type shardSnapshot struct {
+	owner   *InMemoryStorage
+	entries []Entry
+}
+
+// Walk calls fn for every key in the snapshot, one shard at a time. It stops
+// at the first error returned by fn.
+func (s *Snapshot) Walk(fn func(Entry) error) error {
+	for _, ss := range s.shards {
+		for _, e := range ss.load() {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys in the snapshot, capturing any shard that
+// has not been captured yet.
+func (s *Snapshot) Len() int {
+	n := 0
+	for _, ss := range s.shards {
+		n += len(ss.load())
+	}
+	return n
+}
+
+// load returns the captured entries, capturing the shard first if no write
+// has done so yet.
+func (ss *shardSnapshot) load() []Entry {
+	ss.owner.lock()
+	defer ss.owner.mux.Unlock()
+	return ss.entries
+}
+
+// snapshot registers a pending snapshot of this shard. Callers must hold the
+// write lock.
+func (s *InMemoryStorage) snapshot() *shardSnapshot {
+	ss := &shardSnapshot{owner: s}
+	s.pending = append(s.pending, ss)
+	return ss
+}
+
+// capture fulfils every pending snapshot of this shard with its current
+// contents. Callers must hold the write lock. Strings, hash values and
+// stream entries are never mutated in place, so entries share them with the
+// live map; the collections holding them are copied.
+func (s *InMemoryStorage) capture() {
+	if len(s.pending) == 0 {
+		return
+	}
+	ms := now().UnixMilli()
+	entries := make([]Entry, 0, len(s.m))
+	for k, v := range s.m {
+		e := Entry{Key: k, Kind: kindOf(v)}
+		switch v := v.(type) {
+		case []byte:
+			e.Value = v
+		case *list:
+			e.Elems = v.elements()
+		case hash:
+			e.Elems = make([][]byte, 0, 2*len(v))
+			for f, val := range v {
+				e.Elems = append(e.Elems, []byte(f), val)
+			}
+		case set:
+			e.Elems = v.members()
+		case *zset:
+			e.Elems = make([][]byte, 0, 2*v.len())
+			for n := v.sl.header.next(); n != nil; n = n.next() {
+				e.Elems = append(e.Elems, []byte(strconv.FormatFloat(n.score, 'g', -1, 64)), []byte(n.member))
+			}
+		case *stream:
+			e.Stream = v.value()
+		}
+		if at, ok := s.expires[k]; ok {
+			if at <= ms {
+				continue
+			}
+			e.ExpireAt = time.UnixMilli(at)
+		}
+		entries = append(entries, e)
+	}
+	for _, ss := range s.pending {
+		ss.entries = entries
+	}
+	s.pending = nil
+}
+
+// lock takes the write lock and captures any pending snapshot, so that the
+// caller may modify the shard.
+func (s *InMemoryStorage) lock() {
+	s.mux.Lock()
+	s.capture()
+}
+
+func (s *InMemoryStorage) Snapshot() *Snapshot {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return &Snapshot{shards: []*shardSnapshot{s.snapshot()}}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walkKeys(t *testing.T, snap *Snapshot) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	require.NoError(t, snap.Walk(func(e Entry) error {
+		got[e.Key] = string(e.Value)
+		return nil
+	}))
+	return got
+}
+
+func TestSnapshotIsPointInTime(t *testing.T) {
+	s := NewInMemoryShardedStorage()
+	defer s.Close()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, s.Set(k, []byte(k)))
+	}
+	snap := s.Snapshot()
+
+	// Writes after the snapshot must not be visible through it.
+	require.NoError(t, s.Set("a", []byte("changed")))
+	_, err := s.Del("b")
+	require.NoError(t, err)
+	require.NoError(t, s.Set("e", []byte("new")))
+	_, err = s.Incr("counter")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"a": "a", "b": "b", "c": "c", "d": "d"}, walkKeys(t, snap))
+	assert.Equal(t, 4, snap.Len())
+
+	later := walkKeys(t, s.Snapshot())
+	keys := make([]string, 0, len(later))
+	for k := range later {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"a", "c", "counter", "d", "e"}, keys)
+}
+
+func TestSnapshotSkipsExpiredKeys(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+	advance := setClock(t, base)
+	s := NewInMemoryStorage()
+
+	s.SetWithOptions("short", []byte("v"), SetOptions{ExpireAt: base.Add(time.Second)})
+	s.SetWithOptions("long", []byte("v"), SetOptions{ExpireAt: base.Add(time.Hour)})
+	advance(time.Minute)
+
+	var entries []Entry
+	require.NoError(t, s.Snapshot().Walk(func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "long", entries[0].Key)
+	assert.Equal(t, base.Add(time.Hour).UnixMilli(), entries[0].ExpireAt.UnixMilli())
+}
@@ -1,15 +1,599 @@
 package storage
 
-import "errors"
+import (
+	"cmp"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
 
 var (
 	ErrKeyNotFound     = errors.New("key not found")
 	ErrIntegerOverflow = errors.New("integer overflow")
+	ErrWrongType       = errors.New("operation against a key holding the wrong kind of value")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrNotInteger      = errors.New("value is not an integer")
+	ErrNotFloat        = errors.New("value is not a valid float")
+	ErrNotFinite       = errors.New("increment would produce NaN or Infinity")
+	// ErrOutOfMemory is returned by writes refused because the memory limit
+	// has been reached.
+	ErrOutOfMemory = errors.New("used memory exceeds the limit")
+	// ErrStreamIDTooSmall is returned when adding an entry whose ID is not
+	// greater than the last ID of the stream.
+	ErrStreamIDTooSmall = errors.New("stream ID is equal or smaller than the stream top item")
+	ErrStreamIDZero     = errors.New("stream ID must be greater than 0-0")
+	ErrStreamExhausted  = errors.New("stream has exhausted the last possible ID")
+	// ErrNoGroup is returned by consumer group operations on a missing key
+	// or group.
+	ErrNoGroup     = errors.New("no such key or consumer group")
+	ErrGroupExists = errors.New("consumer group name already exists")
+)
+
+// Kind is the type of the value held by a key.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindList
+	KindHash
+	KindSet
+	KindZSet
+	KindStream
+)
+
+// String returns the name of the kind as reported by the TYPE command.
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindList:
+		return "list"
+	case KindHash:
+		return "hash"
+	case KindSet:
+		return "set"
+	case KindZSet:
+		return "zset"
+	case KindStream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+// ListEnd selects the head or the tail of a list.
+type ListEnd int
+
+const (
+	ListHead ListEnd = iota
+	ListTail
+)
+
+// SetOp is an operation combining several sets.
+type SetOp int
+
+const (
+	SetUnion SetOp = iota
+	SetInter
+	SetDiff // members of the first set missing from all the others
+)
+
+// name names op in the commands and events storing its result, such as
+// SUNIONSTORE.
+func (op SetOp) name() string {
+	switch op {
+	case SetInter:
+		return "inter"
+	case SetDiff:
+		return "diff"
+	}
+	return "union"
+}
+
+// ScoredMember is a member of a sorted set and its score.
+type ScoredMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ZAddOptions restricts which members ZAdd and ZIncrBy write.
+type ZAddOptions struct {
+	NX bool // only add new members
+	XX bool // only update existing members
+	GT bool // only update scores that increase
+	LT bool // only update scores that decrease
+}
+
+// ScoreBound is one end of a range of scores.
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a range of members, compared bytewise. Inf is -1
+// for the lowest possible member and 1 for the highest, in which case Value
+// is ignored.
+type LexBound struct {
+	Value     []byte
+	Exclusive bool
+	Inf       int
+}
+
+// ZRangeBy selects how a ZRange is specified.
+type ZRangeBy int
+
+const (
+	ZByRank ZRangeBy = iota
+	ZByScore
+	ZByLex
+)
+
+// name names by in the commands and events removing a range, such as
+// ZREMRANGEBYSCORE.
+func (by ZRangeBy) name() string {
+	switch by {
+	case ZByScore:
+		return "score"
+	case ZByLex:
+		return "lex"
+	}
+	return "rank"
+}
+
+// ZRange selects members of a sorted set.
+type ZRange struct {
+	By ZRangeBy
+	// Start and Stop are inclusive, possibly negative, ranks for ZByRank.
+	Start, Stop int
+	// Min and Max bound the scores for ZByScore.
+	Min, Max ScoreBound
+	// LexMin and LexMax bound the members for ZByLex, which is only
+	// meaningful when all the scores are equal.
+	LexMin, LexMax LexBound
+	// Rev returns the members from the highest to the lowest. Ranks then
+	// count from the highest member.
+	Rev bool
+	// With Limit set, the first Offset members of the range are skipped
+	// and at most Count are returned, or all of them if Count is negative.
+	Limit         bool
+	Offset, Count int
+}
+
+// ZAggregate combines the scores of a member present in several sorted sets.
+type ZAggregate int
+
+const (
+	ZAggSum ZAggregate = iota
+	ZAggMin
+	ZAggMax
+)
+
+// StreamID identifies a stream entry: a unix time in milliseconds and a
+// sequence number among the entries added in that millisecond.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// MaxStreamID is the greatest possible ID.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 as id is lower than, equal to or greater than o.
+func (id StreamID) Compare(o StreamID) int {
+	if c := cmp.Compare(id.Ms, o.Ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Seq, o.Seq)
+}
+
+// Next returns the smallest ID greater than id, or false if id is
+// MaxStreamID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// Prev returns the greatest ID lower than id, or false if id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// StreamEntry is an entry of a stream. Fields holds its fields and values as
+// pairs. It is nil for entries read back from a pending entries list after
+// they were deleted from the stream.
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// XAddOptions controls how XAdd picks the ID of the new entry and trims the
+// stream.
+type XAddOptions struct {
+	// ID is the ID of the new entry. With AutoID set it is generated from
+	// the clock instead, and with AutoSeq only its sequence number is.
+	ID              StreamID
+	AutoID, AutoSeq bool
+	// NoMkStream adds nothing if the stream does not exist.
+	NoMkStream bool
+	Trim       StreamTrim
+}
+
+// StreamTrimBy selects how a stream is trimmed.
+type StreamTrimBy int
+
+const (
+	TrimNone StreamTrimBy = iota
+	TrimMaxLen
+	TrimMinID
 )
 
+// StreamTrim evicts the oldest entries of a stream, either all but the
+// newest MaxLen or all those with an ID lower than MinID.
+type StreamTrim struct {
+	By     StreamTrimBy
+	MaxLen int
+	MinID  StreamID
+	// Limit caps the number of entries evicted, if positive.
+	Limit int
+}
+
+// PendingEntry is an entry delivered to a consumer of a group and not yet
+// acknowledged.
+type PendingEntry struct {
+	ID          StreamID
+	Consumer    string
+	DeliveredAt time.Time
+	// Deliveries is the number of times the entry was delivered.
+	Deliveries int
+}
+
+// PendingSummary summarises the pending entries list of a group.
+type PendingSummary struct {
+	Count    int
+	Min, Max StreamID
+	// Consumers maps each consumer with pending entries to their number.
+	Consumers map[string]int
+}
+
+// PendingRange selects entries of a pending entries list.
+type PendingRange struct {
+	// Start and End are inclusive.
+	Start, End StreamID
+	Count      int
+	// Consumer, if not empty, selects the entries of one consumer only.
+	Consumer string
+	// MinIdle selects the entries delivered at least this long ago.
+	MinIdle time.Duration
+}
+
+// XClaimOptions controls how XClaim changes the entries it claims.
+type XClaimOptions struct {
+	// DeliveredAt is the delivery time recorded, now if it is zero.
+	DeliveredAt time.Time
+	// RetryCount, if not negative, is the delivery count recorded.
+	// Otherwise the count is incremented, unless JustID is set.
+	RetryCount int
+	// Force claims entries that are not pending yet, as long as they are
+	// still in the stream.
+	Force  bool
+	JustID bool
+	// LastID raises the last delivered ID of the group if it is greater.
+	LastID StreamID
+}
+
+// StreamValue is a whole stream captured by a Snapshot.
+type StreamValue struct {
+	Entries []StreamEntry
+	// LastID is the ID of the last entry ever added, which may have been
+	// deleted since.
+	LastID StreamID
+	Groups []StreamGroup
+}
+
+// StreamGroup is a consumer group captured by a Snapshot.
+type StreamGroup struct {
+	Name string
+	// LastID is the ID of the last entry delivered to the group.
+	LastID    StreamID
+	Consumers []StreamConsumer
+	Pending   []PendingEntry
+}
+
+// StreamConsumer is a consumer captured by a Snapshot.
+type StreamConsumer struct {
+	Name   string
+	SeenAt time.Time
+}
+
+// SetOptions controls the conditional and expiry behaviour of SetWithOptions.
+type SetOptions struct {
+	// ExpireAt is the absolute expiry time of the key. The zero value means
+	// the key does not expire, unless KeepTTL is set.
+	ExpireAt time.Time
+	KeepTTL  bool
+	NX       bool // only set the key if it does not already exist
+	XX       bool // only set the key if it already exists
+	// Get fails the call with ErrWrongType, without writing, if the key
+	// holds a value that is not a string, since it could not be returned.
+	Get bool
+}
+
+// ExpireCond restricts when Expire replaces a key's current expiry.
+type ExpireCond int
+
+const (
+	ExpireAlways ExpireCond = iota
+	ExpireNX                // only if the key has no expiry
+	ExpireXX                // only if the key has an expiry
+	ExpireGT                // only if the new expiry is later than the current one
+	ExpireLT                // only if the new expiry is earlier than the current one
+)
+
+// KeyCount is the number of keys in a keyspace, and of those with an expiry.
+// Keys that expired but were not deleted yet are counted.
+type KeyCount struct {
+	Keys, Expires int
+}
+
 type Storage interface {
 	Set(k string, v []byte) error
 	Get(k string) ([]byte, error)
 	Del(keys ...string) (int, error)
 	Incr(k string) (int64, error)
+
+	// Type returns the kind of value held by k.
+	Type(k string) (Kind, error)
+	// Restore stores a key captured by a Snapshot, replacing any value.
+	Restore(e Entry) error
+
+	// SetWithOptions stores v under k subject to opts. It returns the value
+	// held before the call (nil if the key did not exist) and whether the
+	// new value was written.
+	SetWithOptions(k string, v []byte, opts SetOptions) (old []byte, ok bool, err error)
+	// Expire sets the absolute expiry time of k. An expiry in the past
+	// deletes the key. It reports whether the expiry was changed.
+	Expire(k string, at time.Time, cond ExpireCond) (bool, error)
+	// Persist removes the expiry of k and reports whether one was removed.
+	Persist(k string) (bool, error)
+	// ExpireTime returns the absolute expiry of k, or the zero time if k
+	// does not expire.
+	ExpireTime(k string) (time.Time, error)
+	// Snapshot returns a point-in-time view of the whole keyspace.
+	Snapshot() *Snapshot
+	// FlushAll deletes every key.
+	FlushAll() error
+	// Version returns a value that changes whenever k is written, deleted or
+	// expires, and zero if k does not exist. A deleted key keeps the version
+	// of its deletion while it is watched, so that a watched key created and
+	// deleted again in between two calls reads differently.
+	Version(k string) uint64
+	// Watch makes k keep a version once deleted until Unwatch is called as
+	// many times as Watch was.
+	Watch(k string)
+	Unwatch(k string)
+	// KeyCounts returns the number of keys in each shard of the keyspace.
+	// A storage that is not sharded has a single one.
+	KeyCounts() []KeyCount
+	// UsedMemory returns an estimate of the memory used by the keys and
+	// their values, in bytes.
+	UsedMemory() int64
+	// SetMemoryLimit sets the memory limit Evict enforces.
+	SetMemoryLimit(l MemoryLimit)
+	// Evict deletes keys by the eviction policy until UsedMemory is within
+	// the limit, and returns them. It fails with ErrOutOfMemory if it could
+	// not free enough memory, always so under NoEviction, in which case
+	// writes that would use more memory must be refused.
+	Evict() ([]string, error)
+	// SetNotifier sets the function the events of the keyspace are sent
+	// to, or with nil, stops sending them.
+	SetNotifier(n Notifier)
+
+	// List operations take Redis-style indexes: negative ones count from the
+	// tail. Missing keys behave as empty lists, and lists that become empty
+	// are deleted. All of them fail with ErrWrongType on keys of other kinds.
+
+	// ListPush adds each of vals in turn at the given end of the list at k
+	// and returns the new length. With existing set, nothing happens unless
+	// the list already exists.
+	ListPush(k string, end ListEnd, vals [][]byte, existing bool) (int, error)
+	// ListPop removes up to count elements from the given end.
+	ListPop(k string, end ListEnd, count int) ([][]byte, error)
+	ListLen(k string) (int, error)
+	// ListIndex returns element i, or nil if it is out of range.
+	ListIndex(k string, i int) ([]byte, error)
+	// ListSet replaces element i. It fails with ErrKeyNotFound or
+	// ErrIndexOutOfRange.
+	ListSet(k string, i int, v []byte) error
+	// ListRange returns the elements from start to stop inclusive.
+	ListRange(k string, start, stop int) ([][]byte, error)
+	// ListRem removes up to count occurrences of v, all of them if count is
+	// zero, scanning from the tail if it is negative.
+	ListRem(k string, count int, v []byte) (int, error)
+	// ListTrim keeps only the elements from start to stop inclusive.
+	ListTrim(k string, start, stop int) error
+	// ListInsert adds v before or after the first occurrence of pivot and
+	// returns the new length, -1 if pivot was not found or 0 if the list
+	// does not exist.
+	ListInsert(k string, before bool, pivot, v []byte) (int, error)
+	// ListMove pops an element from one end of src and pushes it at one end
+	// of dst, atomically, and returns it. It returns nil if src is empty.
+	ListMove(src, dst string, from, to ListEnd) ([]byte, error)
+
+	// Hash operations treat missing keys as empty hashes and delete hashes
+	// that become empty, like the list operations. Fields and values are
+	// passed around as pairs: a flat slice alternating the two.
+
+	// HashSet sets fields from pairs and returns the number of fields that
+	// were added. With nx set, fields that already exist are left alone.
+	HashSet(k string, pairs [][]byte, nx bool) (int, error)
+	// HashGet returns the value of each field, or nil for missing ones.
+	HashGet(k string, fields ...[]byte) ([][]byte, error)
+	HashDel(k string, fields ...[]byte) (int, error)
+	HashLen(k string) (int, error)
+	// HashGetAll returns every field and value as pairs, in no particular
+	// order.
+	HashGetAll(k string) ([][]byte, error)
+	// HashIncrBy adds delta to the integer held by field, a missing field
+	// counting as zero. It fails with ErrNotInteger or ErrIntegerOverflow.
+	HashIncrBy(k string, field []byte, delta int64) (int64, error)
+	// HashIncrByFloat is HashIncrBy for floating point values, returning
+	// the new value as stored. It fails with ErrNotFloat or ErrNotFinite.
+	HashIncrByFloat(k string, field []byte, delta float64) ([]byte, error)
+	// HashScan returns about count pairs starting at cursor, and the cursor
+	// to continue from, which is 0 once every field has been returned.
+	// Fields present for the whole scan are returned at least once.
+	HashScan(k string, cursor uint64, count int) (uint64, [][]byte, error)
+	// HashRandFields returns count distinct random pairs, or all of them if
+	// the hash is smaller. A negative count asks for exactly -count pairs
+	// that may repeat.
+	HashRandFields(k string, count int) ([][]byte, error)
+
+	// Set operations treat missing keys as empty sets and delete sets that
+	// become empty. Operations on several keys are atomic, even when the
+	// keys live in different shards.
+
+	// SetAdd adds members and returns the number that were not already in
+	// the set.
+	SetAdd(k string, members ...[]byte) (int, error)
+	// SetRem removes members and returns the number that were in the set.
+	SetRem(k string, members ...[]byte) (int, error)
+	SetMembers(k string) ([][]byte, error)
+	// SetIsMember reports, for each of members, whether it is in the set.
+	SetIsMember(k string, members ...[]byte) ([]bool, error)
+	SetCard(k string) (int, error)
+	// SetPop removes and returns up to count random members.
+	SetPop(k string, count int) ([][]byte, error)
+	// SetRandMembers returns count distinct random members, or all of them
+	// if the set is smaller. A negative count asks for exactly -count
+	// members that may repeat.
+	SetRandMembers(k string, count int) ([][]byte, error)
+	// SetScan iterates over the members like HashScan.
+	SetScan(k string, cursor uint64, count int) (uint64, [][]byte, error)
+	// SetMove moves member from src to dst and reports whether it was in
+	// src. It fails with ErrWrongType, changing nothing, if either key is
+	// not a set.
+	SetMove(src, dst string, member []byte) (bool, error)
+	// SetCombine returns the result of op on the sets at keys.
+	SetCombine(op SetOp, keys ...string) ([][]byte, error)
+	// SetCombineStore stores the result of op on the sets at keys in dst,
+	// replacing any value, and returns its size. dst is deleted if the
+	// result is empty.
+	SetCombineStore(op SetOp, dst string, keys ...string) (int, error)
+	// SetInterCard returns the size of the intersection of the sets at
+	// keys, stopping at limit if it is positive.
+	SetInterCard(limit int, keys ...string) (int, error)
+
+	// Sorted set operations treat missing keys as empty sorted sets and
+	// delete sorted sets that become empty. Members are ordered by score,
+	// then bytewise.
+
+	// ZAdd adds members or updates their scores, subject to opts, and
+	// returns the number of members added and the number updated.
+	ZAdd(k string, opts ZAddOptions, members []ScoredMember) (added, updated int, err error)
+	// ZIncrBy adds delta to the score of member, subject to opts, and
+	// returns the new score and whether it was written. It fails with
+	// ErrNotFinite if the score would become NaN.
+	ZIncrBy(k string, opts ZAddOptions, member []byte, delta float64) (float64, bool, error)
+	ZRem(k string, members ...[]byte) (int, error)
+	ZCard(k string) (int, error)
+	// ZScores returns the score of each member, or nil for missing ones.
+	ZScores(k string, members ...[]byte) ([]*float64, error)
+	// ZRank returns the 0-based rank of member, counted from the highest
+	// member if rev is set, and its score. The rank is -1 if member is
+	// missing.
+	ZRank(k string, member []byte, rev bool) (int, float64, error)
+	// ZRange returns the members in r, in order.
+	ZRange(k string, r ZRange) ([]ScoredMember, error)
+	// ZCount returns the number of members in r, ignoring Rev and Limit,
+	// in O(log n).
+	ZCount(k string, r ZRange) (int, error)
+	// ZRangeStore stores the members of src in r in dst, replacing any
+	// value, and returns their number.
+	ZRangeStore(dst, src string, r ZRange) (int, error)
+	// ZRemRange removes the members in r, ignoring Rev and Limit, and
+	// returns their number.
+	ZRemRange(k string, r ZRange) (int, error)
+	// ZPop removes and returns up to count members with the lowest scores,
+	// or the highest if max is set.
+	ZPop(k string, count int, max bool) ([]ScoredMember, error)
+	// ZCombine returns the result of op on the sorted sets at keys, in
+	// order. Plain sets count as sorted sets whose scores are all 1. The
+	// scores of each input are multiplied by its weight, if weights is not
+	// nil, and combined with agg. Differences keep the scores of the first
+	// input.
+	ZCombine(op SetOp, keys []string, weights []float64, agg ZAggregate) ([]ScoredMember, error)
+	// ZCombineStore is ZCombine storing the result in dst, replacing any
+	// value, and returning its size. dst is deleted if the result is empty.
+	ZCombineStore(op SetOp, dst string, keys []string, weights []float64, agg ZAggregate) (int, error)
+
+	// Stream operations fail with ErrWrongType on keys of other kinds.
+	// Unlike other collections, streams are not deleted when they become
+	// empty, since they still hold their last ID and consumer groups.
+
+	// XAdd adds an entry made of the field and value pairs and returns its
+	// ID, or false if opts.NoMkStream is set and the stream does not exist.
+	XAdd(k string, fields [][]byte, opts XAddOptions) (StreamID, bool, error)
+	XLen(k string) (int, error)
+	// XRange returns up to count entries with IDs from start to end
+	// inclusive, all of them if count is negative, from the newest if rev
+	// is set.
+	XRange(k string, start, end StreamID, count int, rev bool) ([]StreamEntry, error)
+	XDel(k string, ids ...StreamID) (int, error)
+	// XTrim evicts entries as specified by trim and returns their number.
+	XTrim(k string, trim StreamTrim) (int, error)
+	// XLastID returns the last ID of the stream, 0-0 if it does not exist.
+	XLastID(k string) (StreamID, error)
+	// XSetID sets the last ID of the stream. It fails with ErrKeyNotFound
+	// or ErrStreamIDTooSmall if id is lower than the newest entry.
+	XSetID(k string, id StreamID) error
+
+	// XGroupCreate creates a group that has been delivered the entries up
+	// to id, or to the last ID if latest is set. Without mkStream it fails
+	// with ErrKeyNotFound if the stream does not exist.
+	XGroupCreate(k, group string, id StreamID, latest, mkStream bool) error
+	// XGroupSetID sets the last delivered ID of a group like XGroupCreate.
+	XGroupSetID(k, group string, id StreamID, latest bool) error
+	XGroupDestroy(k, group string) (bool, error)
+	// XGroupCreateConsumer reports whether the consumer was created.
+	XGroupCreateConsumer(k, group, consumer string) (bool, error)
+	// XGroupDelConsumer deletes a consumer and returns the number of
+	// entries it had pending, which are deleted too.
+	XGroupDelConsumer(k, group, consumer string) (int, error)
+	// XReadGroup delivers up to count entries to a consumer, creating it if
+	// needed, and adds them to its pending entries unless noAck is set. If
+	// history is set the consumer's pending entries with IDs greater than
+	// after are delivered again instead of new entries. Deleted entries
+	// are returned without fields.
+	XReadGroup(k, group, consumer string, after StreamID, history bool, count int, noAck bool) ([]StreamEntry, error)
+	// XAck removes entries from the pending entries of a group and returns
+	// how many were pending.
+	XAck(k, group string, ids ...StreamID) (int, error)
+	XPendingSummary(k, group string) (PendingSummary, error)
+	XPending(k, group string, r PendingRange) ([]PendingEntry, error)
+	// XClaim gives a consumer the ownership of the pending entries that
+	// have been idle for at least minIdle, and returns them. Pending
+	// entries deleted from the stream are removed instead.
+	XClaim(k, group, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) ([]StreamEntry, error)
+	// XAutoClaim is XClaim for up to count pending entries idle for at
+	// least minIdle, scanning them from start. It returns the ID to
+	// continue scanning from, 0-0 once the scan is complete, the claimed
+	// entries and the IDs of the deleted entries that were removed.
+	XAutoClaim(k, group, consumer string, minIdle time.Duration, start StreamID, count int, justID bool) (StreamID, []StreamEntry, []StreamID, error)
 }
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+)
+
+// stream is the value of a stream key. Entries are kept sorted by ID. Their
+// fields are never modified in place, so they may be shared with snapshots.
+type stream struct {
+	entries []StreamEntry
+	// lastID is the ID of the last entry added, which may have been
+	// deleted since.
+	lastID StreamID
+	groups map[string]*group
+}
+
+// group is a consumer group. Its pending entries list maps the IDs delivered
+// and not yet acknowledged to their delivery state.
+type group struct {
+	lastID    StreamID
+	pending   map[StreamID]*pending
+	consumers map[string]*consumer
+}
+
+type pending struct {
+	consumer    string
+	deliveredAt int64 // unix milliseconds
+	deliveries  int
+}
+
+type consumer struct {
+	seenAt int64 // unix milliseconds
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*group)}
+}
+
+func newGroup(lastID StreamID) *group {
+	return &group{
+		lastID:    lastID,
+		pending:   make(map[StreamID]*pending),
+		consumers: make(map[string]*consumer),
+	}
+}
+
+// search returns the index of the first entry with an ID not lower than id.
+func (st *stream) search(id StreamID) int {
+	i, _ := slices.BinarySearchFunc(st.entries, id, func(e StreamEntry, id StreamID) int {
+		return e.ID.Compare(id)
+	})
+	return i
+}
+
+// get returns the entry with the given ID.
+func (st *stream) get(id StreamID) (StreamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].ID == id {
+		return st.entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// nextID returns the ID of an entry added at unix time ms with opts.
+func (st *stream) nextID(opts XAddOptions, ms uint64) (StreamID, error) {
+	last := st.lastID
+	if last == MaxStreamID {
+		return StreamID{}, ErrStreamExhausted
+	}
+	switch {
+	case opts.AutoID:
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		id, _ := last.Next()
+		return id, nil
+	case opts.AutoSeq:
+		switch {
+		case opts.ID.Ms > last.Ms:
+			return StreamID{Ms: opts.ID.Ms}, nil
+		case opts.ID.Ms == last.Ms && last.Seq < math.MaxUint64:
+			return StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+		}
+		return StreamID{}, ErrStreamIDTooSmall
+	case opts.ID == StreamID{}:
+		return StreamID{}, ErrStreamIDZero
+	case opts.ID.Compare(last) <= 0:
+		return StreamID{}, ErrStreamIDTooSmall
+	}
+	return opts.ID, nil
+}
+
+// trim evicts the oldest entries as specified by t and returns their number.
+func (st *stream) trim(t StreamTrim) int {
+	n := 0
+	switch t.By {
+	case TrimMaxLen:
+		n = max(len(st.entries)-t.MaxLen, 0)
+	case TrimMinID:
+		n = st.search(t.MinID)
+	}
+	if t.Limit > 0 {
+		n = min(n, t.Limit)
+	}
+	// Clear the evicted entries so the backing array does not keep their
+	// fields alive until it is reallocated.
+	clear(st.entries[:n])
+	st.entries = st.entries[n:]
+	return n
+}
+
+// rangeOf returns the entries with IDs from start to end inclusive, up to
+// count of them if it is not negative, from the newest if rev is set.
+func (st *stream) rangeOf(start, end StreamID, count int, rev bool) []StreamEntry {
+	lo, hi := st.search(start), st.search(end)
+	if hi < len(st.entries) && st.entries[hi].ID == end {
+		hi++
+	}
+	if lo >= hi {
+		return []StreamEntry{}
+	}
+	n := hi - lo
+	if count >= 0 {
+		n = min(n, count)
+	}
+	out := make([]StreamEntry, 0, n)
+	for i := range n {
+		j := lo + i
+		if rev {
+			j = hi - 1 - i
+		}
+		out = append(out, cloneEntry(st.entries[j]))
+	}
+	return out
+}
+
+// latest returns id, or the last ID of the stream if latest is set.
+func (st *stream) latest(id StreamID, latest bool) StreamID {
+	if latest {
+		return st.lastID
+	}
+	return id
+}
+
+// consumer returns the named consumer, creating it if needed, and records
+// that it was seen at ms.
+func (g *group) consumer(name string, ms int64) *consumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &consumer{}
+		g.consumers[name] = c
+	}
+	c.seenAt = ms
+	return c
+}
+
+// pendingIDs returns the IDs of the pending entries, sorted.
+func (g *group) pendingIDs() []StreamID {
+	ids := make([]StreamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, StreamID.Compare)
+	return ids
+}
+
+// claim gives the ownership of the pending entry id to a consumer, creating
+// the entry if needed.
+func (g *group) claim(id StreamID, consumer string, ms int64, opts XClaimOptions) {
+	p, ok := g.pending[id]
+	if !ok {
+		p = &pending{}
+		g.pending[id] = p
+	}
+	p.consumer, p.deliveredAt = consumer, ms
+	switch {
+	case opts.RetryCount >= 0:
+		p.deliveries = opts.RetryCount
+	case !opts.JustID:
+		p.deliveries++
+	}
+}
+
+func cloneEntry(e StreamEntry) StreamEntry {
+	if e.Fields == nil {
+		return e
+	}
+	fields := make([][]byte, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = clone(f)
+	}
+	return StreamEntry{ID: e.ID, Fields: fields}
+}
+
+// value captures the stream for a snapshot, with groups and consumers sorted
+// by name. Entries share their fields with the stream.
+func (st *stream) value() *StreamValue {
+	v := &StreamValue{
+		Entries: append([]StreamEntry(nil), st.entries...),
+		LastID:  st.lastID,
+		Groups:  make([]StreamGroup, 0, len(st.groups)),
+	}
+	for name, g := range st.groups {
+		sg := StreamGroup{Name: name, LastID: g.lastID}
+		for cname, c := range g.consumers {
+			sg.Consumers = append(sg.Consumers, StreamConsumer{Name: cname, SeenAt: time.UnixMilli(c.seenAt)})
+		}
+		for _, id := range g.pendingIDs() {
+			p := g.pending[id]
+			sg.Pending = append(sg.Pending, PendingEntry{
+				ID:          id,
+				Consumer:    p.consumer,
+				DeliveredAt: time.UnixMilli(p.deliveredAt),
+				Deliveries:  p.deliveries,
+			})
+		}
+		slices.SortFunc(sg.Consumers, func(a, b StreamConsumer) int { return cmp.Compare(a.Name, b.Name) })
+		v.Groups = append(v.Groups, sg)
+	}
+	slices.SortFunc(v.Groups, func(a, b StreamGroup) int { return cmp.Compare(a.Name, b.Name) })
+	return v
+}
+
+// streamFrom rebuilds a stream captured by value.
+func streamFrom(v *StreamValue) *stream {
+	st := newStream()
+	st.lastID = v.LastID
+	st.entries = make([]StreamEntry, 0, len(v.Entries))
+	for _, e := range v.Entries {
+		st.entries = append(st.entries, cloneEntry(e))
+	}
+	slices.SortFunc(st.entries, func(a, b StreamEntry) int { return a.ID.Compare(b.ID) })
+	if n := len(st.entries); n > 0 && st.entries[n-1].ID.Compare(st.lastID) > 0 {
+		st.lastID = st.entries[n-1].ID
+	}
+	for _, sg := range v.Groups {
+		g := newGroup(sg.LastID)
+		for _, c := range sg.Consumers {
+			g.consumers[c.Name] = &consumer{seenAt: c.SeenAt.UnixMilli()}
+		}
+		for _, p := range sg.Pending {
+			g.pending[p.ID] = &pending{consumer: p.Consumer, deliveredAt: p.DeliveredAt.UnixMilli(), deliveries: p.Deliveries}
+			if _, ok := g.consumers[p.Consumer]; !ok {
+				g.consumers[p.Consumer] = &consumer{seenAt: p.DeliveredAt.UnixMilli()}
+			}
+		}
+		st.groups[sg.Name] = g
+	}
+	return st
+}
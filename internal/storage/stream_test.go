@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ids(entries []StreamEntry) []StreamID {
+	out := make([]StreamID, len(entries))
+	for i, e := range entries {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func TestXAddIDs(t *testing.T) {
+	setClock(t, time.UnixMilli(1000))
+	s := NewInMemoryStorage()
+	add := func(opts XAddOptions) (StreamID, error) {
+		id, _, err := s.XAdd("s", [][]byte{[]byte("f"), []byte("v")}, opts)
+		return id, err
+	}
+
+	id, err := add(XAddOptions{AutoID: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{1000, 0}, id)
+	id, err = add(XAddOptions{AutoID: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{1000, 1}, id, "same millisecond bumps the sequence")
+
+	_, err = add(XAddOptions{ID: StreamID{1000, 1}})
+	assert.ErrorIs(t, err, ErrStreamIDTooSmall)
+	_, err = add(XAddOptions{ID: StreamID{999, 0}, AutoSeq: true})
+	assert.ErrorIs(t, err, ErrStreamIDTooSmall)
+	id, err = add(XAddOptions{ID: StreamID{1000, 0}, AutoSeq: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{1000, 2}, id)
+	id, err = add(XAddOptions{ID: StreamID{5000, 0}, AutoSeq: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{5000, 0}, id)
+	id, err = add(XAddOptions{AutoID: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{5000, 1}, id, "a clock behind the last ID never goes backwards")
+
+	_, _, err = s.XAdd("new", nil, XAddOptions{})
+	assert.ErrorIs(t, err, ErrStreamIDZero)
+	id, _, err = s.XAdd("new", nil, XAddOptions{AutoSeq: true})
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{0, 1}, id)
+
+	require.NoError(t, s.XSetID("s", MaxStreamID))
+	_, err = add(XAddOptions{AutoID: true})
+	assert.ErrorIs(t, err, ErrStreamExhausted)
+
+	_, ok, err := s.XAdd("missing", nil, XAddOptions{AutoID: true, NoMkStream: true})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	n, err := s.Del("missing")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	require.NoError(t, s.Set("str", []byte("v")))
+	_, _, err = s.XAdd("str", nil, XAddOptions{AutoID: true})
+	assert.ErrorIs(t, err, ErrWrongType)
+}
+
+func TestXRangeAndTrim(t *testing.T) {
+	s := NewInMemoryStorage()
+	for i := uint64(1); i <= 10; i++ {
+		_, _, err := s.XAdd("s", [][]byte{[]byte("n"), []byte{byte('0' + i)}}, XAddOptions{ID: StreamID{i, 0}})
+		require.NoError(t, err)
+	}
+
+	got, err := s.XRange("s", StreamID{3, 0}, StreamID{5, 0}, -1, false)
+	require.NoError(t, err)
+	assert.Equal(t, []StreamID{{3, 0}, {4, 0}, {5, 0}}, ids(got))
+	got, err = s.XRange("s", StreamID{}, MaxStreamID, 2, true)
+	require.NoError(t, err)
+	assert.Equal(t, []StreamID{{10, 0}, {9, 0}}, ids(got))
+
+	n, err := s.XDel("s", StreamID{4, 0}, StreamID{4, 0}, StreamID{42, 0})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = s.XTrim("s", StreamTrim{By: TrimMinID, MinID: StreamID{3, 0}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = s.XTrim("s", StreamTrim{By: TrimMaxLen, MaxLen: 2, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n, "limit caps the evictions")
+	n, err = s.XLen("s")
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	n, err = s.XTrim("s", StreamTrim{By: TrimMaxLen})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	kind, err := s.Type("s")
+	require.NoError(t, err)
+	assert.Equal(t, KindStream, kind, "an empty stream still exists")
+	last, err := s.XLastID("s")
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{10, 0}, last)
+	assert.ErrorIs(t, s.XSetID("missing", StreamID{1, 0}), ErrKeyNotFound)
+}
+
+func TestConsumerGroups(t *testing.T) {
+	advance := setClock(t, time.UnixMilli(1_000_000))
+	s := NewInMemoryStorage()
+	for i := uint64(1); i <= 5; i++ {
+		_, _, err := s.XAdd("s", [][]byte{[]byte("n"), []byte{byte('0' + i)}}, XAddOptions{ID: StreamID{i, 0}})
+		require.NoError(t, err)
+	}
+
+	assert.ErrorIs(t, s.XGroupCreate("missing", "g", StreamID{}, false, false), ErrKeyNotFound)
+	require.NoError(t, s.XGroupCreate("s", "g", StreamID{}, false, false))
+	assert.ErrorIs(t, s.XGroupCreate("s", "g", StreamID{}, false, false), ErrGroupExists)
+	_, err := s.XReadGroup("s", "nope", "c", StreamID{}, false, 0, false)
+	assert.ErrorIs(t, err, ErrNoGroup)
+
+	got, err := s.XReadGroup("s", "g", "alice", StreamID{}, false, 2, false)
+	require.NoError(t, err)
+	assert.Equal(t, []StreamID{{1, 0}, {2, 0}}, ids(got))
+	got, err = s.XReadGroup("s", "g", "bob", StreamID{}, false, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, []StreamID{{3, 0}, {4, 0}, {5, 0}}, ids(got))
+	got, err = s.XReadGroup("s", "g", "bob", StreamID{}, false, 0, false)
+	require.NoError(t, err)
+	assert.Empty(t, got, "everything was delivered")
+
+	sum, err := s.XPendingSummary("s", "g")
+	require.NoError(t, err)
+	assert.Equal(t, PendingSummary{Count: 5, Min: StreamID{1, 0}, Max: StreamID{5, 0}, Consumers: map[string]int{"alice": 2, "bob": 3}}, sum)
+
+	n, err := s.XAck("s", "g", StreamID{3, 0}, StreamID{3, 0}, StreamID{9, 0})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	t.Run("history", func(t *testing.T) {
+		n, err := s.XDel("s", StreamID{4, 0})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		got, err := s.XReadGroup("s", "g", "bob", StreamID{}, true, 0, false)
+		require.NoError(t, err)
+		require.Equal(t, []StreamID{{4, 0}, {5, 0}}, ids(got))
+		assert.Nil(t, got[0].Fields, "deleted entries come back without fields")
+		assert.Equal(t, [][]byte{[]byte("n"), []byte("5")}, got[1].Fields)
+
+		pel, err := s.XPending("s", "g", PendingRange{Start: StreamID{}, End: MaxStreamID, Count: 10, Consumer: "bob"})
+		require.NoError(t, err)
+		require.Len(t, pel, 2)
+		assert.Equal(t, 2, pel[1].Deliveries)
+	})
+
+	advance(time.Minute)
+	t.Run("claim", func(t *testing.T) {
+		got, err := s.XClaim("s", "g", "carol", time.Hour, []StreamID{{1, 0}}, XClaimOptions{RetryCount: -1})
+		require.NoError(t, err)
+		assert.Empty(t, got, "not idle long enough")
+
+		got, err = s.XClaim("s", "g", "carol", time.Second, []StreamID{{1, 0}, {4, 0}, {3, 0}}, XClaimOptions{RetryCount: -1})
+		require.NoError(t, err)
+		assert.Equal(t, []StreamID{{1, 0}}, ids(got), "deleted and acknowledged entries are not claimed")
+		pel, err := s.XPending("s", "g", PendingRange{Start: StreamID{}, End: MaxStreamID, Count: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []PendingEntry{
+			{ID: StreamID{1, 0}, Consumer: "carol", DeliveredAt: now(), Deliveries: 2},
+			{ID: StreamID{2, 0}, Consumer: "alice", DeliveredAt: now().Add(-time.Minute), Deliveries: 1},
+			{ID: StreamID{5, 0}, Consumer: "bob", DeliveredAt: now().Add(-time.Minute), Deliveries: 2},
+		}, pel, "the deleted entry left the pending list")
+
+		got, err = s.XClaim("s", "g", "carol", 0, []StreamID{{3, 0}}, XClaimOptions{RetryCount: 7, Force: true, JustID: true})
+		require.NoError(t, err)
+		assert.Equal(t, []StreamEntry{{ID: StreamID{3, 0}}}, got)
+		pel, err = s.XPending("s", "g", PendingRange{Start: StreamID{3, 0}, End: StreamID{3, 0}, Count: 10})
+		require.NoError(t, err)
+		assert.Equal(t, 7, pel[0].Deliveries)
+	})
+
+	t.Run("autoclaim", func(t *testing.T) {
+		next, got, deleted, err := s.XAutoClaim("s", "g", "dave", 30*time.Second, StreamID{}, 1, false)
+		require.NoError(t, err)
+		assert.Equal(t, []StreamID{{2, 0}}, ids(got), "only alice's and bob's entries are idle")
+		assert.Empty(t, deleted)
+		assert.Equal(t, StreamID{3, 0}, next)
+
+		_, err = s.XDel("s", StreamID{5, 0})
+		require.NoError(t, err)
+		next, got, deleted, err = s.XAutoClaim("s", "g", "dave", 30*time.Second, next, 10, false)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+		assert.Equal(t, []StreamID{{5, 0}}, deleted)
+		assert.Equal(t, StreamID{}, next, "the scan is complete")
+	})
+
+	t.Run("consumers", func(t *testing.T) {
+		created, err := s.XGroupCreateConsumer("s", "g", "dave")
+		require.NoError(t, err)
+		assert.False(t, created)
+		n, err := s.XGroupDelConsumer("s", "g", "dave")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		destroyed, err := s.XGroupDestroy("s", "g")
+		require.NoError(t, err)
+		assert.True(t, destroyed)
+		n, err = s.XAck("s", "g", StreamID{1, 0})
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+}
+
+func TestStreamSnapshotRoundTrip(t *testing.T) {
+	setClock(t, time.UnixMilli(1_000_000))
+	s := NewInMemoryStorage()
+	for i := uint64(1); i <= 3; i++ {
+		_, _, err := s.XAdd("s", [][]byte{[]byte("n"), []byte{byte('0' + i)}}, XAddOptions{ID: StreamID{i, 0}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.XGroupCreate("s", "g", StreamID{}, false, false))
+	_, err := s.XReadGroup("s", "g", "c", StreamID{}, false, 2, false)
+	require.NoError(t, err)
+	_, err = s.XDel("s", StreamID{3, 0})
+	require.NoError(t, err)
+
+	snap := s.Snapshot()
+	_, err = s.XAck("s", "g", StreamID{1, 0})
+	require.NoError(t, err)
+
+	var entries []Entry
+	require.NoError(t, snap.Walk(func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+	require.Len(t, entries[0].Stream.Groups, 1)
+	assert.Len(t, entries[0].Stream.Groups[0].Pending, 2, "the snapshot predates the ack")
+
+	r := NewInMemoryStorage()
+	require.NoError(t, r.Restore(entries[0]))
+	last, err := r.XLastID("s")
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{3, 0}, last)
+	got, err := r.XReadGroup("s", "g", "c", StreamID{}, true, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, []StreamID{{1, 0}, {2, 0}}, ids(got))
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkSkiplist verifies the links, spans and order of the skiplist.
+func checkSkiplist(t *testing.T, sl *skiplist) {
+	t.Helper()
+	var nodes []*skipNode
+	for n := sl.header.next(); n != nil; n = n.next() {
+		if len(nodes) > 0 {
+			prev := nodes[len(nodes)-1]
+			require.True(t, prev.before(n.score, n.member), "out of order")
+			require.Equal(t, prev, n.backward)
+		} else {
+			require.Nil(t, n.backward)
+		}
+		nodes = append(nodes, n)
+	}
+	require.Len(t, nodes, sl.length)
+	if sl.length > 0 {
+		require.Equal(t, nodes[len(nodes)-1], sl.tail)
+	}
+
+	rank := map[*skipNode]int{sl.header: 0}
+	for i, n := range nodes {
+		rank[n] = i + 1
+	}
+	for i := range sl.level {
+		for x := sl.header; x != nil; x = x.level[i].forward {
+			if f := x.level[i].forward; f != nil {
+				require.Equal(t, rank[f]-rank[x], x.level[i].span, "span at level %d", i)
+			}
+		}
+	}
+}
+
+func TestSkiplistAgainstSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	z := newZSet()
+	ref := map[string]float64{}
+	sortedRef := func() []ScoredMember {
+		out := []ScoredMember{}
+		for m, s := range ref {
+			out = append(out, ScoredMember{Member: []byte(m), Score: s})
+		}
+		slices.SortFunc(out, func(a, b ScoredMember) int {
+			if c := cmp.Compare(a.Score, b.Score); c != 0 {
+				return c
+			}
+			return cmp.Compare(string(a.Member), string(b.Member))
+		})
+		return out
+	}
+
+	for i := range 3000 {
+		m := fmt.Sprint("m", rng.Intn(200))
+		if rng.Intn(3) == 0 {
+			_, ok := ref[m]
+			assert.Equal(t, ok, z.remove(m))
+			delete(ref, m)
+		} else {
+			score := float64(rng.Intn(50))
+			z.set(m, score)
+			ref[m] = score
+		}
+
+		if i%100 != 0 {
+			continue
+		}
+		checkSkiplist(t, z.sl)
+		want := sortedRef()
+		require.Equal(t, want, z.members())
+		for r, sm := range want {
+			require.Equal(t, r, z.sl.rank(sm.Score, string(sm.Member)))
+			require.Equal(t, string(sm.Member), z.sl.byRank(r).member)
+		}
+		require.Nil(t, z.sl.byRank(len(want)))
+		require.Equal(t, -1, z.sl.rank(1000, "nope"))
+
+		lo, hi := float64(rng.Intn(50)), float64(rng.Intn(50))
+		r := &ZRange{By: ZByScore, Min: ScoreBound{Value: lo, Exclusive: true}, Max: ScoreBound{Value: hi}}
+		inRange := []ScoredMember{}
+		for _, sm := range want {
+			if sm.Score > lo && sm.Score <= hi {
+				inRange = append(inRange, sm)
+			}
+		}
+		require.Equal(t, inRange, scored(z.nodes(r)))
+		require.Equal(t, len(inRange), z.count(r))
+	}
+}
+
+func TestZRange(t *testing.T) {
+	s := NewInMemoryStorage()
+	var members []ScoredMember
+	for i, m := range []string{"a", "b", "c", "d", "e"} {
+		members = append(members, ScoredMember{Member: []byte(m), Score: float64(i)})
+	}
+	_, _, err := s.ZAdd("z", ZAddOptions{}, members)
+	require.NoError(t, err)
+
+	names := func(sms []ScoredMember) []string {
+		out := []string{}
+		for _, sm := range sms {
+			out = append(out, string(sm.Member))
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name string
+		r    ZRange
+		want []string
+	}{
+		{"rank", ZRange{Start: 1, Stop: -2}, []string{"b", "c", "d"}},
+		{"rank rev", ZRange{Start: 0, Stop: 1, Rev: true}, []string{"e", "d"}},
+		{"rank empty", ZRange{Start: 3, Stop: 1}, []string{}},
+		{"score", ZRange{By: ZByScore, Min: ScoreBound{Value: 1}, Max: ScoreBound{Value: 3, Exclusive: true}}, []string{"b", "c"}},
+		{"score rev limit", ZRange{By: ZByScore, Min: ScoreBound{Value: math.Inf(-1)}, Max: ScoreBound{Value: math.Inf(1)}, Rev: true, Limit: true, Offset: 1, Count: 2}, []string{"d", "c"}},
+		{"score limit all", ZRange{By: ZByScore, Min: ScoreBound{Value: 0}, Max: ScoreBound{Value: 10}, Limit: true, Offset: 3, Count: -1}, []string{"d", "e"}},
+		{"score none", ZRange{By: ZByScore, Min: ScoreBound{Value: 5}, Max: ScoreBound{Value: 10}}, []string{}},
+		{"lex", ZRange{By: ZByLex, LexMin: LexBound{Value: []byte("b"), Exclusive: true}, LexMax: LexBound{Inf: 1}}, []string{"c", "d", "e"}},
+		{"lex rev", ZRange{By: ZByLex, LexMin: LexBound{Inf: -1}, LexMax: LexBound{Value: []byte("b")}, Rev: true}, []string{"b", "a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.ZRange("z", tc.r)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, names(got))
+		})
+	}
+
+	n, err := s.ZCount("z", ZRange{By: ZByScore, Min: ScoreBound{Value: 1}, Max: ScoreBound{Value: 3}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	rank, score, err := s.ZRank("z", []byte("b"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rank)
+	assert.Equal(t, 1.0, score)
+
+	n, err = s.ZRemRange("z", ZRange{Start: 0, Stop: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	popped, err := s.ZPop("z", 2, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e", "d"}, names(popped))
+}
+
+func TestZAddOptions(t *testing.T) {
+	s := NewInMemoryStorage()
+	add := func(opts ZAddOptions, member string, score float64) (int, int) {
+		t.Helper()
+		added, updated, err := s.ZAdd("z", opts, []ScoredMember{{Member: []byte(member), Score: score}})
+		require.NoError(t, err)
+		return added, updated
+	}
+	score := func(member string) float64 {
+		t.Helper()
+		scores, err := s.ZScores("z", []byte(member))
+		require.NoError(t, err)
+		require.NotNil(t, scores[0])
+		return *scores[0]
+	}
+
+	a, u := add(ZAddOptions{XX: true}, "m", 1)
+	assert.Equal(t, [2]int{0, 0}, [2]int{a, u})
+	_, err := s.Type("z")
+	assert.ErrorIs(t, err, ErrKeyNotFound, "XX does not create the key")
+
+	a, _ = add(ZAddOptions{}, "m", 5)
+	assert.Equal(t, 1, a)
+	_, u = add(ZAddOptions{NX: true}, "m", 1)
+	assert.Zero(t, u)
+	_, u = add(ZAddOptions{GT: true}, "m", 4)
+	assert.Zero(t, u)
+	_, u = add(ZAddOptions{GT: true}, "m", 6)
+	assert.Equal(t, 1, u)
+	_, u = add(ZAddOptions{LT: true}, "m", 7)
+	assert.Zero(t, u)
+	assert.Equal(t, 6.0, score("m"))
+
+	v, ok, err := s.ZIncrBy("z", ZAddOptions{}, []byte("m"), 1.5)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 7.5, v)
+	_, ok, err = s.ZIncrBy("z", ZAddOptions{LT: true}, []byte("m"), 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	add(ZAddOptions{}, "inf", math.Inf(1))
+	_, _, err = s.ZIncrBy("z", ZAddOptions{}, []byte("inf"), math.Inf(-1))
+	assert.ErrorIs(t, err, ErrNotFinite)
+}
+
+func TestZCombine(t *testing.T) {
+	s := NewInMemoryShardedStorage()
+	t.Cleanup(func() { s.Close() })
+	_, _, err := s.ZAdd("a", ZAddOptions{}, []ScoredMember{{[]byte("x"), 1}, {[]byte("y"), 2}})
+	require.NoError(t, err)
+	_, _, err = s.ZAdd("b", ZAddOptions{}, []ScoredMember{{[]byte("y"), 10}, {[]byte("z"), 20}})
+	require.NoError(t, err)
+	_, err = s.SetAdd("s", []byte("y"))
+	require.NoError(t, err)
+
+	got, err := s.ZCombine(SetUnion, []string{"a", "b"}, []float64{1, 2}, ZAggSum)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{[]byte("x"), 1}, {[]byte("y"), 22}, {[]byte("z"), 40}}, got)
+
+	got, err = s.ZCombine(SetInter, []string{"a", "b", "s"}, nil, ZAggMax)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{[]byte("y"), 10}}, got)
+
+	got, err = s.ZCombine(SetDiff, []string{"a", "b"}, nil, ZAggSum)
+	require.NoError(t, err)
+	assert.Equal(t, []ScoredMember{{[]byte("x"), 1}}, got)
+
+	n, err := s.ZCombineStore(SetInter, "dst", []string{"a", "b"}, nil, ZAggMin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	kind, err := s.Type("dst")
+	require.NoError(t, err)
+	assert.Equal(t, KindZSet, kind)
+
+	require.NoError(t, s.Set("str", []byte("v")))
+	_, err = s.ZCombine(SetUnion, []string{"a", "str"}, nil, ZAggSum)
+	assert.ErrorIs(t, err, ErrWrongType)
+}